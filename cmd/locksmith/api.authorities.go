@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"path/filepath"
+	"time"
 )
 
 // readAuthorityAPI handles the GET /v1/authority endpoint
@@ -57,13 +58,22 @@ func readAuthorityAPI(w http.ResponseWriter, r *http.Request) {
 				certificate, err := x509.ParseCertificate(pem.Bytes)
 				check(err)
 
+				// Decode the certificate policy extensions, which x509.Certificate only holds the OIDs of
+				certificatePolicies, err := parseCertificatePolicies(certificate)
+				check(err)
+
+				caArchived, err := isCAArchived(absPath)
+				check(err)
+
 				returnData := &RESTGETAuthorityJSONReturn{
-					Status:          "success",
-					Errors:          []string{},
-					Messages:        []string{"Certificate Authority information for '" + caPathRaw + "'"},
-					Slug:            caPathRaw,
-					CertificatePEM:  B64EncodeBytesToStr(pem.Bytes),
-					CertificateInfo: certificate}
+					Status:              "success",
+					Errors:              []string{},
+					Messages:            []string{"Certificate Authority information for '" + caPathRaw + "'"},
+					Slug:                caPathRaw,
+					CertificatePEM:      B64EncodeBytesToStr(pem.Bytes),
+					CertificateInfo:     certificate,
+					CertificatePolicies: certificatePolicies,
+					Archived:            caArchived}
 				returnResponse, _ := json.Marshal(returnData)
 				fmt.Fprintf(w, string(returnResponse))
 			} else {
@@ -86,3 +96,515 @@ func readAuthorityAPI(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// importAuthorityAPI handles the POST /v1/authority/import endpoint
+func importAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	caImport := RESTPOSTImportCAJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&caImport)
+	check(err)
+
+	// The parent CA Path is optional, without one the CA is imported as a top level CA
+	var parentPath string
+	var absPath string
+	if caImport.CommonNamePath != "" {
+		parentPath = splitCACNChainToPath(caImport.CommonNamePath)
+	}
+	if caImport.SlugPath != "" {
+		parentPath = splitCACNChainToPath(caImport.SlugPath)
+	}
+	if parentPath != "" {
+		absPath, err = filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + parentPath)
+		checkAndFail(err)
+
+		caParentPathExists, err := DirectoryExists(absPath)
+		check(err)
+		if !caParentPathExists {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-parent-path",
+				Errors:   []string{"Invalid parent path, no chain exists!"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+	}
+
+	caImported, messages, caCert, err := importCA(caImport, absPath)
+	if !caImported {
+		logNeworkRequestStdOut("ca-import-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   messages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	sluggedName := slugger(caCert.Subject.CommonName)
+	caPath := readConfig.Locksmith.PKIRoot + "/roots/" + sluggedName
+	if absPath != "" {
+		caPath = absPath + "/intermed-ca/" + sluggedName
+	}
+
+	logNeworkRequestStdOut(caCert.Subject.CommonName+" ("+sluggedName+") ca-imported", r)
+	returnData := &ReturnPostRoots{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: messages,
+		Root: RootInfo{
+			Slug:     sluggedName,
+			CertInfo: caCert,
+			Serial:   readSerialNumberAbs(caPath + "/ca.serial")}}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// renewAuthorityAPI handles the POST /v1/authority/renew endpoint
+func renewAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	renewOrRekeyAuthority(w, r, false)
+}
+
+// rekeyAuthorityAPI handles the POST /v1/authority/rekey endpoint
+func rekeyAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	renewOrRekeyAuthority(w, r, true)
+}
+
+// renewOrRekeyAuthority issues a new certificate for the CA at the submitted CA Path, with a new key pair on rekey
+func renewOrRekeyAuthority(w http.ResponseWriter, r *http.Request, rekey bool) {
+	var caPath string
+	var caPathRaw string
+
+	caRenewal := RESTPOSTRenewCAJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&caRenewal)
+	check(err)
+
+	if caRenewal.CommonNamePath != "" {
+		caPath = splitCACNChainToPath(caRenewal.CommonNamePath)
+		caPathRaw = caRenewal.CommonNamePath
+	}
+	if caRenewal.SlugPath != "" {
+		caPath = splitCACNChainToPath(caRenewal.SlugPath)
+		caPathRaw = caRenewal.SlugPath
+	}
+
+	// Neither options are submitted - error
+	if caPath == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "missing-parent-path",
+			Errors:   []string{"Missing parent path!  Must supply either `cn_path` or `slug_path`"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	absPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + caPath)
+	checkAndFail(err)
+
+	caPathExists, err := DirectoryExists(absPath)
+	check(err)
+	if !caPathExists {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-parent-path",
+			Errors:   []string{"Invalid parent path, no chain exists!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// Archived CAs, and CAs under one, are not renewed or rekeyed
+	archiveMessages, err := checkCANotArchived(absPath)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   archiveMessages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// CAs created in a key ceremony are opened with the key shares of their custodians
+	operation := "renew"
+	if rekey {
+		operation = "rekey"
+	}
+	keyShareMessages, err := keyPassphrasesForRenewal(absPath, &caRenewal, operation)
+	if err != nil {
+		logNeworkRequestStdOut("ca-renewal-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   keyShareMessages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	caRenewed, messages, renewed, err := renewCA(absPath, caRenewal, rekey)
+	if !caRenewed {
+		logNeworkRequestStdOut("ca-renewal-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   messages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	logNeworkRequestStdOut(renewed.Certificate.Subject.CommonName+" ("+caPathRaw+") ca-renewed", r)
+	returnData := &RESTPOSTRenewCAJSONReturn{
+		Status:          "success",
+		Errors:          []string{},
+		Messages:        messages,
+		Slug:            caPathRaw,
+		ArchivedVersion: renewed.Version,
+		CertificatePEM:  B64EncodeBytesToStr(pemEncodeCertificate(renewed.Certificate.Raw).Bytes()),
+		CertificateInfo: renewed.Certificate}
+	if rekey {
+		returnData.OldWithNewPEM = B64EncodeBytesToStr(pemEncodeCertificate(renewed.OldWithNew.Raw).Bytes())
+		returnData.NewWithOldPEM = B64EncodeBytesToStr(pemEncodeCertificate(renewed.NewWithOld.Raw).Bytes())
+	}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// crossSignAuthorityAPI handles the POST /v1/authority/cross-sign endpoint
+func crossSignAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	var caPath string
+	var caPathRaw string
+
+	crossSign := RESTPOSTCrossSignJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&crossSign)
+	check(err)
+
+	if crossSign.CommonNamePath != "" {
+		caPath = splitCACNChainToPath(crossSign.CommonNamePath)
+		caPathRaw = crossSign.CommonNamePath
+	}
+	if crossSign.SlugPath != "" {
+		caPath = splitCACNChainToPath(crossSign.SlugPath)
+		caPathRaw = crossSign.SlugPath
+	}
+
+	// Neither options are submitted - error
+	if caPath == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "missing-parent-path",
+			Errors:   []string{"Missing parent path!  Must supply either `cn_path` or `slug_path`"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	absPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + caPath)
+	checkAndFail(err)
+
+	caPathExists, err := DirectoryExists(absPath)
+	check(err)
+	if !caPathExists {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-parent-path",
+			Errors:   []string{"Invalid parent path, no chain exists!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// Archived CAs, and CAs under one, do not cross-sign
+	archiveMessages, err := checkCANotArchived(absPath)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   archiveMessages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// A signing CA created in a key ceremony is opened with the key shares of its custodians
+	signingPassphrase, keyShareMessages, err := keyPassphraseForCA(absPath, crossSign.SigningPrivateKeyPassphrase, crossSign.SigningKeyShares, "cross-sign", crossSign.SubjectCommonNamePath+crossSign.SubjectSlugPath)
+	if err != nil {
+		logNeworkRequestStdOut("ca-cross-sign-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   keyShareMessages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	crossSign.SigningPrivateKeyPassphrase = signingPassphrase
+
+	crossSigned, messages, crossCert, err := crossSignCA(absPath, crossSign)
+	if !crossSigned {
+		logNeworkRequestStdOut("ca-cross-sign-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   messages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	certificateID := crossSignedCertificateID(&crossCert)
+	logNeworkRequestStdOut(crossCert.Subject.CommonName+" ("+certificateID+") ca-cross-signed in '"+caPathRaw+"'", r)
+	returnData := &RESTPOSTCrossSignJSONReturn{
+		Status:          "success",
+		Errors:          []string{},
+		Messages:        messages,
+		Slug:            caPathRaw,
+		CertificateID:   certificateID,
+		CertificatePEM:  B64EncodeBytesToStr(pemEncodeCertificate(crossCert.Raw).Bytes()),
+		CertificateInfo: &crossCert}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// resolveAuthorityPath finds the CA directory of a request by its CA Path, writing the error response if there is none
+func resolveAuthorityPath(w http.ResponseWriter, commonNamePath string, slugPath string) (string, string, bool) {
+	var caPath string
+	var caPathRaw string
+	if commonNamePath != "" {
+		caPath = splitCACNChainToPath(commonNamePath)
+		caPathRaw = commonNamePath
+	}
+	if slugPath != "" {
+		caPath = splitCACNChainToPath(slugPath)
+		caPathRaw = slugPath
+	}
+
+	// Neither options are submitted - error
+	if caPath == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "missing-parent-path",
+			Errors:   []string{"Missing parent path!  Must supply either `cn_path` or `slug_path`"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return "", "", false
+	}
+
+	absPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + caPath)
+	checkAndFail(err)
+
+	caPathExists, err := DirectoryExists(absPath)
+	check(err)
+	if !caPathExists {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-parent-path",
+			Errors:   []string{"Invalid parent path, no chain exists!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return "", "", false
+	}
+	return absPath, caPathRaw, true
+}
+
+// readUnlockSessionAPI handles the GET /v1/authority/unlock endpoint
+func readUnlockSessionAPI(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	absPath, caPathRaw, ok := resolveAuthorityPath(w, queryParams.Get("cn_path"), queryParams.Get("slug_path"))
+	if !ok {
+		return
+	}
+
+	offline, err := isCAOffline(absPath)
+	check(err)
+	returnData := &RESTUnlockSessionJSONReturn{
+		Status:   "locked",
+		Errors:   []string{},
+		Messages: []string{"CA '" + caPathRaw + "' is locked"},
+		Slug:     caPathRaw,
+		Offline:  offline}
+	if !offline {
+		returnData.Status = "online"
+		returnData.Messages = []string{"CA '" + caPathRaw + "' is not offline"}
+	} else if session, unlocked := unlockSessionForCA(absPath); unlocked {
+		returnData.Status = "unlocked"
+		returnData.Messages = []string{"CA '" + caPathRaw + "' is unlocked until " + session.ExpiresAt.Format(time.RFC3339)}
+		returnData.Session = &session
+	}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// unlockAuthorityAPI handles the POST /v1/authority/unlock endpoint
+func unlockAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	unlockRequest := RESTPOSTUnlockCAJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&unlockRequest)
+	check(err)
+
+	absPath, caPathRaw, ok := resolveAuthorityPath(w, unlockRequest.CommonNamePath, unlockRequest.SlugPath)
+	if !ok {
+		return
+	}
+
+	duration := defaultUnlockSessionDuration
+	if unlockRequest.Duration != "" {
+		duration, err = time.ParseDuration(unlockRequest.Duration)
+		if err != nil {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-duration",
+				Errors:   []string{"Duration '" + unlockRequest.Duration + "' is not a valid duration, eg 15m"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+	}
+
+	// Archived CAs do not sign, so there is nothing to unlock them for
+	archiveMessages, err := checkCANotArchived(absPath)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   archiveMessages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// CAs created in a key ceremony are unlocked with the key shares of their custodians
+	passphrase, keyShareMessages, err := keyPassphraseForCA(absPath, unlockRequest.RSAPrivateKeyPassphrase, unlockRequest.KeyShares, "unlock", caPathRaw)
+	if err != nil {
+		logNeworkRequestStdOut("ca-unlock-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   keyShareMessages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	session, messages, err := unlockCA(absPath, passphrase, duration)
+	if err != nil {
+		logNeworkRequestStdOut("ca-unlock-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   messages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	logNeworkRequestStdOut("'"+caPathRaw+"' unlocked with session "+session.ID+" until "+session.ExpiresAt.Format(time.RFC3339), r)
+	returnData := &RESTUnlockSessionJSONReturn{
+		Status:   "unlocked",
+		Errors:   []string{},
+		Messages: messages,
+		Slug:     caPathRaw,
+		Offline:  true,
+		Session:  &session}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// lockAuthorityAPI handles the POST /v1/authority/lock endpoint
+func lockAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	lockRequest := RESTPOSTLockCAJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&lockRequest)
+	check(err)
+
+	absPath, caPathRaw, ok := resolveAuthorityPath(w, lockRequest.CommonNamePath, lockRequest.SlugPath)
+	if !ok {
+		return
+	}
+
+	session, err := endUnlockSession(absPath, "locked")
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   []string{"CA '" + caPathRaw + "' has no open unlock session"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	logNeworkRequestStdOut("'"+caPathRaw+"' locked, ending session "+session.ID, r)
+	returnData := &RESTUnlockSessionJSONReturn{
+		Status:   "locked",
+		Errors:   []string{},
+		Messages: []string{fmt.Sprintf("Locked CA '%s' after %d signatures", caPathRaw, len(session.Signings))},
+		Slug:     caPathRaw,
+		Offline:  true,
+		Session:  &session}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// archiveAuthorityAPI handles the POST /v1/authority/archive endpoint
+func archiveAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	archiveRequest := RESTPOSTArchiveCAJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&archiveRequest)
+	check(err)
+
+	absPath, caPathRaw, ok := resolveAuthorityPath(w, archiveRequest.CommonNamePath, archiveRequest.SlugPath)
+	if !ok {
+		return
+	}
+
+	messages, err := archiveCA(absPath)
+	if err != nil {
+		logNeworkRequestStdOut("ca-archive-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   messages,
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	logNeworkRequestStdOut("'"+caPathRaw+"' ca-archived", r)
+	returnData := &ReturnGenericMessage{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: []string{"Archived CA '" + caPathRaw + "'"}}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// deleteAuthorityAPI handles the DELETE /v1/authority endpoint
+func deleteAuthorityAPI(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	absPath, caPathRaw, ok := resolveAuthorityPath(w, queryParams.Get("cn_path"), queryParams.Get("slug_path"))
+	if !ok {
+		return
+	}
+	force := queryParams.Get("force") == "true"
+
+	archivePath, messages, err := deleteCA(absPath, force)
+	if err != nil {
+		logNeworkRequestStdOut("ca-deletion-error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   []string{"CA '" + caPathRaw + "' can not be deleted!"},
+			Messages: messages}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	logNeworkRequestStdOut("'"+caPathRaw+"' ca-deleted, moved to "+archivePath, r)
+	returnData := &RESTDELETEAuthorityJSONReturn{
+		Status:      "success",
+		Errors:      []string{},
+		Messages:    append([]string{"Deleted CA '" + caPathRaw + "'"}, messages...),
+		Slug:        caPathRaw,
+		ArchivePath: archivePath}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
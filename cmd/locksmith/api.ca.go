@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"path/filepath"
 )
 
@@ -35,6 +36,7 @@ func createNewRootCAAPI(w http.ResponseWriter, r *http.Request) {
 			Root:     RootInfo{}}
 		returnResponse, _ := json.Marshal(returnData)
 		fmt.Fprintf(w, string(returnResponse))
+		return
 	}
 	sluggedName := slugger(caName)
 
@@ -60,10 +62,50 @@ func createNewRootCAAPI(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, string(returnResponse))
 	} else {
 
+		// Hold a key ceremony, the key passphrase is generated and split between the custodians
+		var keyCeremony KeyCeremony
+		var keyShares []KeyShare
+		if certInfo.KeyCeremony != nil {
+			if err := validateKeyCeremony(certInfo.KeyCeremony, certInfo.RSAPrivateKeyPassphrase); err != nil {
+				logNeworkRequestStdOut(caName+" ("+sluggedName+") key-ceremony-error", r)
+				returnData := &ReturnPostRoots{
+					Status:   "key-ceremony-error",
+					Errors:   []string{err.Error()},
+					Messages: []string{},
+					Root:     RootInfo{}}
+				returnResponse, _ := json.Marshal(returnData)
+				fmt.Fprintf(w, string(returnResponse))
+				return
+			}
+			certInfo.RSAPrivateKeyPassphrase, keyCeremony, keyShares, err = holdKeyCeremony(certInfo.KeyCeremony)
+			if err != nil {
+				logNeworkRequestStdOut(caName+" ("+sluggedName+") key-ceremony-error", r)
+				returnData := &ReturnPostRoots{
+					Status:   "key-ceremony-error",
+					Errors:   []string{err.Error()},
+					Messages: []string{"Root CA Key Ceremony Failure"},
+					Root:     RootInfo{}}
+				returnResponse, _ := json.Marshal(returnData)
+				fmt.Fprintf(w, string(returnResponse))
+				return
+			}
+		}
+
 		// Generate a new Certificate Authority
 		newCAState, newCA, caCert, err := createNewCA(certInfo)
 		check(err)
 
+		if newCAState && certInfo.KeyCeremony != nil {
+			err = writeKeyCeremony(checkForRootPath, keyCeremony, &caCert)
+			if err != nil {
+				newCAState = false
+				newCA = []string{"Root CA Key Ceremony Failure"}
+			}
+		}
+		if !newCAState && err == nil {
+			err = Stoerr("root-creation-error")
+		}
+
 		if newCAState {
 
 			logNeworkRequestStdOut(caName+" ("+sluggedName+") root-created", r)
@@ -74,20 +116,23 @@ func createNewRootCAAPI(w http.ResponseWriter, r *http.Request) {
 				Root: RootInfo{
 					Slug:     sluggedName,
 					CertInfo: caCert,
-					Serial:   readSerialNumber(sluggedName)}}
+					Serial:   readSerialNumber(sluggedName)},
+				KeyShares: keyShares}
 			returnResponse, _ := json.Marshal(returnData)
 			fmt.Fprintf(w, string(returnResponse))
 
 		} else {
 
+			// A half created Root CA would block creating it again, and one from a key ceremony could never be opened as its key shares are not handed out
+			check(os.RemoveAll(checkForRootPath))
+
 			logNeworkRequestStdOut(caName+" ("+sluggedName+") root-creation-error", r)
 			returnData := &ReturnPostRoots{
 				Status:   "root-creation-error",
 				Errors:   []string{err.Error()},
 				Messages: newCA,
 				Root: RootInfo{
-					Slug:   sluggedName,
-					Serial: readSerialNumber(sluggedName)}}
+					Slug: sluggedName}}
 			returnResponse, _ := json.Marshal(returnData)
 			fmt.Fprintf(w, string(returnResponse))
 
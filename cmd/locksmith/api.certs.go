@@ -2,7 +2,6 @@ package locksmith
 
 import (
 	"crypto/x509"
-	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -76,13 +75,18 @@ func readCertificateAPI(w http.ResponseWriter, r *http.Request) {
 					certificate, err := x509.ParseCertificate(pem.Bytes)
 					check(err)
 
+					// Decode the certificate policy extensions, which x509.Certificate only holds the OIDs of
+					certificatePolicies, err := parseCertificatePolicies(certificate)
+					check(err)
+
 					returnData := &RESTGETCertificateInformationJSONReturn{
-						Status:          "success",
-						Errors:          []string{},
-						Messages:        []string{"Certificate information for '" + parentPathRaw + "'"},
-						Slug:            certificateID,
-						CertificatePEM:  B64EncodeBytesToStr(pem.Bytes),
-						CertificateInfo: certificate}
+						Status:              "success",
+						Errors:              []string{},
+						Messages:            []string{"Certificate information for '" + parentPathRaw + "'"},
+						Slug:                certificateID,
+						CertificatePEM:      B64EncodeBytesToStr(pem.Bytes),
+						CertificateInfo:     certificate,
+						CertificatePolicies: certificatePolicies}
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
 
@@ -111,6 +115,102 @@ func readCertificateAPI(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// exportCertificatePKCS12API handles the POST /v1/certificate/pkcs12 endpoint
+// The key passphrase and export password are read from the JSON body so they do not end up in URLs and request logs
+func exportCertificatePKCS12API(w http.ResponseWriter, r *http.Request) {
+	exportRequest := RESTPOSTCertificatePKCS12JSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&exportRequest)
+	check(err)
+
+	absPath, parentPathRaw, ok := resolveAuthorityPath(w, exportRequest.CommonNamePath, exportRequest.SlugPath)
+	if !ok {
+		return
+	}
+
+	certificateID := slugger(exportRequest.CertificateID)
+	passphrase := exportRequest.Passphrase
+	exportPassword := exportRequest.ExportPassword
+
+	// certificateID has to be present and not null
+	if certificateID == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "missing-certificate-id",
+			Errors:   []string{"Missing Certificate ID!  Must supply `certificate_id`"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// The bundle has to be protected with an export password
+	if exportPassword == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "missing-export-password",
+			Errors:   []string{"Missing export password!  Must supply `export_password`"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	certificate, err := ReadCertFromFile(absPath + "/certs/" + certificateID + ".pem")
+	check(err)
+	if certificate == nil {
+		// Certificate does not exist
+		returnData := &ReturnGenericMessage{
+			Status:   "no-certificate",
+			Errors:   []string{},
+			Messages: []string{"Certificate '" + exportRequest.CertificateID + "' PEM File does not exists in '" + parentPathRaw + "'!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	privKey, err := ReadPrivateKey(absPath+"/keys/"+certificateID+".priv.pem", passphrase)
+	if err != nil {
+		// Key is there but could not be opened, most likely a bad passphrase
+		logNeworkRequestStdOut(certificateID+" pkcs12 private key error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   "private-key-decryption-error",
+			Errors:   []string{"Private Key for Certificate '" + exportRequest.CertificateID + "' could not be loaded in '" + parentPathRaw + "'!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	if privKey == nil {
+		// Certificates signed from an outside CSR have no key stored with the CA
+		returnData := &ReturnGenericMessage{
+			Status:   "no-private-key",
+			Errors:   []string{"No Private Key is stored for Certificate '" + exportRequest.CertificateID + "' in '" + parentPathRaw + "'!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	pfxData, err := createPKCS12Bundle(certificate, privKey, generateCABundle(parentPathRaw), exportPassword)
+	if err != nil {
+		logNeworkRequestStdOut(certificateID+" pkcs12 error: "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   "pkcs12-creation-error",
+			Errors:   []string{"Error creating PKCS #12 bundle for Certificate '" + exportRequest.CertificateID + "' in '" + parentPathRaw + "'!", err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	returnData := &RESTPOSTCertificatePKCS12JSONReturn{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: []string{"PKCS #12 bundle for Certificate '" + exportRequest.CertificateID + "' in '" + parentPathRaw + "'"},
+		Slug:     certificateID,
+		PKCS12:   B64EncodeBytesToStr(pfxData)}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
 // listCertsAPI handles the GET /v1/certificates endpoint
 func listCertsAPI(w http.ResponseWriter, r *http.Request) {
 	var parentPath string
@@ -202,16 +302,18 @@ func createNewCertAPI(w http.ResponseWriter, r *http.Request) {
 		parentPathRaw = certInfo.SlugPath
 	}
 
-	// Check to see if the CSR is passed in via base64 encoded input
+	// Check to see if the CSR is passed in as a PEM, or a base64 encoded PEM or DER
 	if certInfo.CertificateRequestInput.FromPEM != "" {
-		csrSource, err := b64.StdEncoding.DecodeString(certInfo.CertificateRequestInput.FromPEM)
-		check(err)
-
-		csrPEM, err := decodeByteSliceToPEM(csrSource, "CERTIFICATE REQUEST")
-		check(err)
-
-		csr, err = readCSR(csrPEM.Bytes)
-		check(err)
+		csr, err = parseSubmittedCSR(certInfo.CertificateRequestInput.FromPEM)
+		if err != nil {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-csr",
+				Errors:   []string{"Invalid CSR, expecting a PEM or a base64 encoded PEM or DER in '.csr_input.from_pem'!"},
+				Messages: []string{err.Error()}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
 	}
 	// Check to see if we can retrieve the CSR from the file system
 	if certInfo.CertificateRequestInput.FromCAPath.Target != "" && certInfo.CertificateRequestInput.FromCAPath.CNPath != "" {
@@ -275,6 +377,18 @@ func createNewCertAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The CSR has to be signed by the key it carries, which has to be a key certificates are issued for
+	csrMessages, err := verifyCSR(csr)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   []string{"CSR for " + csr.Subject.CommonName + " can not be signed!"},
+			Messages: csrMessages}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
 	// Neither options are submitted - error
 	if parentPath == "" {
 		returnData := &ReturnGenericMessage{
@@ -325,27 +439,62 @@ func createNewCertAPI(w http.ResponseWriter, r *http.Request) {
 	}
 	// Cert does not exist, go ahead with creation
 
-	// Make sure the CSR PublicKey was passed in as a base64 encoded string
-	if certInfo.CertificateRequestInput.PublicKey == "" {
-		// empty csr public key
-		logNeworkRequestStdOut(certName+" ("+sluggedCertCommonName+") empty public key", r)
+	// The certificate is issued for the key of the CSR, a public key passed along has to be the same key
+	if certInfo.CertificateRequestInput.PublicKey != "" {
+		publicKeyMessages, err := checkCSRPublicKeyMatches(csr, certInfo.CertificateRequestInput.PublicKey)
+		if err != nil {
+			logNeworkRequestStdOut(certName+" ("+sluggedCertCommonName+") "+err.Error(), r)
+			returnData := &ReturnGenericMessage{
+				Status:   err.Error(),
+				Errors:   []string{"Certificate public key does not match the CSR!"},
+				Messages: publicKeyMessages}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+	}
+
+	// Refuse certificate types without a built-in or config.yml profile before the Signing CA key is opened
+	if _, err := certificateProfileForType(certInfo.CertificateRequestInput.CertificateType); err != nil {
+		logNeworkRequestStdOut(certName+" ("+sluggedCertCommonName+") "+err.Error(), r)
 		returnData := &ReturnGenericMessage{
-			Status:   "empty-public-key",
-			Errors:   []string{"Certificate public key empty - provide with '.csr_input.public_key'!"},
-			Messages: []string{}}
+			Status:   err.Error(),
+			Errors:   []string{"Unknown certificate type '" + certInfo.CertificateRequestInput.CertificateType + "'!"},
+			Messages: []string{"Certificate types are " + strings.Join(certificateTypes(), "|")}}
 		returnResponse, _ := json.Marshal(returnData)
 		fmt.Fprintf(w, string(returnResponse))
 		return
 	}
 
-	decodedPublicKey, err := B64DecodeStrToBytes(certInfo.CertificateRequestInput.PublicKey)
-	check(err)
+	// Archived CAs, and CAs under one, do not issue certificates
+	archiveMessages, err := checkCANotArchived(absPath)
+	if err != nil {
+		logNeworkRequestStdOut(certName+" ("+sluggedCertCommonName+") "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   []string{"Certificate " + certName + " can not be signed by '" + parentPathRaw + "'!"},
+			Messages: archiveMessages}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
 
-	_, pubKeyPEMBytes := DecodePublicKeyPem(decodedPublicKey)
-	csrPublicKey := parsePublicKey(pubKeyPEMBytes)
+	// A signing CA created in a key ceremony is opened with the key shares of its custodians
+	signingPassphrase, keyShareMessages, err := keyPassphraseForCA(absPath, certInfo.SigningPrivateKeyPassphrase, certInfo.SigningKeyShares, "certificate", certName)
+	if err != nil {
+		logNeworkRequestStdOut(certName+" ("+sluggedCertCommonName+") "+err.Error(), r)
+		returnData := &ReturnGenericMessage{
+			Status:   err.Error(),
+			Errors:   []string{"Certificate " + certName + " can not be signed by '" + parentPathRaw + "'!"},
+			Messages: keyShareMessages}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	certInfo.SigningPrivateKeyPassphrase = signingPassphrase
 
 	logNeworkRequestStdOut(certName+" ("+sluggedCertCommonName+") Creating certificate in '"+parentPathRaw+"'", r)
-	certCreated, certificate, messages, err := createNewCertificateFromCSR(absPath, certInfo.SigningPrivateKeyPassphrase, csr, certInfo.CertificateRequestInput.CertificateType, csrPublicKey, certInfo.ExpirationDate)
+	certCreated, certificate, messages, err := createNewCertificateFromCSR(absPath, certInfo.SigningPrivateKeyPassphrase, csr, certInfo.CertificateRequestInput.CertificateType, csr.PublicKey, certInfo.ExpirationDate)
 	check(err)
 
 	if !certCreated {
@@ -0,0 +1,55 @@
+package locksmith
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportCertificatePKCS12API(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, _ := createTestRootCA(t, testCertificateConfiguration("PKCS12 Root CA", "ecdsa"))
+	csr, privKey := createTestCSR(t, "OpenVPN Server", "ecdsa", nil)
+	if created, _, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0}); err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+
+	// The key is stored with the CA encrypted, as for a Certificate Request Locksmith generated
+	_, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(privKey, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writeRSAKeyPair(encryptedPrivateKeyBytes, pemEncodePublicKey(privKey.Public()), caPath+"/keys/openvpn-server"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus string
+	}{
+		{"exported", `{"cn_path": "PKCS12 Root CA", "certificate_id": "OpenVPN Server", "passphrase": "s3cr3t", "export_password": "exp0rt"}`, "success"},
+		{"wrong passphrase", `{"cn_path": "PKCS12 Root CA", "certificate_id": "OpenVPN Server", "passphrase": "wrong", "export_password": "exp0rt"}`, "private-key-decryption-error"},
+		{"no export password", `{"cn_path": "PKCS12 Root CA", "certificate_id": "OpenVPN Server", "passphrase": "s3cr3t"}`, "missing-export-password"},
+		{"no certificate", `{"cn_path": "PKCS12 Root CA", "certificate_id": "Missing Server", "export_password": "exp0rt"}`, "no-certificate"},
+		{"no CA path", `{"certificate_id": "OpenVPN Server", "export_password": "exp0rt"}`, "missing-parent-path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			exportCertificatePKCS12API(recorder, httptest.NewRequest("POST", "/locksmith/v1/certificate/pkcs12", strings.NewReader(tt.body)))
+
+			response := RESTPOSTCertificatePKCS12JSONReturn{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Status != tt.wantStatus {
+				t.Fatalf("got status %s %v, want %s", response.Status, response.Errors, tt.wantStatus)
+			}
+			if tt.wantStatus == "success" && response.PKCS12 == "" {
+				t.Fatal("no PKCS #12 bundle returned")
+			}
+		})
+	}
+}
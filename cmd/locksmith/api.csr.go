@@ -146,7 +146,7 @@ func createNewCSRAPI(w http.ResponseWriter, r *http.Request) {
 
 				if csrCreated {
 					logNeworkRequestStdOut(csrName+" ("+sluggedCSRCommonName+") csr-created in '"+parentPathRaw+"'", r)
-					pemEncodedPrivateKey, _ := pemEncodeRSAPrivateKey(keyPair.PrivateKey, csrInfo.CertificateConfiguration.RSAPrivateKeyPassphrase)
+					pemEncodedPrivateKey, _, err := pemEncodePrivateKey(keyPair.PrivateKey, csrInfo.CertificateConfiguration.RSAPrivateKeyPassphrase)
 
 					check(err)
 					returnData := &RESTPOSTCertificateRequestJSONReturn{
@@ -158,7 +158,7 @@ func createNewCSRAPI(w http.ResponseWriter, r *http.Request) {
 							CertificateRequest:    csrCert,
 							CertificateRequestPEM: B64EncodeBytesToStr(pemEncodeCSR(csrCert.Raw).Bytes()),
 							KeyPair: KeyPair{
-								PublicKey:  B64EncodeBytesToStr(pemEncodeRSAPublicKey(keyPair.PublicKey).Bytes()),
+								PublicKey:  B64EncodeBytesToStr(pemEncodePublicKey(keyPair.PublicKey).Bytes()),
 								PrivateKey: B64EncodeBytesToStr(pemEncodedPrivateKey.Bytes())}}}
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
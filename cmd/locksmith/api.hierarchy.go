@@ -0,0 +1,52 @@
+package locksmith
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+)
+
+// readHierarchyAPI handles the GET /v1/hierarchy endpoint
+func readHierarchyAPI(w http.ResponseWriter, r *http.Request) {
+	var caPaths []string
+	var parentSlugPath string
+	var parentCommonNamePath string
+	message := "Hierarchy of all Certificate Authorities"
+
+	// Scope the tree to a CA and everything under it when a CA Path is submitted
+	queryParams := r.URL.Query()
+	if queryParams.Get("cn_path") != "" || queryParams.Get("slug_path") != "" {
+		absPath, caPathRaw, ok := resolveAuthorityPath(w, queryParams.Get("cn_path"), queryParams.Get("slug_path"))
+		if !ok {
+			return
+		}
+		caPaths = []string{absPath}
+		parentSlugPath, parentCommonNamePath = caHierarchyParentPaths(absPath)
+		message = "Hierarchy of Certificate Authorities under '" + caPathRaw + "'"
+	} else {
+		rootsPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots")
+		checkAndFail(err)
+		roots, err := ioutil.ReadDir(rootsPath)
+		check(err)
+		for _, root := range roots {
+			if root.IsDir() {
+				caPaths = append(caPaths, rootsPath+"/"+root.Name())
+			}
+		}
+	}
+
+	hierarchy := []CAHierarchyNode{}
+	for _, caPath := range caPaths {
+		hierarchy = append(hierarchy, readCAHierarchy(caPath, parentSlugPath, parentCommonNamePath))
+	}
+
+	returnData := &RESTGETHierarchyJSONReturn{
+		Status:    "success",
+		Errors:    []string{},
+		Messages:  []string{message},
+		Hierarchy: hierarchy}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
@@ -118,8 +118,75 @@ func createNewIntermediateCAAPI(w http.ResponseWriter, r *http.Request) {
 				// TODO:
 				// If the intermediate doesn't exist, check the parent signing key and see if it's password protected - decrypt if needed
 
+				// Archived CAs, and CAs under one, do not sign Intermediate CAs
+				archiveMessages, err := checkCANotArchived(absPath)
+				if err != nil {
+					logNeworkRequestStdOut(caName+" ("+sluggedName+") "+err.Error(), r)
+					returnData := &ReturnGenericMessage{
+						Status:   err.Error(),
+						Errors:   []string{"Intermediate CA '" + caName + "' can not be signed by its parent CA!"},
+						Messages: archiveMessages}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
+
+				// An offline parent CA has to be unlocked to sign the Intermediate CA
+				unlockMessages, err := checkCAUnlocked(absPath)
+				if err != nil {
+					logNeworkRequestStdOut(caName+" ("+sluggedName+") "+err.Error(), r)
+					returnData := &ReturnGenericMessage{
+						Status:   err.Error(),
+						Errors:   []string{"Intermediate CA '" + caName + "' can not be signed by its parent CA!"},
+						Messages: unlockMessages}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
+
+				// Make sure the CAs above allow another CA to be chained under them
+				pathLength, pathLengthMessages, err := checkPathLengthForCA(absPath, caPathLength(intermedCAInfo.CertificateConfiguration))
+				if err != nil {
+					logNeworkRequestStdOut(caName+" ("+sluggedName+") "+err.Error(), r)
+					returnData := &ReturnGenericMessage{
+						Status:   "path-length-exceeded",
+						Errors:   []string{"Intermediate CA '" + caName + "' can not be chained under its parent CA!"},
+						Messages: pathLengthMessages}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
+				if intermedCAInfo.CertificateConfiguration.MaxPathLength == nil {
+					intermedCAInfo.CertificateConfiguration.MaxPathLength = pathLength
+				}
+
+				// Only Root CAs are created in a key ceremony
+				if intermedCAInfo.CertificateConfiguration.KeyCeremony != nil {
+					returnData := &ReturnGenericMessage{
+						Status:   "key-ceremony-error",
+						Errors:   []string{"Key ceremonies are only held for Root CAs!"},
+						Messages: []string{}}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
+
+				// A parent CA created in a key ceremony is opened with the key shares of its custodians
+				signingPassphrase, keyShareMessages, err := keyPassphraseForCA(absPath, intermedCAInfo.SigningPrivateKeyPassphrase, intermedCAInfo.SigningKeyShares, "intermediate-ca", caName)
+				if err != nil {
+					logNeworkRequestStdOut(caName+" ("+sluggedName+") "+err.Error(), r)
+					returnData := &ReturnGenericMessage{
+						Status:   err.Error(),
+						Errors:   []string{"Intermediate CA '" + caName + "' can not be signed by its parent CA!"},
+						Messages: keyShareMessages}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
+				intermedCAInfo.SigningPrivateKeyPassphrase = signingPassphrase
+
 				logNeworkRequestStdOut(caName+" ("+sluggedName+") creating intermediate ca", r)
-				icaCreated, _, icaCert, err := createNewIntermediateCA(intermedCAInfo, absPath)
+				icaCreated, icaMessages, icaCert, err := createNewIntermediateCA(intermedCAInfo, absPath)
 				check(err)
 				if icaCreated {
 					logNeworkRequestStdOut(caName+" ("+sluggedName+") intermed-ca-created", r)
@@ -138,7 +205,7 @@ func createNewIntermediateCAAPI(w http.ResponseWriter, r *http.Request) {
 					returnData := &ReturnGenericMessage{
 						Status:   "intermed-ca-creation-error",
 						Errors:   []string{"Error creating Intermediate CA '" + caName + "'!"},
-						Messages: []string{}}
+						Messages: icaMessages}
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
 				}
@@ -1,12 +1,13 @@
 package locksmith
 
 import (
-	"bytes"
-	b64 "encoding/base64"
+	"crypto"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // listKeyPairsAPI handles the GET /v1/keys endpoint
@@ -16,13 +17,29 @@ func listKeyPairsAPI(w http.ResponseWriter, r *http.Request) {
 	// Read in the submitted parameters
 	queryParams := r.URL.Query()
 	keyStoreID, presentKSID := queryParams["key_store_id"]
+	selector, presentSelector := queryParams["selector"]
 
 	if presentKSID {
-		sluggedKeyStoreID = slugger(keyStoreID[0])
+		sluggedKeyStoreID = resolveKeyStoreID(slugger(keyStoreID[0]))
 	} else {
 		sluggedKeyStoreID = "default"
 	}
 
+	var labelRequirements []labelRequirement
+	if presentSelector {
+		requirements, err := parseLabelSelector(selector[0])
+		if err != nil {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-label-selector",
+				Errors:   []string{err.Error()},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+		labelRequirements = requirements
+	}
+
 	keyStorePath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/")
 	check(err)
 
@@ -40,14 +57,28 @@ func listKeyPairsAPI(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Key store exists, proceed
 
-		keyPairs := DirectoryListingNames(readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/")
+		keyPairs := listKeyPairs(keyStorePath)
 		if len(keyPairs) > 0 {
-			// Return list of key pair ids (dirs lol) in the key store
+			// Return list of key pair ids (dirs lol) in the key store with their metadata, filtered by the label selector
+			matchingKeyPairs := []string{}
+			keyPairsMetadata := []KeyPairMetadata{}
+			for _, keyPair := range keyPairs {
+				metadata, err := readKeyPairMetadata(keyStorePath + "/" + keyPair)
+				if err != nil {
+					metadata = KeyPairMetadata{ID: keyPair}
+				}
+				if matchesLabelSelector(metadata.Labels, labelRequirements) {
+					matchingKeyPairs = append(matchingKeyPairs, keyPair)
+					keyPairsMetadata = append(keyPairsMetadata, metadata)
+				}
+			}
+
 			returnData := &RESTGETKeyPairsJSONReturn{
 				Status:   "success",
 				Errors:   []string{},
 				Messages: []string{"Listing of Key Pair IDs in Key Store '" + sluggedKeyStoreID + "'"},
-				KeyPairs: keyPairs}
+				KeyPairs: matchingKeyPairs,
+				Metadata: keyPairsMetadata}
 			returnResponse, _ := json.Marshal(returnData)
 			fmt.Fprintf(w, string(returnResponse))
 		} else {
@@ -72,16 +103,44 @@ func createKeyPairAPI(w http.ResponseWriter, r *http.Request) {
 	check(err)
 
 	if keyPairInfo.KeyStoreID != "" {
-		sluggedKeyStoreID = slugger(keyPairInfo.KeyStoreID)
+		sluggedKeyStoreID = resolveKeyStoreID(slugger(keyPairInfo.KeyStoreID))
 	} else {
 		sluggedKeyStoreID = "default"
 	}
 
 	passphrase = keyPairInfo.Passphrase
 
+	if err := validateLabels(keyPairInfo.Labels); err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-labels",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// Existing key pairs are imported rather than generated
+	if keyPairInfo.ImportPrivateKey != "" {
+		importKeyPairAPI(w, keyPairInfo, sluggedKeyStoreID)
+		return
+	}
+
+	// Make sure the requested key algorithm and size are supported
+	keyAlgorithm, keySize, err := normalizeKeyAlgorithm(keyPairInfo.KeyAlgorithm, keyPairInfo.KeySize)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-key-algorithm",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
 	if keyPairInfo.KeyPairID != "" {
 		basePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/" + slugger(keyPairInfo.KeyPairID)
-		pubKeyPath := basePath + "/rsa.pub.pem"
+		pubKeyPath := basePath + "/" + keyPairFilePrefix(basePath) + ".pub.pem"
 
 		// Check for certificate authority key pair
 		keyCheck, err := FileExists(pubKeyPath)
@@ -93,7 +152,7 @@ func createKeyPairAPI(w http.ResponseWriter, r *http.Request) {
 			// Create the directory
 			CreateDirectory(basePath)
 
-			privKey, pubKey, err := GenerateRSAKeypair(4096)
+			privKey, pubKey, err := GenerateKeypair(keyAlgorithm, keySize)
 			check(err)
 
 			if keyPairInfo.StorePrivateKey {
@@ -102,22 +161,27 @@ func createKeyPairAPI(w http.ResponseWriter, r *http.Request) {
 				var privKeyFile bool
 				var pubKeyFile bool
 
-				pemEncodedPrivateKey, encryptedPrivateKeyBytes := pemEncodeRSAPrivateKey(privKey, passphrase)
+				pemEncodedPrivateKey, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(privKey, passphrase)
+				if err != nil {
+					returnData := &ReturnGenericMessage{
+						Status:   "key-pair-generation-error",
+						Errors:   []string{err.Error()},
+						Messages: []string{"Key Pair generation error!"}}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
 				if passphrase != "" {
 					// Encrypt the PEM bytes
 					//logStdOut("passphrase!=nil pemEncodedPrivateKey: " + string(pemEncodedPrivateKey.Bytes()))
 					//logStdOut("passphrase!=nil encryptedPrivateKeyBytes: " + string(encryptedPrivateKeyBytes.Bytes()))
 
-					encStr := B64EncodeBytesToStr(encryptedPrivateKeyBytes.Bytes())
-					encBufferB := bytes.NewBufferString(encStr)
-
-					//logStdOut("encBuffer" + string(encBufferB.Bytes()))
-					privKeyFile, pubKeyFile, err = writeRSAKeyPair(encBufferB, pemEncodeRSAPublicKey(pubKey), basePath+"/rsa")
+					privKeyFile, pubKeyFile, err = writeRSAKeyPair(encryptedPrivateKeyBytes, pemEncodePublicKey(pubKey), basePath+"/"+keyAlgorithm)
 					check(err)
 				} else {
 					// No passphrase, store keys plain text
 					//logStdOut("passphrase==nil pemEncodedPrivateKey: " + string(pemEncodedPrivateKey.Bytes()))
-					privKeyFile, pubKeyFile, err = writeRSAKeyPair(pemEncodedPrivateKey, pemEncodeRSAPublicKey(pubKey), basePath+"/rsa")
+					privKeyFile, pubKeyFile, err = writeRSAKeyPair(pemEncodedPrivateKey, pemEncodePublicKey(pubKey), basePath+"/"+keyAlgorithm)
 					check(err)
 				}
 
@@ -130,21 +194,31 @@ func createKeyPairAPI(w http.ResponseWriter, r *http.Request) {
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
 				} else {
-					// All clear - pass keys
+					// All clear - record the first version and pass keys
+					check(writeKeyPairMetadata(basePath, keyPairMetadataFromInput(keyPairInfo, keyAlgorithm, keySize)))
 					returnData := &RESTPOSTNewKeyPairReturn{
 						Status:    "success",
 						Errors:    []string{},
 						Messages:  []string{"Successfully created Key Pair '" + slugger(keyPairInfo.KeyPairID) + "' in Key Store '" + sluggedKeyStoreID + "'!"},
 						KeyPairID: slugger(keyPairInfo.KeyPairID),
-						KeyPair:   KeyPair{PublicKey: B64EncodeBytesToStr(pemEncodeRSAPublicKey(pubKey).Bytes()), PrivateKey: B64EncodeBytesToStr(pemEncodedPrivateKey.Bytes())}}
+						KeyPair:   KeyPair{PublicKey: B64EncodeBytesToStr(pemEncodePublicKey(pubKey).Bytes()), PrivateKey: B64EncodeBytesToStr(pemEncodedPrivateKey.Bytes())}}
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
 				}
 
 			} else {
 				// Do NOT save the Private Key to the file system (the default case)
-				pemEncodedPrivateKey, _ := pemEncodeRSAPrivateKey(privKey, keyPairInfo.Passphrase)
-				pubKeyFile, err := writeKeyFile(pemEncodeRSAPublicKey(pubKey), basePath+"/rsa.pub.pem", 0644)
+				pemEncodedPrivateKey, _, err := pemEncodePrivateKey(privKey, keyPairInfo.Passphrase)
+				if err != nil {
+					returnData := &ReturnGenericMessage{
+						Status:   "key-pair-generation-error",
+						Errors:   []string{err.Error()},
+						Messages: []string{"Key Pair generation error!"}}
+					returnResponse, _ := json.Marshal(returnData)
+					fmt.Fprintf(w, string(returnResponse))
+					return
+				}
+				pubKeyFile, err := writeKeyFile(pemEncodePublicKey(pubKey), basePath+"/"+keyAlgorithm+".pub.pem", 0644)
 
 				if !pubKeyFile {
 					// Something messed up...
@@ -155,13 +229,14 @@ func createKeyPairAPI(w http.ResponseWriter, r *http.Request) {
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
 				} else {
-					// All clear - pass keys
+					// All clear - record the first version and pass keys
+					check(writeKeyPairMetadata(basePath, keyPairMetadataFromInput(keyPairInfo, keyAlgorithm, keySize)))
 					returnData := &RESTPOSTNewKeyPairReturn{
 						Status:    "success",
 						Errors:    []string{},
 						Messages:  []string{"Successfully created Key Pair '" + slugger(keyPairInfo.KeyPairID) + "' in Key Store '" + sluggedKeyStoreID + "'!"},
 						KeyPairID: slugger(keyPairInfo.KeyPairID),
-						KeyPair:   KeyPair{PublicKey: B64EncodeBytesToStr(pemEncodeRSAPublicKey(pubKey).Bytes()), PrivateKey: B64EncodeBytesToStr(pemEncodedPrivateKey.Bytes())}}
+						KeyPair:   KeyPair{PublicKey: B64EncodeBytesToStr(pemEncodePublicKey(pubKey).Bytes()), PrivateKey: B64EncodeBytesToStr(pemEncodedPrivateKey.Bytes())}}
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
 				}
@@ -181,155 +256,578 @@ func createKeyPairAPI(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// keyPairMetadataFromInput starts the metadata of a key pair created through the API
+func keyPairMetadataFromInput(keyPairInfo RESTPOSTNewKeyPairIn, keyAlgorithm string, keySize int) KeyPairMetadata {
+	metadata := newKeyPairMetadata(keyAlgorithm, keySize)
+	metadata.Description = keyPairInfo.Description
+	metadata.Owner = keyPairInfo.Owner
+	metadata.Labels = keyPairInfo.Labels
+	return metadata
+}
+
+// importKeyPairAPI handles the POST /v1/key endpoint when an existing key pair is submitted for import
+func importKeyPairAPI(w http.ResponseWriter, keyPairInfo RESTPOSTNewKeyPairIn, sluggedKeyStoreID string) {
+	if keyPairInfo.KeyPairID == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "missing-key-pair-id",
+			Errors:   []string{"Missing Key Pair ID!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	sluggedKeyPairID := slugger(keyPairInfo.KeyPairID)
+	basePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/" + sluggedKeyPairID
+
+	// Decode and parse the submitted key pair
+	privKeyBytes, err := B64DecodeStrToBytes(keyPairInfo.ImportPrivateKey)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-private-key",
+			Errors:   []string{"Private Key is not valid base64!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	privKey, err := parseImportedPrivateKey(privKeyBytes, keyPairInfo.ImportPassphrase)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-private-key",
+			Errors:   []string{err.Error()},
+			Messages: []string{"Private Key could not be parsed!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	var pubKey crypto.PublicKey
+	if keyPairInfo.ImportPublicKey != "" {
+		pubKeyBytes, err := B64DecodeStrToBytes(keyPairInfo.ImportPublicKey)
+		if err == nil {
+			pubKey, err = parseImportedPublicKey(pubKeyBytes)
+		}
+		if err != nil {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-public-key",
+				Errors:   []string{err.Error()},
+				Messages: []string{"Public Key could not be parsed!"}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+	}
+
+	// Both halves have to belong together
+	if err := validateImportedKeyPair(privKey, pubKey); err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-mismatch",
+			Errors:   []string{err.Error()},
+			Messages: []string{"Key Pair failed validation!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// Check for an existing key pair of any algorithm
+	keyCheck, err := FileExists(basePath + "/" + keyPairFilePrefix(basePath) + ".pub.pem")
+	check(err)
+	if keyCheck {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-exists",
+			Errors:   []string{"Key Pair '" + sluggedKeyPairID + "' in Key Store '" + sluggedKeyStoreID + "' already exists!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// Store the key pair the same way as a generated one, under the key store passphrase and KEK
+	keyAlgorithm := keyAlgorithmForKey(privKey)
+	CreateDirectory(basePath)
+
+	pemEncodedPrivateKey, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(privKey, keyPairInfo.Passphrase)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-import-error",
+			Errors:   []string{err.Error()},
+			Messages: []string{"Key Pair import error!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	if keyPairInfo.Passphrase != "" {
+		pemEncodedPrivateKey = encryptedPrivateKeyBytes
+	}
+	pemEncodedPublicKey := pemEncodePublicKey(privKey.Public())
+	encodedPublicKey := B64EncodeBytesToStr(pemEncodedPublicKey.Bytes())
+
+	privKeyFile, pubKeyFile, err := writeRSAKeyPair(pemEncodedPrivateKey, pemEncodedPublicKey, basePath+"/"+keyAlgorithm)
+	if err != nil || !privKeyFile || !pubKeyFile {
+		errorMessages := []string{}
+		if err != nil {
+			errorMessages = append(errorMessages, err.Error())
+		}
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-import-error",
+			Errors:   errorMessages,
+			Messages: []string{"Key Pair import error!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	check(writeKeyPairMetadata(basePath, keyPairMetadataFromInput(keyPairInfo, keyAlgorithm, keySizeForKey(privKey))))
+
+	returnData := &RESTPOSTNewKeyPairReturn{
+		Status:    "success",
+		Errors:    []string{},
+		Messages:  []string{"Successfully imported " + keyAlgorithm + " " + strconv.Itoa(keySizeForKey(privKey)) + " Key Pair '" + sluggedKeyPairID + "' in Key Store '" + sluggedKeyStoreID + "'!"},
+		KeyPairID: sluggedKeyPairID,
+		KeyPair:   KeyPair{PublicKey: encodedPublicKey}}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
 // readKeyPairAPI handles the GET /v1/key endpoint
 func readKeyPairAPI(w http.ResponseWriter, r *http.Request) {
-	var sluggedKeyStoreID string
+	var keyLocation string
+	var pubKeyPath string
+	var privKeyPath string
+	var keyVersion int
+	var keyVersions []KeyPairVersion
+	keyFormat := keyExportFormatPEM
 
 	// Read in the submitted parameters
 	queryParams := r.URL.Query()
 	keyPairID, presentKPID := queryParams["key_pair_id"]
 	keyStoreID, presentKSID := queryParams["key_store_id"]
 	passphrase, presentPassphrase := queryParams["passphrase"]
+	parentCNPath, presentCN := queryParams["cn_path"]
+	parentSlugPath, presentSlug := queryParams["slug_path"]
+	formatIn, presentFormat := queryParams["format"]
+	versionIn, presentVersion := queryParams["version"]
 
-	if presentKSID {
-		sluggedKeyStoreID = slugger(keyStoreID[0])
-	} else {
-		sluggedKeyStoreID = "default"
+	if presentFormat {
+		keyFormat = strings.ToLower(formatIn[0])
 	}
-
-	keyStorePath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/")
-	check(err)
-
-	checkKeyStorePath, err := DirectoryExists(keyStorePath)
-	check(err)
-
-	if !checkKeyStorePath {
-		// No valid key store
+	if !isSupportedKeyExportFormat(keyFormat) {
 		returnData := &ReturnGenericMessage{
-			Status:   "invalid-key-store",
-			Errors:   []string{"Invalid Key Store '" + sluggedKeyStoreID + "'!"},
+			Status:   "invalid-key-format",
+			Errors:   []string{"Invalid key format '" + keyFormat + "'!  Must be one of " + strings.Join(supportedKeyExportFormats, ", ")},
 			Messages: []string{}}
 		returnResponse, _ := json.Marshal(returnData)
 		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	if presentCN || presentSlug {
+		// Key pair kept in the keys/ directory of a Certificate Authority
+		var parentPath string
+		var parentPathRaw string
+		if presentCN {
+			parentPath = splitCACNChainToPath(parentCNPath[0])
+			parentPathRaw = parentCNPath[0]
+		}
+		if presentSlug {
+			parentPath = splitCACNChainToPath(parentSlugPath[0])
+			parentPathRaw = parentSlugPath[0]
+		}
+
+		absPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + parentPath)
+		check(err)
+
+		parentPathExists, err := DirectoryExists(absPath)
+		check(err)
+
+		if !parentPathExists {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-parent-path",
+				Errors:   []string{"Invalid parent path, no chain exists!"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+
+		keyLocation = "CA Path '" + parentPathRaw + "'"
+		if presentKPID {
+			pubKeyPath = absPath + "/keys/" + slugger(keyPairID[0]) + ".pub.pem"
+			privKeyPath = absPath + "/keys/" + slugger(keyPairID[0]) + ".priv.pem"
+		}
 	} else {
-		// Key store exists, proceed
+		// Key pair kept in a key store
+		var sluggedKeyStoreID string
+		if presentKSID {
+			sluggedKeyStoreID = resolveKeyStoreID(slugger(keyStoreID[0]))
+		} else {
+			sluggedKeyStoreID = "default"
+		}
+
+		keyStorePath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/")
+		check(err)
+
+		checkKeyStorePath, err := DirectoryExists(keyStorePath)
+		check(err)
+
+		if !checkKeyStorePath {
+			// No valid key store
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-key-store",
+				Errors:   []string{"Invalid Key Store '" + sluggedKeyStoreID + "'!"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+
+		keyLocation = "Key Store '" + sluggedKeyStoreID + "'"
 		if presentKPID {
-			// Key Pair ID is passed
-			pubKeyPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/" + slugger(keyPairID[0]) + "/rsa.pub.pem")
-			check(err)
+			keyPairPath := keyStorePath + "/" + resolveKeyPairID(keyStorePath, slugger(keyPairID[0]))
 
-			privKeyPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID + "/" + slugger(keyPairID[0]) + "/rsa.priv.pem")
-			check(err)
-			pubKeyBytes := LoadKeyFile(pubKeyPath)
-
-			if presentPassphrase {
-				//The Passphrase is present, open and decrypt the private key if the passphrase is valid
-
-				privKeyFileCheck, err := FileExists(privKeyPath)
-				check(err)
-
-				if privKeyFileCheck {
-					// Now check the password against the key
-					privKeyBytes := LoadKeyFile(privKeyPath)
-
-					if string(privKeyBytes) == "" {
-						// Private Key is empty
-						returnData := &ReturnGenericMessage{
-							Status:   "empty-private-key",
-							Errors:   []string{"Private Key file is empty for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in Key Store '" + sluggedKeyStoreID + "'!"},
-							Messages: []string{}}
-						returnResponse, _ := json.Marshal(returnData)
-						fmt.Fprintf(w, string(returnResponse))
-					} else {
-						// Got some data
-						if isPrivateKeyEncrypted(privKeyBytes) {
-							//logStdOut("key is encrypted")
-							//logStdOut("privKeyBytes to String: " + string(privKeyBytes))
-
-							// Decode from base64
-							decodedPrivKey, err := b64.StdEncoding.DecodeString(string(privKeyBytes))
-							check(err)
-							//logStdOut("decodedPrivKey: " + string(decodedPrivKey))
-
-							bit, byted, err := decryptBytes(decodedPrivKey, passphrase[0])
-							check(err)
-							//logStdOut("decrypted")
-							//logStdOut("byted: " + string(byted))
-
-							if bit {
-								// Successfully decoded, return bytes
-								returnData := &RESTGETKeyPairJSONReturn{
-									Status:   "success",
-									Errors:   []string{},
-									Messages: []string{"Loaded Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in Key Store '" + sluggedKeyStoreID + "'"},
-									KeyPair:  KeyPair{PublicKey: B64EncodeBytesToStr(pubKeyBytes), PrivateKey: B64EncodeBytesToStr(byted)}}
-								returnResponse, _ := json.Marshal(returnData)
-								fmt.Fprintf(w, string(returnResponse))
-
-							} else {
-								// Decryption failed for some reason
-								returnData := &ReturnGenericMessage{
-									Status:   "private-key-decryption-error",
-									Errors:   []string{"Private Key decryption failed for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in Key Store '" + sluggedKeyStoreID + "'!"},
-									Messages: []string{}}
-								returnResponse, _ := json.Marshal(returnData)
-								fmt.Fprintf(w, string(returnResponse))
-							}
-						} else {
-							// Plain text key, send it on through
-							returnData := &RESTGETKeyPairJSONReturn{
-								Status:   "success",
-								Errors:   []string{},
-								Messages: []string{"Loaded Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in Key Store '" + sluggedKeyStoreID + "'"},
-								KeyPair:  KeyPair{PublicKey: B64EncodeBytesToStr(pubKeyBytes), PrivateKey: B64EncodeBytesToStr(privKeyBytes)}}
-							returnResponse, _ := json.Marshal(returnData)
-							fmt.Fprintf(w, string(returnResponse))
-						}
-					}
-				} else {
-					// Private Key does not exist
+			// Prior versions of a rotated key pair are read from versions/<n>/
+			metadata, err := readKeyPairMetadata(keyPairPath)
+			if err == nil {
+				keyVersion = metadata.CurrentVersion
+				keyVersions = metadata.Versions
+			}
+			if presentVersion {
+				requestedVersion, err := strconv.Atoi(versionIn[0])
+				if err == nil {
+					keyPairPath, err = keyPairVersionPath(keyPairPath, metadata, requestedVersion)
+				}
+				if err != nil {
 					returnData := &ReturnGenericMessage{
-						Status:   "no-private-key",
-						Errors:   []string{"No Private Key is stored for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in Key Store '" + sluggedKeyStoreID + "'!"},
+						Status:   "invalid-key-version",
+						Errors:   []string{"Invalid version '" + versionIn[0] + "' of Key Pair ID '" + keyPairID[0] + "' in " + keyLocation + "!"},
 						Messages: []string{}}
 					returnResponse, _ := json.Marshal(returnData)
 					fmt.Fprintf(w, string(returnResponse))
+					return
 				}
+				keyVersion = requestedVersion
+			}
+			keyFilePrefix := keyPairFilePrefix(keyPairPath)
+			pubKeyPath = keyPairPath + "/" + keyFilePrefix + ".pub.pem"
+			privKeyPath = keyPairPath + "/" + keyFilePrefix + ".priv.pem"
+		}
+	}
 
-			} else {
+	if !presentKPID {
+		// Key Pair not specified
+		returnData := &ReturnGenericMessage{
+			Status:   "no-key-pair-id",
+			Errors:   []string{"No Key Pair ID specified!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
 
-				// Targeting a specific Key Pair ID for a Public Key
-				fileCheck, err := FileExists(pubKeyPath)
-				check(err)
-				if fileCheck {
-					keyBytes := LoadKeyFile(pubKeyPath)
-
-					returnData := &RESTGETKeyPairJSONReturn{
-						Status:   "success",
-						Errors:   []string{},
-						Messages: []string{"Public Key for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in Key Store '" + sluggedKeyStoreID + "'"},
-						KeyPair:  KeyPair{PublicKey: B64EncodeBytesToStr(keyBytes)}}
-					returnResponse, _ := json.Marshal(returnData)
-					fmt.Fprintf(w, string(returnResponse))
-				} else {
-					// Key Pair does not exist
-					returnData := &ReturnGenericMessage{
-						Status:   "invalid-key-pair-id",
-						Errors:   []string{"Invalid Key Pair ID in Key Store '" + sluggedKeyStoreID + "'!"},
-						Messages: []string{}}
-					returnResponse, _ := json.Marshal(returnData)
-					fmt.Fprintf(w, string(returnResponse))
-				}
+	fileCheck, err := FileExists(pubKeyPath)
+	check(err)
+	if !fileCheck {
+		// Key Pair does not exist
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-key-pair-id",
+			Errors:   []string{"Invalid Key Pair ID in " + keyLocation + "!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	pubKeyBytes := LoadKeyFile(pubKeyPath)
+
+	var privKeyPEM []byte
+	message := "Public Key for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in " + keyLocation
+	if presentPassphrase {
+		//The Passphrase is present, open and decrypt the private key if the passphrase is valid
+
+		privKeyFileCheck, err := FileExists(privKeyPath)
+		check(err)
+
+		if !privKeyFileCheck {
+			// Private Key does not exist
+			returnData := &ReturnGenericMessage{
+				Status:   "no-private-key",
+				Errors:   []string{"No Private Key is stored for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in " + keyLocation + "!"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+
+		// Now check the password against the key
+		privKeyBytes, err := readPrivateKeyFile(privKeyPath)
+		check(err)
+
+		if err != nil {
+			// Key file could not be unwrapped with the server key encryption key
+			returnData := &ReturnGenericMessage{
+				Status:   "private-key-decryption-error",
+				Errors:   []string{"Private Key could not be unwrapped for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in " + keyLocation + "!"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+		if string(privKeyBytes) == "" {
+			// Private Key is empty
+			returnData := &ReturnGenericMessage{
+				Status:   "empty-private-key",
+				Errors:   []string{"Private Key file is empty for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in " + keyLocation + "!"},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+
+		privKeyPEM = privKeyBytes
+		if isPrivateKeyEncrypted(privKeyBytes) {
+			decrypted, decryptedPEM, err := decryptPrivateKeyBytes(privKeyBytes, passphrase[0])
+			check(err)
 
+			if !decrypted {
+				// Decryption failed for some reason
+				returnData := &ReturnGenericMessage{
+					Status:   "private-key-decryption-error",
+					Errors:   []string{"Private Key decryption failed for Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in " + keyLocation + "!"},
+					Messages: []string{}}
+				returnResponse, _ := json.Marshal(returnData)
+				fmt.Fprintf(w, string(returnResponse))
+				return
 			}
-		} else {
-			// Key Pair not specified
+			privKeyPEM = decryptedPEM
+		}
+		message = "Loaded Key Pair ID '" + keyPairID[0] + "' (" + slugger(keyPairID[0]) + ") in " + keyLocation
+	}
+
+	// Convert the key pair to the requested format
+	keyPair, kid, err := exportKeyPair(pubKeyBytes, privKeyPEM, keyFormat)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-export-error",
+			Errors:   []string{err.Error()},
+			Messages: []string{"Key Pair could not be exported as " + keyFormat + "!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	returnData := &RESTGETKeyPairJSONReturn{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: []string{message},
+		KeyPair:  keyPair,
+		Format:   keyFormat,
+		KeyID:    kid,
+		Version:  keyVersion,
+		Versions: keyVersions}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// rotateKeyPairAPI handles the POST /v1/key/rotate endpoint
+func rotateKeyPairAPI(w http.ResponseWriter, r *http.Request) {
+	var sluggedKeyStoreID string
+
+	keyPairInfo := RESTPOSTNewKeyPairIn{}
+	err := json.NewDecoder(r.Body).Decode(&keyPairInfo)
+	check(err)
+
+	if keyPairInfo.KeyStoreID != "" {
+		sluggedKeyStoreID = resolveKeyStoreID(slugger(keyPairInfo.KeyStoreID))
+	} else {
+		sluggedKeyStoreID = "default"
+	}
+	keyStorePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID
+	sluggedKeyPairID := resolveKeyPairID(keyStorePath, slugger(keyPairInfo.KeyPairID))
+	keyPairPath := keyStorePath + "/" + sluggedKeyPairID
+
+	keyCheck, err := FileExists(keyPairPath + "/" + keyPairFilePrefix(keyPairPath) + ".pub.pem")
+	check(err)
+	if keyPairInfo.KeyPairID == "" || !keyCheck {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-key-pair-id",
+			Errors:   []string{"Invalid Key Pair ID in Key Store '" + sluggedKeyStoreID + "'!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	// Keep the algorithm and size of the current version unless new ones are requested
+	metadata, err := readKeyPairMetadata(keyPairPath)
+	check(err)
+	if keyPairInfo.KeyAlgorithm == "" && err == nil {
+		for _, keyPairVersion := range metadata.Versions {
+			if keyPairVersion.Version == metadata.CurrentVersion {
+				keyPairInfo.KeyAlgorithm = keyPairVersion.KeyAlgorithm
+				if keyPairInfo.KeySize == 0 {
+					keyPairInfo.KeySize = keyPairVersion.KeySize
+				}
+			}
+		}
+	}
+	keyAlgorithm, keySize, err := normalizeKeyAlgorithm(keyPairInfo.KeyAlgorithm, keyPairInfo.KeySize)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-key-algorithm",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	metadata, privKey, err := rotateKeyPair(keyPairPath, keyAlgorithm, keySize, keyPairInfo.Passphrase, keyPairInfo.StorePrivateKey)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-rotation-error",
+			Errors:   []string{err.Error()},
+			Messages: []string{"Key Pair rotation error!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	pemEncodedPrivateKey, _, err := pemEncodePrivateKey(privKey, "")
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-rotation-error",
+			Errors:   []string{err.Error()},
+			Messages: []string{"Key Pair rotation error!"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	returnData := &RESTPOSTNewKeyPairReturn{
+		Status:    "success",
+		Errors:    []string{},
+		Messages:  []string{"Successfully rotated Key Pair '" + sluggedKeyPairID + "' in Key Store '" + sluggedKeyStoreID + "' to version " + strconv.Itoa(metadata.CurrentVersion) + "!"},
+		KeyPairID: sluggedKeyPairID,
+		KeyPair:   KeyPair{PublicKey: B64EncodeBytesToStr(pemEncodePublicKey(privKey.Public()).Bytes()), PrivateKey: B64EncodeBytesToStr(pemEncodedPrivateKey.Bytes())},
+		Version:   metadata.CurrentVersion}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// deleteKeyPairAPI handles the DELETE /v1/key endpoint
+func deleteKeyPairAPI(w http.ResponseWriter, r *http.Request) {
+	var sluggedKeyStoreID string
+
+	// Read in the submitted parameters
+	queryParams := r.URL.Query()
+	keyPairID, presentKPID := queryParams["key_pair_id"]
+	keyStoreID, presentKSID := queryParams["key_store_id"]
+	versionIn, presentVersion := queryParams["version"]
+
+	if presentKSID {
+		sluggedKeyStoreID = resolveKeyStoreID(slugger(keyStoreID[0]))
+	} else {
+		sluggedKeyStoreID = "default"
+	}
+
+	if !presentKPID || slugger(keyPairID[0]) == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "no-key-pair-id",
+			Errors:   []string{"No Key Pair ID specified!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+	keyStorePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID
+	sluggedKeyPairID := resolveKeyPairID(keyStorePath, slugger(keyPairID[0]))
+	keyPairPath := keyStorePath + "/" + sluggedKeyPairID
+
+	keyPairCheck, err := DirectoryExists(keyPairPath)
+	check(err)
+	if !keyPairCheck {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-key-pair-id",
+			Errors:   []string{"Invalid Key Pair ID in Key Store '" + sluggedKeyStoreID + "'!"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	if presentVersion {
+		// Only remove a retired version
+		version, err := strconv.Atoi(versionIn[0])
+		if err == nil {
+			err = deleteKeyPairVersion(keyPairPath, version)
+		}
+		if err != nil {
 			returnData := &ReturnGenericMessage{
-				Status:   "no-key-pair-id",
-				Errors:   []string{"No Key Pair ID specified!"},
+				Status:   "invalid-key-version",
+				Errors:   []string{err.Error()},
 				Messages: []string{}}
 			returnResponse, _ := json.Marshal(returnData)
 			fmt.Fprintf(w, string(returnResponse))
+			return
 		}
+
+		returnData := &ReturnGenericMessage{
+			Status:   "success",
+			Errors:   []string{},
+			Messages: []string{"Deleted version " + versionIn[0] + " of Key Pair '" + sluggedKeyPairID + "' in Key Store '" + sluggedKeyStoreID + "'"}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	if err := deleteKeyPair(keyStorePath, sluggedKeyPairID); err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-deletion-error",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	returnData := &ReturnGenericMessage{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: []string{"Deleted Key Pair '" + sluggedKeyPairID + "' in Key Store '" + sluggedKeyStoreID + "'"}}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// renameKeyPairAPI handles the POST /v1/key/rename endpoint
+func renameKeyPairAPI(w http.ResponseWriter, r *http.Request) {
+	var sluggedKeyStoreID string
+
+	renameInfo := RESTPOSTRenameJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&renameInfo)
+	check(err)
+
+	if renameInfo.KeyStoreID != "" {
+		sluggedKeyStoreID = resolveKeyStoreID(slugger(renameInfo.KeyStoreID))
+	} else {
+		sluggedKeyStoreID = "default"
 	}
+	keyStorePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreID
+	sluggedKeyPairID := resolveKeyPairID(keyStorePath, slugger(renameInfo.KeyPairID))
+
+	newKeyPairID, err := renameKeyPair(keyStorePath, sluggedKeyPairID, renameInfo.NewID)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-pair-rename-failed",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	returnData := &RESTPOSTNewKeyPairReturn{
+		Status:    "success",
+		Errors:    []string{},
+		Messages:  []string{"Renamed Key Pair '" + sluggedKeyPairID + "' to '" + newKeyPairID + "' in Key Store '" + sluggedKeyStoreID + "'"},
+		KeyPairID: newKeyPairID}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
 }
@@ -8,13 +8,44 @@ import (
 
 // listKeyStoresAPI returns the key stores for GET /keystores requests
 func listKeyStoresAPI(w http.ResponseWriter, r *http.Request) {
-	keyPairs := listKeyStores()
+	var labelRequirements []labelRequirement
+
+	queryParams := r.URL.Query()
+	selector, presentSelector := queryParams["selector"]
+	if presentSelector {
+		requirements, err := parseLabelSelector(selector[0])
+		if err != nil {
+			returnData := &ReturnGenericMessage{
+				Status:   "invalid-label-selector",
+				Errors:   []string{err.Error()},
+				Messages: []string{}}
+			returnResponse, _ := json.Marshal(returnData)
+			fmt.Fprintf(w, string(returnResponse))
+			return
+		}
+		labelRequirements = requirements
+	}
+
+	// Filter the key stores by their labels
+	keyStores := []string{}
+	keyStoresMetadata := []KeyStoreMetadata{}
+	for _, keyStore := range listKeyStores() {
+		metadata, err := readKeyStoreMetadata(readConfig.Locksmith.PKIRoot + "/keystores/" + keyStore)
+		if err != nil {
+			metadata = KeyStoreMetadata{ID: keyStore}
+		}
+		if matchesLabelSelector(metadata.Labels, labelRequirements) {
+			keyStores = append(keyStores, keyStore)
+			keyStoresMetadata = append(keyStoresMetadata, metadata)
+		}
+	}
 
 	returnData := &RESTGETKeyStoresJSONReturn{
 		Status:    "success",
 		Errors:    []string{},
 		Messages:  []string{"Listings of Key Stores"},
-		KeyStores: keyPairs}
+		KeyStores: keyStores,
+		Metadata:  keyStoresMetadata}
 	returnResponse, _ := json.Marshal(returnData)
 	fmt.Fprintf(w, string(returnResponse))
 }
@@ -26,8 +57,21 @@ func createKeyStoreAPI(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&keyStoreInfo)
 	check(err)
 
+	if err := validateLabels(keyStoreInfo.Labels); err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "invalid-labels",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
 	if keyStoreInfo.KeyStore != "" {
-		keyStoreCreated, keyStoreSlug, err := createKeyStore(keyStoreInfo.KeyStore)
+		keyStoreCreated, keyStoreSlug, err := createKeyStore(keyStoreInfo.KeyStore, KeyStoreMetadata{
+			Description: keyStoreInfo.Description,
+			Owner:       keyStoreInfo.Owner,
+			Labels:      keyStoreInfo.Labels})
 		if keyStoreCreated {
 			// Key store was created
 			returnData := &RESTPOSTKeyStoresJSONReturn{
@@ -57,3 +101,63 @@ func createKeyStoreAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// deleteKeyStoreAPI handles the requests for DELETE /keystore, only empty key stores are deleted
+func deleteKeyStoreAPI(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	keyStoreID, presentKSID := queryParams["key_store_id"]
+
+	if !presentKSID || slugger(keyStoreID[0]) == "" {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-store-name-missing",
+			Errors:   []string{"Key Store ID parameter missing!  Pass with `key_store_id`"},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	if err := deleteKeyStore(resolveKeyStoreID(slugger(keyStoreID[0]))); err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-store-deletion-failed",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	returnData := &ReturnGenericMessage{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: []string{"Key Store '" + slugger(keyStoreID[0]) + "' successfully deleted!"}}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
+
+// renameKeyStoreAPI handles the requests for POST /keystore/rename, the old ID keeps resolving to the key store
+func renameKeyStoreAPI(w http.ResponseWriter, r *http.Request) {
+	renameInfo := RESTPOSTRenameJSONIn{}
+	err := json.NewDecoder(r.Body).Decode(&renameInfo)
+	check(err)
+
+	sluggedKeyStoreID := resolveKeyStoreID(slugger(renameInfo.KeyStoreID))
+	newKeyStoreID, err := renameKeyStore(sluggedKeyStoreID, renameInfo.NewID)
+	if err != nil {
+		returnData := &ReturnGenericMessage{
+			Status:   "key-store-rename-failed",
+			Errors:   []string{err.Error()},
+			Messages: []string{}}
+		returnResponse, _ := json.Marshal(returnData)
+		fmt.Fprintf(w, string(returnResponse))
+		return
+	}
+
+	returnData := &RESTPOSTKeyStoresJSONReturn{
+		Status:   "success",
+		Errors:   []string{},
+		Messages: []string{"Key Store '" + sluggedKeyStoreID + "' renamed to '" + newKeyStoreID + "'!"},
+		KeyStore: newKeyStoreID}
+	returnResponse, _ := json.Marshal(returnData)
+	fmt.Fprintf(w, string(returnResponse))
+}
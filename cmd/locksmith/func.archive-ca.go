@@ -0,0 +1,177 @@
+package locksmith
+
+import (
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archivedSignerBackend refuses to sign for an archived CA, or a CA under one, while keeping its key pair readable
+type archivedSignerBackend struct {
+	caPath  string
+	backend SignerBackend
+}
+
+// isCAArchived checks if the CA at caPath is marked archived
+func isCAArchived(caPath string) (bool, error) {
+	return FileExists(caPath + "/" + archivedCAFileName)
+}
+
+// archivedCAForPath returns the path of the CA at caPath if it is archived, or of the closest archived CA above it, empty if there is none
+func archivedCAForPath(caPath string) (string, error) {
+	for path := caPath; path != ""; path = parentCAPath(path) {
+		archived, err := isCAArchived(path)
+		if err != nil {
+			return "", err
+		}
+		if archived {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// checkCANotArchived refuses archived CAs, and CAs under one, before anything is written for a signing
+func checkCANotArchived(caPath string) ([]string, error) {
+	archivedPath, err := archivedCAForPath(caPath)
+	if err != nil {
+		return []string{"CA Archive Failure"}, err
+	}
+	if archivedPath == "" {
+		return []string{}, nil
+	}
+	if archivedPath == caPath {
+		return []string{"CA is archived, it only serves its certificates and CRL"}, Stoerr("ca-archived")
+	}
+	archivedCAName := filepath.Base(archivedPath)
+	if archivedCACert, err := ReadCACertificate(archivedPath); err == nil && archivedCACert != nil {
+		archivedCAName = archivedCACert.Subject.CommonName
+	}
+	return []string{"CA '" + archivedCAName + "' above this CA is archived, no CA under it can sign"}, Stoerr("ca-archived")
+}
+
+// archiveCA marks the CA at caPath read-only, it keeps serving its chain and CRL but refuses to sign anything
+func archiveCA(caPath string) ([]string, error) {
+	archived, err := isCAArchived(caPath)
+	if err != nil {
+		return []string{"CA Archive Failure"}, err
+	}
+	if archived {
+		return []string{"CA is already archived"}, Stoerr("ca-archived")
+	}
+
+	if _, err := endUnlockSession(caPath, "locked"); err != nil && !errors.Is(err, errCALocked) {
+		return []string{"CA Unlock Session Failure"}, err
+	}
+	if _, err := WriteByteFile(caPath+"/"+archivedCAFileName, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644, true); err != nil {
+		return []string{"CA Archive Failure"}, err
+	}
+	return []string{}, nil
+}
+
+// caDependents lists the Intermediate CAs under the CA at caPath and the certificates it issued that are neither expired nor revoked
+// Certificates a CA issued for itself, such as its self-signed, renewed or cross certificates, do not count
+func caDependents(caPath string) ([]string, error) {
+	var dependents []string
+
+	intermedCAs, err := ioutil.ReadDir(caPath + "/intermed-ca")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, intermedCA := range intermedCAs {
+		if !intermedCA.IsDir() {
+			continue
+		}
+		intermedCAName := intermedCA.Name()
+		if intermedCACert, err := ReadCACertificate(caPath + "/intermed-ca/" + intermedCA.Name()); err == nil && intermedCACert != nil {
+			intermedCAName = intermedCACert.Subject.CommonName
+		}
+		dependents = append(dependents, "Intermediate CA '"+intermedCAName+"' is under the CA")
+	}
+
+	caIndex, err := readCAIndex(caPath + "/ca.index")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var caSubject string
+	if caCert, err := ReadCACertificate(caPath); err == nil && caCert != nil {
+		caSubject = compileSubjectString(caCert.Subject)
+	}
+	now := time.Now()
+	for _, entry := range caIndex {
+		if entry.State != "V" || entry.Subject == caSubject {
+			continue
+		}
+		endDate, err := parseCAIndexTime(entry.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		if endDate.After(now) {
+			dependents = append(dependents, "Certificate '"+entry.Subject+"' (serial "+entry.Serial+") is valid until "+endDate.UTC().Format(time.RFC3339))
+		}
+	}
+	return dependents, nil
+}
+
+// deleteCA moves the CA at caPath, and everything under it, to a timestamped directory in the archive/ area of the PKI root
+// CAs with Intermediate CAs under them or unexpired certificates are only moved when forced
+func deleteCA(caPath string, force bool) (string, []string, error) {
+	dependents, err := caDependents(caPath)
+	if err != nil {
+		return "", []string{"CA Dependency Check Failure"}, err
+	}
+	if len(dependents) > 0 && !force {
+		return "", dependents, Stoerr("ca-has-dependents")
+	}
+
+	pkiRootPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot)
+	if err != nil {
+		return "", []string{"PKI Root Failure"}, err
+	}
+	relativeCAPath, err := filepath.Rel(pkiRootPath, caPath)
+	if err != nil || strings.HasPrefix(relativeCAPath, "..") {
+		return "", []string{"CA is not under the PKI root"}, Stoerr("invalid-parent-path")
+	}
+	archivePath := filepath.Join(pkiRootPath, "archive", time.Now().UTC().Format("20060102T150405.000000000Z"), relativeCAPath)
+
+	// Unlock sessions of the CA and the CAs under it hold keys that are about to move
+	unlockSessionsMutex.Lock()
+	for sessionKey := range unlockSessions {
+		if sessionKey == caPath || strings.HasPrefix(sessionKey, caPath+string(os.PathSeparator)) {
+			closeUnlockSession(sessionKey, "locked")
+		}
+	}
+	unlockSessionsMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0700); err != nil {
+		return "", []string{"CA Archive Failure"}, err
+	}
+	if err := os.Rename(caPath, archivePath); err != nil {
+		return "", []string{"CA Archive Failure"}, err
+	}
+	return archivePath, dependents, nil
+}
+
+// HasKey checks the backend holding the archived CA key pair
+func (backend *archivedSignerBackend) HasKey() (bool, error) {
+	return backend.backend.HasKey()
+}
+
+// GenerateKey refuses to create a key pair for an archived CA
+func (backend *archivedSignerBackend) GenerateKey(keyAlgorithm string, keySize int, passphrase string) (crypto.PublicKey, error) {
+	return nil, Stoerr("ca-archived")
+}
+
+// DeleteKey refuses to remove the key pair of an archived CA, it is only removed with the archive
+func (backend *archivedSignerBackend) DeleteKey() error {
+	return Stoerr("ca-archived")
+}
+
+// Signer refuses to open the key of an archived CA
+func (backend *archivedSignerBackend) Signer(passphrase string) (crypto.Signer, error) {
+	return nil, Stoerr("ca-archived")
+}
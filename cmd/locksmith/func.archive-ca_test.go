@@ -0,0 +1,123 @@
+package locksmith
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveCA(t *testing.T) {
+	useTestPKIRoot(t)
+	rootPath, _ := createTestRootCA(t, testCertificateConfiguration("Archived Root CA", "ecdsa"))
+	created, messages, _, err := createNewIntermediateCA(RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Archived Intermediate CA", "ecdsa")}, rootPath)
+	if err != nil || !created {
+		t.Fatalf("creating the Intermediate CA failed: %v %v", err, messages)
+	}
+	intermedPath := rootPath + "/intermed-ca/" + slugger("Archived Intermediate CA")
+
+	if messages, err := archiveCA(rootPath); err != nil {
+		t.Fatalf("archive failed: %v %v", err, messages)
+	}
+	if _, err := archiveCA(rootPath); err == nil {
+		t.Fatal("archived the CA twice")
+	}
+
+	// The archived CA and the CAs under it refuse to sign
+	if archivedPath, err := archivedCAForPath(intermedPath); err != nil || archivedPath != rootPath {
+		t.Fatalf("got archived CA %q %v, want the Root CA", archivedPath, err)
+	}
+	if _, err := checkCANotArchived(rootPath); err == nil {
+		t.Fatal("archived CA is allowed to sign")
+	}
+	messages, err = checkCANotArchived(intermedPath)
+	if err == nil || len(messages) != 1 || !strings.Contains(messages[0], "Archived Root CA") {
+		t.Fatalf("got %v %v, want the archived Root CA named", messages, err)
+	}
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", nil)
+	if created, _, _, err := createNewCertificateFromCSR(intermedPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0}); created || err == nil {
+		t.Fatal("signed with a CA under an archived CA")
+	}
+
+	// The archived CA still serves its certificate and key pair
+	if caCert, err := ReadCACertificate(rootPath); err != nil || caCert == nil {
+		t.Fatalf("archived CA Certificate could not be read: %v", err)
+	}
+	signerBackend, err := signerBackendForCA(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasKey, err := signerBackend.HasKey(); err != nil || !hasKey {
+		t.Fatalf("archived CA key pair is gone: %v", err)
+	}
+	if err := signerBackend.DeleteKey(); err == nil {
+		t.Fatal("deleted the key pair of an archived CA")
+	}
+}
+
+func TestCADependents(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, _ := createTestRootCA(t, testCertificateConfiguration("Dependents Root CA", "ecdsa"))
+	if dependents, err := caDependents(caPath); err != nil || len(dependents) != 0 {
+		t.Fatalf("got dependents %v %v, want none", dependents, err)
+	}
+
+	// Only valid certificates that have not expired count
+	if err := appendCAIndexEntries(caPath+"/ca.index", []CAIndex{
+		{State: "V", EndDate: formatCAIndexTime(time.Now().Add(time.Hour)), Serial: "10", PathToCertificate: "unknown", Subject: "/CN=valid"},
+		{State: "V", EndDate: formatCAIndexTime(time.Now().Add(-time.Hour)), Serial: "11", PathToCertificate: "unknown", Subject: "/CN=expired"},
+		{State: "R", EndDate: formatCAIndexTime(time.Now().Add(time.Hour)), DateOfRevokation: formatCAIndexTime(time.Now()), Serial: "12", PathToCertificate: "unknown", Subject: "/CN=revoked"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	created, messages, _, err := createNewIntermediateCA(RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Dependent Intermediate CA", "ecdsa")}, caPath)
+	if err != nil || !created {
+		t.Fatalf("creating the Intermediate CA failed: %v %v", err, messages)
+	}
+
+	// The Intermediate CA is listed as a CA under it and as a valid certificate it issued
+	dependents, err := caDependents(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dependents) != 3 || !strings.Contains(dependents[0], "Dependent Intermediate CA") || !strings.Contains(dependents[1], "/CN=valid") {
+		t.Fatalf("got dependents %v", dependents)
+	}
+}
+
+func TestDeleteCA(t *testing.T) {
+	useTestPKIRoot(t)
+	rootPath, _ := createTestRootCA(t, testCertificateConfiguration("Deleted Root CA", "ecdsa"))
+	created, messages, _, err := createNewIntermediateCA(RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Deleted Intermediate CA", "ecdsa")}, rootPath)
+	if err != nil || !created {
+		t.Fatalf("creating the Intermediate CA failed: %v %v", err, messages)
+	}
+
+	// A CA with dependents is kept unless forced
+	archivePath, dependents, err := deleteCA(rootPath, false)
+	if err == nil || archivePath != "" || len(dependents) == 0 {
+		t.Fatalf("got %q %v %v, want the dependents listed", archivePath, dependents, err)
+	}
+	if exists, _ := DirectoryExists(rootPath); !exists {
+		t.Fatal("CA with dependents was moved")
+	}
+
+	archivePath, _, err = deleteCA(rootPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(archivePath, readConfig.Locksmith.PKIRoot+"/archive/") {
+		t.Fatalf("got archive path %q, want it in the archive area", archivePath)
+	}
+	if exists, _ := DirectoryExists(rootPath); exists {
+		t.Fatal("deleted CA was not moved")
+	}
+	if exists, _ := FileExists(archivePath + "/intermed-ca/" + slugger("Deleted Intermediate CA") + "/certs/ca.pem"); !exists {
+		t.Fatal("CAs under the deleted CA were not moved along")
+	}
+
+	// A CA without dependents is moved right away
+	leafRootPath, _ := createTestRootCA(t, testCertificateConfiguration("Empty Root CA", "ecdsa"))
+	if _, dependents, err := deleteCA(leafRootPath, false); err != nil || len(dependents) != 0 {
+		t.Fatalf("got %v %v, want the CA moved", dependents, err)
+	}
+}
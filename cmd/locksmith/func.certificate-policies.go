@@ -0,0 +1,398 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// policyInformation is the ASN.1 structure of a certificate policy in the certificatePolicies extension
+type policyInformation struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []policyQualifierInfo `asn1:"optional"`
+}
+
+// policyQualifierInfo is the ASN.1 structure of a CPS URI or user notice qualifier
+type policyQualifierInfo struct {
+	QualifierID asn1.ObjectIdentifier
+	Qualifier   asn1.RawValue
+}
+
+// userNoticeQualifier is the ASN.1 structure of a user notice qualifier
+type userNoticeQualifier struct {
+	NoticeRef    noticeReference `asn1:"optional"`
+	ExplicitText string          `asn1:"optional,utf8"`
+}
+
+// noticeReference is the ASN.1 structure of the notice reference of a user notice qualifier
+type noticeReference struct {
+	Organization  string `asn1:"utf8"`
+	NoticeNumbers []int
+}
+
+// policyMapping is the ASN.1 structure of a mapping in the policyMappings extension
+type policyMapping struct {
+	IssuerDomainPolicy  asn1.ObjectIdentifier
+	SubjectDomainPolicy asn1.ObjectIdentifier
+}
+
+// parseOID converts a dotted OID string, eg "2.23.140.1.2.1", to an ObjectIdentifier
+func parseOID(oid string) (asn1.ObjectIdentifier, error) {
+	arcs := strings.Split(oid, ".")
+	if len(arcs) < 2 {
+		return nil, fmt.Errorf("'%s' is not a valid OID", oid)
+	}
+	var identifier asn1.ObjectIdentifier
+	for _, arc := range arcs {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("'%s' is not a valid OID", oid)
+		}
+		identifier = append(identifier, n)
+	}
+	if identifier[0] > 2 || (identifier[0] < 2 && identifier[1] > 39) {
+		return nil, fmt.Errorf("'%s' is not a valid OID", oid)
+	}
+	return identifier, nil
+}
+
+// validateCertificatePolicies makes sure the certificate policies can be stamped into certificates
+func validateCertificatePolicies(certificatePolicies *CertificatePolicyConfig) error {
+	if certificatePolicies == nil {
+		return nil
+	}
+
+	seenPolicies := map[string]bool{}
+	for _, policy := range certificatePolicies.Policies {
+		policyOID, err := parseOID(policy.OID)
+		if err != nil {
+			return fmt.Errorf("certificate policy %s", err.Error())
+		}
+		if seenPolicies[policyOID.String()] {
+			return fmt.Errorf("certificate policy '%s' is listed more than once", policy.OID)
+		}
+		seenPolicies[policyOID.String()] = true
+
+		for _, cpsURI := range policy.CPSURIs {
+			parsedURL, err := url.Parse(cpsURI)
+			if err != nil || parsedURL.Scheme == "" || isIA5String(cpsURI) != nil {
+				return fmt.Errorf("CPS URI '%s' of certificate policy '%s' is not an absolute ASCII URL", cpsURI, policy.OID)
+			}
+		}
+		for _, userNotice := range policy.UserNotices {
+			if userNotice.ExplicitText == "" && userNotice.Organization == "" {
+				return fmt.Errorf("user notice of certificate policy '%s' needs an explicit text or an organization with notice numbers", policy.OID)
+			}
+			if (userNotice.Organization == "") != (len(userNotice.NoticeNumbers) == 0) {
+				return fmt.Errorf("user notice of certificate policy '%s' needs both an organization and notice numbers", policy.OID)
+			}
+			if utf8.RuneCountInString(userNotice.ExplicitText) > 200 || utf8.RuneCountInString(userNotice.Organization) > 200 {
+				return fmt.Errorf("user notice of certificate policy '%s' can be at most 200 characters", policy.OID)
+			}
+		}
+	}
+
+	for _, mapping := range certificatePolicies.PolicyMappings {
+		for _, mappedPolicy := range []string{mapping.IssuerDomainPolicy, mapping.SubjectDomainPolicy} {
+			mappedPolicyOID, err := parseOID(mappedPolicy)
+			if err != nil {
+				return fmt.Errorf("policy mapping %s", err.Error())
+			}
+			if mappedPolicyOID.Equal(oidAnyPolicy) {
+				return fmt.Errorf("policy mappings can not map anyPolicy")
+			}
+		}
+	}
+
+	for name, skipCerts := range map[string]*int{
+		"require_explicit_policy": certificatePolicies.RequireExplicitPolicy,
+		"inhibit_policy_mapping":  certificatePolicies.InhibitPolicyMapping,
+		"inhibit_any_policy":      certificatePolicies.InhibitAnyPolicy,
+	} {
+		if skipCerts != nil && *skipCerts < 0 {
+			return fmt.Errorf("%s can not be negative", name)
+		}
+	}
+	return nil
+}
+
+// certificatePoliciesForCA reads the certificate policies set for the CA at caPath, nil when it has none
+func certificatePoliciesForCA(caPath string) (*CertificatePolicyConfig, error) {
+	caCertificatePoliciesFile := caPath + "/" + certificatePoliciesFileName
+	caCertificatePoliciesExist, err := FileExists(caCertificatePoliciesFile)
+	if err != nil || !caCertificatePoliciesExist {
+		return nil, err
+	}
+	certificatePoliciesBytes, err := ioutil.ReadFile(caCertificatePoliciesFile)
+	if err != nil {
+		return nil, err
+	}
+	certificatePolicies := CertificatePolicyConfig{}
+	if err := json.Unmarshal(certificatePoliciesBytes, &certificatePolicies); err != nil {
+		return nil, err
+	}
+	return &certificatePolicies, nil
+}
+
+// writeCertificatePolicies saves the certificate policies supplied when creating a CA so every certificate it issues later carries them
+func writeCertificatePolicies(caPath string, certificatePolicies *CertificatePolicyConfig) error {
+	certificatePoliciesBytes, err := json.MarshalIndent(certificatePolicies, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(caPath+"/"+certificatePoliciesFileName, certificatePoliciesBytes, 0644)
+}
+
+// marshalCertificatePolicies encodes the certificatePolicies extension with the CPS URI and user notice qualifiers
+func marshalCertificatePolicies(policies []CertificatePolicy) ([]byte, error) {
+	var policyInformations []policyInformation
+	for _, policy := range policies {
+		policyOID, err := parseOID(policy.OID)
+		if err != nil {
+			return nil, err
+		}
+		information := policyInformation{Policy: policyOID}
+		for _, cpsURI := range policy.CPSURIs {
+			qualifierBytes, err := asn1.MarshalWithParams(cpsURI, "ia5")
+			if err != nil {
+				return nil, err
+			}
+			information.Qualifiers = append(information.Qualifiers, policyQualifierInfo{QualifierID: oidPolicyQualifierCPS, Qualifier: asn1.RawValue{FullBytes: qualifierBytes}})
+		}
+		for _, userNotice := range policy.UserNotices {
+			qualifierBytes, err := asn1.Marshal(userNoticeQualifier{
+				NoticeRef:    noticeReference{Organization: userNotice.Organization, NoticeNumbers: userNotice.NoticeNumbers},
+				ExplicitText: userNotice.ExplicitText,
+			})
+			if err != nil {
+				return nil, err
+			}
+			information.Qualifiers = append(information.Qualifiers, policyQualifierInfo{QualifierID: oidPolicyQualifierUserNotice, Qualifier: asn1.RawValue{FullBytes: qualifierBytes}})
+		}
+		policyInformations = append(policyInformations, information)
+	}
+	return asn1.Marshal(policyInformations)
+}
+
+// certificatePolicyExtensions encodes the certificate policy extensions of a certificate
+// CA Certificates also get the policyMappings, policyConstraints and inhibitAnyPolicy extensions, which RFC 5280 has marked critical
+func certificatePolicyExtensions(certificatePolicies *CertificatePolicyConfig, isCA bool) ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+	if certificatePolicies == nil {
+		return extensions, nil
+	}
+
+	if len(certificatePolicies.Policies) > 0 {
+		policiesBytes, err := marshalCertificatePolicies(certificatePolicies.Policies)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionCertificatePolicies, Value: policiesBytes})
+	}
+	if !isCA {
+		return extensions, nil
+	}
+
+	if len(certificatePolicies.PolicyMappings) > 0 {
+		var mappings []policyMapping
+		for _, mapping := range certificatePolicies.PolicyMappings {
+			issuerDomainPolicy, err := parseOID(mapping.IssuerDomainPolicy)
+			if err != nil {
+				return nil, err
+			}
+			subjectDomainPolicy, err := parseOID(mapping.SubjectDomainPolicy)
+			if err != nil {
+				return nil, err
+			}
+			mappings = append(mappings, policyMapping{IssuerDomainPolicy: issuerDomainPolicy, SubjectDomainPolicy: subjectDomainPolicy})
+		}
+		mappingsBytes, err := asn1.Marshal(mappings)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionPolicyMappings, Critical: true, Value: mappingsBytes})
+	}
+
+	// requireExplicitPolicy and inhibitPolicyMapping are implicitly tagged [0] and [1], and a value of 0 still has to be written
+	var constraints []asn1.RawValue
+	for tag, skipCerts := range []*int{certificatePolicies.RequireExplicitPolicy, certificatePolicies.InhibitPolicyMapping} {
+		if skipCerts == nil {
+			continue
+		}
+		constraintBytes, err := asn1.MarshalWithParams(*skipCerts, fmt.Sprintf("tag:%d", tag))
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, asn1.RawValue{FullBytes: constraintBytes})
+	}
+	if len(constraints) > 0 {
+		constraintsBytes, err := asn1.Marshal(constraints)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionPolicyConstraints, Critical: true, Value: constraintsBytes})
+	}
+
+	if certificatePolicies.InhibitAnyPolicy != nil {
+		inhibitAnyPolicyBytes, err := asn1.Marshal(*certificatePolicies.InhibitAnyPolicy)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionInhibitAnyPolicy, Critical: true, Value: inhibitAnyPolicyBytes})
+	}
+	return extensions, nil
+}
+
+// applyCertificatePolicies stamps the certificate policy extensions into a certificate template
+func applyCertificatePolicies(certificate *x509.Certificate, certificatePolicies *CertificatePolicyConfig, isCA bool) error {
+	extensions, err := certificatePolicyExtensions(certificatePolicies, isCA)
+	if err != nil {
+		return err
+	}
+	certificate.ExtraExtensions = append(certificate.ExtraExtensions, extensions...)
+	return nil
+}
+
+// asn1SequenceElements splits an ASN.1 SEQUENCE into its elements
+func asn1SequenceElements(der []byte) ([]asn1.RawValue, error) {
+	var sequence asn1.RawValue
+	rest, err := asn1.Unmarshal(der, &sequence)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 || sequence.Class != asn1.ClassUniversal || sequence.Tag != asn1.TagSequence {
+		return nil, fmt.Errorf("x509: malformed sequence")
+	}
+	var elements []asn1.RawValue
+	for data := sequence.Bytes; len(data) > 0; {
+		var element asn1.RawValue
+		if data, err = asn1.Unmarshal(data, &element); err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// decodeDisplayText decodes the UTF8String, IA5String, VisibleString or BMPString of a user notice
+func decodeDisplayText(displayText asn1.RawValue) string {
+	if displayText.Tag == asn1.TagBMPString {
+		var codeUnits []uint16
+		for i := 0; i+1 < len(displayText.Bytes); i += 2 {
+			codeUnits = append(codeUnits, uint16(displayText.Bytes[i])<<8|uint16(displayText.Bytes[i+1]))
+		}
+		return string(utf16.Decode(codeUnits))
+	}
+	return string(displayText.Bytes)
+}
+
+// parseUserNotice decodes a user notice qualifier
+func parseUserNotice(qualifier []byte) (UserNotice, error) {
+	userNotice := UserNotice{}
+	elements, err := asn1SequenceElements(qualifier)
+	if err != nil {
+		return userNotice, err
+	}
+	for _, element := range elements {
+		if element.Class != asn1.ClassUniversal || element.Tag != asn1.TagSequence {
+			userNotice.ExplicitText = decodeDisplayText(element)
+			continue
+		}
+		noticeRefElements, err := asn1SequenceElements(element.FullBytes)
+		if err != nil || len(noticeRefElements) != 2 {
+			return userNotice, fmt.Errorf("x509: malformed notice reference")
+		}
+		userNotice.Organization = decodeDisplayText(noticeRefElements[0])
+		if _, err := asn1.Unmarshal(noticeRefElements[1].FullBytes, &userNotice.NoticeNumbers); err != nil {
+			return userNotice, err
+		}
+	}
+	return userNotice, nil
+}
+
+// parseCertificatePolicies reads the certificate policy extensions of a certificate, nil when it has none
+func parseCertificatePolicies(certificate *x509.Certificate) (*CertificatePolicyConfig, error) {
+	var certificatePolicies *CertificatePolicyConfig
+	for _, extension := range certificate.Extensions {
+		switch {
+		case extension.Id.Equal(oidExtensionCertificatePolicies):
+			var policyInformations []policyInformation
+			if _, err := asn1.Unmarshal(extension.Value, &policyInformations); err != nil {
+				return nil, err
+			}
+			if certificatePolicies == nil {
+				certificatePolicies = &CertificatePolicyConfig{}
+			}
+			for _, information := range policyInformations {
+				policy := CertificatePolicy{OID: information.Policy.String()}
+				for _, qualifier := range information.Qualifiers {
+					switch {
+					case qualifier.QualifierID.Equal(oidPolicyQualifierCPS):
+						policy.CPSURIs = append(policy.CPSURIs, string(qualifier.Qualifier.Bytes))
+					case qualifier.QualifierID.Equal(oidPolicyQualifierUserNotice):
+						userNotice, err := parseUserNotice(qualifier.Qualifier.FullBytes)
+						if err != nil {
+							return nil, err
+						}
+						policy.UserNotices = append(policy.UserNotices, userNotice)
+					}
+				}
+				certificatePolicies.Policies = append(certificatePolicies.Policies, policy)
+			}
+
+		case extension.Id.Equal(oidExtensionPolicyMappings):
+			var mappings []policyMapping
+			if _, err := asn1.Unmarshal(extension.Value, &mappings); err != nil {
+				return nil, err
+			}
+			if certificatePolicies == nil {
+				certificatePolicies = &CertificatePolicyConfig{}
+			}
+			for _, mapping := range mappings {
+				certificatePolicies.PolicyMappings = append(certificatePolicies.PolicyMappings, PolicyMapping{IssuerDomainPolicy: mapping.IssuerDomainPolicy.String(), SubjectDomainPolicy: mapping.SubjectDomainPolicy.String()})
+			}
+
+		case extension.Id.Equal(oidExtensionPolicyConstraints):
+			constraints, err := asn1SequenceElements(extension.Value)
+			if err != nil {
+				return nil, err
+			}
+			if certificatePolicies == nil {
+				certificatePolicies = &CertificatePolicyConfig{}
+			}
+			for _, constraint := range constraints {
+				if constraint.Class != asn1.ClassContextSpecific || constraint.Tag > 1 {
+					continue
+				}
+				var skipCerts int
+				if _, err := asn1.UnmarshalWithParams(constraint.FullBytes, &skipCerts, fmt.Sprintf("tag:%d", constraint.Tag)); err != nil {
+					return nil, err
+				}
+				if constraint.Tag == 0 {
+					certificatePolicies.RequireExplicitPolicy = &skipCerts
+				} else {
+					certificatePolicies.InhibitPolicyMapping = &skipCerts
+				}
+			}
+
+		case extension.Id.Equal(oidExtensionInhibitAnyPolicy):
+			var skipCerts int
+			if _, err := asn1.Unmarshal(extension.Value, &skipCerts); err != nil {
+				return nil, err
+			}
+			if certificatePolicies == nil {
+				certificatePolicies = &CertificatePolicyConfig{}
+			}
+			certificatePolicies.InhibitAnyPolicy = &skipCerts
+		}
+	}
+	return certificatePolicies, nil
+}
@@ -0,0 +1,181 @@
+package locksmith
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOID(t *testing.T) {
+	tests := []struct {
+		oid       string
+		want      asn1.ObjectIdentifier
+		wantError bool
+	}{
+		{"2.23.140.1.2.1", asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}, false},
+		{"1.3.6.1.4.1.99999.1", asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, false},
+		{"2.999", asn1.ObjectIdentifier{2, 999}, false},
+		{"1", nil, true},
+		{"1.40", nil, true},
+		{"3.1", nil, true},
+		{"1.2.a", nil, true},
+		{"1.2.-3", nil, true},
+	}
+	for _, tt := range tests {
+		oid, err := parseOID(tt.oid)
+		if (err != nil) != tt.wantError {
+			t.Errorf("parseOID(%q) got error %v, want error %v", tt.oid, err, tt.wantError)
+			continue
+		}
+		if !tt.wantError && !oid.Equal(tt.want) {
+			t.Errorf("parseOID(%q) = %v, want %v", tt.oid, oid, tt.want)
+		}
+	}
+}
+
+func TestValidateCertificatePolicies(t *testing.T) {
+	tests := []struct {
+		name                string
+		certificatePolicies *CertificatePolicyConfig
+		wantError           bool
+	}{
+		{"none", nil, false},
+		{"policy with qualifiers", &CertificatePolicyConfig{Policies: []CertificatePolicy{{
+			OID:         "2.23.140.1.2.1",
+			CPSURIs:     []string{"https://pki.example.labs/cps"},
+			UserNotices: []UserNotice{{Organization: "Example Labs", NoticeNumbers: []int{1}, ExplicitText: "For testing only"}},
+		}}}, false},
+		{"invalid OID", &CertificatePolicyConfig{Policies: []CertificatePolicy{{OID: "policy"}}}, true},
+		{"duplicate policy", &CertificatePolicyConfig{Policies: []CertificatePolicy{{OID: "2.23.140.1.2.1"}, {OID: "2.23.140.1.2.1"}}}, true},
+		{"relative CPS URI", &CertificatePolicyConfig{Policies: []CertificatePolicy{{OID: "2.23.140.1.2.1", CPSURIs: []string{"/cps"}}}}, true},
+		{"empty user notice", &CertificatePolicyConfig{Policies: []CertificatePolicy{{OID: "2.23.140.1.2.1", UserNotices: []UserNotice{{}}}}}, true},
+		{"organization without notice numbers", &CertificatePolicyConfig{Policies: []CertificatePolicy{{OID: "2.23.140.1.2.1", UserNotices: []UserNotice{{Organization: "Example Labs"}}}}}, true},
+		{"mapping anyPolicy", &CertificatePolicyConfig{PolicyMappings: []PolicyMapping{{IssuerDomainPolicy: "2.5.29.32.0", SubjectDomainPolicy: "2.23.140.1.2.1"}}}, true},
+		{"negative inhibit_any_policy", &CertificatePolicyConfig{InhibitAnyPolicy: intPointer(-1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCertificatePolicies(tt.certificatePolicies); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+// createTestPolicyCertificate signs a self-signed certificate carrying the certificate policy extensions
+func createTestPolicyCertificate(t *testing.T, certificatePolicies *CertificatePolicyConfig, isCA bool) *x509.Certificate {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Policy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if err := applyCertificatePolicies(template, certificatePolicies, isCA); err != nil {
+		t.Fatal(err)
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, privKey.Public(), privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func TestCertificatePoliciesRoundTrip(t *testing.T) {
+	certificatePolicies := &CertificatePolicyConfig{
+		Policies: []CertificatePolicy{
+			{
+				OID:         "1.3.6.1.4.1.99999.1",
+				CPSURIs:     []string{"https://pki.example.labs/cps"},
+				UserNotices: []UserNotice{{Organization: "Example Labs", NoticeNumbers: []int{1, 2}, ExplicitText: "For testing only"}},
+			},
+			{OID: "2.23.140.1.2.1"},
+		},
+		PolicyMappings:        []PolicyMapping{{IssuerDomainPolicy: "1.3.6.1.4.1.99999.1", SubjectDomainPolicy: "1.3.6.1.4.1.88888.1"}},
+		RequireExplicitPolicy: intPointer(0),
+		InhibitPolicyMapping:  intPointer(2),
+		InhibitAnyPolicy:      intPointer(1),
+	}
+
+	caCert := createTestPolicyCertificate(t, certificatePolicies, true)
+	if len(caCert.PolicyIdentifiers) != 2 || !caCert.PolicyIdentifiers[1].Equal(asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}) {
+		t.Fatalf("got policy identifiers %v", caCert.PolicyIdentifiers)
+	}
+	parsedPolicies, err := parseCertificatePolicies(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsedPolicies, certificatePolicies) {
+		t.Fatalf("got %+v, want %+v", parsedPolicies, certificatePolicies)
+	}
+
+	// Certificates that are not CAs only get the certificatePolicies extension
+	leafCert := createTestPolicyCertificate(t, certificatePolicies, false)
+	parsedPolicies, err = parseCertificatePolicies(leafCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsedPolicies, &CertificatePolicyConfig{Policies: certificatePolicies.Policies}) {
+		t.Fatalf("got %+v, want only the policies", parsedPolicies)
+	}
+
+	// A certificate without the extensions has no certificate policies
+	if parsedPolicies, err := parseCertificatePolicies(createTestPolicyCertificate(t, nil, true)); err != nil || parsedPolicies != nil {
+		t.Fatalf("got %+v %v, want none", parsedPolicies, err)
+	}
+}
+
+func TestDecodeDisplayText(t *testing.T) {
+	bmpString := asn1.RawValue{Tag: asn1.TagBMPString, Bytes: []byte{0x00, 'H', 0x00, 'i', 0x00, 0xe9}}
+	if text := decodeDisplayText(bmpString); text != "Hié" {
+		t.Fatalf("got %q, want Hié", text)
+	}
+	utf8String := asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte("Hié")}
+	if text := decodeDisplayText(utf8String); text != "Hié" {
+		t.Fatalf("got %q, want Hié", text)
+	}
+}
+
+func TestCertificatePoliciesStampedByCA(t *testing.T) {
+	useTestPKIRoot(t)
+	certConfig := testCertificateConfiguration("Policy Root CA", "ecdsa")
+	certConfig.CertificatePolicies = &CertificatePolicyConfig{
+		Policies:         []CertificatePolicy{{OID: "2.23.140.1.2.1", CPSURIs: []string{"https://pki.example.labs/cps"}}},
+		InhibitAnyPolicy: intPointer(0),
+	}
+	caPath, caCert := createTestRootCA(t, certConfig)
+	if caPolicies, err := parseCertificatePolicies(&caCert); err != nil || !reflect.DeepEqual(caPolicies, certConfig.CertificatePolicies) {
+		t.Fatalf("got CA policies %+v %v", caPolicies, err)
+	}
+
+	// Certificates issued by the CA carry its policies, without the CA only extensions
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", &x509.CertificateRequest{DNSNames: []string{"www.example.labs"}})
+	created, certificate, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	certificatePolicies, err := parseCertificatePolicies(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(certificatePolicies, &CertificatePolicyConfig{Policies: certConfig.CertificatePolicies.Policies}) {
+		t.Fatalf("got certificate policies %+v", certificatePolicies)
+	}
+}
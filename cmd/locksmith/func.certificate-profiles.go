@@ -0,0 +1,172 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// certificateProfileForType finds the built-in or config.yml profile of a certificate type
+// authority and authority-no-subs are the subordinate-ca profile, and no certificate type is a server certificate
+func certificateProfileForType(certificateType string) (certificateProfile, error) {
+	switch certificateType {
+	case "":
+		certificateType = "server"
+	case "authority", "authority-no-subs":
+		certificateType = "subordinate-ca"
+	}
+	if profile, ok := builtinCertificateProfiles[certificateType]; ok {
+		return profile, nil
+	}
+
+	if readConfig != nil {
+		for _, profileConfig := range readConfig.Locksmith.CertificateProfiles {
+			if profileConfig.Name == certificateType {
+				return profileFromConfig(profileConfig)
+			}
+		}
+	}
+	return certificateProfile{}, Stoerr("invalid-certificate-type")
+}
+
+// certificateTypes lists the built-in and config.yml certificate types
+func certificateTypes() []string {
+	types := []string{"server", "client", "server-client", "code-signing", "email", "ocsp-signing", "timestamping", "subordinate-ca"}
+	if readConfig != nil {
+		for _, profileConfig := range readConfig.Locksmith.CertificateProfiles {
+			types = append(types, profileConfig.Name)
+		}
+	}
+	return types
+}
+
+// profileFromConfig turns a config.yml certificate profile into the key usages it stamps in
+func profileFromConfig(profileConfig CertificateProfileConfig) (certificateProfile, error) {
+	profile := certificateProfile{
+		ExtKeyUsage:         profileConfig.ExtKeyUsage,
+		CriticalExtKeyUsage: profileConfig.CriticalExtKeyUsage,
+		RequireEmailAddress: profileConfig.RequireEmailAddress}
+	for _, keyUsageName := range profileConfig.KeyUsage {
+		keyUsage, ok := keyUsagesByName[keyUsageName]
+		if !ok {
+			return certificateProfile{}, fmt.Errorf("certificate profile '%s' has an unknown key usage '%s'", profileConfig.Name, keyUsageName)
+		}
+		profile.KeyUsage |= keyUsage
+	}
+	if _, _, _, err := resolveExtKeyUsages(profile.ExtKeyUsage); err != nil {
+		return certificateProfile{}, fmt.Errorf("certificate profile '%s' has %s", profileConfig.Name, err.Error())
+	}
+	return profile, nil
+}
+
+// validateCertificateProfiles makes sure the config.yml certificate profiles are usable and do not hide a built-in certificate type
+func validateCertificateProfiles(profileConfigs []CertificateProfileConfig) error {
+	seenProfiles := map[string]bool{}
+	for _, profileConfig := range profileConfigs {
+		if profileConfig.Name == "" {
+			return fmt.Errorf("certificate profiles need a name")
+		}
+		if _, ok := builtinCertificateProfiles[profileConfig.Name]; ok || profileConfig.Name == "authority" || profileConfig.Name == "authority-no-subs" {
+			return fmt.Errorf("certificate profile '%s' is a built-in certificate type", profileConfig.Name)
+		}
+		if seenProfiles[profileConfig.Name] {
+			return fmt.Errorf("certificate profile '%s' is listed twice", profileConfig.Name)
+		}
+		seenProfiles[profileConfig.Name] = true
+
+		profile, err := profileFromConfig(profileConfig)
+		if err != nil {
+			return err
+		}
+		if profile.KeyUsage == 0 && len(profile.ExtKeyUsage) == 0 {
+			return fmt.Errorf("certificate profile '%s' needs a key_usage or ext_key_usage", profileConfig.Name)
+		}
+	}
+	return nil
+}
+
+// resolveExtKeyUsages turns extended key usage names and dotted OIDs into the usages Go knows, the OIDs it does not, and the OIDs of all of them
+func resolveExtKeyUsages(extKeyUsageNames []string) ([]x509.ExtKeyUsage, []asn1.ObjectIdentifier, []asn1.ObjectIdentifier, error) {
+	var extKeyUsages []x509.ExtKeyUsage
+	var unknownOIDs []asn1.ObjectIdentifier
+	var oids []asn1.ObjectIdentifier
+	for _, extKeyUsageName := range extKeyUsageNames {
+		if extKeyUsage, ok := extKeyUsagesByName[extKeyUsageName]; ok {
+			extKeyUsages = append(extKeyUsages, extKeyUsage.usage)
+			oids = append(oids, extKeyUsage.oid)
+			continue
+		}
+		oid, err := parseOID(extKeyUsageName)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("an unknown ext key usage '%s'", extKeyUsageName)
+		}
+		unknownOIDs = append(unknownOIDs, oid)
+		oids = append(oids, oid)
+	}
+	return extKeyUsages, unknownOIDs, oids, nil
+}
+
+// setupProfileCert creates the Certificate structure of a certificate issued with a certificate profile
+func setupProfileCert(profile certificateProfile, serialNumber int64, csr *x509.CertificateRequest, addTime []int, signingPubKey crypto.PublicKey, csrPublicKey crypto.PublicKey) (*x509.Certificate, error) {
+	if profile.RequireEmailAddress && len(csr.EmailAddresses) == 0 {
+		return nil, Stoerr("the certificate type needs an email address in the CSR")
+	}
+
+	// Set time for UTC format
+	currentTime := time.Now()
+	yesterdayTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).Add(-24 * time.Hour)
+
+	// Set up SubjectKeyID from Public Key
+	publicKeyBytes, _, err := marshalPublicKey(signingPubKey)
+	check(err)
+	h := sha1.Sum(publicKeyBytes)
+	subjectKeyID := h[:]
+
+	keyUsage := profile.KeyUsage
+	if _, isRSA := csrPublicKey.(*rsa.PublicKey); isRSA && profile.RSAKeyEncipherment {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	certificate := &x509.Certificate{
+		SerialNumber:          big.NewInt(serialNumber),
+		Subject:               csr.Subject,
+		IPAddresses:           csr.IPAddresses,
+		URIs:                  csr.URIs,
+		DNSNames:              csr.DNSNames,
+		EmailAddresses:        csr.EmailAddresses,
+		NotBefore:             time.Date(yesterdayTime.Year(), yesterdayTime.Month(), yesterdayTime.Day(), 0, 0, 0, 0, yesterdayTime.Location()),
+		NotAfter:              time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).AddDate(addTime[0], addTime[1], addTime[2]),
+		KeyUsage:              keyUsage,
+		AuthorityKeyId:        subjectKeyID,
+		BasicConstraintsValid: true,
+		IsCA:                  profile.IsCA,
+	}
+
+	extKeyUsages, unknownExtKeyUsages, extKeyUsageOIDs, err := resolveExtKeyUsages(profile.ExtKeyUsage)
+	if err != nil {
+		return nil, err
+	}
+	if profile.CriticalExtKeyUsage && len(extKeyUsageOIDs) > 0 {
+		// Go always writes the extended key usage non-critical, written by hand it takes the place of the generated one
+		extKeyUsageBytes, err := asn1.Marshal(extKeyUsageOIDs)
+		if err != nil {
+			return nil, err
+		}
+		certificate.ExtraExtensions = append(certificate.ExtraExtensions, pkix.Extension{Id: oidExtensionExtendedKeyUsage, Critical: true, Value: extKeyUsageBytes})
+	} else {
+		certificate.ExtKeyUsage = extKeyUsages
+		certificate.UnknownExtKeyUsage = unknownExtKeyUsages
+	}
+
+	if profile.OCSPNoCheck {
+		// id-pkix-ocsp-nocheck carries an ASN.1 NULL
+		certificate.ExtraExtensions = append(certificate.ExtraExtensions, pkix.Extension{Id: oidExtensionOCSPNoCheck, Value: asn1.NullBytes})
+	}
+	return certificate, nil
+}
@@ -0,0 +1,173 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+// findExtension returns the extension of a certificate with the OID, if any
+func findExtension(certificate *x509.Certificate, oid asn1.ObjectIdentifier) (pkix.Extension, bool) {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(oid) {
+			return extension, true
+		}
+	}
+	return pkix.Extension{}, false
+}
+
+func TestCertificateProfileForType(t *testing.T) {
+	useTestPKIRoot(t)
+	readConfig.Locksmith.CertificateProfiles = []CertificateProfileConfig{
+		{Name: "vpn", KeyUsage: []string{"digital_signature"}, ExtKeyUsage: []string{"server_auth", "1.3.6.1.5.5.8.2.2"}},
+		{Name: "broken", KeyUsage: []string{"sign_everything"}},
+	}
+
+	tests := []struct {
+		certificateType string
+		want            certificateProfile
+		wantError       bool
+	}{
+		{"", builtinCertificateProfiles["server"], false},
+		{"authority", builtinCertificateProfiles["subordinate-ca"], false},
+		{"authority-no-subs", builtinCertificateProfiles["subordinate-ca"], false},
+		{"timestamping", builtinCertificateProfiles["timestamping"], false},
+		{"vpn", certificateProfile{KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []string{"server_auth", "1.3.6.1.5.5.8.2.2"}}, false},
+		{"broken", certificateProfile{}, true},
+		{"unknown", certificateProfile{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.certificateType, func(t *testing.T) {
+			profile, err := certificateProfileForType(tt.certificateType)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if profile.KeyUsage != tt.want.KeyUsage || len(profile.ExtKeyUsage) != len(tt.want.ExtKeyUsage) || profile.IsCA != tt.want.IsCA {
+				t.Fatalf("got profile %+v, want %+v", profile, tt.want)
+			}
+		})
+	}
+
+	types := certificateTypes()
+	if types[len(types)-2] != "vpn" || types[len(types)-1] != "broken" {
+		t.Fatalf("got certificate types %v, want the config.yml profiles listed last", types)
+	}
+}
+
+func TestValidateCertificateProfiles(t *testing.T) {
+	tests := []struct {
+		name           string
+		profileConfigs []CertificateProfileConfig
+		wantError      bool
+	}{
+		{"none", nil, false},
+		{"valid", []CertificateProfileConfig{{Name: "vpn", ExtKeyUsage: []string{"1.3.6.1.5.5.8.2.2"}}, {Name: "signing", KeyUsage: []string{"content_commitment"}}}, false},
+		{"no name", []CertificateProfileConfig{{KeyUsage: []string{"digital_signature"}}}, true},
+		{"built-in name", []CertificateProfileConfig{{Name: "server", KeyUsage: []string{"digital_signature"}}}, true},
+		{"authority name", []CertificateProfileConfig{{Name: "authority", KeyUsage: []string{"digital_signature"}}}, true},
+		{"listed twice", []CertificateProfileConfig{{Name: "vpn", KeyUsage: []string{"digital_signature"}}, {Name: "vpn", KeyUsage: []string{"digital_signature"}}}, true},
+		{"unknown key usage", []CertificateProfileConfig{{Name: "vpn", KeyUsage: []string{"sign_everything"}}}, true},
+		{"unknown ext key usage", []CertificateProfileConfig{{Name: "vpn", ExtKeyUsage: []string{"vpn_auth"}}}, true},
+		{"no usages", []CertificateProfileConfig{{Name: "vpn"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCertificateProfiles(tt.profileConfigs); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestResolveExtKeyUsages(t *testing.T) {
+	extKeyUsages, unknownOIDs, oids, err := resolveExtKeyUsages([]string{"server_auth", "1.3.6.1.5.5.8.2.2", "any"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extKeyUsages) != 2 || extKeyUsages[0] != x509.ExtKeyUsageServerAuth || extKeyUsages[1] != x509.ExtKeyUsageAny {
+		t.Fatalf("got ext key usages %v", extKeyUsages)
+	}
+	if len(unknownOIDs) != 1 || !unknownOIDs[0].Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 2, 2}) {
+		t.Fatalf("got unknown OIDs %v", unknownOIDs)
+	}
+	if len(oids) != 3 || !oids[0].Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}) {
+		t.Fatalf("got OIDs %v", oids)
+	}
+	if _, _, _, err := resolveExtKeyUsages([]string{"vpn_auth"}); err == nil {
+		t.Fatal("expected an error for an unknown ext key usage")
+	}
+}
+
+func TestIssueProfileCertificates(t *testing.T) {
+	useTestPKIRoot(t)
+	readConfig.Locksmith.CertificateProfiles = []CertificateProfileConfig{
+		{Name: "vpn", KeyUsage: []string{"digital_signature"}, ExtKeyUsage: []string{"server_auth", "1.3.6.1.5.5.8.2.2"}},
+	}
+	caPath, _ := createTestRootCA(t, testCertificateConfiguration("Profile Root CA", "ecdsa"))
+
+	issue := func(commonName string, keyAlgorithm string, certificateType string, template *x509.CertificateRequest) (*x509.Certificate, error) {
+		t.Helper()
+		csr, _ := createTestCSR(t, commonName, keyAlgorithm, template)
+		_, certificate, _, err := createNewCertificateFromCSR(caPath, "", csr, certificateType, csr.PublicKey, []int{0, 1, 0})
+		return certificate, err
+	}
+
+	// RSA server keys can also transport keys, EC keys can not
+	rsaServer, err := issue("rsa.example.labs", "rsa", "server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsaServer.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment {
+		t.Fatalf("got RSA server key usage %v", rsaServer.KeyUsage)
+	}
+	ecdsaServer, err := issue("ecdsa.example.labs", "ecdsa", "server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ecdsaServer.KeyUsage != x509.KeyUsageDigitalSignature || len(ecdsaServer.ExtKeyUsage) != 1 || ecdsaServer.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Fatalf("got ECDSA server key usage %v %v", ecdsaServer.KeyUsage, ecdsaServer.ExtKeyUsage)
+	}
+
+	// Email certificates need an email address
+	if _, err := issue("No Email", "ecdsa", "email", nil); err == nil {
+		t.Fatal("issued an email certificate without an email address")
+	}
+	if _, err := issue("Email", "ecdsa", "email", &x509.CertificateRequest{EmailAddresses: []string{"admin@example.labs"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Timestamping certificates have a critical extended key usage
+	timestamping, err := issue("Timestamping", "ecdsa", "timestamping", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extension, ok := findExtension(timestamping, oidExtensionExtendedKeyUsage); !ok || !extension.Critical {
+		t.Fatal("timestamping certificate has no critical extended key usage")
+	}
+	if len(timestamping.ExtKeyUsage) != 1 || timestamping.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping {
+		t.Fatalf("got timestamping ext key usage %v", timestamping.ExtKeyUsage)
+	}
+
+	// OCSP responder certificates tell relying parties not to check their revocation
+	ocspSigning, err := issue("OCSP Responder", "ecdsa", "ocsp-signing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findExtension(ocspSigning, oidExtensionOCSPNoCheck); !ok {
+		t.Fatal("OCSP signing certificate has no OCSP no check extension")
+	}
+
+	// config.yml profiles stamp in their usages, including OIDs Go does not know
+	vpn, err := issue("vpn.example.labs", "ecdsa", "vpn", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vpn.ExtKeyUsage) != 1 || len(vpn.UnknownExtKeyUsage) != 1 || !vpn.UnknownExtKeyUsage[0].Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 2, 2}) {
+		t.Fatalf("got VPN ext key usage %v %v", vpn.ExtKeyUsage, vpn.UnknownExtKeyUsage)
+	}
+
+	if _, err := issue("unknown.example.labs", "ecdsa", "unknown", nil); err == nil {
+		t.Fatal("issued a certificate of an unknown type")
+	}
+}
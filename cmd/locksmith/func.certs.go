@@ -2,18 +2,20 @@ package locksmith
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"io/ioutil"
-	"math/big"
-	"time"
+	"os"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
 )
 
 // createNewCertificateFromCSR allows the maturation of a CSR to a Certificate
-func createNewCertificateFromCSR(signingCAPath string, signingCAPassphrase string, csr *x509.CertificateRequest, certificateType string, csrPublicKey *rsa.PublicKey, expirationDate []int) (certCreated bool, certificate *x509.Certificate, messages []string, err error) {
+func createNewCertificateFromCSR(signingCAPath string, signingCAPassphrase string, csr *x509.CertificateRequest, certificateType string, csrPublicKey crypto.PublicKey, expirationDate []int) (certCreated bool, certificate *x509.Certificate, messages []string, err error) {
 	// Check to make sure the ca.pem file exists
 	signingCACertExists, err := FileExists(signingCAPath + "/certs/ca.pem")
 	check(err)
@@ -26,8 +28,12 @@ func createNewCertificateFromCSR(signingCAPath string, signingCAPassphrase strin
 	signingCACertFileBytes, err := ReadCACertificate(signingCAPath)
 	check(err)
 
-	// Check for Signing CA Private Key file
-	signingCAPrivateKeyExists, err := FileExists(signingCAPath + "/private/ca.priv.pem")
+	// Check for the Signing CA Private Key in its signer backend
+	signingCASignerBackend, err := signerBackendForCA(signingCAPath)
+	if err != nil {
+		return false, &x509.Certificate{}, []string{"Signing CA Signer Backend is misconfigured!"}, err
+	}
+	signingCAPrivateKeyExists, err := signingCASignerBackend.HasKey()
 	check(err)
 
 	if !signingCAPrivateKeyExists {
@@ -35,7 +41,13 @@ func createNewCertificateFromCSR(signingCAPath string, signingCAPassphrase strin
 		return false, &x509.Certificate{}, []string{"Signing CA Private Key does not exist!"}, Stoerr("no-signing-ca-key")
 	}
 	// Open Signing CA Key Pair
-	signingCAPrivateKey := GetPrivateKey(signingCAPath+"/private/ca.priv.pem", signingCAPassphrase)
+	signingCAPrivateKey, err := signingCASignerBackend.Signer(signingCAPassphrase)
+	if err != nil {
+		if errors.Is(err, errCAOffline) {
+			return false, &x509.Certificate{}, []string{"Signing CA is offline, open an unlock session before signing with it"}, err
+		}
+		return false, &x509.Certificate{}, []string{"Signing CA Private Key could not be opened!"}, err
+	}
 	signingCAPublicKey := GetPublicKey(signingCAPath + "/private/ca.pub.pem")
 
 	// Check for the Signing CA's Serial file
@@ -63,30 +75,65 @@ func createNewCertificateFromCSR(signingCAPath string, signingCAPassphrase strin
 		return false, &x509.Certificate{}, []string{"Invalid expiration date!"}, Stoerr("invalid-expiration-date")
 	}
 
-	// Assemble certificate
-	switch certificateType {
-	case "authority":
-		// do something for CA certs
-	case "server":
-	default:
-		// by default, we'll generate a server type certificate
-		certificate = setupServerCert(currentSerial, csr, expirationDate, signingCAPublicKey)
+	// Assemble certificate from the profile of its certificate type
+	profile, err := certificateProfileForType(certificateType)
+	if err != nil {
+		return false, &x509.Certificate{}, []string{"Unknown certificate type '" + certificateType + "', use one of " + strings.Join(certificateTypes(), "|")}, err
+	}
+	certificate, err = setupProfileCert(profile, currentSerial, csr, expirationDate, signingCAPublicKey, csrPublicKey)
+	if err != nil {
+		return false, &x509.Certificate{}, []string{err.Error()}, Stoerr("invalid-certificate-type")
+	}
+	if profile.IsCA {
+		// Subordinate CAs have to fit under the path length of the Signing CA and the CAs above it
+		pathLength, pathLengthMessages, err := checkPathLengthForCA(signingCAPath, caPathLength(CertificateConfiguration{CertificateType: certificateType}))
+		if err != nil {
+			return false, &x509.Certificate{}, pathLengthMessages, err
+		}
+		applyPathLength(certificate, pathLength)
+	}
+
+	// Refuse names the Signing CA or the CAs above it are not allowed to certify
+	if violations, err := checkNameConstraintsForCA(signingCAPath, certificate); err != nil {
+		return false, &x509.Certificate{}, violations, err
+	}
+
+	// Stamp in the certificate policies of the Signing CA
+	signingCACertificatePolicies, err := certificatePoliciesForCA(signingCAPath)
+	if err != nil {
+		return false, &x509.Certificate{}, []string{"Signing CA Certificate Policies could not be read!"}, err
+	}
+	if err := applyCertificatePolicies(certificate, signingCACertificatePolicies, false); err != nil {
+		return false, &x509.Certificate{}, []string{"Signing CA Certificate Policies could not be set!"}, err
+	}
+
+	// Stamp in the URLs of the Signing CA
+	if err := applyDistributionPoints(certificate, signingCAPath, signingCACertFileBytes.Subject.CommonName); err != nil {
+		return false, &x509.Certificate{}, []string{"Signing CA Distribution Points could not be set!"}, err
 	}
 
 	// Sign Certificate
 	certBytes, err := CreateCert(certificate, signingCACertFileBytes, csrPublicKey, signingCAPrivateKey)
-	check(err)
+	if err != nil {
+		return false, &x509.Certificate{}, []string{"Certificate signing failed: " + err.Error()}, err
+	}
 
 	// Save Signed Certificate File
 	certificatePath := signingCAPath + "/certs/" + slugger(certificate.Subject.CommonName) + ".pem"
 	certificateFile, err := writeCertificateFile(pemEncodeCertificate(certBytes), certificatePath)
-	check(err)
+	if err != nil {
+		return false, &x509.Certificate{}, []string{"Certificate could not be written!"}, err
+	}
 	if !certificateFile {
-		return false, &x509.Certificate{}, []string{"Certificate Creation Failure!"}, err
+		return false, &x509.Certificate{}, []string{"Certificate Creation Failure!"}, Stoerr("certificate-creation-error")
 	}
 
 	cert, err := ReadCertFromFile(certificatePath)
-	check(err)
+	if err != nil {
+		// Nothing points at the certificate yet, so it does not stay behind half written
+		check(os.Remove(certificatePath))
+		return false, &x509.Certificate{}, []string{"Certificate could not be read back!"}, err
+	}
 
 	// Increment Signing CA Serial Number
 	increaseSerial, err := IncreaseSerialNumberAbs(signingCAPath + "/ca.serial")
@@ -108,36 +155,6 @@ func createNewCertificateFromCSR(signingCAPath string, signingCAPassphrase strin
 	return true, cert, []string{"Certificate created successfully!"}, nil
 }
 
-// setupServerCert creates the Certificate structure of a Server type certificate
-func setupServerCert(serialNumber int64, csr *x509.CertificateRequest, addTime []int, signingPubKey *rsa.PublicKey) *x509.Certificate {
-
-	// Set time for UTC format
-	currentTime := time.Now()
-	yesterdayTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).Add(-24 * time.Hour)
-
-	// Set up SubjectKeyID from Public Key
-	publicKeyBytes, _, err := marshalPublicKey(signingPubKey)
-	check(err)
-	h := sha1.Sum(publicKeyBytes)
-	subjectKeyID := h[:]
-
-	return &x509.Certificate{
-		SignatureAlgorithm:    x509.SHA512WithRSA,
-		SerialNumber:          big.NewInt(serialNumber),
-		Subject:               csr.Subject,
-		IPAddresses:           csr.IPAddresses,
-		URIs:                  csr.URIs,
-		DNSNames:              csr.DNSNames,
-		NotBefore:             time.Date(yesterdayTime.Year(), yesterdayTime.Month(), yesterdayTime.Day(), 0, 0, 0, 0, yesterdayTime.Location()),
-		NotAfter:              time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).AddDate(addTime[0], addTime[1], addTime[2]),
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature,
-		AuthorityKeyId:        subjectKeyID,
-		BasicConstraintsValid: true,
-		IsCA:                  false,
-	}
-}
-
 // pemEncodeCertificate
 func pemEncodeCertificate(certByte []byte) *bytes.Buffer {
 	pemRet := new(bytes.Buffer)
@@ -159,7 +176,9 @@ func writeCertificateFile(certPem *bytes.Buffer, path string) (bool, error) {
 }
 
 // CreateCert is a wrapper for x509.CreateCertificate to switch between parent certificates through the chain
+// The signature algorithm is chosen to match the signing key
 func CreateCert(certTemplate *x509.Certificate, signingCert *x509.Certificate, certPubkey, signingPrivKey interface{}) (cert []byte, err error) {
+	certTemplate.SignatureAlgorithm = signatureAlgorithmForKey(signingPrivKey)
 	return x509.CreateCertificate(rand.Reader, certTemplate, signingCert, certPubkey, signingPrivKey)
 }
 
@@ -196,5 +215,37 @@ func generateCABundle(caPath string) string {
 			path = path + "intermed-ca/"
 		}
 	}
+
+	// Top level CAs imported with a chain carry the certificates above them
+	chainBytes, err := ReadFileToBytes(readConfig.Locksmith.PKIRoot + "/roots/" + slugger(splitPath[0]) + "/" + caChainFileName)
+	if err == nil {
+		pemString = pemString + string(chainBytes)
+	}
 	return pemString
 }
+
+// createPKCS12Bundle packs a certificate, its private key, and the PEM bundle of its signing CA chain into a password protected PKCS #12 file
+func createPKCS12Bundle(certificate *x509.Certificate, privKey crypto.Signer, caBundle string, exportPassword string) ([]byte, error) {
+	// Make sure the key actually belongs to the certificate
+	pubKey, ok := privKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pubKey.Equal(certificate.PublicKey) {
+		return nil, Stoerr("private key does not match the certificate")
+	}
+
+	var caCerts []*x509.Certificate
+	rest := []byte(caBundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		caCert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		caCerts = append(caCerts, caCert)
+	}
+
+	return pkcs12.Encode(rand.Reader, privKey, certificate, caCerts, exportPassword)
+}
@@ -0,0 +1,118 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testCertificateConfiguration is the smallest configuration a CA is created from
+func testCertificateConfiguration(commonName string, keyAlgorithm string) CertificateConfiguration {
+	return CertificateConfiguration{
+		Subject: CertificateConfigurationSubject{
+			CommonName:         commonName,
+			Organization:       []string{"Example Labs"},
+			OrganizationalUnit: []string{"Example Labs Cyber and Information Security"},
+		},
+		ExpirationDate: []int{1, 0, 0},
+		KeyAlgorithm:   keyAlgorithm,
+	}
+}
+
+// createTestRootCA creates a Root CA under the PKI root and returns its path
+func createTestRootCA(t *testing.T, certConfig CertificateConfiguration) (string, x509.Certificate) {
+	t.Helper()
+	created, messages, caCert, err := createNewCA(certConfig)
+	if err != nil || !created {
+		t.Fatalf("creating Root CA failed: %v %v", err, messages)
+	}
+	return readConfig.Locksmith.PKIRoot + "/roots/" + slugger(certConfig.Subject.CommonName), caCert
+}
+
+// createTestCSR creates a certificate request for the common name signed with a new key of the algorithm
+func createTestCSR(t *testing.T, commonName string, keyAlgorithm string, template *x509.CertificateRequest) (*x509.CertificateRequest, crypto.Signer) {
+	t.Helper()
+	privKey, _, err := GenerateKeypair(keyAlgorithm, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template == nil {
+		template = &x509.CertificateRequest{}
+	}
+	template.Subject = pkix.Name{CommonName: commonName}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, template, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr, privKey
+}
+
+func TestEd25519CertificateChain(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, caCert := createTestRootCA(t, testCertificateConfiguration("Ed25519 Root CA", "ed25519"))
+	if caCert.PublicKeyAlgorithm != x509.Ed25519 || caCert.SignatureAlgorithm != x509.PureEd25519 {
+		t.Fatalf("got Root CA %s signed with %s, want Ed25519", caCert.PublicKeyAlgorithm, caCert.SignatureAlgorithm)
+	}
+	if err := caCert.CheckSignatureFrom(&caCert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Ed25519 CA signs an Ed25519 server certificate
+	csr, _ := createTestCSR(t, "www.example.labs", "ed25519", &x509.CertificateRequest{DNSNames: []string{"www.example.labs"}})
+	created, certificate, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	if certificate.PublicKeyAlgorithm != x509.Ed25519 {
+		t.Fatalf("got certificate key %s, want Ed25519", certificate.PublicKeyAlgorithm)
+	}
+	if err := certificate.CheckSignatureFrom(&caCert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreatePKCS12Bundle(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, caCert := createTestRootCA(t, testCertificateConfiguration("PKCS12 Root CA", "ecdsa"))
+	csr, privKey := createTestCSR(t, "OpenVPN Server", "ecdsa", nil)
+	created, certificate, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+
+	pfxData, err := createPKCS12Bundle(certificate, privKey, generateCABundle("PKCS12 Root CA"), "exp0rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundleKey, bundleCert, bundleCACerts, err := pkcs12.DecodeChain(pfxData, "exp0rt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !privKey.(*ecdsa.PrivateKey).Equal(bundleKey) || !bundleCert.Equal(certificate) {
+		t.Fatal("bundle does not hold the certificate and its key")
+	}
+	if len(bundleCACerts) != 1 || !bundleCACerts[0].Equal(&caCert) {
+		t.Fatalf("got %d CA certificates, want the Root CA", len(bundleCACerts))
+	}
+	if _, _, _, err := pkcs12.DecodeChain(pfxData, "wrong"); err == nil {
+		t.Fatal("bundle opened with the wrong export password")
+	}
+
+	// A key that does not belong to the certificate is refused
+	otherKey, _, err := GenerateKeypair("ecdsa", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createPKCS12Bundle(certificate, otherKey, "", "exp0rt"); err == nil {
+		t.Fatal("expected an error for a key that does not match the certificate")
+	}
+}
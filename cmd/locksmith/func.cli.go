@@ -1,6 +1,18 @@
 package locksmith
 
-import "flag"
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
 
 // ParseFlags will create and parse the CLI flags
 // and return the path to be used elsewhere
@@ -23,3 +35,435 @@ func ParseFlags() (string, error) {
 	// Return the configuration path
 	return configPath, nil
 }
+
+// RunCommand runs a one-off maintenance command given after the global flags instead of starting the server
+func RunCommand(args []string) error {
+	switch args[0] {
+	case "migrate-keys":
+		return runMigrateKeysCommand(args[1:])
+	case "migrate-index":
+		return runMigrateIndexCommand(args[1:])
+	case "rotate-kek":
+		return runRotateKEKCommand(args[1:])
+	case "import-ca":
+		return runImportCACommand(args[1:])
+	case "set-ca-offline":
+		return runSetCAOfflineCommand(args[1:])
+	case "unlock-ca":
+		return runUnlockCACommand(args[1:])
+	case "lock-ca":
+		return runLockCACommand(args[1:])
+	}
+	return fmt.Errorf("unknown command '%s'", args[0])
+}
+
+// runMigrateKeysCommand re-wraps every legacy encrypted private key under the PKI root with encryptPrivateKeyPEM,
+// into encrypted PKCS #8 by default or a versioned key envelope when key_encryption_format asks for one
+func runMigrateKeysCommand(args []string) error {
+	var passphrase string
+
+	cmdFlags := flag.NewFlagSet("migrate-keys", flag.ExitOnError)
+	cmdFlags.StringVar(&passphrase, "passphrase", os.Getenv("LOCKSMITH_KEY_PASSPHRASE"), "passphrase the keys were encrypted with, defaults to $LOCKSMITH_KEY_PASSPHRASE")
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+
+	if passphrase == "" {
+		return Stoerr("migrate-keys needs a -passphrase or $LOCKSMITH_KEY_PASSPHRASE")
+	}
+
+	migrated, skipped, err := migrateEncryptedKeys(readConfig.Locksmith.PKIRoot, passphrase)
+	for _, path := range migrated {
+		logStdOut("Migrated " + path)
+	}
+	for _, path := range skipped {
+		logStdOut("Skipped " + path + " - could not be decrypted with the supplied passphrase")
+	}
+	if err != nil {
+		return err
+	}
+
+	logStdOut(fmt.Sprintf("Migrated %d keys, skipped %d keys", len(migrated), len(skipped)))
+	return nil
+}
+
+// runMigrateIndexCommand rewrites the decimal serials of CA Index files under the PKI root as hex
+func runMigrateIndexCommand(args []string) error {
+	cmdFlags := flag.NewFlagSet("migrate-index", flag.ExitOnError)
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+
+	migrated, skipped, err := migrateCAIndexSerials(readConfig.Locksmith.PKIRoot)
+	for _, path := range migrated {
+		logStdOut("Migrated " + path)
+	}
+	for _, entry := range skipped {
+		logStdOut("Skipped " + entry + " - the certificate it points to could not be read")
+	}
+	if err != nil {
+		return err
+	}
+
+	logStdOut(fmt.Sprintf("Migrated %d index files, skipped %d entries", len(migrated), len(skipped)))
+	return nil
+}
+
+// runRotateKEKCommand re-wraps every private key under the PKI root with a new master key encryption key
+func runRotateKEKCommand(args []string) error {
+	var newKEKFile string
+
+	cmdFlags := flag.NewFlagSet("rotate-kek", flag.ExitOnError)
+	cmdFlags.StringVar(&newKEKFile, "new-kek-file", "", "file holding the new base64 encoded KEK, defaults to reading $LOCKSMITH_NEW_KEK")
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+
+	encodedKEK := os.Getenv("LOCKSMITH_NEW_KEK")
+	if newKEKFile != "" {
+		kekBytes, err := ReadFileToBytes(newKEKFile)
+		if err != nil {
+			return err
+		}
+		encodedKEK = string(kekBytes)
+	}
+	if encodedKEK == "" {
+		return Stoerr("rotate-kek needs a -new-kek-file or $LOCKSMITH_NEW_KEK")
+	}
+
+	newKEK, err := parseKEK(encodedKEK)
+	if err != nil {
+		return err
+	}
+
+	rotated, skipped, err := rotateKEK(readConfig.Locksmith.PKIRoot, newKEK)
+	for _, path := range rotated {
+		logStdOut("Re-wrapped " + path)
+	}
+	for _, path := range skipped {
+		logStdOut("Skipped " + path + " - already wrapped with the new KEK")
+	}
+	if err != nil {
+		return err
+	}
+
+	logStdOut(fmt.Sprintf("Re-wrapped %d keys with KEK %s, skipped %d keys - point kek_file/LOCKSMITH_KEK at the new KEK before restarting", len(rotated), kekID(newKEK), len(skipped)))
+	return nil
+}
+
+// runImportCACommand imports an existing external CA from its certificate and private key files
+func runImportCACommand(args []string) error {
+	var certFile, keyFile, chainFile, parentCAPath, openSSLDir string
+	var importPassphrase, passphrase string
+	var nextSerial, nextCRLNumber int64
+
+	cmdFlags := flag.NewFlagSet("import-ca", flag.ExitOnError)
+	cmdFlags.StringVar(&openSSLDir, "openssl-dir", "", "OpenSSL CA directory to import along with its index, serial, crlnumber and newcerts")
+	cmdFlags.StringVar(&certFile, "cert", "", "CA certificate file, PEM or DER, found in -openssl-dir if unset")
+	cmdFlags.StringVar(&keyFile, "key", "", "CA private key file, PEM, DER or OpenSSH and optionally encrypted, found in -openssl-dir if unset")
+	cmdFlags.StringVar(&chainFile, "chain", "", "optional PEM bundle of the certificates above a top level CA")
+	cmdFlags.StringVar(&parentCAPath, "parent", "", "CommonName or slugged CA Path of the parent CA, the CA is imported as a top level CA if unset")
+	cmdFlags.StringVar(&importPassphrase, "key-passphrase", os.Getenv("LOCKSMITH_IMPORT_PASSPHRASE"), "passphrase of an encrypted CA private key file, defaults to $LOCKSMITH_IMPORT_PASSPHRASE")
+	cmdFlags.StringVar(&passphrase, "passphrase", os.Getenv("LOCKSMITH_KEY_PASSPHRASE"), "passphrase to store the CA private key with, defaults to $LOCKSMITH_KEY_PASSPHRASE")
+	cmdFlags.Int64Var(&nextSerial, "next-serial", 0, "serial number of the next certificate the CA issues, must be past every serial the CA already issued, read from -openssl-dir if unset")
+	cmdFlags.Int64Var(&nextCRLNumber, "next-crl-number", 0, "number of the next CRL the CA issues")
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+
+	// Pick up the CA files and numbers from an OpenSSL CA directory, explicit flags win
+	var openSSLIndexPath string
+	if openSSLDir != "" {
+		var err error
+		if certFile == "" {
+			if certFile, err = findOpenSSLCAFile(openSSLDir, openSSLCACertificateFiles); err != nil {
+				return err
+			}
+		}
+		if keyFile == "" {
+			if keyFile, err = findOpenSSLCAFile(openSSLDir, openSSLCAPrivateKeyFiles); err != nil {
+				return err
+			}
+		}
+		if openSSLIndexPath, err = findOpenSSLCAFile(openSSLDir, openSSLCAIndexFiles); err != nil {
+			return err
+		}
+		if openSSLIndexPath == "" {
+			return fmt.Errorf("no index.txt or ca.index found in '%s'", openSSLDir)
+		}
+		if nextSerial == 0 {
+			serialPath, err := findOpenSSLCAFile(openSSLDir, openSSLCASerialFiles)
+			if err != nil {
+				return err
+			}
+			if serialPath != "" {
+				if nextSerial, err = readOpenSSLHexNumberFile(serialPath); err != nil {
+					return err
+				}
+			}
+		}
+		if nextSerial == 0 {
+			// Without a serial file carry on past the highest serial the OpenSSL CA recorded in its index
+			if nextSerial, err = nextSerialFromOpenSSLIndex(openSSLIndexPath); err != nil {
+				return err
+			}
+		}
+		if nextCRLNumber == 0 {
+			crlNumberPath, err := findOpenSSLCAFile(openSSLDir, openSSLCACRLNumberFiles)
+			if err != nil {
+				return err
+			}
+			if crlNumberPath != "" {
+				if nextCRLNumber, err = readOpenSSLHexNumberFile(crlNumberPath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if certFile == "" || keyFile == "" {
+		return Stoerr("import-ca needs a -cert and a -key, or an -openssl-dir holding them")
+	}
+
+	caImport := RESTPOSTImportCAJSONIn{
+		PrivateKeyPassphrase:    importPassphrase,
+		RSAPrivateKeyPassphrase: passphrase,
+		NextSerial:              nextSerial,
+		NextCRLNumber:           nextCRLNumber}
+	certBytes, err := ReadFileToBytes(certFile)
+	if err != nil {
+		return err
+	}
+	caImport.Certificate = B64EncodeBytesToStr(certBytes)
+
+	keyBytes, err := ReadFileToBytes(keyFile)
+	if err != nil {
+		return err
+	}
+	caImport.PrivateKey = B64EncodeBytesToStr(keyBytes)
+
+	if chainFile != "" {
+		chainBytes, err := ReadFileToBytes(chainFile)
+		if err != nil {
+			return err
+		}
+		caImport.Chain = B64EncodeBytesToStr(chainBytes)
+	}
+
+	var parentPath string
+	if parentCAPath != "" {
+		absPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + splitCACNChainToPath(parentCAPath))
+		if err != nil {
+			return err
+		}
+		parentPathExists, err := DirectoryExists(absPath)
+		if err != nil {
+			return err
+		}
+		if !parentPathExists {
+			return fmt.Errorf("parent CA '%s' does not exist", parentCAPath)
+		}
+		parentPath = absPath
+	}
+
+	caImported, messages, caCert, err := importCA(caImport, parentPath)
+	for _, message := range messages {
+		logStdOut(message)
+	}
+	if !caImported {
+		return err
+	}
+
+	if openSSLIndexPath != "" {
+		caPath := importedCAPath(&caCert, parentPath)
+		signerBackend, err := signerBackendForCA(caPath)
+		if err != nil {
+			return err
+		}
+		privKey, err := signerBackend.Signer(passphrase)
+		if err != nil {
+			return err
+		}
+		messages, err := importOpenSSLCAHistory(caPath, openSSLIndexPath, filepath.Join(openSSLDir, "newcerts"), &caCert, privKey)
+		for _, message := range messages {
+			logStdOut(message)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	logStdOut("Imported CA " + caCert.Subject.CommonName + " (" + slugger(caCert.Subject.CommonName) + ")")
+	return nil
+}
+
+// runSetCAOfflineCommand marks a CA offline, or back online, on the machine holding the PKI root
+func runSetCAOfflineCommand(args []string) error {
+	var caPath string
+	var online bool
+
+	cmdFlags := flag.NewFlagSet("set-ca-offline", flag.ExitOnError)
+	cmdFlags.StringVar(&caPath, "ca-path", "", "CommonName or slugged CA Path of the CA")
+	cmdFlags.BoolVar(&online, "online", false, "take the CA back online so it signs without an unlock session")
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+	if caPath == "" {
+		return Stoerr("set-ca-offline needs a -ca-path")
+	}
+
+	absPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + splitCACNChainToPath(caPath))
+	if err != nil {
+		return err
+	}
+	caCertExists, err := FileExists(absPath + "/certs/ca.pem")
+	if err != nil {
+		return err
+	}
+	if !caCertExists {
+		return fmt.Errorf("CA '%s' does not exist", caPath)
+	}
+
+	if err := setCAOffline(absPath, !online); err != nil {
+		return err
+	}
+	if online {
+		logStdOut("CA " + caPath + " is online")
+	} else {
+		logStdOut("CA " + caPath + " is offline, it only signs during an unlock session")
+	}
+	return nil
+}
+
+// runUnlockCACommand opens an unlock session for an offline CA on the running Locksmith server
+func runUnlockCACommand(args []string) error {
+	var caPath, passphrase, serverURL string
+	var duration time.Duration
+	var keyShares keySharesFlag
+
+	cmdFlags := flag.NewFlagSet("unlock-ca", flag.ExitOnError)
+	cmdFlags.StringVar(&caPath, "ca-path", "", "CommonName or slugged CA Path of the offline CA")
+	cmdFlags.StringVar(&passphrase, "passphrase", os.Getenv("LOCKSMITH_KEY_PASSPHRASE"), "passphrase of the CA private key, defaults to $LOCKSMITH_KEY_PASSPHRASE")
+	cmdFlags.DurationVar(&duration, "duration", defaultUnlockSessionDuration, "how long the CA stays unlocked")
+	cmdFlags.Var(&keyShares, "key-share", "key share of a key ceremony custodian, repeat once per custodian")
+	cmdFlags.StringVar(&serverURL, "server", defaultServerURL(), "base URL of the running Locksmith server")
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+	if caPath == "" {
+		return Stoerr("unlock-ca needs a -ca-path")
+	}
+
+	unlockSession := RESTUnlockSessionJSONReturn{}
+	err := postToServer(serverURL+"/authority/unlock", RESTPOSTUnlockCAJSONIn{CommonNamePath: caPath, RSAPrivateKeyPassphrase: passphrase, Duration: duration.String(), KeyShares: keyShares}, &unlockSession)
+	if err != nil {
+		return err
+	}
+	if unlockSession.Status != "unlocked" || unlockSession.Session == nil {
+		return fmt.Errorf("%s: %s", unlockSession.Status, strings.Join(unlockSession.Errors, ", "))
+	}
+
+	logStdOut("Unlocked CA " + caPath + " with session " + unlockSession.Session.ID + " until " + unlockSession.Session.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// runLockCACommand ends the unlock session of an offline CA on the running Locksmith server
+func runLockCACommand(args []string) error {
+	var caPath, serverURL string
+
+	cmdFlags := flag.NewFlagSet("lock-ca", flag.ExitOnError)
+	cmdFlags.StringVar(&caPath, "ca-path", "", "CommonName or slugged CA Path of the offline CA")
+	cmdFlags.StringVar(&serverURL, "server", defaultServerURL(), "base URL of the running Locksmith server")
+	if err := cmdFlags.Parse(args); err != nil {
+		return err
+	}
+	if caPath == "" {
+		return Stoerr("lock-ca needs a -ca-path")
+	}
+
+	lockedSession := RESTUnlockSessionJSONReturn{}
+	if err := postToServer(serverURL+"/authority/lock", RESTPOSTLockCAJSONIn{CommonNamePath: caPath}, &lockedSession); err != nil {
+		return err
+	}
+	if lockedSession.Status != "locked" || lockedSession.Session == nil {
+		return fmt.Errorf("%s: %s", lockedSession.Status, strings.Join(lockedSession.Errors, ", "))
+	}
+
+	for _, signing := range lockedSession.Session.Signings {
+		logStdOut("Signed " + signing.Hash + " " + signing.Digest + " at " + signing.Time.Format(time.RFC3339))
+	}
+	logStdOut(fmt.Sprintf("Locked CA %s, session %s made %d signatures", caPath, lockedSession.Session.ID, len(lockedSession.Session.Signings)))
+	return nil
+}
+
+// keySharesFlag collects a command line flag that can be repeated, such as the key shares of several custodians
+type keySharesFlag []string
+
+// String lists the collected key shares
+func (keyShares *keySharesFlag) String() string {
+	return strings.Join(*keyShares, ",")
+}
+
+// Set adds another key share
+func (keyShares *keySharesFlag) Set(keyShare string) error {
+	*keyShares = append(*keyShares, keyShare)
+	return nil
+}
+
+// defaultServerURL is the v1 API of the Locksmith server in the config.yml, reached over the loopback interface if it listens on every interface
+// The server only speaks plain HTTP, so postToServer refuses this URL when the server is bound to another interface
+func defaultServerURL() string {
+	host := readConfig.Locksmith.Server.Host
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, readConfig.Locksmith.Server.Port) + strings.TrimRight(readConfig.Locksmith.Server.BasePath, "/") + "/v1"
+}
+
+// checkServerURL refuses to send passphrases and key shares over plain HTTP to anything but the loopback interface
+func checkServerURL(endpointURL string) error {
+	parsedURL, err := url.Parse(endpointURL)
+	if err != nil {
+		return err
+	}
+	switch parsedURL.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsedURL.Hostname()
+		if host == "localhost" {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return Stoerr("refusing to send secrets over plain http to " + parsedURL.Host + ", use an https:// server URL or the loopback interface")
+	default:
+		return Stoerr("unsupported server URL scheme '" + parsedURL.Scheme + "', use https:// or http:// on the loopback interface")
+	}
+}
+
+// postToServer posts a JSON request to the running Locksmith server and decodes its JSON response
+func postToServer(endpointURL string, requestData interface{}, responseData interface{}) error {
+	if err := checkServerURL(endpointURL); err != nil {
+		return err
+	}
+	requestBytes, err := json.Marshal(requestData)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", endpointURL, bytes.NewReader(requestBytes))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", serverUA)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(responseData)
+}
@@ -0,0 +1,23 @@
+package locksmith
+
+import "testing"
+
+func TestCheckServerURL(t *testing.T) {
+	tests := []struct {
+		serverURL string
+		wantError bool
+	}{
+		{"http://127.0.0.1:8080/locksmith/v1/authority/unlock", false},
+		{"http://localhost:8080/locksmith/v1/authority/unlock", false},
+		{"http://[::1]:8080/locksmith/v1/authority/unlock", false},
+		{"https://ca.example.labs/locksmith/v1/authority/unlock", false},
+		{"http://ca.example.labs/locksmith/v1/authority/unlock", true},
+		{"http://10.0.0.5:8080/locksmith/v1/authority/unlock", true},
+		{"ftp://127.0.0.1/locksmith/v1/authority/unlock", true},
+	}
+	for _, tt := range tests {
+		if err := checkServerURL(tt.serverURL); (err != nil) != tt.wantError {
+			t.Errorf("checkServerURL(%q) = %v, want error %v", tt.serverURL, err, tt.wantError)
+		}
+	}
+}
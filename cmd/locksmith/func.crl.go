@@ -44,8 +44,15 @@ func PEMEncodeCRL(certByte []byte) *bytes.Buffer {
 
 // CreateNewCRLForCA wraps all the processes needed to create a new CRL for a CA
 func CreateNewCRLForCA(certificate *x509.Certificate, privateKey crypto.Signer, path string) (bool, error) {
+	return createCRLForCA(certificate, privateKey, path, nil, big.NewInt(0))
+}
+
+// createCRLForCA creates a CRL listing the revoked certificates with the given CRL number
+func createCRLForCA(certificate *x509.Certificate, privateKey crypto.Signer, path string, revokedCertificates []pkix.RevokedCertificate, crlNumber *big.Int) (bool, error) {
 	// Create the template
-	crlTemplate := SetupNewCRLTemplate(certificate.SignatureAlgorithm, time.Now().AddDate(1, 0, 0))
+	crlTemplate := SetupNewCRLTemplate(signatureAlgorithmForKey(privateKey), time.Now().AddDate(1, 0, 0))
+	crlTemplate.RevokedCertificates = revokedCertificates
+	crlTemplate.Number = crlNumber
 
 	// Take the SAN data from the Certificate and format for IAN
 	issuerBytes, err := marshalIANs(certificate.DNSNames, certificate.EmailAddresses, certificate.IPAddresses, certificate.URIs)
@@ -62,8 +69,27 @@ func CreateNewCRLForCA(certificate *x509.Certificate, privateKey crypto.Signer,
 	// PEM Encode the object
 	pemBytes := PEMEncodeCRL(crlObject)
 
-	// Save the PEM to a file
-	return writePEMFile(pemBytes, path)
+	// Save the PEM to a file, re-issuing a CRL replaces the previous one
+	return WriteByteFile(path, pemBytes.Bytes(), 0600, true)
+}
+
+// reissueCRLForCA replaces the CRL of the CA at caPath with one listing the revoked certificates of its CA Index under the next CRL number
+func reissueCRLForCA(caPath string, certificate *x509.Certificate, privateKey crypto.Signer) error {
+	revokedCertificates, err := revokedCertificatesFromCAIndex(caPath + "/ca.index")
+	if err != nil {
+		return err
+	}
+
+	crlNumberPath := caPath + "/ca.crlnum"
+	caCRL, err := createCRLForCA(certificate, privateKey, caPath+"/crl/ca.crl", revokedCertificates, big.NewInt(readSerialNumberAsInt64Abs(crlNumberPath)))
+	if !caCRL {
+		if err == nil {
+			err = Stoerr("ca-crl-error")
+		}
+		return err
+	}
+	_, err = IncreaseSerialNumberAbs(crlNumberPath)
+	return err
 }
 
 // ReadCRLFromFile just wraps a byte reader and CRL Decoder
@@ -0,0 +1,152 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"math/big"
+	"path/filepath"
+	"time"
+)
+
+// crossSignedCertificateID is the ID a cross certificate is listed under with the certificates of the signing CA
+func crossSignedCertificateID(subjectCert *x509.Certificate) string {
+	return slugger(subjectCert.Subject.CommonName) + "-cross"
+}
+
+// crossSignCA has the CA at signingCAPath certify the subject and public key of another CA
+// The other CA is either a Locksmith CA at the subject CA Path or an uploaded CA Certificate, the cross certificate is stored in the signing CA's certs/
+func crossSignCA(signingCAPath string, crossSign RESTPOSTCrossSignJSONIn) (bool, []string, x509.Certificate, error) {
+	if len(crossSign.ExpirationDate) != 3 {
+		return false, []string{"Missing Expiration Date field"}, x509.Certificate{}, Stoerr("cert-config-error")
+	}
+	if crossSign.MaxPathLength != nil && *crossSign.MaxPathLength < 0 {
+		return false, []string{"max_path_length can not be negative"}, x509.Certificate{}, Stoerr("cert-config-error")
+	}
+	if err := validateCertificatePolicies(crossSign.CertificatePolicies); err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("cert-config-error")
+	}
+
+	// Find the CA being certified
+	var subjectCert *x509.Certificate
+	var subjectPath string
+	if crossSign.SubjectCommonNamePath != "" {
+		subjectPath = splitCACNChainToPath(crossSign.SubjectCommonNamePath)
+	}
+	if crossSign.SubjectSlugPath != "" {
+		subjectPath = splitCACNChainToPath(crossSign.SubjectSlugPath)
+	}
+	switch {
+	case subjectPath != "" && crossSign.Certificate != "":
+		return false, []string{"Supply either a subject CA Path or a certificate, not both"}, x509.Certificate{}, Stoerr("invalid-subject")
+	case subjectPath != "":
+		absSubjectPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + subjectPath)
+		if err != nil {
+			return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-subject")
+		}
+		absSigningCAPath, err := filepath.Abs(signingCAPath)
+		if err != nil {
+			return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-subject")
+		}
+		if absSubjectPath == absSigningCAPath {
+			return false, []string{"A CA can not cross-sign itself"}, x509.Certificate{}, Stoerr("invalid-subject")
+		}
+		subjectCert, err = ReadCACertificate(absSubjectPath)
+		if err != nil || subjectCert == nil {
+			return false, []string{"Subject CA Path does not exist or has no CA Certificate"}, x509.Certificate{}, Stoerr("invalid-subject")
+		}
+	case crossSign.Certificate != "":
+		certBytes, err := B64DecodeStrToBytes(crossSign.Certificate)
+		if err != nil || len(certBytes) == 0 {
+			return false, []string{"CA Certificate must be base64 encoded"}, x509.Certificate{}, Stoerr("invalid-certificate")
+		}
+		certificates, err := parseImportedCertificates(certBytes)
+		if err != nil {
+			return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-certificate")
+		}
+		subjectCert = certificates[0]
+		if err := validateImportedCACertificate(subjectCert); err != nil {
+			return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-certificate")
+		}
+	default:
+		return false, []string{"Missing subject!  Must supply either `subject_cn_path`, `subject_slug_path` or `certificate`"}, x509.Certificate{}, Stoerr("invalid-subject")
+	}
+
+	certificateID := crossSignedCertificateID(subjectCert)
+	certPath := signingCAPath + "/certs/" + certificateID + ".pem"
+	certExists, err := FileExists(certPath)
+	check(err)
+	if certExists {
+		return false, []string{"Certificate " + certificateID + " exists in the signing CA!"}, x509.Certificate{}, Stoerr("certificate-exists")
+	}
+
+	// The cross certificate keeps the path length of the certified CA unless another one is requested
+	requestedPathLength := crossSign.MaxPathLength
+	if subjectPathLength, limited := certificatePathLength(subjectCert); requestedPathLength == nil && limited {
+		requestedPathLength = &subjectPathLength
+	}
+
+	// The cross certificate is a CA under the signing CA, so it counts against the path length of the CAs above
+	pathLength, pathLengthMessages, err := checkPathLengthForCA(signingCAPath, requestedPathLength)
+	if err != nil {
+		return false, pathLengthMessages, x509.Certificate{}, err
+	}
+
+	// Open the Signing CA
+	signingCACert, err := ReadCACertificate(signingCAPath)
+	if err != nil || signingCACert == nil {
+		return false, []string{"Signing CA Certificate does not exist!"}, x509.Certificate{}, Stoerr("no-signing-ca-certificate")
+	}
+	signingCASignerBackend, err := signerBackendForCA(signingCAPath)
+	if err != nil {
+		return false, []string{"Signing CA Signer Backend is misconfigured!"}, x509.Certificate{}, err
+	}
+	signingCAPrivateKey, err := signingCASignerBackend.Signer(crossSign.SigningPrivateKeyPassphrase)
+	if err != nil {
+		return false, []string{"Signing CA Private Key could not be opened: " + err.Error()}, x509.Certificate{}, Stoerr("invalid-signing-ca-key")
+	}
+
+	// Set time for UTC format
+	currentTime := time.Now()
+	yesterdayTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).Add(-24 * time.Hour)
+
+	// The subject and key identifier are carried over as they are so chains can be built through either CA Certificate
+	crossTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(readSerialNumberAsInt64Abs(signingCAPath + "/ca.serial")),
+		RawSubject:            subjectCert.RawSubject,
+		Subject:               subjectCert.Subject,
+		NotBefore:             yesterdayTime,
+		NotAfter:              time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).AddDate(crossSign.ExpirationDate[0], crossSign.ExpirationDate[1], crossSign.ExpirationDate[2]),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            -1,
+		SubjectKeyId:          subjectCert.SubjectKeyId,
+		AuthorityKeyId:        signingCACert.SubjectKeyId,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	applyPathLength(crossTemplate, pathLength)
+	if err := applyNameConstraints(crossTemplate, crossSign.NameConstraints); err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("cert-config-error")
+	}
+	// Without certificate policies of its own the cross certificate carries the policies of the signing CA
+	certificatePolicies := crossSign.CertificatePolicies
+	if certificatePolicies == nil {
+		if certificatePolicies, err = certificatePoliciesForCA(signingCAPath); err != nil {
+			return false, []string{"Signing CA Certificate Policies Failure"}, x509.Certificate{}, err
+		}
+	}
+	if err := applyCertificatePolicies(crossTemplate, certificatePolicies, true); err != nil {
+		return false, []string{"Cross Certificate Policies Failure"}, x509.Certificate{}, err
+	}
+	if err := applyDistributionPoints(crossTemplate, signingCAPath, signingCACert.Subject.CommonName); err != nil {
+		return false, []string{"Signing CA Distribution Points Failure"}, x509.Certificate{}, err
+	}
+
+	crossCert, err := issueCACertificate(crossTemplate, signingCACert, subjectCert.PublicKey, signingCAPrivateKey, signingCAPath)
+	if err != nil {
+		return false, []string{"Cross Certificate Creation Failure"}, x509.Certificate{}, err
+	}
+	if _, err := writeCertificateFile(pemEncodeCertificate(crossCert.Raw), certPath); err != nil {
+		return false, []string{"Cross Certificate Failure"}, x509.Certificate{}, err
+	}
+
+	return true, []string{"Cross-signed CA '" + subjectCert.Subject.CommonName + "' with '" + signingCACert.Subject.CommonName + "'"}, *crossCert, nil
+}
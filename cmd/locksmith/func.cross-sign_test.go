@@ -0,0 +1,99 @@
+package locksmith
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestCrossSignCA(t *testing.T) {
+	useTestPKIRoot(t)
+	signingPath, signingCert := createTestRootCA(t, testCertificateConfiguration("Cross Signing Root CA", "ecdsa"))
+	subjectPath, subjectCert := createTestRootCA(t, testCertificateConfiguration("Cross Subject Root CA", "ecdsa"))
+
+	tests := []struct {
+		name      string
+		crossSign RESTPOSTCrossSignJSONIn
+	}{
+		{"no expiration date", RESTPOSTCrossSignJSONIn{SubjectCommonNamePath: "Cross Subject Root CA"}},
+		{"no subject", RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}}},
+		{"subject path and certificate", RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, SubjectCommonNamePath: "Cross Subject Root CA", Certificate: B64EncodeBytesToStr(subjectCert.Raw)}},
+		{"itself", RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, SubjectCommonNamePath: "Cross Signing Root CA"}},
+		{"unknown subject", RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, SubjectCommonNamePath: "Missing CA"}},
+		{"negative path length", RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, SubjectCommonNamePath: "Cross Subject Root CA", MaxPathLength: intPointer(-1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if crossSigned, _, _, err := crossSignCA(signingPath, tt.crossSign); crossSigned || err == nil {
+				t.Fatal("expected the cross-sign to be refused")
+			}
+		})
+	}
+
+	crossSigned, messages, crossCert, err := crossSignCA(signingPath, RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, SubjectCommonNamePath: "Cross Subject Root CA"})
+	if err != nil || !crossSigned {
+		t.Fatalf("cross-sign failed: %v %v", err, messages)
+	}
+
+	// The cross certificate certifies the subject and key of the other CA with the signing CA
+	if !bytes.Equal(crossCert.RawSubject, subjectCert.RawSubject) || !bytes.Equal(crossCert.RawSubjectPublicKeyInfo, subjectCert.RawSubjectPublicKeyInfo) {
+		t.Fatal("cross certificate does not carry the subject and key of the other CA")
+	}
+	if err := crossCert.CheckSignatureFrom(&signingCert); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := FileExists(signingPath + "/certs/" + crossSignedCertificateID(&subjectCert) + ".pem"); !exists {
+		t.Fatal("cross certificate was not stored with the signing CA")
+	}
+
+	// Certificates issued by the other CA now chain up to the signing CA
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", &x509.CertificateRequest{DNSNames: []string{"www.example.labs"}})
+	created, certificate, messages, err := createNewCertificateFromCSR(subjectPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(&signingCert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(&crossCert)
+	if _, err := certificate.Verify(x509.VerifyOptions{DNSName: "www.example.labs", Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same CA is not cross-signed twice
+	if crossSigned, _, _, err := crossSignCA(signingPath, RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, SubjectCommonNamePath: "Cross Subject Root CA"}); crossSigned || err == nil {
+		t.Fatal("expected an error cross-signing the CA again")
+	}
+}
+
+func TestCrossSignUploadedCA(t *testing.T) {
+	useTestPKIRoot(t)
+	signingPath, signingCert := createTestRootCA(t, testCertificateConfiguration("Cross Signing Root CA", "ecdsa"))
+	externalCert, _ := writeTestCA(t, t.TempDir(), "External Root CA", intPointer(1), nil, nil)
+
+	crossSigned, messages, crossCert, err := crossSignCA(signingPath, RESTPOSTCrossSignJSONIn{
+		ExpirationDate: []int{1, 0, 0},
+		Certificate:    B64EncodeBytesToStr(pemEncodeCertificate(externalCert.Raw).Bytes()),
+	})
+	if err != nil || !crossSigned {
+		t.Fatalf("cross-sign failed: %v %v", err, messages)
+	}
+	if err := crossCert.CheckSignatureFrom(&signingCert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The path length of the uploaded CA is kept
+	if length, limited := certificatePathLength(&crossCert); !limited || length != 1 {
+		t.Fatalf("got path length %d limited %v, want 1", length, limited)
+	}
+
+	// Uploaded certificates that are not CAs are refused
+	csr, _ := createTestCSR(t, "not-a-ca", "ecdsa", nil)
+	created, leafCert, messages, err := createNewCertificateFromCSR(signingPath, "", csr, "client", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	if crossSigned, _, _, err := crossSignCA(signingPath, RESTPOSTCrossSignJSONIn{ExpirationDate: []int{1, 0, 0}, Certificate: B64EncodeBytesToStr(leafCert.Raw)}); crossSigned || err == nil {
+		t.Fatal("expected an error for a certificate that is not a CA")
+	}
+}
@@ -0,0 +1,89 @@
+package locksmith
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// parseSubmittedCSR reads a CSR submitted for signing, either a PEM as is or base64 encoded PEM or DER
+func parseSubmittedCSR(csrInput string) (*x509.CertificateRequest, error) {
+	csrBytes := []byte(strings.TrimSpace(csrInput))
+	if !bytes.HasPrefix(csrBytes, []byte("-----BEGIN")) {
+		decodedBytes, err := B64DecodeStrToBytes(strings.Join(strings.Fields(csrInput), ""))
+		if err != nil {
+			return nil, Stoerr("the CSR is neither a PEM nor base64 encoded")
+		}
+		csrBytes = decodedBytes
+	}
+
+	// PEM CSRs are unwrapped, anything else is taken as DER
+	if block, _ := pem.Decode(csrBytes); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("expected a CERTIFICATE REQUEST PEM, got %s", block.Type)
+		}
+		csrBytes = block.Bytes
+	}
+	csr, err := readCSR(csrBytes)
+	if err != nil {
+		return nil, fmt.Errorf("the CSR could not be parsed: %s", err.Error())
+	}
+	return csr, nil
+}
+
+// verifyCSR checks the proof of possession of a CSR, its signature by the private key of the public key it carries,
+// and that the public key is one Locksmith issues certificates for
+func verifyCSR(csr *x509.CertificateRequest) ([]string, error) {
+	switch csr.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.DSAWithSHA256, x509.ECDSAWithSHA1:
+		return []string{"CSR is signed with " + csr.SignatureAlgorithm.String() + ", sign it with SHA-256 or better"}, Stoerr("invalid-csr-signature")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return []string{"CSR signature does not verify against its public key: " + err.Error()}, Stoerr("invalid-csr-signature")
+	}
+	return checkCSRPublicKey(csr.PublicKey)
+}
+
+// checkCSRPublicKey refuses public keys that are too weak, or of an algorithm Locksmith does not issue certificates for
+func checkCSRPublicKey(publicKey crypto.PublicKey) ([]string, error) {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		if publicKey.N.BitLen() < 2048 {
+			return []string{fmt.Sprintf("RSA keys must be at least 2048 bits, got %d", publicKey.N.BitLen())}, Stoerr("weak-public-key")
+		}
+		if publicKey.E < 65537 {
+			return []string{fmt.Sprintf("RSA keys must have a public exponent of at least 65537, got %d", publicKey.E)}, Stoerr("weak-public-key")
+		}
+	case *ecdsa.PublicKey:
+		if _, err := ellipticCurveFromKeySize(publicKey.Curve.Params().BitSize); err != nil {
+			return []string{"ECDSA keys must be on P-256, P-384 or P-521, got " + publicKey.Curve.Params().Name}, Stoerr("weak-public-key")
+		}
+	case ed25519.PublicKey:
+	default:
+		return []string{fmt.Sprintf("Unsupported public key type %T, must be one of %s", publicKey, strings.Join(supportedKeyAlgorithms, ", "))}, Stoerr("unsupported-public-key")
+	}
+	return []string{}, nil
+}
+
+// checkCSRPublicKeyMatches makes sure a public key supplied alongside a CSR is the one the CSR carries
+func checkCSRPublicKeyMatches(csr *x509.CertificateRequest, publicKeyInput string) ([]string, error) {
+	publicKeyBytes, err := B64DecodeStrToBytes(publicKeyInput)
+	if err != nil {
+		return []string{"Public key is not base64 encoded"}, Stoerr("public-key-mismatch")
+	}
+	publicKey, err := parseImportedPublicKey(publicKeyBytes)
+	if err != nil {
+		return []string{"Public key could not be parsed: " + err.Error()}, Stoerr("public-key-mismatch")
+	}
+	csrPublicKey, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !csrPublicKey.Equal(publicKey) {
+		return []string{"Public key is not the key of the CSR, leave out public_key to use the key of the CSR"}, Stoerr("public-key-mismatch")
+	}
+	return []string{}, nil
+}
@@ -0,0 +1,165 @@
+package locksmith
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestParseSubmittedCSR(t *testing.T) {
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", nil)
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}))
+	encodedPEM := B64EncodeBytesToStr([]byte(csrPEM))
+
+	tests := []struct {
+		name      string
+		csrInput  string
+		wantError bool
+	}{
+		{"PEM", csrPEM, false},
+		{"PEM with surrounding whitespace", "\n  " + csrPEM + "\n", false},
+		{"NEW CERTIFICATE REQUEST PEM", string(pem.EncodeToMemory(&pem.Block{Type: "NEW CERTIFICATE REQUEST", Bytes: csr.Raw})), false},
+		{"base64 PEM", encodedPEM, false},
+		{"base64 PEM wrapped over lines", encodedPEM[:40] + "\n" + encodedPEM[40:], false},
+		{"base64 DER", B64EncodeBytesToStr(csr.Raw), false},
+		{"other PEM block", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: csr.Raw})), true},
+		{"not base64", "not a CSR!", true},
+		{"base64 garbage", B64EncodeBytesToStr([]byte("not a CSR")), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsedCSR, err := parseSubmittedCSR(tt.csrInput)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if !tt.wantError && parsedCSR.Subject.CommonName != "www.example.labs" {
+				t.Fatalf("got CSR for %q", parsedCSR.Subject.CommonName)
+			}
+		})
+	}
+}
+
+func TestVerifyCSR(t *testing.T) {
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", nil)
+	if messages, err := verifyCSR(csr); err != nil {
+		t.Fatalf("valid CSR refused: %v %v", err, messages)
+	}
+
+	// A CSR not signed by the key it carries does not prove possession of it
+	otherCSR, _ := createTestCSR(t, "other.example.labs", "ecdsa", nil)
+	forgedCSR := *csr
+	forgedCSR.PublicKey = otherCSR.PublicKey
+	if _, err := verifyCSR(&forgedCSR); err == nil || err.Error() != "invalid-csr-signature" {
+		t.Fatalf("got %v, want invalid-csr-signature", err)
+	}
+
+	// Weak signature algorithms are refused before the signature is checked
+	weakCSR := *csr
+	weakCSR.SignatureAlgorithm = x509.ECDSAWithSHA1
+	if _, err := verifyCSR(&weakCSR); err == nil || err.Error() != "invalid-csr-signature" {
+		t.Fatalf("got %v, want invalid-csr-signature", err)
+	}
+
+	// A properly signed CSR for a weak key is refused
+	weakKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "weak.example.labs"}}, weakKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakKeyCSR, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyCSR(weakKeyCSR); err == nil || err.Error() != "weak-public-key" {
+		t.Fatalf("got %v, want weak-public-key", err)
+	}
+}
+
+func TestCheckCSRPublicKey(t *testing.T) {
+	rsaKey, _, err := GenerateKeypair("rsa", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicKey := rsaKey.Public().(*rsa.PublicKey)
+	smallExponentKey := *rsaPublicKey
+	smallExponentKey.E = 3
+	smallRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519PublicKey, err := GenerateKeypair("ed25519", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ecdsaPublicKey, err := GenerateKeypair("ecdsa", 384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey interface{}
+		wantError string
+	}{
+		{"RSA 2048", rsaPublicKey, ""},
+		{"ECDSA P-384", ecdsaPublicKey, ""},
+		{"Ed25519", ed25519PublicKey, ""},
+		{"RSA 1024", &smallRSAKey.PublicKey, "weak-public-key"},
+		{"RSA exponent 3", &smallExponentKey, "weak-public-key"},
+		{"ECDSA P-224", &p224Key.PublicKey, "weak-public-key"},
+		{"unsupported", "not a key", "unsupported-public-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := checkCSRPublicKey(tt.publicKey)
+			if tt.wantError == "" {
+				if err != nil {
+					t.Fatalf("key refused: %v %v", err, messages)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantError {
+				t.Fatalf("got %v, want %s", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestCheckCSRPublicKeyMatches(t *testing.T) {
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", nil)
+	_, otherPublicKey, err := GenerateKeypair("ecdsa", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		publicKeyInput string
+		wantError      bool
+	}{
+		{"key of the CSR", B64EncodeBytesToStr(pemEncodePublicKey(csr.PublicKey).Bytes()), false},
+		{"other key", B64EncodeBytesToStr(pemEncodePublicKey(otherPublicKey).Bytes()), true},
+		{"not base64", "not a key!", true},
+		{"not a key", B64EncodeBytesToStr([]byte(strings.Repeat("A", 32))), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := checkCSRPublicKeyMatches(csr, tt.publicKeyInput); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package locksmith
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -58,12 +58,11 @@ func setupCSR(names pkix.Name, isCA bool, sanData SANData) *x509.CertificateRequ
 		check(err)
 
 		return &x509.CertificateRequest{
-			Subject:            names,
-			SignatureAlgorithm: x509.SHA512WithRSA,
-			DNSNames:           sanData.DNSNames,
-			EmailAddresses:     sanData.EmailAddresses,
-			IPAddresses:        sanData.IPAddresses,
-			URIs:               actualURIs,
+			Subject:        names,
+			DNSNames:       sanData.DNSNames,
+			EmailAddresses: sanData.EmailAddresses,
+			IPAddresses:    sanData.IPAddresses,
+			URIs:           actualURIs,
 			ExtraExtensions: []pkix.Extension{
 				{
 					// This identifies that the CSR is a CA
@@ -75,18 +74,18 @@ func setupCSR(names pkix.Name, isCA bool, sanData SANData) *x509.CertificateRequ
 		}
 	}
 	return &x509.CertificateRequest{
-		Subject:            names,
-		SignatureAlgorithm: x509.SHA512WithRSA,
-		DNSNames:           sanData.DNSNames,
-		EmailAddresses:     sanData.EmailAddresses,
-		IPAddresses:        sanData.IPAddresses,
-		URIs:               actualURIs,
+		Subject:        names,
+		DNSNames:       sanData.DNSNames,
+		EmailAddresses: sanData.EmailAddresses,
+		IPAddresses:    sanData.IPAddresses,
+		URIs:           actualURIs,
 	}
 }
 
 // createCSR is a wrapper for x509.CreateCertificateRequest
-// template is a CSR template, priv is the CSR requester private key
+// template is a CSR template, priv is the CSR requester private key which also sets the signature algorithm
 func createCSR(template *x509.CertificateRequest, priv interface{}) ([]byte, error) {
+	template.SignatureAlgorithm = signatureAlgorithmForKey(priv)
 	return x509.CreateCertificateRequest(rand.Reader, template, priv)
 }
 
@@ -121,8 +120,8 @@ func createNewCertificateRequest(config RESTPOSTCertificateRequestJSONIn, parent
 	var csrIsCA bool
 	var csrCommonName string
 	var csrCommonNameSlug string
-	var privateKey *rsa.PrivateKey
-	var publicKey *rsa.PublicKey
+	var privateKey crypto.Signer
+	var publicKey crypto.PublicKey
 
 	// Check if the certificate configuration is valid
 	certificateValid, validationMsgs, err := ValidateCertificateConfiguration(config.CertificateConfiguration)
@@ -159,27 +158,27 @@ func createNewCertificateRequest(config RESTPOSTCertificateRequestJSONIn, parent
 
 		if !privKeyCheck {
 			// if there is no private key, create one
-			csrPrivKey, csrPubKey, err := GenerateRSAKeypair(4096)
+			csrPrivKey, csrPubKey, err := GenerateKeypair(config.CertificateConfiguration.KeyAlgorithm, config.CertificateConfiguration.KeySize)
 			check(err)
 
 			// Save the Private Key to the file system
 			var csrPrivKeyFile bool
 			var csrPubKeyFile bool
 
-			pemEncodedPrivateKey, encryptedPrivateKeyBytes := pemEncodeRSAPrivateKey(csrPrivKey, config.CertificateConfiguration.RSAPrivateKeyPassphrase)
+			pemEncodedPrivateKey, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(csrPrivKey, config.CertificateConfiguration.RSAPrivateKeyPassphrase)
+			if err != nil {
+				return false, []string{"CSR Key Pair Failure"}, &x509.CertificateRequest{}, RealKeyPair{}, err
+			}
 
 			if config.CertificateConfiguration.RSAPrivateKeyPassphrase == "" {
-				csrPrivKeyFile, csrPubKeyFile, err = writeRSAKeyPair(pemEncodedPrivateKey, pemEncodeRSAPublicKey(csrPubKey), parentPath+"/keys/"+csrCommonNameSlug)
+				csrPrivKeyFile, csrPubKeyFile, err = writeRSAKeyPair(pemEncodedPrivateKey, pemEncodePublicKey(csrPubKey), parentPath+"/keys/"+csrCommonNameSlug)
 				check(err)
 				if !csrPrivKeyFile || !csrPubKeyFile {
 					return false, []string{"CSR Key Pair Failure epkbNil"}, &x509.CertificateRequest{}, RealKeyPair{}, err
 				}
 			} else {
 
-				encStr := B64EncodeBytesToStr(encryptedPrivateKeyBytes.Bytes())
-				encBufferB := bytes.NewBufferString(encStr)
-
-				csrPrivKeyFile, csrPubKeyFile, err = writeRSAKeyPair(encBufferB, pemEncodeRSAPublicKey(csrPubKey), parentPath+"/keys/"+csrCommonNameSlug)
+				csrPrivKeyFile, csrPubKeyFile, err = writeRSAKeyPair(encryptedPrivateKeyBytes, pemEncodePublicKey(csrPubKey), parentPath+"/keys/"+csrCommonNameSlug)
 				check(err)
 				if !csrPrivKeyFile || !csrPubKeyFile {
 					return false, []string{"CSR Key Pair Failure"}, &x509.CertificateRequest{}, RealKeyPair{}, err
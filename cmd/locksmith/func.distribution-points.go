@@ -0,0 +1,140 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// distributionPointsForCA finds the CRL, caIssuers and OCSP URL templates for the CA at caPath
+// URLs set when the CA was created win over a config.yml entry for its ca_path, which wins over a config.yml entry without a ca_path
+func distributionPointsForCA(caPath string) (*DistributionPointConfig, error) {
+	caDistributionPointsFile := caPath + "/" + distributionPointsFileName
+	caDistributionPointsExist, err := FileExists(caDistributionPointsFile)
+	if err != nil {
+		return nil, err
+	}
+	if caDistributionPointsExist {
+		distributionPointsBytes, err := ioutil.ReadFile(caDistributionPointsFile)
+		if err != nil {
+			return nil, err
+		}
+		distributionPoints := DistributionPointConfig{}
+		if err := json.Unmarshal(distributionPointsBytes, &distributionPoints); err != nil {
+			return nil, err
+		}
+		return &distributionPoints, nil
+	}
+
+	if readConfig == nil {
+		return nil, nil
+	}
+
+	absCAPath, err := filepath.Abs(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaultDistributionPoints *DistributionPointConfig
+	for i, distributionPoints := range readConfig.Locksmith.DistributionPoints {
+		if distributionPoints.CAPath == "" {
+			defaultDistributionPoints = &readConfig.Locksmith.DistributionPoints[i]
+			continue
+		}
+		absConfigPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + splitCACNChainToPath(distributionPoints.CAPath))
+		if err != nil {
+			return nil, err
+		}
+		if absConfigPath == absCAPath {
+			return &readConfig.Locksmith.DistributionPoints[i], nil
+		}
+	}
+	return defaultDistributionPoints, nil
+}
+
+// writeDistributionPoints saves the URL templates supplied when creating a CA so every certificate it issues later carries them
+func writeDistributionPoints(caPath string, distributionPoints *DistributionPointConfig) error {
+	distributionPointsBytes, err := json.MarshalIndent(distributionPoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(caPath+"/"+distributionPointsFileName, distributionPointsBytes, 0644)
+}
+
+// validateDistributionPoints makes sure every URL template expands to an absolute URL
+func validateDistributionPoints(distributionPoints *DistributionPointConfig) error {
+	if distributionPoints == nil {
+		return nil
+	}
+	for _, urlTemplates := range [][]string{distributionPoints.CRLURLs, distributionPoints.IssuerURLs, distributionPoints.OCSPURLs} {
+		for _, urlTemplate := range urlTemplates {
+			expandedURL := expandDistributionPointURL(urlTemplate, "example-ca", "example-root-ca/example-ca", "Example CA")
+			parsedURL, err := url.Parse(expandedURL)
+			if err != nil || parsedURL.Scheme == "" || (parsedURL.Host == "" && parsedURL.Scheme != "ldap") {
+				return fmt.Errorf("distribution point URL '%s' is not an absolute URL", urlTemplate)
+			}
+		}
+	}
+	return nil
+}
+
+// expandDistributionPointURL fills in the {ca_slug}, {ca_slug_path} and {ca_common_name} placeholders of a URL template
+func expandDistributionPointURL(urlTemplate string, caSlug string, caSlugPath string, caCommonName string) string {
+	return strings.NewReplacer(
+		"{ca_slug}", caSlug,
+		"{ca_slug_path}", caSlugPath,
+		"{ca_common_name}", url.PathEscape(caCommonName),
+	).Replace(urlTemplate)
+}
+
+// caSlugPathFromPath converts a CA directory back to its slug path
+// eg, converts "<pki_root>/roots/example-labs-root-ca/intermed-ca/example-labs-ica" to "example-labs-root-ca/example-labs-ica"
+func caSlugPathFromPath(caPath string) (string, error) {
+	absRootsPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots")
+	if err != nil {
+		return "", err
+	}
+	absCAPath, err := filepath.Abs(caPath)
+	if err != nil {
+		return "", err
+	}
+	relativePath, err := filepath.Rel(absRootsPath, absCAPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(filepath.ToSlash(relativePath), "/intermed-ca/", "/", -1), nil
+}
+
+// applyDistributionPoints stamps the CRL Distribution Point, caIssuers and OCSP URLs of the issuing CA at caPath into a certificate template
+func applyDistributionPoints(certificate *x509.Certificate, caPath string, caCommonName string) error {
+	distributionPoints, err := distributionPointsForCA(caPath)
+	if err != nil {
+		return err
+	}
+	if distributionPoints == nil {
+		return nil
+	}
+
+	caSlugPath, err := caSlugPathFromPath(caPath)
+	if err != nil {
+		return err
+	}
+	caSlug := filepath.Base(caSlugPath)
+
+	expand := func(urlTemplates []string) []string {
+		var urls []string
+		for _, urlTemplate := range urlTemplates {
+			urls = append(urls, expandDistributionPointURL(urlTemplate, caSlug, caSlugPath, caCommonName))
+		}
+		return urls
+	}
+
+	certificate.CRLDistributionPoints = expand(distributionPoints.CRLURLs)
+	certificate.IssuingCertificateURL = expand(distributionPoints.IssuerURLs)
+	certificate.OCSPServer = expand(distributionPoints.OCSPURLs)
+	return nil
+}
@@ -0,0 +1,90 @@
+package locksmith
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateDistributionPoints(t *testing.T) {
+	tests := []struct {
+		name               string
+		distributionPoints *DistributionPointConfig
+		wantError          bool
+	}{
+		{"none", nil, false},
+		{"templates", &DistributionPointConfig{CRLURLs: []string{"http://pki.example.labs/crl/{ca_slug_path}.crl"}, OCSPURLs: []string{"http://ocsp.example.labs/{ca_slug}"}}, false},
+		{"ldap", &DistributionPointConfig{CRLURLs: []string{"ldap:///CN={ca_common_name},DC=example,DC=labs?certificateRevocationList"}}, false},
+		{"relative", &DistributionPointConfig{IssuerURLs: []string{"/ca/{ca_slug}.crt"}}, true},
+		{"no host", &DistributionPointConfig{OCSPURLs: []string{"http://"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateDistributionPoints(tt.distributionPoints); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestDistributionPointsForCA(t *testing.T) {
+	useTestPKIRoot(t)
+	readConfig.Locksmith.DistributionPoints = []DistributionPointConfig{
+		{CRLURLs: []string{"http://pki.example.labs/default/{ca_slug}.crl"}},
+		{CAPath: "Configured Root CA", CRLURLs: []string{"http://pki.example.labs/configured/{ca_slug}.crl"}},
+	}
+
+	// A Root CA created with its own URLs keeps them, the others fall back to config.yml
+	ownConfig := testCertificateConfiguration("Own Root CA", "ecdsa")
+	ownConfig.DistributionPoints = &DistributionPointConfig{
+		CRLURLs:    []string{"http://pki.example.labs/crl/{ca_slug_path}.crl"},
+		IssuerURLs: []string{"http://pki.example.labs/ca/{ca_common_name}.crt"},
+		OCSPURLs:   []string{"http://ocsp.example.labs/{ca_slug}"},
+	}
+	ownPath, _ := createTestRootCA(t, ownConfig)
+	configuredPath, _ := createTestRootCA(t, testCertificateConfiguration("Configured Root CA", "ecdsa"))
+	defaultPath, _ := createTestRootCA(t, testCertificateConfiguration("Default Root CA", "ecdsa"))
+
+	tests := []struct {
+		name        string
+		caPath      string
+		wantCRLURLs []string
+	}{
+		{"created with URLs", ownPath, []string{"http://pki.example.labs/crl/{ca_slug_path}.crl"}},
+		{"config.yml ca_path", configuredPath, []string{"http://pki.example.labs/configured/{ca_slug}.crl"}},
+		{"config.yml default", defaultPath, []string{"http://pki.example.labs/default/{ca_slug}.crl"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distributionPoints, err := distributionPointsForCA(tt.caPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if distributionPoints == nil || !reflect.DeepEqual(distributionPoints.CRLURLs, tt.wantCRLURLs) {
+				t.Fatalf("got %+v, want CRL URLs %v", distributionPoints, tt.wantCRLURLs)
+			}
+		})
+	}
+
+	// Certificates issued by the CA carry the expanded URLs
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", nil)
+	created, certificate, messages, err := createNewCertificateFromCSR(ownPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	if !reflect.DeepEqual(certificate.CRLDistributionPoints, []string{"http://pki.example.labs/crl/own-root-ca.crl"}) ||
+		!reflect.DeepEqual(certificate.IssuingCertificateURL, []string{"http://pki.example.labs/ca/Own%20Root%20CA.crt"}) ||
+		!reflect.DeepEqual(certificate.OCSPServer, []string{"http://ocsp.example.labs/own-root-ca"}) {
+		t.Fatalf("got CRL %v, caIssuers %v, OCSP %v", certificate.CRLDistributionPoints, certificate.IssuingCertificateURL, certificate.OCSPServer)
+	}
+}
+
+func TestCASlugPathFromPath(t *testing.T) {
+	pkiRoot := useTestPKIRoot(t)
+	caSlugPath, err := caSlugPathFromPath(pkiRoot + "/roots/example-labs-root-ca/intermed-ca/example-labs-ica/intermed-ca/example-labs-signing-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caSlugPath != "example-labs-root-ca/example-labs-ica/example-labs-signing-ca" {
+		t.Fatalf("got slug path %s", caSlugPath)
+	}
+}
@@ -7,12 +7,20 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha1"
+	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"io"
-	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
 )
 
 // passphraseToHash returns a hexadecimal string of an SHA1 checksumed passphrase
+// This is only used to read keys encrypted before the versioned key envelope was introduced
 func passphraseToHash(pass string) (string, []byte) {
 	// The salt is used as a unique string to defeat rainbow table attacks
 	//salt := "l0ckSmithS41t"
@@ -32,56 +40,190 @@ func passphraseToHash(pass string) (string, []byte) {
 	return hex.EncodeToString(hash), hash
 }
 
-// encryptBytes is a wrapper that takes a plain byte slice and a passphrase and returns an encrypted byte slice
+// deriveKeyFromPassphrase runs the passphrase and salt through the KDF described by an envelope header
+func deriveKeyFromPassphrase(passphrase string, header keyEnvelopeHeader) ([]byte, error) {
+	switch header.KDF {
+	case kdfArgon2id:
+		if header.Param1 == 0 || header.Param1 > maxArgon2idTime || header.Param2 > maxArgon2idMemory || header.Param3 == 0 {
+			return nil, Stoerr("key envelope argon2id parameters are out of range")
+		}
+		return argon2.IDKey([]byte(passphrase), header.Salt, header.Param1, header.Param2, header.Param3, 32), nil
+	case kdfScrypt:
+		if header.Param1 > maxScryptN || header.Param2 > maxScryptR || header.Param3 > maxScryptP {
+			return nil, Stoerr("key envelope scrypt parameters are out of range")
+		}
+		return scrypt.Key([]byte(passphrase), header.Salt, int(header.Param1), int(header.Param2), int(header.Param3), 32)
+	}
+	return nil, fmt.Errorf("unknown key envelope KDF id %d", header.KDF)
+}
+
+// newKeyEnvelopeHeader creates an envelope header with a fresh random salt and nonce using the default KDF
+func newKeyEnvelopeHeader(nonceSize int) (keyEnvelopeHeader, error) {
+	header := keyEnvelopeHeader{
+		Version: keyEnvelopeVersion,
+		KDF:     keyEnvelopeKDF,
+		Salt:    make([]byte, 16),
+		Nonce:   make([]byte, nonceSize),
+	}
+	switch header.KDF {
+	case kdfScrypt:
+		header.Param1, header.Param2, header.Param3 = scryptN, scryptR, scryptP
+	default:
+		header.Param1, header.Param2, header.Param3 = argon2idTime, argon2idMemory, argon2idThreads
+	}
+
+	if _, err := io.ReadFull(rand.Reader, header.Salt); err != nil {
+		return header, err
+	}
+	// Never use more than 2^32 random nonces with a given key because of the risk of repeat - with a fresh salt per envelope every key is unique anyway
+	if _, err := io.ReadFull(rand.Reader, header.Nonce); err != nil {
+		return header, err
+	}
+	return header, nil
+}
+
+// marshal serializes the envelope header, which is also authenticated as the AES-GCM additional data
+// Layout: magic[4] | version[1] | kdf[1] | param1[4] | param2[4] | param3[1] | saltLen[1] | salt | nonceLen[1] | nonce
+func (header keyEnvelopeHeader) marshal() []byte {
+	b := new(bytes.Buffer)
+	b.WriteString(keyEnvelopeMagic)
+	b.WriteByte(header.Version)
+	b.WriteByte(header.KDF)
+	binary.Write(b, binary.BigEndian, header.Param1)
+	binary.Write(b, binary.BigEndian, header.Param2)
+	b.WriteByte(header.Param3)
+	b.WriteByte(byte(len(header.Salt)))
+	b.Write(header.Salt)
+	b.WriteByte(byte(len(header.Nonce)))
+	b.Write(header.Nonce)
+	return b.Bytes()
+}
+
+// parseKeyEnvelopeHeader reads an envelope header off the front of a byte slice, returning the header, its raw bytes, and the ciphertext that follows
+func parseKeyEnvelopeHeader(bytesIn []byte) (header keyEnvelopeHeader, rawHeader []byte, ciphertext []byte, err error) {
+	r := bytes.NewReader(bytesIn)
+
+	magic := make([]byte, len(keyEnvelopeMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != keyEnvelopeMagic {
+		return header, nil, nil, Stoerr("not a versioned key envelope")
+	}
+
+	fields := []interface{}{&header.Version, &header.KDF, &header.Param1, &header.Param2, &header.Param3}
+	for _, field := range fields {
+		if err = binary.Read(r, binary.BigEndian, field); err != nil {
+			return header, nil, nil, Stoerr("truncated key envelope header")
+		}
+	}
+	if header.Version != keyEnvelopeVersion {
+		return header, nil, nil, fmt.Errorf("unsupported key envelope version %d", header.Version)
+	}
+
+	for _, field := range []*[]byte{&header.Salt, &header.Nonce} {
+		length, err := r.ReadByte()
+		if err != nil {
+			return header, nil, nil, Stoerr("truncated key envelope header")
+		}
+		*field = make([]byte, length)
+		if _, err = io.ReadFull(r, *field); err != nil {
+			return header, nil, nil, Stoerr("truncated key envelope header")
+		}
+	}
+
+	headerLength := len(bytesIn) - r.Len()
+	return header, bytesIn[:headerLength], bytesIn[headerLength:], nil
+}
+
+// isKeyEnvelope checks if a decoded byte slice is a versioned key envelope rather than the legacy format
+func isKeyEnvelope(bytesIn []byte) bool {
+	return bytes.HasPrefix(bytesIn, []byte(keyEnvelopeMagic))
+}
+
+// encryptBytes is a wrapper that takes a plain byte slice and a passphrase and returns a versioned key envelope
 func encryptBytes(bytesIn []byte, passphrase string) []byte {
-	passHash, _ := passphraseToHash(passphrase)
-	targetPassHash := passHash[0:32]
+	header, err := newKeyEnvelopeHeader(12)
+	check(err)
+
+	key, err := deriveKeyFromPassphrase(passphrase, header)
+	check(err)
 
 	// Create an AES Cipher
-	block, err := aes.NewCipher([]byte(targetPassHash))
+	block, err := aes.NewCipher(key)
 	check(err)
 
 	// Create a new gcm block container
 	gcm, err := cipher.NewGCM(block)
 	check(err)
 
-	// Never use more than 2^32 random nonces with a given key because of the risk of repeat.
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		log.Fatal(err)
-	}
-
-	// Seal will encrypt the file using the GCM mode, appending the nonce and tag (MAC value) to the final data, so we can use it to decrypt it later.
-	return gcm.Seal(nonce, nonce, bytesIn, nil)
+	// Seal will encrypt the file using the GCM mode, authenticating the header and appending the tag (MAC value) to the final data
+	rawHeader := header.marshal()
+	return gcm.Seal(rawHeader, header.Nonce, bytesIn, rawHeader)
 }
 
 // decryptBytes takes in a byte slice from a file and a passphrase then returns if the encrypted byte slice was decrypted, if so the plaintext contents, and any errors
+// Both versioned key envelopes and the legacy nonce+ciphertext format are read
 func decryptBytes(bytesIn []byte, passphrase string) (decrypted bool, plaintextBytes []byte, err error) {
 	// bytesIn must be decoded from base 64 first
 	// b64.StdEncoding.DecodeString(bytesIn)
 
+	if !isKeyEnvelope(bytesIn) {
+		return decryptLegacyBytes(bytesIn, passphrase)
+	}
+
+	header, rawHeader, ciphertext, err := parseKeyEnvelopeHeader(bytesIn)
+	if err != nil {
+		return false, []byte{}, err
+	}
+
+	key, err := deriveKeyFromPassphrase(passphrase, header)
+	if err != nil {
+		return false, []byte{}, err
+	}
+
+	// Create an AES Cipher
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return false, []byte{}, err
+	}
+
+	// Create a new gcm block container
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(header.Nonce))
+	if err != nil {
+		return false, []byte{}, err
+	}
+
+	plaintextBytes, err = gcm.Open(nil, header.Nonce, ciphertext, rawHeader)
+	if err != nil {
+		return false, []byte{}, err
+	}
+
+	// successfully decrypted
+	return true, plaintextBytes, nil
+}
+
+// decryptLegacyBytes decrypts the pre-envelope format of nonce+ciphertext keyed by passphraseToHash
+func decryptLegacyBytes(bytesIn []byte, passphrase string) (decrypted bool, plaintextBytes []byte, err error) {
 	passHash, _ := passphraseToHash(passphrase)
 	targetPassHash := passHash[0:32]
 
 	// Create an AES Cipher
 	block, err := aes.NewCipher([]byte(targetPassHash))
 	if err != nil {
-		log.Panic(err)
 		return false, []byte{}, err
 	}
 
 	// Create a new gcm block container
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		log.Panic(err)
 		return false, []byte{}, err
 	}
 
+	if len(bytesIn) < gcm.NonceSize() {
+		return false, []byte{}, Stoerr("encrypted key is too short")
+	}
 	nonce := bytesIn[:gcm.NonceSize()]
 	ciphertext := bytesIn[gcm.NonceSize():]
 	plaintextBytes, err = gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		log.Panic(err)
 		return false, []byte{}, err
 	}
 
@@ -89,6 +231,54 @@ func decryptBytes(bytesIn []byte, passphrase string) (decrypted bool, plaintextB
 	return true, plaintextBytes, nil
 }
 
+// migrateEncryptedKeys walks a directory tree and re-wraps every legacy encrypted *.priv.pem that opens with the passphrase into the configured key encryption format
+// Plain text keys, encrypted PKCS #8 keys, keys already in an envelope, and keys encrypted with a different passphrase are skipped
+func migrateEncryptedKeys(rootPath string, passphrase string) (migrated []string, skipped []string, err error) {
+	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".priv.pem") {
+			return nil
+		}
+
+		keyBytes, err := readPrivateKeyFile(path)
+		if err != nil {
+			return err
+		}
+		if !isPrivateKeyEncrypted(keyBytes) || bytes.Contains(keyBytes, []byte("ENCRYPTED PRIVATE KEY")) {
+			return nil
+		}
+
+		decodedPrivKey, err := B64DecodeStrToBytes(string(keyBytes))
+		if err != nil || isKeyEnvelope(decodedPrivKey) {
+			return nil
+		}
+
+		decrypted, plaintextBytes, err := decryptLegacyBytes(decodedPrivKey, passphrase)
+		if err != nil || !decrypted {
+			skipped = append(skipped, path)
+			return nil
+		}
+
+		encBytes, err := encryptPrivateKeyPEM(plaintextBytes, passphrase)
+		if err != nil {
+			return err
+		}
+		encBytes, err = wrapPrivateKeyBytes(encBytes)
+		if err != nil {
+			return err
+		}
+		if err := replacePrivateKeyFile(path, encBytes); err != nil {
+			return err
+		}
+
+		migrated = append(migrated, path)
+		return nil
+	})
+	return migrated, skipped, err
+}
+
 // isPEMEncrypted Checks to see if the byte slice from a file contains a plain-text PEM file
 func isPEMEncrypted(bytesIn []byte, typeOfPEM string) bool {
 	return !bytes.Contains(bytesIn, []byte(typeOfPEM))
@@ -96,7 +286,7 @@ func isPEMEncrypted(bytesIn []byte, typeOfPEM string) bool {
 
 // isPrivateKeyEncrypted Checks to see if the byte slice from a file contains a plain-text Private PEM Key
 func isPrivateKeyEncrypted(bytesIn []byte) bool {
-	return !bytes.Contains(bytesIn, []byte("PRIVATE KEY"))
+	return bytes.Contains(bytesIn, []byte("ENCRYPTED PRIVATE KEY")) || !bytes.Contains(bytesIn, []byte("PRIVATE KEY"))
 }
 
 // isCertificateEncrypted Checks to see if the byte slice from a file contains a plain-text Certificate PEM file
@@ -0,0 +1,152 @@
+package locksmith
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// readCAHierarchy builds the hierarchy node of the CA at caPath and every Intermediate CA under it
+// The slug and CommonName paths are those of the parent CA, empty for a Root CA
+// A CA that can not be read fully is kept in the tree with the problem as its status, so one broken CA does not hide the others
+func readCAHierarchy(caPath string, parentSlugPath string, parentCommonNamePath string) CAHierarchyNode {
+	node := CAHierarchyNode{
+		Slug:          filepath.Base(caPath),
+		SlugPath:      joinCAPath(parentSlugPath, filepath.Base(caPath)),
+		Intermediates: []CAHierarchyNode{}}
+
+	caCert, err := ReadCACertificate(caPath)
+	if err != nil || caCert == nil {
+		// A CA directory without a readable certificate still shows up so it can be found and cleaned up
+		node.CommonNamePath = joinCAPath(parentCommonNamePath, node.Slug)
+		node.Status = "no-ca-certificate"
+	} else {
+		node.CommonName = caCert.Subject.CommonName
+		node.CommonNamePath = joinCAPath(parentCommonNamePath, caCert.Subject.CommonName)
+		node.Subject = compileSubjectString(caCert.Subject)
+		node.SerialNumber = formatSerialHex(caCert.SerialNumber)
+		notBefore := caCert.NotBefore.UTC()
+		notAfter := caCert.NotAfter.UTC()
+		node.NotBefore = &notBefore
+		node.NotAfter = &notAfter
+		node.KeyAlgorithm = keyAlgorithmForKey(caCert.PublicKey)
+		node.KeySize = keySizeForKey(caCert.PublicKey)
+		if pathLength, ok := certificatePathLength(caCert); ok {
+			node.MaxPathLength = &pathLength
+		}
+		if node.Status, err = caHierarchyStatus(caPath, caCert.NotBefore, caCert.NotAfter); err != nil {
+			node.Status = "status-error"
+			node.Errors = append(node.Errors, err.Error())
+		}
+	}
+
+	if node.Certificates, err = caCertificateCounts(caPath); err != nil {
+		node.Status = "index-error"
+		node.Errors = append(node.Errors, err.Error())
+	}
+
+	intermedCAs, err := ioutil.ReadDir(caPath + "/intermed-ca")
+	if err != nil && !os.IsNotExist(err) {
+		node.Status = "intermediates-error"
+		node.Errors = append(node.Errors, err.Error())
+	}
+	for _, intermedCA := range intermedCAs {
+		if !intermedCA.IsDir() {
+			continue
+		}
+		node.Intermediates = append(node.Intermediates, readCAHierarchy(caPath+"/intermed-ca/"+intermedCA.Name(), node.SlugPath, node.CommonNamePath))
+	}
+	return node
+}
+
+// caHierarchyParentPaths returns the slug and CommonName paths of the CAs above the CA at caPath, empty for a Root CA
+func caHierarchyParentPaths(caPath string) (string, string) {
+	var slugPath string
+	var commonNamePath string
+	for parentPath := parentCAPath(caPath); parentPath != ""; parentPath = parentCAPath(parentPath) {
+		parentName := filepath.Base(parentPath)
+		slugPath = joinCAPath(parentName, slugPath)
+		if parentCert, err := ReadCACertificate(parentPath); err == nil && parentCert != nil {
+			parentName = parentCert.Subject.CommonName
+		}
+		commonNamePath = joinCAPath(parentName, commonNamePath)
+	}
+	return slugPath, commonNamePath
+}
+
+// caHierarchyStatus sums up if the CA at caPath can sign: archived, expired, not-yet-valid, locked, unlocked or active
+func caHierarchyStatus(caPath string, notBefore time.Time, notAfter time.Time) (string, error) {
+	archivedPath, err := archivedCAForPath(caPath)
+	if err != nil {
+		return "", err
+	}
+	if archivedPath != "" {
+		return "archived", nil
+	}
+
+	now := time.Now()
+	if now.After(notAfter) {
+		return "expired", nil
+	}
+	if now.Before(notBefore) {
+		return "not-yet-valid", nil
+	}
+
+	offline, err := isCAOffline(caPath)
+	if err != nil {
+		return "", err
+	}
+	if offline {
+		if _, unlocked := unlockSessionForCA(caPath); unlocked {
+			return "unlocked", nil
+		}
+		return "locked", nil
+	}
+	return "active", nil
+}
+
+// caCertificateCounts counts the certificates in the CA Index of the CA at caPath, valid ones past their end date count as expired
+func caCertificateCounts(caPath string) (CAHierarchyCertificateCounts, error) {
+	counts := CAHierarchyCertificateCounts{}
+	caIndex, err := readCAIndex(caPath + "/ca.index")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return counts, nil
+		}
+		return counts, err
+	}
+
+	now := time.Now()
+	for _, entry := range caIndex {
+		counts.Total++
+		switch entry.State {
+		case "R":
+			counts.Revoked++
+		case "E":
+			counts.Expired++
+		default:
+			endDate, err := parseCAIndexTime(entry.EndDate)
+			if err != nil {
+				return counts, err
+			}
+			if endDate.After(now) {
+				counts.Valid++
+			} else {
+				counts.Expired++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// joinCAPath joins two slug or CommonName CA paths, either can be empty
+func joinCAPath(parentPath string, childPath string) string {
+	if parentPath == "" {
+		return childPath
+	}
+	if childPath == "" {
+		return parentPath
+	}
+	return parentPath + "/" + childPath
+}
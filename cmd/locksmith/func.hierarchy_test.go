@@ -0,0 +1,108 @@
+package locksmith
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoinCAPath(t *testing.T) {
+	tests := []struct {
+		parentPath string
+		childPath  string
+		want       string
+	}{
+		{"", "", ""},
+		{"", "root-ca", "root-ca"},
+		{"root-ca", "", "root-ca"},
+		{"root-ca", "intermediate-ca", "root-ca/intermediate-ca"},
+	}
+	for _, tt := range tests {
+		if got := joinCAPath(tt.parentPath, tt.childPath); got != tt.want {
+			t.Errorf("joinCAPath(%q, %q) = %q, want %q", tt.parentPath, tt.childPath, got, tt.want)
+		}
+	}
+}
+
+func TestCACertificateCounts(t *testing.T) {
+	caPath := t.TempDir()
+	if counts, err := caCertificateCounts(caPath); err != nil || counts != (CAHierarchyCertificateCounts{}) {
+		t.Fatalf("got %+v %v, want no certificates without a CA Index", counts, err)
+	}
+
+	future := formatCAIndexTime(time.Now().Add(time.Hour))
+	past := formatCAIndexTime(time.Now().Add(-time.Hour))
+	if err := appendCAIndexEntries(caPath+"/ca.index", []CAIndex{
+		{State: "V", EndDate: future, Serial: "01", PathToCertificate: "unknown", Subject: "/CN=valid"},
+		{State: "V", EndDate: past, Serial: "02", PathToCertificate: "unknown", Subject: "/CN=past end date"},
+		{State: "E", EndDate: past, Serial: "03", PathToCertificate: "unknown", Subject: "/CN=expired"},
+		{State: "R", EndDate: future, DateOfRevokation: past, Serial: "04", PathToCertificate: "unknown", Subject: "/CN=revoked"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := caCertificateCounts(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (CAHierarchyCertificateCounts{Total: 4, Valid: 1, Expired: 2, Revoked: 1}); counts != want {
+		t.Fatalf("got %+v, want %+v", counts, want)
+	}
+}
+
+func TestReadCAHierarchy(t *testing.T) {
+	useTestPKIRoot(t)
+	rootPath, rootCert := createTestRootCA(t, testCertificateConfiguration("Hierarchy Root CA", "ecdsa"))
+	created, messages, _, err := createNewIntermediateCA(RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Hierarchy Intermediate CA", "ed25519")}, rootPath)
+	if err != nil || !created {
+		t.Fatalf("creating the Intermediate CA failed: %v %v", err, messages)
+	}
+	intermedPath := rootPath + "/intermed-ca/" + slugger("Hierarchy Intermediate CA")
+	if err := setCAOffline(intermedPath, true); err != nil {
+		t.Fatal(err)
+	}
+	// A CA directory without a certificate is still listed
+	CreateDirectory(rootPath + "/intermed-ca/broken-ca")
+
+	root := readCAHierarchy(rootPath, "", "")
+	if root.CommonName != "Hierarchy Root CA" || root.SlugPath != slugger("Hierarchy Root CA") || root.Status != "active" {
+		t.Fatalf("got Root CA node %+v", root)
+	}
+	if root.SerialNumber != formatSerialHex(rootCert.SerialNumber) || root.KeyAlgorithm != "ecdsa" {
+		t.Fatalf("got serial %s key %s", root.SerialNumber, root.KeyAlgorithm)
+	}
+	if root.Certificates.Total != 2 || root.Certificates.Valid != 2 {
+		t.Fatalf("got certificate counts %+v, want the Root and Intermediate CA Certificates", root.Certificates)
+	}
+	if len(root.Intermediates) != 2 {
+		t.Fatalf("got %d Intermediate CAs, want 2", len(root.Intermediates))
+	}
+
+	var broken, intermediate CAHierarchyNode
+	for _, node := range root.Intermediates {
+		if node.Slug == "broken-ca" {
+			broken = node
+		} else {
+			intermediate = node
+		}
+	}
+	if broken.Status != "no-ca-certificate" || broken.CommonNamePath != "Hierarchy Root CA/broken-ca" {
+		t.Fatalf("got broken CA node %+v", broken)
+	}
+	if intermediate.CommonNamePath != "Hierarchy Root CA/Hierarchy Intermediate CA" || intermediate.KeyAlgorithm != "ed25519" || intermediate.Status != "locked" {
+		t.Fatalf("got Intermediate CA node %+v", intermediate)
+	}
+
+	slugPath, commonNamePath := caHierarchyParentPaths(intermedPath)
+	if slugPath != slugger("Hierarchy Root CA") || commonNamePath != "Hierarchy Root CA" {
+		t.Fatalf("got parent paths %q %q", slugPath, commonNamePath)
+	}
+
+	// Archiving the Root CA shows on every CA under it
+	if _, err := archiveCA(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range readCAHierarchy(rootPath, "", "").Intermediates {
+		if node.Slug != "broken-ca" && node.Status != "archived" {
+			t.Fatalf("got Intermediate CA status %q, want archived", node.Status)
+		}
+	}
+}
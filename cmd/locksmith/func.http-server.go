@@ -80,6 +80,31 @@ func NewRouter(basePath string) *http.ServeMux {
 		case "POST":
 			// create - create new keys in key store
 			createKeyPairAPI(w, r)
+		case "DELETE":
+			// delete - delete a key pair or one of its retired versions
+			deleteKeyPairAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/key/rename", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// rename - move a key pair to a new ID, the old ID keeps resolving
+			renameKeyPairAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/key/rotate", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// rotate - retire the current version of a key pair and create a new one
+			rotateKeyPairAPI(w, r)
 		default:
 			methodNotAllowedAPI(w, r)
 		}
@@ -87,7 +112,7 @@ func NewRouter(basePath string) *http.ServeMux {
 
 	//====================================================================================
 	// KEY STORES
-	// Key Store Manipulation - Listing, Creating
+	// Key Store Manipulation - Listing, Creating, Deleting, Renaming
 	router.HandleFunc(formattedBasePath+apiVersionTag+"/keystores", func(w http.ResponseWriter, r *http.Request) {
 		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
 		switch r.Method {
@@ -105,6 +130,20 @@ func NewRouter(basePath string) *http.ServeMux {
 		case "POST":
 			// create - create new key store
 			createKeyStoreAPI(w, r)
+		case "DELETE":
+			// delete - delete an empty key store
+			deleteKeyStoreAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/keystore/rename", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// rename - move a key store to a new ID, the old ID keeps resolving
+			renameKeyStoreAPI(w, r)
 		default:
 			methodNotAllowedAPI(w, r)
 		}
@@ -136,6 +175,20 @@ func NewRouter(basePath string) *http.ServeMux {
 		}
 	})
 
+	//====================================================================================
+	// CA HIERARCHY
+	// Hierarchy - Reading the tree of Root and Intermediate CAs
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/hierarchy", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "GET":
+			// read - get the tree of CAs, optionally under a CA path
+			readHierarchyAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
 	//====================================================================================
 	// INTERMEDIATE CERTIFICATE AUTHORITIES
 	// Intermediate CA Manipulation - Listing, Creating, Deleting
@@ -163,13 +216,97 @@ func NewRouter(basePath string) *http.ServeMux {
 
 	//====================================================================================
 	// AUTHORITY
-	// Reading a Certificate Authority's Information
+	// Reading a Certificate Authority's Information, Importing external CAs, Renewing, Rekeying and Cross-signing CAs,
+	// Unlocking and Locking offline CAs, Archiving and Deleting CAs
 	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority", func(w http.ResponseWriter, r *http.Request) {
 		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
 		switch r.Method {
 		case "GET":
 			// index - get information for CA in parent path
 			readAuthorityAPI(w, r)
+		case "DELETE":
+			// delete - move a CA and everything under it to the archive area
+			deleteAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/import", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// import - bring an existing external CA into the PKI
+			importAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/renew", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// renew - issue a new certificate for a CA with the same key pair
+			renewAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/rekey", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// rekey - issue a new certificate for a CA with a new key pair, cross-signed with the old one
+			rekeyAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/cross-sign", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// cross-sign - certify another CA's subject and public key with a CA
+			crossSignAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/archive", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// archive - stop a CA from signing while it keeps serving its chain and CRL
+			archiveAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/unlock", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "GET":
+			// read - get the unlock session of an offline CA and the signatures made during it
+			readUnlockSessionAPI(w, r)
+		case "POST":
+			// unlock - open a time-limited unlock session so an offline CA can sign
+			unlockAuthorityAPI(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/authority/lock", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.RequestURI, r)
+		switch r.Method {
+		case "POST":
+			// lock - end the unlock session of an offline CA before it expires
+			lockAuthorityAPI(w, r)
 		default:
 			methodNotAllowedAPI(w, r)
 		}
@@ -245,6 +382,17 @@ func NewRouter(basePath string) *http.ServeMux {
 		}
 	})
 
+	router.HandleFunc(formattedBasePath+apiVersionTag+"/certificate/pkcs12", func(w http.ResponseWriter, r *http.Request) {
+		logNeworkRequestStdOut(r.Method+" "+r.URL.Path, r)
+		switch r.Method {
+		case "POST":
+			// export - bundle a cert, its key, and CA chain into a PKCS #12 file
+			exportCertificatePKCS12API(w, r)
+		default:
+			methodNotAllowedAPI(w, r)
+		}
+	})
+
 	return router
 }
 
@@ -0,0 +1,255 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// parseImportedCertificates reads one or more certificates supplied as a PEM bundle or a single DER certificate
+func parseImportedCertificates(certBytes []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+
+	rest := certBytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) > 0 {
+		return certificates, nil
+	}
+
+	// Not PEM, try a raw DER certificate
+	certificate, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, Stoerr("unable to parse certificate as PEM or DER")
+	}
+	return []*x509.Certificate{certificate}, nil
+}
+
+// validateImportedCACertificate makes sure a certificate can be used to issue certificates from
+func validateImportedCACertificate(caCert *x509.Certificate) error {
+	if !caCert.BasicConstraintsValid || !caCert.IsCA {
+		return Stoerr("certificate is not a CA certificate, basic constraints do not allow it to sign certificates")
+	}
+	if caCert.KeyUsage != 0 && caCert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return Stoerr("certificate key usage does not include certificate signing")
+	}
+	if time.Now().After(caCert.NotAfter) {
+		return fmt.Errorf("certificate expired on %s", caCert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if caCert.Subject.CommonName == "" {
+		return Stoerr("certificate has no common name to slug the CA Path from")
+	}
+	return nil
+}
+
+// verifyImportedCAChain checks every certificate in a chain was signed by the one following it, starting with the CA certificate
+func verifyImportedCAChain(caCert *x509.Certificate, chain []*x509.Certificate) error {
+	previousCert := caCert
+	for _, chainCert := range chain {
+		if err := previousCert.CheckSignatureFrom(chainCert); err != nil {
+			return fmt.Errorf("'%s' is not signed by '%s' in the chain: %s", previousCert.Subject.CommonName, chainCert.Subject.CommonName, err.Error())
+		}
+		previousCert = chainCert
+	}
+	return nil
+}
+
+// isSelfSignedCertificate checks if a certificate is its own issuer
+func isSelfSignedCertificate(certificate *x509.Certificate) bool {
+	return string(certificate.RawIssuer) == string(certificate.RawSubject) && certificate.CheckSignatureFrom(certificate) == nil
+}
+
+// importedCAPath is where an imported CA is placed, under roots/ or in the intermed-ca/ directory of its parent CA
+func importedCAPath(caCert *x509.Certificate, parentPath string) string {
+	if parentPath != "" {
+		return parentPath + "/intermed-ca/" + slugger(caCert.Subject.CommonName)
+	}
+	return readConfig.Locksmith.PKIRoot + "/roots/" + slugger(caCert.Subject.CommonName)
+}
+
+// importCA brings an existing external CA into the PKI so Locksmith can keep issuing from it
+// Without a parentPath the CA is placed in roots/, otherwise in the intermed-ca/ directory of the CA at parentPath, which has to have signed it
+func importCA(caImport RESTPOSTImportCAJSONIn, parentPath string) (bool, []string, x509.Certificate, error) {
+	// Decode the submitted CA certificate, private key and chain
+	certBytes, err := B64DecodeStrToBytes(caImport.Certificate)
+	if err != nil || len(certBytes) == 0 {
+		return false, []string{"CA Certificate must be base64 encoded"}, x509.Certificate{}, Stoerr("invalid-certificate")
+	}
+	certificates, err := parseImportedCertificates(certBytes)
+	if err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-certificate")
+	}
+	caCert := certificates[0]
+	if err := validateImportedCACertificate(caCert); err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-certificate")
+	}
+
+	keyBytes, err := B64DecodeStrToBytes(caImport.PrivateKey)
+	if err != nil || len(keyBytes) == 0 {
+		return false, []string{"CA Private Key must be base64 encoded"}, x509.Certificate{}, Stoerr("invalid-private-key")
+	}
+	privKey, err := parseImportedPrivateKey(keyBytes, caImport.PrivateKeyPassphrase)
+	if err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-private-key")
+	}
+	if err := validateImportedKeyPair(privKey, caCert.PublicKey); err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("key-pair-mismatch")
+	}
+
+	// Any certificates after the CA certificate in the certificate input are treated as its chain
+	chain := certificates[1:]
+	if caImport.Chain != "" {
+		chainBytes, err := B64DecodeStrToBytes(caImport.Chain)
+		if err != nil {
+			return false, []string{"CA Chain must be base64 encoded"}, x509.Certificate{}, Stoerr("invalid-chain")
+		}
+		chainCertificates, err := parseImportedCertificates(chainBytes)
+		if err != nil {
+			return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-chain")
+		}
+		chain = append(chain, chainCertificates...)
+	}
+
+	if parentPath != "" {
+		// The parent CA has to be the issuer, the chain is built from the CA Path
+		parentCert, err := ReadCACertificate(parentPath)
+		if err != nil || parentCert == nil {
+			return false, []string{"Parent CA Certificate could not be read"}, x509.Certificate{}, Stoerr("invalid-parent-path")
+		}
+		if err := caCert.CheckSignatureFrom(parentCert); err != nil {
+			return false, []string{"CA Certificate was not signed by the parent CA '" + parentCert.Subject.CommonName + "'"}, x509.Certificate{}, Stoerr("invalid-chain")
+		}
+		chain = nil
+	} else {
+		// A top level CA is either self-signed or brings the chain up to its root along
+		if !isSelfSignedCertificate(caCert) && len(chain) == 0 {
+			return false, []string{"CA Certificate is not self-signed, supply its chain or import it under its parent CA"}, x509.Certificate{}, Stoerr("invalid-chain")
+		}
+		if err := verifyImportedCAChain(caCert, chain); err != nil {
+			return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-chain")
+		}
+	}
+	caPath := importedCAPath(caCert, parentPath)
+
+	if err := validateDistributionPoints(caImport.DistributionPoints); err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("invalid-distribution-points")
+	}
+
+	// Locksmith can not tell which serials an external CA already used, so the next one has to be given
+	if caImport.NextSerial <= 0 {
+		return false, []string{"next_serial has to be set past every serial the CA already issued"}, x509.Certificate{}, Stoerr("invalid-serial")
+	}
+
+	caPathExists, err := DirectoryExists(caPath)
+	check(err)
+	if caPathExists {
+		return false, []string{"CA '" + caCert.Subject.CommonName + "' already exists!"}, x509.Certificate{}, Stoerr("ca-exists")
+	}
+
+	// Only the file backend can take an existing private key
+	signerBackend, err := signerBackendForCA(caPath)
+	if err != nil {
+		return false, []string{"CA Signer Backend Failure"}, x509.Certificate{}, err
+	}
+	fileBackend, ok := signerBackend.(*fileSignerBackend)
+	if !ok {
+		return false, []string{"CA private keys can only be imported into the file signer backend"}, x509.Certificate{}, Stoerr("ca-import-error")
+	}
+
+	// Create the CA base directories and files
+	certPaths := setupCAFileStructure(caPath)
+
+	// A half imported CA would block importing it again, so everything written from here on is removed when a step fails
+	var copiedCertificates []string
+	importFailed := func(message string, err error) (bool, []string, x509.Certificate, error) {
+		for _, copiedCertificate := range copiedCertificates {
+			check(os.Remove(copiedCertificate))
+		}
+		check(os.RemoveAll(caPath))
+		if err == nil {
+			err = Stoerr("ca-import-error")
+		}
+		return false, []string{message}, x509.Certificate{}, err
+	}
+
+	if err := fileBackend.writeKeyPair(privKey, caImport.RSAPrivateKeyPassphrase); err != nil {
+		return importFailed("CA Private Key Failure", err)
+	}
+
+	certificateFile, err := writeCertificateFile(pemEncodeCertificate(caCert.Raw), certPaths.RootCACertsPath+"/ca.pem")
+	if err != nil || !certificateFile {
+		return importFailed("CA Certificate Failure", err)
+	}
+
+	if len(chain) > 0 {
+		var chainPEM []byte
+		for _, chainCert := range chain {
+			chainPEM = append(chainPEM, pemEncodeCertificate(chainCert.Raw).Bytes()...)
+		}
+		if _, err := WriteByteFile(caPath+"/"+caChainFileName, chainPEM, 0644, true); err != nil {
+			return importFailed("CA Chain Failure", err)
+		}
+	}
+
+	if caImport.DistributionPoints != nil {
+		if err := writeDistributionPoints(caPath, caImport.DistributionPoints); err != nil {
+			return importFailed("CA Distribution Points Failure", err)
+		}
+	}
+
+	// Seed the serial and CRL numbers to carry on from where the external CA left off
+	if _, err := WriteFile(certPaths.RootCACertSerialFilePath, fmt.Sprintf("%02d", caImport.NextSerial), 0600, true); err != nil {
+		return importFailed("CA Serial Failure", err)
+	}
+	if caImport.NextCRLNumber > 0 {
+		if _, err := WriteFile(certPaths.RootCACrlnumFilePath, fmt.Sprintf("%02d", caImport.NextCRLNumber), 0600, true); err != nil {
+			return importFailed("CA CRL Number Failure", err)
+		}
+	}
+
+	// Create an empty CRL signed with the imported key, numbered on from the CRLs the external CA already issued
+	if err := reissueCRLForCA(caPath, caCert, privKey); err != nil {
+		return importFailed("CA CRL Creation Error", err)
+	}
+
+	// Record the CA Certificate with its issuer, like a CA created in Locksmith, the index entry is written last
+	serialNumber := formatSerialHex(caCert.SerialNumber)
+	absoluteCertPath, _ := filepath.Abs(certPaths.RootCACertsPath + "/ca.pem")
+	indexPath := certPaths.RootCACertIndexFilePath
+	newCertsPath := certPaths.RootCANewCertsPath
+	if parentPath != "" {
+		parentCertificatePath := parentPath + "/certs/" + slugger(caCert.Subject.CommonName) + ".pem"
+		if err := CopyFile(certPaths.RootCACertsPath+"/ca.pem", parentCertificatePath, BUFFERSIZE); err != nil {
+			return importFailed("CA Certificate Copy Failure", err)
+		}
+		copiedCertificates = append(copiedCertificates, parentCertificatePath)
+		indexPath = parentPath + "/ca.index"
+		newCertsPath = parentPath + "/newcerts"
+	}
+	if err := CopyFile(certPaths.RootCACertsPath+"/ca.pem", newCertsPath+"/"+serialNumber+".pem", BUFFERSIZE); err != nil {
+		return importFailed("CA Certificate Copy Failure", err)
+	}
+	copiedCertificates = append(copiedCertificates, newCertsPath+"/"+serialNumber+".pem")
+	addedEntry, err := AddEntryToCAIndex(indexPath, absoluteCertPath)
+	if !addedEntry {
+		return importFailed("CA Index Entry Error", err)
+	}
+
+	return true, []string{"Finished importing CA: " + caCert.Subject.CommonName}, *caCert, nil
+}
@@ -0,0 +1,172 @@
+package locksmith
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImportCA assembles the import request for an external CA certificate and its key
+func testImportCA(t *testing.T, caCert *x509.Certificate, privKey *ecdsa.PrivateKey, nextSerial int64) RESTPOSTImportCAJSONIn {
+	t.Helper()
+	keyDer, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return RESTPOSTImportCAJSONIn{
+		Certificate: B64EncodeBytesToStr(pemEncodeCertificate(caCert.Raw).Bytes()),
+		PrivateKey:  B64EncodeBytesToStr(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})),
+		NextSerial:  nextSerial,
+	}
+}
+
+func TestParseImportedCertificates(t *testing.T) {
+	rootCert, rootKey := writeTestCA(t, t.TempDir(), "External Root CA", nil, nil, nil)
+	intermedCert, _ := writeTestCA(t, t.TempDir(), "External Intermediate CA", nil, rootCert, rootKey)
+	bundle := append(pemEncodeCertificate(intermedCert.Raw).Bytes(), pemEncodeCertificate(rootCert.Raw).Bytes()...)
+
+	tests := []struct {
+		name      string
+		input     []byte
+		wantCerts int
+		wantError bool
+	}{
+		{"PEM bundle", bundle, 2, false},
+		{"DER", rootCert.Raw, 1, false},
+		{"other PEM blocks are skipped", append([]byte("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"), bundle...), 2, false},
+		{"garbage", []byte("not a certificate"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificates, err := parseImportedCertificates(tt.input)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if len(certificates) != tt.wantCerts {
+				t.Fatalf("got %d certificates, want %d", len(certificates), tt.wantCerts)
+			}
+		})
+	}
+}
+
+func TestValidateImportedCACertificate(t *testing.T) {
+	validCA := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "External CA"},
+			SerialNumber:          big.NewInt(1),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(*x509.Certificate)
+		wantError bool
+	}{
+		{"valid", func(*x509.Certificate) {}, false},
+		{"no key usage", func(c *x509.Certificate) { c.KeyUsage = 0 }, false},
+		{"not a CA", func(c *x509.Certificate) { c.IsCA = false }, true},
+		{"no basic constraints", func(c *x509.Certificate) { c.BasicConstraintsValid = false }, true},
+		{"no certificate signing", func(c *x509.Certificate) { c.KeyUsage = x509.KeyUsageDigitalSignature }, true},
+		{"expired", func(c *x509.Certificate) { c.NotAfter = time.Now().Add(-time.Hour) }, true},
+		{"no common name", func(c *x509.Certificate) { c.Subject.CommonName = "" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caCert := validCA()
+			tt.modify(caCert)
+			if err := validateImportedCACertificate(caCert); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestImportCA(t *testing.T) {
+	useTestPKIRoot(t)
+	rootCert, rootKey := writeTestCA(t, t.TempDir(), "External Root CA", nil, nil, nil)
+
+	// The next serial has to be given
+	if imported, _, _, err := importCA(testImportCA(t, rootCert, rootKey, 0), ""); imported || err == nil {
+		t.Fatal("expected an error without next_serial")
+	}
+
+	// A key that does not belong to the certificate is refused
+	_, otherKey := writeTestCA(t, t.TempDir(), "Other CA", nil, nil, nil)
+	if imported, _, _, err := importCA(testImportCA(t, rootCert, otherKey, 42), ""); imported || err == nil {
+		t.Fatal("expected an error for a mismatched private key")
+	}
+
+	imported, messages, caCert, err := importCA(testImportCA(t, rootCert, rootKey, 42), "")
+	if err != nil || !imported {
+		t.Fatalf("import failed: %v %v", err, messages)
+	}
+	if !caCert.Equal(rootCert) {
+		t.Fatal("imported CA certificate differs from the submitted one")
+	}
+	caPath := readConfig.Locksmith.PKIRoot + "/roots/" + slugger("External Root CA")
+	if serial := readSerialNumberAbs(caPath + "/ca.serial"); serial != "42" {
+		t.Fatalf("got serial %q, want 42", serial)
+	}
+
+	// The imported CA carries on issuing from the given serial
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", &x509.CertificateRequest{DNSNames: []string{"www.example.labs"}})
+	created, certificate, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	if certificate.SerialNumber.Int64() != 42 {
+		t.Fatalf("got serial %d, want 42", certificate.SerialNumber.Int64())
+	}
+	if err := certificate.CheckSignatureFrom(rootCert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same CA can not be imported twice
+	if imported, _, _, err := importCA(testImportCA(t, rootCert, rootKey, 42), ""); imported || err == nil {
+		t.Fatal("expected an error importing the CA again")
+	}
+}
+
+func TestImportCAWithChain(t *testing.T) {
+	useTestPKIRoot(t)
+	rootCert, rootKey := writeTestCA(t, t.TempDir(), "External Root CA", nil, nil, nil)
+	intermedCert, intermedKey := writeTestCA(t, t.TempDir(), "External Intermediate CA", nil, rootCert, rootKey)
+
+	// A CA that is not self-signed needs its chain
+	caImport := testImportCA(t, intermedCert, intermedKey, 1)
+	if imported, _, _, err := importCA(caImport, ""); imported || err == nil {
+		t.Fatal("expected an error without the chain")
+	}
+
+	// A chain that did not sign the CA is refused
+	otherCert, _ := writeTestCA(t, t.TempDir(), "Other Root CA", nil, nil, nil)
+	caImport.Chain = B64EncodeBytesToStr(pemEncodeCertificate(otherCert.Raw).Bytes())
+	if imported, _, _, err := importCA(caImport, ""); imported || err == nil {
+		t.Fatal("expected an error for a chain that did not sign the CA")
+	}
+	caPath := readConfig.Locksmith.PKIRoot + "/roots/" + slugger("External Intermediate CA")
+	if exists, _ := DirectoryExists(caPath); exists {
+		t.Fatal("refused import left the CA Path behind")
+	}
+
+	caImport.Chain = B64EncodeBytesToStr(pemEncodeCertificate(rootCert.Raw).Bytes())
+	imported, messages, _, err := importCA(caImport, "")
+	if err != nil || !imported {
+		t.Fatalf("import failed: %v %v", err, messages)
+	}
+
+	// The CA bundle ends with the certificates above the imported CA
+	bundle := generateCABundle("External Intermediate CA")
+	if !strings.HasSuffix(bundle, string(pemEncodeCertificate(rootCert.Raw).Bytes())) {
+		t.Fatal("CA bundle does not end with the imported chain")
+	}
+}
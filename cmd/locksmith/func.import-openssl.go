@@ -0,0 +1,178 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+)
+
+// findOpenSSLCAFile returns the path of the first candidate file that exists in an OpenSSL CA directory, or an empty string
+func findOpenSSLCAFile(openSSLDir string, candidates []string) (string, error) {
+	for _, candidate := range candidates {
+		candidatePath := filepath.Join(openSSLDir, candidate)
+		candidateExists, err := FileExists(candidatePath)
+		if err != nil {
+			return "", err
+		}
+		if candidateExists {
+			return candidatePath, nil
+		}
+	}
+	return "", nil
+}
+
+// readOpenSSLHexNumberFile reads the hex number out of an OpenSSL serial or crlnumber file
+func readOpenSSLHexNumberFile(path string) (int64, error) {
+	numberBytes, err := ReadFileToBytes(path)
+	if err != nil {
+		return 0, err
+	}
+	number, ok := new(big.Int).SetString(strings.TrimSpace(string(numberBytes)), 16)
+	if !ok || number.Sign() < 0 {
+		return 0, fmt.Errorf("'%s' does not hold a hex number", path)
+	}
+	if !number.IsInt64() {
+		return 0, fmt.Errorf("'%s' holds a number too large for Locksmith's sequential serials, set the next number explicitly", path)
+	}
+	return number.Int64(), nil
+}
+
+// nextSerialFromOpenSSLIndex returns one past the highest serial in an OpenSSL index, or 1 for an empty index
+func nextSerialFromOpenSSLIndex(openSSLIndexPath string) (int64, error) {
+	openSSLIndex, err := readCAIndex(openSSLIndexPath)
+	if err != nil {
+		return 0, err
+	}
+	highestSerial := big.NewInt(0)
+	for _, entry := range openSSLIndex {
+		serialNumber, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			return 0, fmt.Errorf("OpenSSL index entry has an invalid serial '%s'", entry.Serial)
+		}
+		if serialNumber.Cmp(highestSerial) > 0 {
+			highestSerial = serialNumber
+		}
+	}
+	nextSerial := new(big.Int).Add(highestSerial, big.NewInt(1))
+	if !nextSerial.IsInt64() {
+		return 0, fmt.Errorf("'%s' holds serials too large for Locksmith's sequential serials, set the next serial explicitly", openSSLIndexPath)
+	}
+	return nextSerial.Int64(), nil
+}
+
+// importOpenSSLCAHistory carries the certificates an OpenSSL CA issued over into an imported CA at caPath
+// Every entry of the OpenSSL index is added to the CA Index with its state and dates, certificates found in the newcerts directory are copied over,
+// and the CRL is re-issued so it keeps listing the revoked certificates
+func importOpenSSLCAHistory(caPath string, openSSLIndexPath string, openSSLNewCertsPath string, caCert *x509.Certificate, privKey crypto.Signer) ([]string, error) {
+	openSSLIndex, err := readCAIndex(openSSLIndexPath)
+	if err != nil {
+		return []string{"OpenSSL index could not be read"}, err
+	}
+
+	// Entries already in the CA Index, such as the CA Certificate itself, are not added twice
+	caIndex, err := readCAIndex(caPath + "/ca.index")
+	if err != nil {
+		return []string{"CA Index could not be read"}, err
+	}
+	indexedSerials := map[string]bool{}
+	for _, entry := range caIndex {
+		indexedSerials[strings.ToUpper(entry.Serial)] = true
+	}
+
+	var newEntries []CAIndex
+	revokedCertificates := 0
+	currentCertificates := map[string]*x509.Certificate{}
+	currentEntries := map[string]int{}
+	copiedCertificates := 0
+
+	for _, entry := range openSSLIndex {
+		serialNumber, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			return []string{"OpenSSL index entry has an invalid serial '" + entry.Serial + "'"}, Stoerr("invalid-index")
+		}
+		entry.Serial = formatSerialHex(serialNumber)
+		if indexedSerials[entry.Serial] {
+			continue
+		}
+		indexedSerials[entry.Serial] = true
+
+		switch entry.State {
+		case "V", "E":
+		case "R":
+			if _, err := parseCAIndexRevocation(entry.DateOfRevokation); err != nil {
+				return []string{"OpenSSL index entry " + entry.Serial + ": " + err.Error()}, Stoerr("invalid-index")
+			}
+			revokedCertificates++
+		default:
+			return []string{"OpenSSL index entry " + entry.Serial + " has an unknown state '" + entry.State + "'"}, Stoerr("invalid-index")
+		}
+
+		// OpenSSL names the copies it keeps of issued certificates after their serial
+		entry.PathToCertificate = "unknown"
+		openSSLCertPath := filepath.Join(openSSLNewCertsPath, entry.Serial+".pem")
+		openSSLCertExists, err := FileExists(openSSLCertPath)
+		if err != nil {
+			return []string{"OpenSSL newcerts directory could not be read"}, err
+		}
+		if openSSLCertExists {
+			certBytes, err := ReadFileToBytes(openSSLCertPath)
+			if err != nil {
+				return []string{"OpenSSL certificate " + openSSLCertPath + " could not be read"}, err
+			}
+			certificates, err := parseImportedCertificates(certBytes)
+			if err != nil {
+				return []string{"OpenSSL certificate " + openSSLCertPath + " could not be parsed"}, err
+			}
+			certificate := certificates[0]
+
+			newCertPath := caPath + "/newcerts/" + entry.Serial + ".pem"
+			if _, err := writeCertificateFile(pemEncodeCertificate(certificate.Raw), newCertPath); err != nil {
+				return []string{"Certificate " + entry.Serial + " could not be copied"}, err
+			}
+			copiedCertificates++
+			absoluteCertPath, _ := filepath.Abs(newCertPath)
+			entry.PathToCertificate = absoluteCertPath
+
+			// The latest valid certificate of each Common Name is also kept in certs/, like the certificates Locksmith issues
+			if entry.State == "V" && !certificate.Equal(caCert) && certificate.Subject.CommonName != "" {
+				certSlug := slugger(certificate.Subject.CommonName)
+				if currentCertificate, ok := currentCertificates[certSlug]; !ok || !certificate.NotAfter.Before(currentCertificate.NotAfter) {
+					currentCertificates[certSlug] = certificate
+					currentEntries[certSlug] = len(newEntries)
+				}
+			}
+		}
+
+		newEntries = append(newEntries, entry)
+	}
+
+	for certSlug, certificate := range currentCertificates {
+		certPath := caPath + "/certs/" + certSlug + ".pem"
+		certExists, err := FileExists(certPath)
+		if err != nil {
+			return []string{"CA certs directory could not be read"}, err
+		}
+		if certExists {
+			continue
+		}
+		if _, err := writeCertificateFile(pemEncodeCertificate(certificate.Raw), certPath); err != nil {
+			return []string{"Certificate " + certSlug + " could not be copied"}, err
+		}
+		absoluteCertPath, _ := filepath.Abs(certPath)
+		newEntries[currentEntries[certSlug]].PathToCertificate = absoluteCertPath
+	}
+
+	if err := appendCAIndexEntries(caPath+"/ca.index", newEntries); err != nil {
+		return []string{"CA Index Entry Error"}, err
+	}
+
+	// Re-issue the CRL so it lists the revoked certificates
+	if err := reissueCRLForCA(caPath, caCert, privKey); err != nil {
+		return []string{"CA CRL Creation Error"}, err
+	}
+
+	return []string{fmt.Sprintf("Imported %d index entries, %d revoked, and %d certificates", len(newEntries), revokedCertificates, copiedCertificates)}, nil
+}
@@ -0,0 +1,182 @@
+package locksmith
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFindOpenSSLCAFile(t *testing.T) {
+	openSSLDir := t.TempDir()
+	if _, err := WriteFile(openSSLDir+"/serial", "01", 0600, true); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := findOpenSSLCAFile(openSSLDir, []string{"ca.serial", "serial"})
+	if err != nil || found != openSSLDir+"/serial" {
+		t.Fatalf("got %q %v, want the serial file", found, err)
+	}
+	found, err = findOpenSSLCAFile(openSSLDir, []string{"crlnumber"})
+	if err != nil || found != "" {
+		t.Fatalf("got %q %v, want nothing", found, err)
+	}
+}
+
+func TestReadOpenSSLHexNumberFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		contents  string
+		want      int64
+		wantError bool
+	}{
+		{"hex", "1A\n", 26, false},
+		{"lower case", "ff", 255, false},
+		{"not hex", "zz", 0, true},
+		{"too large", "0123456789ABCDEF0123", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			numberPath := t.TempDir() + "/serial"
+			if err := ioutil.WriteFile(numberPath, []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			number, err := readOpenSSLHexNumberFile(numberPath)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if number != tt.want {
+				t.Fatalf("got %d, want %d", number, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextSerialFromOpenSSLIndex(t *testing.T) {
+	endDate := formatCAIndexTime(time.Now().Add(time.Hour))
+	tests := []struct {
+		name      string
+		entries   []CAIndex
+		want      int64
+		wantError bool
+	}{
+		{"empty index", nil, 1, false},
+		{"highest serial", []CAIndex{
+			{State: "V", EndDate: endDate, Serial: "0A", PathToCertificate: "unknown", Subject: "/CN=a"},
+			{State: "R", EndDate: endDate, DateOfRevokation: endDate, Serial: "03", PathToCertificate: "unknown", Subject: "/CN=b"},
+		}, 11, false},
+		{"invalid serial", []CAIndex{{State: "V", EndDate: endDate, Serial: "XY", PathToCertificate: "unknown", Subject: "/CN=a"}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexPath := t.TempDir() + "/index.txt"
+			if err := ioutil.WriteFile(indexPath, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.entries != nil {
+				if err := appendCAIndexEntries(indexPath, tt.entries); err != nil {
+					t.Fatal(err)
+				}
+			}
+			nextSerial, err := nextSerialFromOpenSSLIndex(indexPath)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if nextSerial != tt.want {
+				t.Fatalf("got %d, want %d", nextSerial, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportOpenSSLCAHistory(t *testing.T) {
+	useTestPKIRoot(t)
+	rootCert, rootKey := writeTestCA(t, t.TempDir(), "OpenSSL Root CA", nil, nil, nil)
+	if imported, messages, _, err := importCA(testImportCA(t, rootCert, rootKey, 0x12), ""); err != nil || !imported {
+		t.Fatalf("import failed: %v %v", err, messages)
+	}
+	caPath := readConfig.Locksmith.PKIRoot + "/roots/" + slugger("OpenSSL Root CA")
+
+	// The OpenSSL CA issued a server certificate, kept in newcerts, and revoked another one
+	openSSLDir := t.TempDir()
+	if err := os.MkdirAll(openSSLDir+"/newcerts", 0755); err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafBytes, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(0x10),
+		Subject:      pkix.Name{CommonName: "www.example.labs"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, rootCert, leafKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeCertificateFile(pemEncodeCertificate(leafBytes), openSSLDir+"/newcerts/10.pem"); err != nil {
+		t.Fatal(err)
+	}
+
+	endDate := formatCAIndexTime(time.Now().Add(time.Hour))
+	revocationDate := formatCAIndexTime(time.Now().Add(-time.Minute))
+	openSSLIndexPath := openSSLDir + "/index.txt"
+	if err := appendCAIndexEntries(openSSLIndexPath, []CAIndex{
+		{State: "V", EndDate: formatCAIndexTime(rootCert.NotAfter), Serial: formatSerialHex(rootCert.SerialNumber), PathToCertificate: "unknown", Subject: "/CN=OpenSSL Root CA"},
+		{State: "V", EndDate: endDate, Serial: "10", PathToCertificate: "unknown", Subject: "/CN=www.example.labs"},
+		{State: "R", EndDate: endDate, DateOfRevokation: revocationDate + ",keyCompromise", Serial: "11", PathToCertificate: "unknown", Subject: "/CN=old.example.labs"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := importOpenSSLCAHistory(caPath, openSSLIndexPath, openSSLDir+"/newcerts", rootCert, rootKey)
+	if err != nil {
+		t.Fatalf("importing history failed: %v %v", err, messages)
+	}
+
+	// The CA Certificate entry is not added twice
+	caIndex, err := readCAIndex(caPath + "/ca.index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caIndex) != 3 {
+		t.Fatalf("got %d CA Index entries, want 3", len(caIndex))
+	}
+
+	// The valid certificate is copied into newcerts/ and certs/
+	for _, certPath := range []string{caPath + "/newcerts/10.pem", caPath + "/certs/" + slugger("www.example.labs") + ".pem"} {
+		if exists, _ := FileExists(certPath); !exists {
+			t.Fatalf("%s was not copied", certPath)
+		}
+	}
+
+	// The CRL lists the revoked certificate
+	crlBytes, err := ioutil.ReadFile(caPath + "/crl/ca.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(crlBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := crl.TBSCertList.RevokedCertificates
+	if len(revoked) != 1 || revoked[0].SerialNumber.Int64() != 0x11 {
+		t.Fatalf("got %d revoked certificates, want serial 11", len(revoked))
+	}
+
+	// Unknown index states are refused
+	badIndexPath := openSSLDir + "/bad.txt"
+	if err := appendCAIndexEntries(badIndexPath, []CAIndex{{State: "X", EndDate: endDate, Serial: "20", PathToCertificate: "unknown", Subject: "/CN=x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importOpenSSLCAHistory(caPath, badIndexPath, openSSLDir+"/newcerts", rootCert, rootKey); err == nil {
+		t.Fatal("expected an error for an unknown index state")
+	}
+}
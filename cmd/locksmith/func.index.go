@@ -2,11 +2,15 @@ package locksmith
 
 import (
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log"
+	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/jszwec/csvutil"
 )
@@ -14,9 +18,9 @@ import (
 /*
 AddEntryToCAIndex adds the needed tab-separated data to the CA Index file when generating certificates
 State: “V” for Valid, “E” for Expired and “R” for revoked
-Enddate: in the format YYMMDDHHmmssZ (the “Z” stands for Zulu/GMT)
-Date of Revocation: same format as “Enddate”
-Serial: serial of the certificate
+Enddate: in the format YYMMDDHHmmssZ (the “Z” stands for Zulu/GMT), YYYYMMDDHHmmssZ from 2050 on
+Date of Revocation: same format as “Enddate”, optionally followed by a comma and the revocation reason
+Serial: serial of the certificate in upper case hex, the same as OpenSSL writes it
 Path to Certificate: can also be “unknown”
 Subject: subject of the certificate
 */
@@ -24,27 +28,38 @@ func AddEntryToCAIndex(indexPath string, certPath string) (bool, error) {
 	// File is created on file structure initializtion - read the file in
 	// Read in Certificate File lol
 	certificate, err := ReadCertFromFile(certPath)
-	check(err)
-
-	f, err := os.OpenFile(indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	check(err)
-
-	defer f.Close()
-
-	endT := certificate.NotAfter.UTC()
-	endYear := fmt.Sprintf("%v", endT.Year())
-	endYearShort := endYear[len(endYear)-2:]
-	formattedDate := fmt.Sprintf("%s%02d%02d%02d%02d%02dZ", endYearShort, endT.Month(), endT.Day(), endT.Hour(), endT.Minute(), endT.Second())
+	if err != nil {
+		return false, err
+	}
+	if certificate == nil {
+		return false, Stoerr("certificate " + certPath + " does not exist")
+	}
 
 	// create CAIndex struct
 	caIndex := []CAIndex{{
 		State:             "V",
-		EndDate:           formattedDate,
+		EndDate:           formatCAIndexTime(certificate.NotAfter),
 		DateOfRevokation:  "",
-		Serial:            fmt.Sprintf("%02d", certificate.SerialNumber),
+		Serial:            formatSerialHex(certificate.SerialNumber),
 		Subject:           compileSubjectString(certificate.Subject),
 		PathToCertificate: certPath}}
 
+	if err := appendCAIndexEntries(indexPath, caIndex); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// appendCAIndexEntries writes entries to the end of a CA Index file, creating it if needed
+func appendCAIndexEntries(indexPath string, caIndex []CAIndex) error {
+	f, err := os.OpenFile(indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
 	w := csv.NewWriter(f)
 	w.Comma = '\t'
 
@@ -52,15 +67,207 @@ func AddEntryToCAIndex(indexPath string, certPath string) (bool, error) {
 	enc.AutoHeader = false
 
 	if err := enc.Encode(caIndex); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	w.Flush()
-	if err := w.Error(); err != nil {
-		log.Fatal(err)
+	return w.Error()
+}
+
+// readCAIndex reads every entry of a CA Index file, Locksmith and OpenSSL index files share the same format
+func readCAIndex(indexPath string) ([]CAIndex, error) {
+	f, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
 	}
 
-	return true, nil
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	r.LazyQuotes = true
+
+	dec, err := csvutil.NewDecoder(r, "State", "EndDate", "DateOfRevokation", "Serial", "PathToCertificate", "Subject")
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var caIndex []CAIndex
+	for {
+		var entry CAIndex
+		if err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		caIndex = append(caIndex, entry)
+	}
+	return caIndex, nil
+}
+
+// writeCAIndex replaces a CA Index file with the given entries, written next to it first so a failed write leaves the old index in place
+func writeCAIndex(indexPath string, caIndex []CAIndex) error {
+	tmpPath := indexPath + ".tmp"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := appendCAIndexEntries(tmpPath, caIndex); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, indexPath)
+}
+
+// migrateCAIndexSerials rewrites the decimal serials of CA Index files written before serials were hex, and renames the newcerts/ copies named after them
+// The serial is taken from the certificate an entry points to, entries whose certificate can not be read are left as they are and reported as skipped
+func migrateCAIndexSerials(rootPath string) (migrated []string, skipped []string, err error) {
+	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != "ca.index" {
+			return nil
+		}
+
+		caIndex, err := readCAIndex(path)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for i, entry := range caIndex {
+			// OpenSSL imports have no certificate path and were always hex
+			if entry.PathToCertificate == "unknown" {
+				continue
+			}
+			certificate, err := ReadCertFromFile(entry.PathToCertificate)
+			if err != nil || certificate == nil {
+				skipped = append(skipped, path+" serial "+entry.Serial)
+				continue
+			}
+			hexSerial := formatSerialHex(certificate.SerialNumber)
+			if entry.Serial == hexSerial {
+				continue
+			}
+
+			newCertsPath := filepath.Dir(path) + "/newcerts/"
+			decimalCertPath := newCertsPath + fmt.Sprintf("%02d", certificate.SerialNumber) + ".pem"
+			hexCertPath := newCertsPath + hexSerial + ".pem"
+			decimalCertExists, err := FileExists(decimalCertPath)
+			if err != nil {
+				return err
+			}
+			hexCertExists, err := FileExists(hexCertPath)
+			if err != nil {
+				return err
+			}
+			if decimalCertExists && !hexCertExists {
+				if err := os.Rename(decimalCertPath, hexCertPath); err != nil {
+					return err
+				}
+			}
+
+			caIndex[i].Serial = hexSerial
+			changed = true
+		}
+
+		if !changed {
+			return nil
+		}
+		if err := writeCAIndex(path, caIndex); err != nil {
+			return err
+		}
+		migrated = append(migrated, path)
+		return nil
+	})
+	return migrated, skipped, err
+}
+
+// parseCAIndexRevocation splits the revocation field of an index entry into the revocation time and CRL reason code
+// OpenSSL writes it as "date", "date,reason" or "date,reason,extra" for the holdInstruction, keyTime and CAkeyTime reasons
+func parseCAIndexRevocation(dateOfRevokation string) (pkix.RevokedCertificate, error) {
+	revocationFields := strings.Split(dateOfRevokation, ",")
+	revocationTime, err := parseCAIndexTime(revocationFields[0])
+	if err != nil {
+		return pkix.RevokedCertificate{}, fmt.Errorf("invalid revocation date '%s'", dateOfRevokation)
+	}
+
+	revokedCertificate := pkix.RevokedCertificate{RevocationTime: revocationTime}
+	if len(revocationFields) > 1 {
+		reasonCode, ok := crlReasonCodes[revocationFields[1]]
+		if !ok {
+			return pkix.RevokedCertificate{}, fmt.Errorf("unknown revocation reason '%s'", revocationFields[1])
+		}
+		reasonBytes, err := asn1.Marshal(asn1.Enumerated(reasonCode))
+		if err != nil {
+			return pkix.RevokedCertificate{}, err
+		}
+		revokedCertificate.Extensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 21}, Value: reasonBytes}}
+	}
+	return revokedCertificate, nil
+}
+
+// revokedCertificatesFromCAIndex lists the revoked entries of a CA Index for a CRL
+func revokedCertificatesFromCAIndex(indexPath string) ([]pkix.RevokedCertificate, error) {
+	caIndex, err := readCAIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var revokedCertificates []pkix.RevokedCertificate
+	for _, entry := range caIndex {
+		if entry.State != "R" {
+			continue
+		}
+		serialNumber, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("CA Index entry has an invalid serial '%s'", entry.Serial)
+		}
+		revokedCertificate, err := parseCAIndexRevocation(entry.DateOfRevokation)
+		if err != nil {
+			return nil, err
+		}
+		revokedCertificate.SerialNumber = serialNumber
+		revokedCertificates = append(revokedCertificates, revokedCertificate)
+	}
+	return revokedCertificates, nil
+}
+
+// formatSerialHex formats a serial number the way OpenSSL does in index files and newcerts file names, upper case hex with an even number of digits
+func formatSerialHex(serial *big.Int) string {
+	hexSerial := strings.ToUpper(serial.Text(16))
+	if len(hexSerial)%2 == 1 {
+		hexSerial = "0" + hexSerial
+	}
+	return hexSerial
+}
+
+// formatCAIndexTime formats a time as an ASN.1 UTCTime, or a GeneralizedTime from 2050 on, like OpenSSL does in index files
+func formatCAIndexTime(t time.Time) string {
+	t = t.UTC()
+	if t.Year() >= 2050 {
+		return t.Format("20060102150405Z")
+	}
+	return t.Format("060102150405Z")
+}
+
+// parseCAIndexTime reads a time written by formatCAIndexTime
+func parseCAIndexTime(indexTime string) (time.Time, error) {
+	if len(indexTime) == len("20060102150405Z") {
+		return time.Parse("20060102150405Z", indexTime)
+	}
+	t, err := time.Parse("060102150405Z", indexTime)
+	if err != nil {
+		return t, err
+	}
+	// UTCTime years from 50 on are in the 1900s
+	if t.Year() >= 2050 {
+		t = t.AddDate(-100, 0, 0)
+	}
+	return t, nil
 }
 
 // CheckCAIndexForExpiredCertificates just scans the CA Index and cycles through the lines checking the expiration date and setting E if expired
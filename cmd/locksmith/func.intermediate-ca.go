@@ -1,19 +1,18 @@
 package locksmith
 
 import (
-	"bytes"
-	"crypto/rsa"
+	"crypto"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"fmt"
 	"math/big"
+	"os"
 	"time"
 )
 
 // setupIntermediateCACert
-func setupIntermediateCACert(serialNumber int64, commonName string, organization []string, organizationalUnit []string, country []string, province []string, locality []string, streetAddress []string, postalCode []string, addTime []int, sanData SANData, pubKey *rsa.PublicKey) *x509.Certificate {
+func setupIntermediateCACert(serialNumber int64, commonName string, organization []string, organizationalUnit []string, country []string, province []string, locality []string, streetAddress []string, postalCode []string, addTime []int, sanData SANData, pubKey crypto.PublicKey) *x509.Certificate {
 	// set up our Intermediate CA certificate
 
 	// Convert string slice of URLs into actual URI objects
@@ -36,8 +35,7 @@ func setupIntermediateCACert(serialNumber int64, commonName string, organization
 	subjectKeyID := h[:]
 
 	return &x509.Certificate{
-		SignatureAlgorithm: x509.SHA512WithRSA,
-		SerialNumber:       big.NewInt(serialNumber),
+		SerialNumber: big.NewInt(serialNumber),
 		Subject: pkix.Name{
 			CommonName:         commonName,
 			Organization:       organization,
@@ -59,8 +57,6 @@ func setupIntermediateCACert(serialNumber int64, commonName string, organization
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		ExtraExtensions:       []pkix.Extension{issuerAltName},
-		CRLDistributionPoints: []string{"https://ca.example.labs:443/crl/ca.example.labs_Root_Certification_Authority.crl"},
-		IssuingCertificateURL: []string{"https://ca.example.labs:443/certs/ca.example.labs_Root_Certification_Authority.cert.pem"},
 	}
 }
 
@@ -77,6 +73,9 @@ func createNewIntermediateCA(configWrapper RESTPOSTIntermedCAJSONIn, parentPath
 	if !certificateValid {
 		return false, validationMsgs, x509.Certificate{}, Stoerr("cert-config-error")
 	}
+	if err := validateNameConstraints(configWrapper.NameConstraints); err != nil {
+		return false, []string{err.Error()}, x509.Certificate{}, Stoerr("cert-config-error")
+	}
 
 	caName = configWrapper.CertificateConfiguration.Subject.CommonName
 	rootSlug = slugger(caName)
@@ -85,42 +84,82 @@ func createNewIntermediateCA(configWrapper RESTPOSTIntermedCAJSONIn, parentPath
 	rsaPrivateKeyPassword := configWrapper.CertificateConfiguration.RSAPrivateKeyPassphrase
 	signingCARSAPrivateKeyPassword := configWrapper.SigningPrivateKeyPassphrase
 
+	// Open the Signing CA and its private key before anything is written, a wrong passphrase or signer backend error leaves nothing behind
+	rootCA, err := ReadCACertificate(parentPath)
+	if err != nil || rootCA == nil {
+		return false, []string{"Signing CA Certificate could not be read"}, x509.Certificate{}, Stoerr("invalid-parent-path")
+	}
+	signingCASignerBackend, err := signerBackendForCA(parentPath)
+	if err != nil {
+		return false, []string{"Signing CA Signer Backend Failure"}, x509.Certificate{}, err
+	}
+	rootCAPrivateKeyFromFile, err := signingCASignerBackend.Signer(signingCARSAPrivateKeyPassword)
+	if err != nil {
+		return false, []string{"Signing CA Private Key Failure"}, x509.Certificate{}, err
+	}
+
 	// Create the Intermediate CA base directories and files
 	certPaths := setupCAFileStructure(rootSlugPath)
 
-	// Check for Intermediate CA key pair
-	caKeyCheck, err := FileExists(certPaths.RootCAKeysPath + "/ca.priv.pem")
-	check(err)
+	// A half created Intermediate CA would block creating it again, so everything written from here on is removed when a step fails
+	// A key generated in an external backend, such as a PKCS #11 token, is destroyed as well
+	var copiedFiles []string
+	var signerBackend SignerBackend
+	generatedKey := false
+	creationFailed := func(messages []string, err error) (bool, []string, x509.Certificate, error) {
+		for _, copiedFile := range copiedFiles {
+			check(os.Remove(copiedFile))
+		}
+		if generatedKey {
+			check(signerBackend.DeleteKey())
+		}
+		check(os.RemoveAll(rootSlugPath))
+		if err == nil {
+			err = Stoerr("intermed-ca-creation-error")
+		}
+		return false, messages, x509.Certificate{}, err
+	}
 
-	if !caKeyCheck {
-		// if there is no private key, create one
-		rootPrivKey, rootPubKey, err := GenerateRSAKeypair(4096)
-		check(err)
+	// Keep the URL templates for the certificates this Intermediate CA issues
+	if configWrapper.CertificateConfiguration.DistributionPoints != nil {
+		if err := writeDistributionPoints(rootSlugPath, configWrapper.CertificateConfiguration.DistributionPoints); err != nil {
+			return creationFailed([]string{"Intermediate CA Distribution Points Failure"}, err)
+		}
+	}
 
-		pemEncodedPrivateKey, encryptedPrivateKeyBytes := pemEncodeRSAPrivateKey(rootPrivKey, rsaPrivateKeyPassword)
+	// Keep the certificate policies for the certificates this Intermediate CA issues
+	if configWrapper.CertificateConfiguration.CertificatePolicies != nil {
+		if err := writeCertificatePolicies(rootSlugPath, configWrapper.CertificateConfiguration.CertificatePolicies); err != nil {
+			return creationFailed([]string{"Intermediate CA Certificate Policies Failure"}, err)
+		}
+	}
 
-		if rsaPrivateKeyPassword == "" {
-			rootPrivKeyFile, rootPubKeyFile, err := writeRSAKeyPair(pemEncodedPrivateKey, pemEncodeRSAPublicKey(rootPubKey), certPaths.RootCAKeysPath+"/ca")
-			check(err)
-			if !rootPrivKeyFile || !rootPubKeyFile {
-				return false, []string{"Root CA Private Key Failure"}, x509.Certificate{}, err
-			}
-		} else {
+	// Find where the Intermediate CA key pair is kept
+	signerBackend, err = signerBackendForCA(rootSlugPath)
+	if err != nil {
+		return creationFailed([]string{"Intermediate CA Signer Backend Failure"}, err)
+	}
 
-			encStr := B64EncodeBytesToStr(encryptedPrivateKeyBytes.Bytes())
-			encBufferB := bytes.NewBufferString(encStr)
+	// Check for Intermediate CA key pair
+	caKeyCheck, err := signerBackend.HasKey()
+	if err != nil {
+		return creationFailed([]string{"Intermediate CA Signer Backend Failure"}, err)
+	}
 
-			rootPrivKeyFile, rootPubKeyFile, err := writeRSAKeyPair(encBufferB, pemEncodeRSAPublicKey(rootPubKey), certPaths.RootCAKeysPath+"/ca")
-			check(err)
-			if !rootPrivKeyFile || !rootPubKeyFile {
-				return false, []string{"Root CA Private Key Failure"}, x509.Certificate{}, err
-			}
+	if !caKeyCheck {
+		// if there is no private key, create one
+		generatedKey = true
+		_, err := signerBackend.GenerateKey(configWrapper.CertificateConfiguration.KeyAlgorithm, configWrapper.CertificateConfiguration.KeySize, rsaPrivateKeyPassword)
+		if err != nil {
+			return creationFailed([]string{"Intermediate CA Private Key Failure"}, err)
 		}
-
 	}
 
 	// Read in the Private key
-	privateKeyFromFile := GetPrivateKey(certPaths.RootCAKeysPath+"/ca.priv.pem", rsaPrivateKeyPassword)
+	privateKeyFromFile, err := signerBackend.Signer(rsaPrivateKeyPassword)
+	if err != nil {
+		return creationFailed([]string{"Intermediate CA Private Key Failure"}, err)
+	}
 
 	// Read in the Public key
 	pubKeyFromFile := GetPublicKey(certPaths.RootCAKeysPath + "/ca.pub.pem")
@@ -143,18 +182,23 @@ func createNewIntermediateCA(configWrapper RESTPOSTIntermedCAJSONIn, parentPath
 			true)
 		if !caCSR {
 			check(err)
-			return false, []string{"Intermediate CA CSR Failure"}, x509.Certificate{}, err
+			return creationFailed([]string{"Intermediate CA CSR Failure"}, err)
 		}
 	}
 
 	// Read in CSR lol
 	caCSRPEM, err := readCSRFromFile(certPaths.RootCACertRequestsPath + "/ca.pem")
-	check(err)
+	if err != nil {
+		return creationFailed([]string{"Intermediate CA CSR Failure"}, err)
+	}
 	//log.Printf("Created CSR with CN: %v", caCSRPEM.Subject.CommonName)
 
 	// Copy Intermediate CA Certificate Request File to the Signing CA's certreqs folder
-	copyCSRErr := CopyFile(certPaths.RootCACertRequestsPath+"/ca.pem", parentPath+"/certreqs/"+slugger(caCSRPEM.Subject.CommonName)+".pem", 4096)
-	check(copyCSRErr)
+	parentCSRPath := parentPath + "/certreqs/" + slugger(caCSRPEM.Subject.CommonName) + ".pem"
+	if err := CopyFile(certPaths.RootCACertRequestsPath+"/ca.pem", parentCSRPath, 4096); err != nil {
+		return creationFailed([]string{"Intermediate CA CSR Copy Failure"}, err)
+	}
+	copiedFiles = append(copiedFiles, parentCSRPath)
 
 	// Check for certificate file
 	certificateFileCheck, err := FileExists(certPaths.RootCACertsPath + "/ca.pem")
@@ -165,66 +209,93 @@ func createNewIntermediateCA(configWrapper RESTPOSTIntermedCAJSONIn, parentPath
 		// Serial number should come from the signing CA's serial
 		intermedCA := setupIntermediateCACert(readSerialNumberAsInt64Abs(parentPath+"/ca.serial"), caCSRPEM.Subject.CommonName, caCSRPEM.Subject.Organization, caCSRPEM.Subject.OrganizationalUnit, caCSRPEM.Subject.Country, caCSRPEM.Subject.Province, caCSRPEM.Subject.Locality, caCSRPEM.Subject.StreetAddress, caCSRPEM.Subject.PostalCode, configWrapper.CertificateConfiguration.ExpirationDate, configWrapper.CertificateConfiguration.SANData, pubKeyFromFile)
 
-		// Read in the Signing CA
-		rootCA, err := ReadCACertificate(parentPath)
-		check(err)
+		// Limit how many CAs can be chained under the Intermediate CA
+		applyPathLength(intermedCA, caPathLength(configWrapper.CertificateConfiguration))
 
-		rootCAPrivateKeyFromFile := GetPrivateKey(parentPath+"/private/ca.priv.pem", signingCARSAPrivateKeyPassword)
+		// Stamp in the certificate policies of the Intermediate CA
+		if err := applyCertificatePolicies(intermedCA, configWrapper.CertificateConfiguration.CertificatePolicies, true); err != nil {
+			return creationFailed([]string{"Intermediate CA Certificate Policies Failure"}, err)
+		}
+
+		// Limit the names this Intermediate CA can issue certificates for
+		if err := applyNameConstraints(intermedCA, configWrapper.NameConstraints); err != nil {
+			return creationFailed([]string{err.Error()}, Stoerr("cert-config-error"))
+		}
+
+		// Stamp in the URLs of the Signing CA
+		if err := applyDistributionPoints(intermedCA, parentPath, rootCA.Subject.CommonName); err != nil {
+			return creationFailed([]string{"Signing CA Distribution Points Failure"}, err)
+		}
 
 		// Byte Encode the Certificate - https://golang.org/pkg/crypto/x509/#CreateCertificate
 		caBytes, err := CreateCert(intermedCA, rootCA, pubKeyFromFile, rootCAPrivateKeyFromFile)
-		check(err)
+		if err != nil {
+			return creationFailed([]string{"Intermediate CA Certificate Signing Failure"}, err)
+		}
 
 		// Write Certificate file
 		certificateFile, err := writeCertificateFile(pemEncodeCertificate(caBytes), certPaths.RootCACertsPath+"/ca.pem")
-		check(err)
-		if !certificateFile {
-			return false, []string{"Intermediate CA Certificate Creation Failure!"}, x509.Certificate{}, err
-		}
-
-		// Increase the serial number in the Intermediate CA Serial file
-		increaseSerial, err := IncreaseSerialNumberAbs(certPaths.RootCACertSerialFilePath)
-		check(err)
-		if !increaseSerial {
-			logStdOut("Serial Increment ERROR!")
-			return false, []string{"Intermediate CA Serial Increment Error"}, x509.Certificate{}, err
-		}
-		// Increase the Signing CA serial number
-		increaseSerial, err = IncreaseSerialNumberAbs(parentPath + "/ca.serial")
-		check(err)
-		if !increaseSerial {
-			logStdOut("Serial Increment ERROR!")
-			return false, []string{"Signing CA Serial Increment Error"}, x509.Certificate{}, err
+		if err != nil || !certificateFile {
+			return creationFailed([]string{"Intermediate CA Certificate Creation Failure!"}, err)
 		}
 	}
 
 	// Read in Certificate File lol
 	caCert, err := ReadCertFromFile(certPaths.RootCACertsPath + "/ca.pem")
-	check(err)
+	if err != nil || caCert == nil {
+		return creationFailed([]string{"Intermediate CA Certificate Read Failure"}, err)
+	}
 
 	// Copy Intermediate CA Certificate File to the Signing CA's certs folder
-	copyCertErr := CopyFile(certPaths.RootCACertsPath+"/ca.pem", parentPath+"/certs/"+slugger(caCert.Subject.CommonName)+".pem", 4096)
-	check(copyCertErr)
+	parentCertificatePath := parentPath + "/certs/" + slugger(caCert.Subject.CommonName) + ".pem"
+	if err := CopyFile(certPaths.RootCACertsPath+"/ca.pem", parentCertificatePath, 4096); err != nil {
+		return creationFailed([]string{"Intermediate CA Certificate Copy Failure"}, err)
+	}
+	copiedFiles = append(copiedFiles, parentCertificatePath)
 
 	// Copy Intermediate CA Certificate File to the Signing CA's newcerts folder
-	serialNumber := fmt.Sprintf("%02d", caCert.SerialNumber)
-	copyNewCertsErr := CopyFile(certPaths.RootCACertsPath+"/ca.pem", parentPath+"/newcerts/"+serialNumber+".pem", 4096)
-	check(copyNewCertsErr)
+	serialNumber := formatSerialHex(caCert.SerialNumber)
+	parentNewCertPath := parentPath + "/newcerts/" + serialNumber + ".pem"
+	if err := CopyFile(certPaths.RootCACertsPath+"/ca.pem", parentNewCertPath, 4096); err != nil {
+		return creationFailed([]string{"Intermediate CA Certificate Copy Failure"}, err)
+	}
+	copiedFiles = append(copiedFiles, parentNewCertPath)
 
-	// Add Certificate to Signing CA Index
-	addedEntry, err := AddEntryToCAIndex(parentPath+"/ca.index", certPaths.RootCACertsPath+"/ca.pem")
+	// Create CRL with CA Cert
+	caCRL, err := CreateNewCRLForCA(caCert, privateKeyFromFile, certPaths.RootCACertRevListPath+"/ca.crl")
+	if !caCRL {
+		logStdOut("Intermediate CA CRL ERROR!")
+		return creationFailed([]string{"Intermediate CA CRL Creation Error"}, err)
+	}
+
+	// An offline Intermediate CA only signs again during an unlock session
+	if configWrapper.CertificateConfiguration.Offline {
+		if err := setCAOffline(rootSlugPath, true); err != nil {
+			return creationFailed([]string{"Intermediate CA Offline Mode Failure"}, err)
+		}
+	}
+
+	// Increase the serial number in the Intermediate CA Serial file
+	increaseSerial, err := IncreaseSerialNumberAbs(certPaths.RootCACertSerialFilePath)
 	check(err)
+	if !increaseSerial {
+		logStdOut("Serial Increment ERROR!")
+		return creationFailed([]string{"Intermediate CA Serial Increment Error"}, err)
+	}
+
+	// Add Certificate to Signing CA Index, the index entry and the Signing CA serial are written last so a failed creation leaves neither behind
+	addedEntry, err := AddEntryToCAIndex(parentPath+"/ca.index", certPaths.RootCACertsPath+"/ca.pem")
 	if !addedEntry {
 		logStdOut("Signing CA Index ERROR!")
-		return false, []string{"Signing CA Index Entry Error"}, x509.Certificate{}, err
+		return creationFailed([]string{"Signing CA Index Entry Error"}, err)
 	}
 
-	// Create CRL with CA Cert
-	caCRL, err := CreateNewCRLForCA(caCert, privateKeyFromFile, certPaths.RootCACertRevListPath+"/ca.crl")
+	// Increase the Signing CA serial number, the Intermediate CA is complete by now so it is kept, but its serial would be reused
+	increaseSerial, err = IncreaseSerialNumberAbs(parentPath + "/ca.serial")
 	check(err)
-	if !caCRL {
-		logStdOut("Intermediate CA CRL ERROR!")
-		return false, []string{"Intermediate CA CRL Creation Error"}, x509.Certificate{}, err
+	if !increaseSerial {
+		logStdOut("Serial Increment ERROR!")
+		return false, []string{"Signing CA Serial Increment Error, set the Signing CA serial past " + formatSerialHex(caCert.SerialNumber) + " before it issues again"}, x509.Certificate{}, Stoerr("serial-increment-error")
 	}
 
 	return true, []string{"Finished creating Intermediate CA: " + caCert.Subject.CommonName}, *caCert, nil
@@ -0,0 +1,204 @@
+package locksmith
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadKEK reads the master key encryption key from the configured kek_file or the LOCKSMITH_KEK environment variable
+// Without either, private keys are written without the server side wrapping
+func loadKEK() error {
+	var encodedKEK string
+
+	if readConfig.Locksmith.KEKFile != "" {
+		kekBytes, err := ReadFileToBytes(readConfig.Locksmith.KEKFile)
+		if err != nil {
+			return err
+		}
+		encodedKEK = string(kekBytes)
+	} else {
+		encodedKEK = os.Getenv(kekEnvVar)
+	}
+
+	if strings.TrimSpace(encodedKEK) == "" {
+		masterKEK = nil
+		return nil
+	}
+
+	kek, err := parseKEK(encodedKEK)
+	if err != nil {
+		return err
+	}
+	masterKEK = kek
+	logStdOut("Loaded key encryption key " + kekID(masterKEK))
+	return nil
+}
+
+// parseKEK decodes a base64 encoded 256-bit key encryption key, as made by `openssl rand -base64 32`
+func parseKEK(encodedKEK string) ([]byte, error) {
+	kek, err := B64DecodeStrToBytes(strings.TrimSpace(encodedKEK))
+	if err != nil {
+		return nil, Stoerr("key encryption key is not valid base64")
+	}
+	if len(kek) != 32 {
+		return nil, Stoerr("key encryption key must be 32 bytes")
+	}
+	return kek, nil
+}
+
+// kekID is a short fingerprint of a key encryption key, stored with wrapped keys to tell which KEK opens them
+func kekID(kek []byte) string {
+	sum := sha256.Sum256(kek)
+	return hex.EncodeToString(sum[:8])
+}
+
+// isKEKWrapped checks if the contents of a private key file are wrapped with a key encryption key
+func isKEKWrapped(keyBytes []byte) bool {
+	block, _ := pem.Decode(keyBytes)
+	return block != nil && block.Type == kekWrappedPEMType
+}
+
+// wrapPrivateKeyBytes wraps private key file contents with the master KEK, when there is no KEK loaded they are returned as is
+func wrapPrivateKeyBytes(keyBytes []byte) ([]byte, error) {
+	if masterKEK == nil || isKEKWrapped(keyBytes) {
+		return keyBytes, nil
+	}
+	return wrapWithKEK(keyBytes, masterKEK)
+}
+
+// wrapWithKEK seals private key file contents with AES-256-GCM under a key encryption key
+func wrapWithKEK(keyBytes []byte, kek []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	// The KEK ID is authenticated so a wrapped key can't be relabeled
+	id := kekID(kek)
+	wrappedBytes := gcm.Seal(nonce, nonce, keyBytes, []byte(id))
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    kekWrappedPEMType,
+		Headers: map[string]string{"KEK-ID": id},
+		Bytes:   wrappedBytes,
+	}), nil
+}
+
+// unwrapPrivateKeyBytes removes the master KEK wrapping from private key file contents, unwrapped contents are returned as is
+func unwrapPrivateKeyBytes(keyBytes []byte) ([]byte, error) {
+	if !isKEKWrapped(keyBytes) {
+		return keyBytes, nil
+	}
+	if masterKEK == nil {
+		return nil, Stoerr("private key is wrapped with a key encryption key but none is loaded")
+	}
+	return unwrapWithKEK(keyBytes, masterKEK)
+}
+
+// unwrapWithKEK opens private key file contents wrapped with a key encryption key
+func unwrapWithKEK(keyBytes []byte, kek []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil || block.Type != kekWrappedPEMType {
+		return nil, Stoerr("private key is not wrapped with a key encryption key")
+	}
+
+	id := block.Headers["KEK-ID"]
+	if id != kekID(kek) {
+		return nil, Stoerr("private key is wrapped with key encryption key " + id + ", not " + kekID(kek))
+	}
+
+	aesBlock, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(aesBlock)
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < gcm.NonceSize() {
+		return nil, Stoerr("wrapped private key is too short")
+	}
+
+	nonce := block.Bytes[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, block.Bytes[gcm.NonceSize():], []byte(id))
+}
+
+// readPrivateKeyFile loads a private key file and removes any KEK wrapping
+func readPrivateKeyFile(path string) ([]byte, error) {
+	keyBytes, err := ReadFileToBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	return unwrapPrivateKeyBytes(keyBytes)
+}
+
+// replacePrivateKeyFile atomically swaps the contents of an existing read-only private key file
+func replacePrivateKeyFile(path string, keyBytes []byte) error {
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+	if err := ioutil.WriteFile(tmpPath, keyBytes, 0400); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// rotateKEK walks a directory tree and re-wraps every *.priv.pem with a new key encryption key
+// Keys wrapped with the current master KEK are unwrapped first, and keys not yet wrapped are wrapped for the first time
+// Keys already wrapped with the new KEK are skipped so an interrupted rotation can be run again
+func rotateKEK(rootPath string, newKEK []byte) (rotated []string, skipped []string, err error) {
+	newID := kekID(newKEK)
+
+	err = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".priv.pem") {
+			return nil
+		}
+
+		keyBytes, err := ReadFileToBytes(path)
+		if err != nil {
+			return err
+		}
+
+		if block, _ := pem.Decode(keyBytes); block != nil && block.Type == kekWrappedPEMType && block.Headers["KEK-ID"] == newID {
+			skipped = append(skipped, path)
+			return nil
+		}
+
+		plainBytes, err := unwrapPrivateKeyBytes(keyBytes)
+		if err != nil {
+			return Stoerr(path + ": " + err.Error())
+		}
+
+		wrappedBytes, err := wrapWithKEK(plainBytes, newKEK)
+		if err != nil {
+			return err
+		}
+		if err := replacePrivateKeyFile(path, wrappedBytes); err != nil {
+			return err
+		}
+
+		rotated = append(rotated, path)
+		return nil
+	})
+	return rotated, skipped, err
+}
@@ -0,0 +1,215 @@
+package locksmith
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validateKeyCeremony checks the threshold and custodians of a key ceremony, the key passphrase is generated so none can be supplied
+func validateKeyCeremony(keyCeremony *KeyCeremonyConfig, passphrase string) error {
+	if passphrase != "" {
+		return Stoerr("a key ceremony generates the key passphrase, rsa_private_key_passphrase can not be set")
+	}
+	if len(keyCeremony.Custodians) < 2 || len(keyCeremony.Custodians) > 255 {
+		return Stoerr("a key ceremony needs between 2 and 255 custodians")
+	}
+	if keyCeremony.Threshold < 2 || keyCeremony.Threshold > len(keyCeremony.Custodians) {
+		return Stoerr("the key ceremony threshold has to be between 2 and the number of custodians")
+	}
+	seenCustodians := map[string]bool{}
+	for _, custodian := range keyCeremony.Custodians {
+		if strings.TrimSpace(custodian) == "" {
+			return Stoerr("key ceremony custodians need a name")
+		}
+		if seenCustodians[custodian] {
+			return Stoerr("key ceremony custodian '" + custodian + "' is listed twice")
+		}
+		seenCustodians[custodian] = true
+	}
+	return nil
+}
+
+// holdKeyCeremony generates the key passphrase of a Root CA and splits it into a share for every custodian
+func holdKeyCeremony(keyCeremony *KeyCeremonyConfig) (string, KeyCeremony, []KeyShare, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", KeyCeremony{}, nil, err
+	}
+	shares, err := splitSecret(secret, len(keyCeremony.Custodians), keyCeremony.Threshold)
+	if err != nil {
+		return "", KeyCeremony{}, nil, err
+	}
+
+	ceremony := KeyCeremony{Threshold: keyCeremony.Threshold, HeldAt: time.Now().UTC()}
+	var keyShares []KeyShare
+	for i, custodian := range keyCeremony.Custodians {
+		ceremony.Custodians = append(ceremony.Custodians, KeyCeremonyCustodian{Name: custodian, ShareFingerprint: keyShareFingerprint(shares[i])})
+		keyShares = append(keyShares, KeyShare{Custodian: custodian, Share: base64.StdEncoding.EncodeToString(shares[i])})
+	}
+	return hex.EncodeToString(secret), ceremony, keyShares, nil
+}
+
+// keyShareFingerprint identifies a key share without giving it away
+func keyShareFingerprint(share []byte) string {
+	fingerprint := sha256.Sum256(share)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// writeKeyCeremony saves the custodians of a Root CA and starts its key ceremony transcript
+func writeKeyCeremony(caPath string, ceremony KeyCeremony, caCert *x509.Certificate) error {
+	ceremonyBytes, err := json.MarshalIndent(ceremony, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(caPath+"/"+keyCeremonyFileName, ceremonyBytes, 0644); err != nil {
+		return err
+	}
+
+	certificateFingerprint := sha256.Sum256(caCert.Raw)
+	return appendKeyCeremonyEvent(caPath, KeyCeremonyEvent{
+		Time:              ceremony.HeldAt,
+		Event:             "held",
+		Subject:           caCert.Subject.CommonName,
+		Threshold:         ceremony.Threshold,
+		Custodians:        ceremony.Custodians,
+		CertificateSHA256: hex.EncodeToString(certificateFingerprint[:])})
+}
+
+// keyCeremonyForCA reads the key ceremony of the CA at caPath, nil if the CA was not created in one
+func keyCeremonyForCA(caPath string) (*KeyCeremony, error) {
+	keyCeremonyFile := caPath + "/" + keyCeremonyFileName
+	keyCeremonyExists, err := FileExists(keyCeremonyFile)
+	if err != nil || !keyCeremonyExists {
+		return nil, err
+	}
+	keyCeremonyBytes, err := ioutil.ReadFile(keyCeremonyFile)
+	if err != nil {
+		return nil, err
+	}
+	ceremony := KeyCeremony{}
+	if err := json.Unmarshal(keyCeremonyBytes, &ceremony); err != nil {
+		return nil, err
+	}
+	return &ceremony, nil
+}
+
+// appendKeyCeremonyEvent adds an event to the key ceremony transcript of the CA at caPath
+func appendKeyCeremonyEvent(caPath string, event KeyCeremonyEvent) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	transcriptFile, err := os.OpenFile(caPath+"/"+keyCeremonyTranscriptFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer transcriptFile.Close()
+	_, err = transcriptFile.Write(append(eventBytes, '\n'))
+	return err
+}
+
+// keyPassphrasesForRenewal opens the keys of a CA being renewed or rekeyed, and of its parent CA, with key shares where they were created in a key ceremony
+func keyPassphrasesForRenewal(caPath string, renewal *RESTPOSTRenewCAJSONIn, operation string) ([]string, error) {
+	ceremony, err := keyCeremonyForCA(caPath)
+	if err != nil {
+		return []string{"Key Ceremony could not be read"}, err
+	}
+	if ceremony != nil && renewal.NewRSAPrivateKeyPassphrase != "" {
+		return []string{"The key of a CA created in a key ceremony stays protected by the shares of its custodians, new_rsa_private_key_passphrase can not be set"}, Stoerr("key-ceremony-error")
+	}
+
+	caName := filepath.Base(caPath)
+	if caCert, err := ReadCACertificate(caPath); err == nil && caCert != nil {
+		caName = caCert.Subject.CommonName
+	}
+	passphrase, messages, err := keyPassphraseForCA(caPath, renewal.RSAPrivateKeyPassphrase, renewal.KeyShares, operation, caName)
+	if err != nil {
+		return messages, err
+	}
+	renewal.RSAPrivateKeyPassphrase = passphrase
+
+	if parentPath := parentCAPath(caPath); parentPath != "" {
+		signingPassphrase, messages, err := keyPassphraseForCA(parentPath, renewal.SigningPrivateKeyPassphrase, renewal.SigningKeyShares, operation, caName)
+		if err != nil {
+			return messages, err
+		}
+		renewal.SigningPrivateKeyPassphrase = signingPassphrase
+	}
+	return []string{}, nil
+}
+
+// keyPassphraseForCA returns the passphrase that opens the key of the CA at caPath
+// CAs created in a key ceremony need the shares of enough custodians, every attempt is recorded in the transcript with the operation and subject it was for
+// The operation is one of intermediate-ca, certificate, renew, rekey, cross-sign or unlock
+func keyPassphraseForCA(caPath string, passphrase string, keyShares []string, operation string, subject string) (string, []string, error) {
+	ceremony, err := keyCeremonyForCA(caPath)
+	if err != nil {
+		return "", []string{"Key Ceremony could not be read"}, err
+	}
+	if ceremony == nil {
+		if len(keyShares) > 0 {
+			return "", []string{"CA was not created in a key ceremony, supply its passphrase instead of key shares"}, Stoerr("no-key-ceremony")
+		}
+		return passphrase, []string{}, nil
+	}
+
+	// Offline CAs sign with the key opened by the shares when they were unlocked, only a rekey needs them again to store the new key
+	offline, err := isCAOffline(caPath)
+	if err != nil {
+		return "", []string{"CA Offline Mode Failure"}, err
+	}
+	if offline && operation != "rekey" && operation != "unlock" {
+		return passphrase, []string{}, nil
+	}
+
+	refuse := func(status string, reason string) (string, []string, error) {
+		check(appendKeyCeremonyEvent(caPath, KeyCeremonyEvent{Time: time.Now().UTC(), Event: "refused", Operation: operation, Subject: subject, Reason: reason}))
+		return "", []string{reason}, Stoerr(status)
+	}
+
+	var shares [][]byte
+	var custodians []KeyCeremonyCustodian
+	for i, keyShare := range keyShares {
+		share, err := base64.StdEncoding.DecodeString(strings.TrimSpace(keyShare))
+		if err != nil {
+			return refuse("invalid-key-share", "Key share "+strconv.Itoa(i+1)+" is not base64 encoded")
+		}
+		fingerprint := keyShareFingerprint(share)
+		var shareCustodian *KeyCeremonyCustodian
+		for c := range ceremony.Custodians {
+			if ceremony.Custodians[c].ShareFingerprint == fingerprint {
+				shareCustodian = &ceremony.Custodians[c]
+			}
+		}
+		if shareCustodian == nil {
+			return refuse("invalid-key-share", "Key share "+strconv.Itoa(i+1)+" does not belong to a custodian of this CA")
+		}
+		for _, custodian := range custodians {
+			if custodian.Name == shareCustodian.Name {
+				return refuse("invalid-key-share", "The key share of custodian '"+custodian.Name+"' was submitted twice")
+			}
+		}
+		shares = append(shares, share)
+		custodians = append(custodians, *shareCustodian)
+	}
+	if len(shares) < ceremony.Threshold {
+		return refuse("key-shares-required", strconv.Itoa(ceremony.Threshold)+" of the "+strconv.Itoa(len(ceremony.Custodians))+" key ceremony custodians have to submit their key shares, got "+strconv.Itoa(len(shares)))
+	}
+
+	secret, err := combineShares(shares)
+	if err != nil {
+		return refuse("invalid-key-share", "Key shares could not be combined: "+err.Error())
+	}
+	check(appendKeyCeremonyEvent(caPath, KeyCeremonyEvent{Time: time.Now().UTC(), Event: "opened", Operation: operation, Subject: subject, Custodians: custodians}))
+	return hex.EncodeToString(secret), []string{}, nil
+}
@@ -0,0 +1,73 @@
+package locksmith
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestKeyCeremonyRecoversRootKey(t *testing.T) {
+	caPath := t.TempDir()
+
+	passphrase, ceremony, keyShares, err := holdKeyCeremony(&KeyCeremonyConfig{Threshold: 2, Custodians: []string{"alice", "bob", "carol"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKeyCeremony(caPath, ceremony, &x509.Certificate{Subject: pkix.Name{CommonName: "Test Root CA"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Root CA key is stored encrypted with the generated passphrase
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey, err := encryptPKCS8PrivateKey(privKey, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		shares    []string
+		wantError string
+	}{
+		{"first two custodians", []string{keyShares[0].Share, keyShares[1].Share}, ""},
+		{"last two custodians", []string{keyShares[2].Share, keyShares[1].Share}, ""},
+		{"all custodians", []string{keyShares[0].Share, keyShares[1].Share, keyShares[2].Share}, ""},
+		{"below threshold", []string{keyShares[0].Share}, "key-shares-required"},
+		{"same custodian twice", []string{keyShares[0].Share, keyShares[0].Share}, "invalid-key-share"},
+		{"foreign share", []string{keyShares[0].Share, "AAAA"}, "invalid-key-share"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recoveredPassphrase, _, err := keyPassphraseForCA(caPath, "", tt.shares, "certificate", "test")
+			if tt.wantError != "" {
+				if err == nil || err.Error() != tt.wantError {
+					t.Fatalf("got error %v, want %s", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recoveredPassphrase != passphrase {
+				t.Fatal("recovered passphrase does not match the generated one")
+			}
+			keyDer, err := decryptPKCS8PrivateKey(encryptedKey.Bytes, recoveredPassphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recoveredKey, err := x509.ParsePKCS8PrivateKey(keyDer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !privKey.Equal(recoveredKey) {
+				t.Fatal("recovered key does not match the Root CA key")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// publicKeyFingerprint is the hex SHA-256 of the PKIX DER of a public key, or empty if it can not be marshaled
+func publicKeyFingerprint(pubKey crypto.PublicKey) string {
+	pubKeyDER, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(pubKeyDER)
+	return hex.EncodeToString(sum[:])
+}
+
+// isSupportedKeyExportFormat checks a requested key export format against the supported ones
+func isSupportedKeyExportFormat(format string) bool {
+	for _, supportedFormat := range supportedKeyExportFormats {
+		if format == supportedFormat {
+			return true
+		}
+	}
+	return false
+}
+
+// exportKeyPair converts a PEM public key and an optional PEM private key into the requested export format
+// The returned key pair is base64 encoded like every other key pair the API returns, kid is set for JWK exports
+func exportKeyPair(pubKeyPEM []byte, privKeyPEM []byte, format string) (keyPair KeyPair, kid string, err error) {
+	if format == keyExportFormatPEM {
+		keyPair.PublicKey = B64EncodeBytesToStr(pubKeyPEM)
+		if len(privKeyPEM) > 0 {
+			keyPair.PrivateKey = B64EncodeBytesToStr(privKeyPEM)
+		}
+		return keyPair, "", nil
+	}
+
+	pubBlock, _ := pem.Decode(pubKeyPEM)
+	if pubBlock == nil {
+		return keyPair, "", Stoerr("unable to decode public key PEM")
+	}
+	pubKey, err := parseImportedPublicKey(pubBlock.Bytes)
+	if err != nil {
+		return keyPair, "", err
+	}
+
+	var privKey crypto.Signer
+	if len(privKeyPEM) > 0 {
+		privBlock, _ := pem.Decode(privKeyPEM)
+		if privBlock == nil {
+			return keyPair, "", Stoerr("unable to decode private key PEM")
+		}
+		privKey, err = parsePrivateKeyDER(privBlock.Bytes)
+		if err != nil {
+			return keyPair, "", err
+		}
+	}
+
+	switch format {
+	case keyExportFormatDER:
+		pubKeyDER, err := x509.MarshalPKIXPublicKey(pubKey)
+		if err != nil {
+			return keyPair, "", err
+		}
+		keyPair.PublicKey = B64EncodeBytesToStr(pubKeyDER)
+		if privKey != nil {
+			privKeyDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+			if err != nil {
+				return keyPair, "", err
+			}
+			keyPair.PrivateKey = B64EncodeBytesToStr(privKeyDER)
+		}
+	case keyExportFormatOpenSSH:
+		// OpenSSH reads PEM private keys, so only the public key changes format
+		sshPubKey, err := ssh.NewPublicKey(pubKey)
+		if err != nil {
+			return keyPair, "", err
+		}
+		keyPair.PublicKey = B64EncodeBytesToStr(ssh.MarshalAuthorizedKey(sshPubKey))
+		if privKey != nil {
+			keyPair.PrivateKey = B64EncodeBytesToStr(privKeyPEM)
+		}
+	case keyExportFormatJWK:
+		pubJWK, err := publicKeyToJWK(pubKey)
+		if err != nil {
+			return keyPair, "", err
+		}
+		pubJWKBytes, err := json.Marshal(pubJWK)
+		if err != nil {
+			return keyPair, "", err
+		}
+		keyPair.PublicKey = B64EncodeBytesToStr(pubJWKBytes)
+		if privKey != nil {
+			privJWK, err := privateKeyToJWK(privKey)
+			if err != nil {
+				return keyPair, "", err
+			}
+			privJWKBytes, err := json.Marshal(privJWK)
+			if err != nil {
+				return keyPair, "", err
+			}
+			keyPair.PrivateKey = B64EncodeBytesToStr(privJWKBytes)
+		}
+		kid = pubJWK.KeyID
+	default:
+		return keyPair, "", fmt.Errorf("unsupported key export format '%s'", format)
+	}
+	return keyPair, kid, nil
+}
+
+// jwkEncode base64url encodes JWK member values without padding as RFC 7518 requires
+func jwkEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwkEncodeFixed encodes a big integer left padded to a fixed number of bytes, used for EC coordinates and private keys
+func jwkEncodeFixed(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return jwkEncode(b)
+}
+
+// publicKeyToJWK builds the RFC 7517 JSON Web Key of a public key, with the RFC 7638 thumbprint as its kid
+func publicKeyToJWK(pubKey crypto.PublicKey) (*jsonWebKey, error) {
+	var jwk jsonWebKey
+	switch pubKey := pubKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = jwkEncode(pubKey.N.Bytes())
+		jwk.E = jwkEncode(big.NewInt(int64(pubKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pubKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pubKey.Curve.Params().Name
+		jwk.X = jwkEncodeFixed(pubKey.X, size)
+		jwk.Y = jwkEncodeFixed(pubKey.Y, size)
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = jwkEncode(pubKey)
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", pubKey)
+	}
+
+	kid, err := jwkThumbprint(&jwk)
+	if err != nil {
+		return nil, err
+	}
+	jwk.KeyID = kid
+	return &jwk, nil
+}
+
+// privateKeyToJWK builds the RFC 7517 JSON Web Key of a private key, it carries the same kid as its public key
+func privateKeyToJWK(privKey crypto.Signer) (*jsonWebKey, error) {
+	jwk, err := publicKeyToJWK(privKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	switch privKey := privKey.(type) {
+	case *rsa.PrivateKey:
+		if len(privKey.Primes) != 2 {
+			return nil, Stoerr("multi-prime rsa keys can not be exported as JWK")
+		}
+		privKey.Precompute()
+		jwk.D = jwkEncode(privKey.D.Bytes())
+		jwk.P = jwkEncode(privKey.Primes[0].Bytes())
+		jwk.Q = jwkEncode(privKey.Primes[1].Bytes())
+		jwk.DP = jwkEncode(privKey.Precomputed.Dp.Bytes())
+		jwk.DQ = jwkEncode(privKey.Precomputed.Dq.Bytes())
+		jwk.QI = jwkEncode(privKey.Precomputed.Qinv.Bytes())
+	case *ecdsa.PrivateKey:
+		jwk.D = jwkEncodeFixed(privKey.D, (privKey.Curve.Params().BitSize+7)/8)
+	case ed25519.PrivateKey:
+		jwk.D = jwkEncode(privKey.Seed())
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privKey)
+	}
+	return jwk, nil
+}
+
+// jwkThumbprint computes the RFC 7638 SHA-256 thumbprint of a JWK from its required members in lexicographic order
+func jwkThumbprint(jwk *jsonWebKey) (string, error) {
+	var members string
+	switch jwk.KeyType {
+	case "RSA":
+		members = `{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`
+	case "EC":
+		members = `{"crv":"` + jwk.Curve + `","kty":"EC","x":"` + jwk.X + `","y":"` + jwk.Y + `"}`
+	case "OKP":
+		members = `{"crv":"` + jwk.Curve + `","kty":"OKP","x":"` + jwk.X + `"}`
+	default:
+		return "", fmt.Errorf("unsupported JWK key type '%s'", jwk.KeyType)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return jwkEncode(sum[:]), nil
+}
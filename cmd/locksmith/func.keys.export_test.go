@@ -0,0 +1,118 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example key and thumbprint of RFC 7638 section 3.1
+	jwk := &jsonWebKey{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+	}
+	thumbprint, err := jwkThumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("got thumbprint %s", thumbprint)
+	}
+}
+
+func TestExportKeyPair(t *testing.T) {
+	for _, keyAlgorithm := range supportedKeyAlgorithms {
+		t.Run(keyAlgorithm, func(t *testing.T) {
+			keySize := 0
+			if keyAlgorithm == "rsa" {
+				keySize = 2048
+			}
+			privKey, pubKey, err := GenerateKeypair(keyAlgorithm, keySize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			privKeyPEM, _, err := pemEncodePrivateKey(privKey, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			pubKeyPEM := pemEncodePublicKey(pubKey).Bytes()
+
+			exportedKeyPair := func(format string) (KeyPair, string) {
+				t.Helper()
+				keyPair, kid, err := exportKeyPair(pubKeyPEM, privKeyPEM.Bytes(), format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return keyPair, kid
+			}
+			decoded := func(encoded string) []byte {
+				t.Helper()
+				decodedBytes, err := B64DecodeStrToBytes(encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return decodedBytes
+			}
+			samePublicKey := func(exportedKey crypto.PublicKey) {
+				t.Helper()
+				if !pubKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(exportedKey) {
+					t.Fatal("exported public key does not match")
+				}
+			}
+
+			// DER is PKIX for the public key and PKCS #8 for the private key
+			keyPair, _ := exportedKeyPair(keyExportFormatDER)
+			derPubKey, err := x509.ParsePKIXPublicKey(decoded(keyPair.PublicKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			samePublicKey(derPubKey)
+			derPrivKey, err := x509.ParsePKCS8PrivateKey(decoded(keyPair.PrivateKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			samePublicKey(derPrivKey.(crypto.Signer).Public())
+
+			// OpenSSH is an authorized_keys line
+			keyPair, _ = exportedKeyPair(keyExportFormatOpenSSH)
+			sshPubKey, err := parseImportedPublicKey(decoded(keyPair.PublicKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+			samePublicKey(sshPubKey)
+
+			// The kid of a JWK is its thumbprint, only the private JWK carries d
+			keyPair, kid := exportedKeyPair(keyExportFormatJWK)
+			var pubJWK, privJWK jsonWebKey
+			if err := json.Unmarshal(decoded(keyPair.PublicKey), &pubJWK); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(decoded(keyPair.PrivateKey), &privJWK); err != nil {
+				t.Fatal(err)
+			}
+			thumbprint, err := jwkThumbprint(&pubJWK)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid == "" || pubJWK.KeyID != kid || privJWK.KeyID != kid || thumbprint != kid {
+				t.Fatalf("got kid %s, public %s, private %s, thumbprint %s", kid, pubJWK.KeyID, privJWK.KeyID, thumbprint)
+			}
+			if pubJWK.D != "" || privJWK.D == "" {
+				t.Fatal("only the private JWK should carry the private key")
+			}
+
+			// Without a private key only the public key is exported
+			keyPair, _, err = exportKeyPair(pubKeyPEM, nil, keyExportFormatJWK)
+			if err != nil || keyPair.PrivateKey != "" {
+				t.Fatalf("got private key %q error %v for a public key export", keyPair.PrivateKey, err)
+			}
+		})
+	}
+
+	if _, _, err := exportKeyPair([]byte{}, nil, "pkcs12"); err == nil {
+		t.Fatal("expected an error for an unsupported export format")
+	}
+}
@@ -2,6 +2,7 @@ package locksmith
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -10,13 +11,75 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	b64 "encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"strings"
 )
 
+// normalizeKeyAlgorithm validates a requested key algorithm and size, filling in the defaults when they are not set
+// Supported algorithms are rsa (2048|3072|4096, default 4096), ecdsa (256|384|521, default 256) and ed25519 (fixed at 256)
+func normalizeKeyAlgorithm(keyAlgorithm string, keySize int) (string, int, error) {
+	keyAlgorithm = strings.ToLower(keyAlgorithm)
+
+	switch keyAlgorithm {
+	case "", "rsa":
+		if keySize == 0 {
+			keySize = 4096
+		}
+		if keySize != 2048 && keySize != 3072 && keySize != 4096 {
+			return "", 0, fmt.Errorf("Invalid RSA key size %d, must be one of 2048, 3072, or 4096", keySize)
+		}
+		return "rsa", keySize, nil
+	case "ec", "ecdsa":
+		if keySize == 0 {
+			keySize = 256
+		}
+		if _, err := ellipticCurveFromKeySize(keySize); err != nil {
+			return "", 0, err
+		}
+		return "ecdsa", keySize, nil
+	case "ed25519":
+		if keySize != 0 && keySize != 256 {
+			return "", 0, fmt.Errorf("Invalid Ed25519 key size %d, Ed25519 keys are always 256 bits", keySize)
+		}
+		return "ed25519", 256, nil
+	default:
+		return "", 0, fmt.Errorf("Invalid key algorithm '%s', must be one of %s", keyAlgorithm, strings.Join(supportedKeyAlgorithms, ", "))
+	}
+}
+
+// ellipticCurveFromKeySize maps an ECDSA key size to its NIST curve
+func ellipticCurveFromKeySize(keySize int) (elliptic.Curve, error) {
+	switch keySize {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("Invalid ECDSA key size %d, must be one of 256, 384, or 521", keySize)
+}
+
+// GenerateKeypair returns a private and public key of the requested algorithm and size
+func GenerateKeypair(keyAlgorithm string, keySize int) (crypto.Signer, crypto.PublicKey, error) {
+	keyAlgorithm, keySize, err := normalizeKeyAlgorithm(keyAlgorithm, keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch keyAlgorithm {
+	case "ecdsa":
+		return GenerateECDSAKeypair(keySize)
+	case "ed25519":
+		return GenerateEd25519Keypair()
+	default:
+		return GenerateRSAKeypair(keySize)
+	}
+}
+
 // GenerateRSAKeypair returns a private RSA key
 func GenerateRSAKeypair(keySize int) (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	if keySize == 0 {
@@ -30,9 +93,36 @@ func GenerateRSAKeypair(keySize int) (*rsa.PrivateKey, *rsa.PublicKey, error) {
 	return privKey, &privKey.PublicKey, nil
 }
 
-// writeRSAKeyPair creates key pairs
+// GenerateECDSAKeypair returns a private ECDSA key on the curve matching the key size
+func GenerateECDSAKeypair(keySize int) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	curve, err := ellipticCurveFromKeySize(keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKey, &privKey.PublicKey, nil
+}
+
+// GenerateEd25519Keypair returns a private Ed25519 key
+func GenerateEd25519Keypair() (ed25519.PrivateKey, ed25519.PublicKey, error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKey, pubKey, nil
+}
+
+// writeRSAKeyPair creates key pairs, the private key is wrapped with the master KEK when one is loaded
 func writeRSAKeyPair(privKey *bytes.Buffer, pubKey *bytes.Buffer, path string) (bool, bool, error) {
-	privKeyFile, err := writeKeyFile(privKey, path+".priv.pem", 0400)
+	privKeyBytes, err := wrapPrivateKeyBytes(privKey.Bytes())
+	if err != nil {
+		return false, false, err
+	}
+
+	privKeyFile, err := writeKeyFile(bytes.NewBuffer(privKeyBytes), path+".priv.pem", 0400)
 	if err != nil {
 		return false, false, err
 	}
@@ -51,6 +141,10 @@ func writeKeyFile(pem *bytes.Buffer, path string, permission int) (bool, error)
 	if err != nil {
 		return false, err
 	}
+	if !keyFile {
+		// Existing key files are never overwritten
+		return false, Stoerr("key file " + path + " already exists")
+	}
 	return keyFile, nil
 }
 
@@ -64,9 +158,12 @@ func writePublicKey(pem *bytes.Buffer, path string) (bool, error) {
 	return keyFile, nil
 }
 
-// writePrivateKey
+// writePrivateKey writes a private key file, wrapped with the master KEK when one is loaded
 func writePrivateKey(pem *bytes.Buffer, path string) (bool, error) {
-	pemByte, _ := ioutil.ReadAll(pem)
+	pemByte, err := wrapPrivateKeyBytes(pem.Bytes())
+	if err != nil {
+		return false, err
+	}
 	keyFile, err := WriteByteFile(path, pemByte, 0400, false)
 	if err != nil {
 		return false, err
@@ -74,14 +171,39 @@ func writePrivateKey(pem *bytes.Buffer, path string) (bool, error) {
 	return keyFile, nil
 }
 
-// pemEncodeRSAPrivateKey creates a PEM from an RSA Private key, and optionally returns an encrypted version
-func pemEncodeRSAPrivateKey(privKey *rsa.PrivateKey, rsaPrivateKeyPassword string) (privKeyPEM *bytes.Buffer, b *bytes.Buffer) {
+// pemEncodePrivateKey creates a PEM from an RSA, ECDSA, or Ed25519 Private key, and optionally returns an encrypted version as it is written to disk
+// Ed25519 keys have no legacy encoding and are always stored as PKCS #8, any other key type is refused
+func pemEncodePrivateKey(privKey crypto.Signer, rsaPrivateKeyPassword string) (privKeyPEM *bytes.Buffer, b *bytes.Buffer, err error) {
 	privKeyPEM = new(bytes.Buffer)
 	b = new(bytes.Buffer)
 
-	privateKeyBlock := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privKey),
+	var privateKeyBlock *pem.Block
+	switch privKey := privKey.(type) {
+	case *ecdsa.PrivateKey:
+		keyBytes, err := x509.MarshalECPrivateKey(privKey)
+		if err != nil {
+			return privKeyPEM, b, err
+		}
+		privateKeyBlock = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+	case *rsa.PrivateKey:
+		privateKeyBlock = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privKey),
+		}
+	case ed25519.PrivateKey:
+		keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+		if err != nil {
+			return privKeyPEM, b, err
+		}
+		privateKeyBlock = &pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+	default:
+		return privKeyPEM, b, fmt.Errorf("unsupported private key type %T", privKey)
 	}
 
 	/*
@@ -94,11 +216,14 @@ func pemEncodeRSAPrivateKey(privKey *rsa.PrivateKey, rsaPrivateKeyPassword strin
 	pem.Encode(privKeyPEM, privateKeyBlock)
 
 	if rsaPrivateKeyPassword != "" {
-		encBytes := encryptBytes(privKeyPEM.Bytes(), rsaPrivateKeyPassword)
+		encBytes, err := encryptPrivateKeyPEM(privKeyPEM.Bytes(), rsaPrivateKeyPassword)
+		if err != nil {
+			return privKeyPEM, b, err
+		}
 		b.Write(encBytes)
 	}
 
-	return privKeyPEM, b
+	return privKeyPEM, b, nil
 }
 
 // pemToEncryptedBytes takes a PEM byte buffer and encrypts it
@@ -111,14 +236,24 @@ func pemToEncryptedBytes(pem *bytes.Buffer, passphrase string) (b *bytes.Buffer)
 	return b
 }
 
-// pemEncodeRSAPublicKey
-func pemEncodeRSAPublicKey(caPubKey *rsa.PublicKey) *bytes.Buffer {
-	caPubKeyPEM := new(bytes.Buffer)
-	pem.Encode(caPubKeyPEM, &pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: x509.MarshalPKCS1PublicKey(caPubKey),
+// pemEncodePublicKey creates a PEM from a Public key - RSA keys stay in PKCS #1 form, others are PKIX encoded
+func pemEncodePublicKey(pubKey crypto.PublicKey) *bytes.Buffer {
+	pubKeyPEM := new(bytes.Buffer)
+	if rsaPubKey, ok := pubKey.(*rsa.PublicKey); ok {
+		pem.Encode(pubKeyPEM, &pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(rsaPubKey),
+		})
+		return pubKeyPEM
+	}
+
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(pubKey)
+	check(err)
+	pem.Encode(pubKeyPEM, &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubKeyBytes,
 	})
-	return caPubKeyPEM
+	return pubKeyPEM
 }
 
 // LoadKeyFile - loads a PEM key file
@@ -138,7 +273,7 @@ func DecodePublicKeyPem(inFile []byte) (*pem.Block, []byte) {
 // DecodePrivateKeyPem from file to pem struct
 func DecodePrivateKeyPem(inFile []byte) (*pem.Block, []byte) {
 	privPem, _ := pem.Decode(inFile)
-	if privPem.Type == "RSA PRIVATE KEY" {
+	if privPem != nil && (privPem.Type == "RSA PRIVATE KEY" || privPem.Type == "EC PRIVATE KEY" || privPem.Type == "PRIVATE KEY") {
 		privPemBytes := privPem.Bytes
 
 		return privPem, privPemBytes
@@ -146,49 +281,110 @@ func DecodePrivateKeyPem(inFile []byte) (*pem.Block, []byte) {
 	return nil, nil
 }
 
-// parsePrivateKey decodes a key from a pem
-func parsePrivateKey(pemBytes []byte) *rsa.PrivateKey {
-	parsedKey, err := x509.ParsePKCS1PrivateKey(pemBytes)
+// parsePrivateKey decodes a PKCS #1 RSA, SEC 1 EC, or PKCS #8 key from a pem
+func parsePrivateKey(pemBytes []byte) crypto.Signer {
+	parsedKey, err := parsePrivateKeyDER(pemBytes)
 	check(err)
 	return parsedKey
 }
 
-// parsePublicKey decodes a key from a pem
-func parsePublicKey(pemBytes []byte) *rsa.PublicKey {
-	parsedKey, err := x509.ParsePKCS1PublicKey(pemBytes)
+// parsePrivateKeyDER parses a PKCS #1, SEC 1 or PKCS #8 private key DER
+func parsePrivateKeyDER(keyDer []byte) (crypto.Signer, error) {
+	if parsedKey, err := x509.ParsePKCS1PrivateKey(keyDer); err == nil {
+		return parsedKey, nil
+	}
+	if parsedKey, err := x509.ParseECPrivateKey(keyDer); err == nil {
+		return parsedKey, nil
+	}
+	parsedKey, err := x509.ParsePKCS8PrivateKey(keyDer)
+	if err != nil {
+		return nil, errors.New("unable to parse private key as PKCS #1, SEC 1, or PKCS #8")
+	}
+	signer, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", parsedKey)
+	}
+	return signer, nil
+}
+
+// parsePublicKey decodes a PKCS #1 RSA or PKIX key from a pem
+func parsePublicKey(pemBytes []byte) crypto.PublicKey {
+	if parsedKey, err := x509.ParsePKCS1PublicKey(pemBytes); err == nil {
+		return parsedKey
+	}
+	parsedKey, err := x509.ParsePKIXPublicKey(pemBytes)
 	check(err)
 	return parsedKey
 }
 
+// signatureAlgorithmForKey picks the signature algorithm to use when signing with a private key, or with the private half of a public key
+func signatureAlgorithmForKey(key interface{}) x509.SignatureAlgorithm {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA512WithRSA
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512
+		}
+		return x509.ECDSAWithSHA256
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
 // GetPrivateKey gets a private key soup to nuts
-func GetPrivateKey(path string, rsaPrivateKeyPassword string) *rsa.PrivateKey {
-	fileCheck, err := FileExists(path)
+func GetPrivateKey(path string, rsaPrivateKeyPassword string) crypto.Signer {
+	privKey, err := ReadPrivateKey(path, rsaPrivateKeyPassword)
 	check(err)
-	if fileCheck {
-		keyBytes := LoadKeyFile(path)
-		if isPrivateKeyEncrypted(keyBytes) {
-			// File is base64 encoded and aes-cbc encrypted file
-			// Test decoding
-			decodedPrivKey, err := b64.StdEncoding.DecodeString(string(keyBytes))
-			check(err)
+	return privKey
+}
 
-			bit, byted, err := decryptBytes(decodedPrivKey, rsaPrivateKeyPassword)
-			check(err)
+// ReadPrivateKey reads a plain text or encrypted private key file, returning why it could not be loaded
+func ReadPrivateKey(path string, rsaPrivateKeyPassword string) (crypto.Signer, error) {
+	fileCheck, err := FileExists(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fileCheck {
+		return nil, nil
+	}
 
-			if bit {
-				_, keyPem := DecodePrivateKeyPem(byted)
-				return parsePrivateKey(keyPem)
-			}
-			return nil
+	keyBytes, err := readPrivateKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isPrivateKeyEncrypted(keyBytes) {
+		// File is either an encrypted PKCS #8 PEM or a base64 encoded key envelope
+		bit, byted, err := decryptPrivateKeyBytes(keyBytes, rsaPrivateKeyPassword)
+		if err != nil {
+			return nil, err
 		}
-		_, keyPem := DecodePrivateKeyPem(keyBytes)
-		return parsePrivateKey(keyPem)
+		if !bit {
+			return nil, Stoerr("unable to decrypt private key " + path)
+		}
+		keyBytes = byted
 	}
-	return nil
+
+	_, keyPem := DecodePrivateKeyPem(keyBytes)
+	if keyPem == nil {
+		return nil, Stoerr("unable to decode private key PEM " + path)
+	}
+	privKey := parsePrivateKey(keyPem)
+	if privKey == nil {
+		return nil, Stoerr("unable to parse private key " + path)
+	}
+	return privKey, nil
 }
 
 // GetPublicKey gets a public key soup to nuts
-func GetPublicKey(path string) *rsa.PublicKey {
+func GetPublicKey(path string) crypto.PublicKey {
 	fileCheck, err := FileExists(path)
 	check(err)
 	if fileCheck {
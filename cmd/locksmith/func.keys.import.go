@@ -0,0 +1,182 @@
+package locksmith
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// parseImportedPrivateKey reads a private key supplied for import in any of the formats people keep keys in:
+// PEM (PKCS #1, SEC 1, PKCS #8, encrypted PKCS #8 and legacy encrypted PEM), raw DER (plain or encrypted PKCS #8) or an OpenSSH private key
+// The passphrase is only used for encrypted inputs
+func parseImportedPrivateKey(keyBytes []byte, passphrase string) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		// Not PEM, try raw DER
+		if privKey, err := parsePrivateKeyDER(keyBytes); err == nil {
+			return privKey, nil
+		}
+		if passphrase == "" {
+			return nil, Stoerr("unable to parse private key as PEM, DER or OpenSSH, if it is encrypted a passphrase is needed")
+		}
+		keyDer, err := decryptPKCS8PrivateKey(keyBytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return parsePrivateKeyDER(keyDer)
+	}
+
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(keyBytes, passphrase)
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, Stoerr("private key is encrypted, a passphrase is needed")
+		}
+		keyDer, err := decryptPKCS8PrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return parsePrivateKeyDER(keyDer)
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+		keyDer := block.Bytes
+		if x509.IsEncryptedPEMBlock(block) {
+			if passphrase == "" {
+				return nil, Stoerr("private key is encrypted, a passphrase is needed")
+			}
+			decryptedDer, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+			if err != nil {
+				return nil, err
+			}
+			keyDer = decryptedDer
+		}
+		return parsePrivateKeyDER(keyDer)
+	}
+	return nil, fmt.Errorf("unsupported private key PEM type '%s'", block.Type)
+}
+
+// parseOpenSSHPrivateKey reads a private key in the OpenSSH format
+func parseOpenSSHPrivateKey(keyBytes []byte, passphrase string) (crypto.Signer, error) {
+	var parsedKey interface{}
+	var err error
+	if passphrase != "" {
+		parsedKey, err = ssh.ParseRawPrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+	} else {
+		parsedKey, err = ssh.ParseRawPrivateKey(keyBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch privKey := parsedKey.(type) {
+	case *rsa.PrivateKey:
+		return privKey, nil
+	case *ecdsa.PrivateKey:
+		return privKey, nil
+	case *ed25519.PrivateKey:
+		return *privKey, nil
+	case ed25519.PrivateKey:
+		return privKey, nil
+	}
+	return nil, fmt.Errorf("unsupported OpenSSH private key type: %T", parsedKey)
+}
+
+// parseImportedPublicKey reads a public key supplied for import as PEM (PKIX or PKCS #1), raw DER or an OpenSSH authorized_keys line
+func parseImportedPublicKey(keyBytes []byte) (crypto.PublicKey, error) {
+	keyDer := keyBytes
+	if block, _ := pem.Decode(keyBytes); block != nil {
+		keyDer = block.Bytes
+	} else if sshPubKey, _, _, _, err := ssh.ParseAuthorizedKey(keyBytes); err == nil {
+		cryptoPubKey, ok := sshPubKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported OpenSSH public key type '%s'", sshPubKey.Type())
+		}
+		return cryptoPubKey.CryptoPublicKey(), nil
+	}
+
+	if pubKey, err := x509.ParsePKCS1PublicKey(keyDer); err == nil {
+		return pubKey, nil
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(keyDer)
+	if err != nil {
+		return nil, Stoerr("unable to parse public key as PEM, DER or OpenSSH")
+	}
+	return pubKey, nil
+}
+
+// validateImportedKeyPair makes sure an imported private key is one locksmith can use and that its public half matches it
+// When a public key is supplied with the import it has to match the private key too
+func validateImportedKeyPair(privKey crypto.Signer, pubKey crypto.PublicKey) error {
+	switch privKey := privKey.(type) {
+	case *rsa.PrivateKey:
+		if err := privKey.Validate(); err != nil {
+			return err
+		}
+		if privKey.N.BitLen() < 2048 {
+			return fmt.Errorf("rsa keys must be at least 2048 bits, got %d", privKey.N.BitLen())
+		}
+	case *ecdsa.PrivateKey:
+		if _, err := ellipticCurveFromKeySize(privKey.Curve.Params().BitSize); err != nil {
+			return err
+		}
+		x, y := privKey.Curve.ScalarBaseMult(privKey.D.Bytes())
+		if x.Cmp(privKey.X) != 0 || y.Cmp(privKey.Y) != 0 {
+			return Stoerr("ecdsa public key does not match the private key")
+		}
+	case ed25519.PrivateKey:
+		if len(privKey) != ed25519.PrivateKeySize {
+			return Stoerr("invalid ed25519 private key length")
+		}
+		derivedKey := ed25519.NewKeyFromSeed(privKey.Seed())
+		if !bytes.Equal(derivedKey, privKey) {
+			return Stoerr("ed25519 public key does not match the private key")
+		}
+	default:
+		return fmt.Errorf("unsupported private key type: %T", privKey)
+	}
+
+	if pubKey != nil {
+		privPubKey, ok := privKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !privPubKey.Equal(pubKey) {
+			return Stoerr("public key does not match the private key")
+		}
+	}
+	return nil
+}
+
+// keyAlgorithmForKey returns the locksmith key algorithm name of a key, used as the file prefix in key stores
+func keyAlgorithmForKey(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "rsa"
+	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+		return "ecdsa"
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "ed25519"
+	}
+	return ""
+}
+
+// keySizeForKey returns the size in bits of a key, following the key_size conventions of normalizeKeyAlgorithm
+func keySizeForKey(key interface{}) int {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key.N.BitLen()
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PrivateKey:
+		return key.Curve.Params().BitSize
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
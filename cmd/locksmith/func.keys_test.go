@@ -0,0 +1,165 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"testing"
+)
+
+func TestNormalizeKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		keyAlgorithm  string
+		keySize       int
+		wantAlgorithm string
+		wantSize      int
+		wantError     bool
+	}{
+		{"", 0, "rsa", 4096, false},
+		{"RSA", 2048, "rsa", 2048, false},
+		{"rsa", 1024, "", 0, true},
+		{"ecdsa", 0, "ecdsa", 256, false},
+		{"EC", 384, "ecdsa", 384, false},
+		{"ecdsa", 521, "ecdsa", 521, false},
+		{"ecdsa", 224, "", 0, true},
+		{"ed25519", 0, "ed25519", 256, false},
+		{"ed25519", 512, "", 0, true},
+		{"dsa", 2048, "", 0, true},
+	}
+	for _, tt := range tests {
+		keyAlgorithm, keySize, err := normalizeKeyAlgorithm(tt.keyAlgorithm, tt.keySize)
+		if (err != nil) != tt.wantError {
+			t.Errorf("normalizeKeyAlgorithm(%q, %d) error = %v, want error %v", tt.keyAlgorithm, tt.keySize, err, tt.wantError)
+			continue
+		}
+		if keyAlgorithm != tt.wantAlgorithm || keySize != tt.wantSize {
+			t.Errorf("normalizeKeyAlgorithm(%q, %d) = %s %d, want %s %d", tt.keyAlgorithm, tt.keySize, keyAlgorithm, keySize, tt.wantAlgorithm, tt.wantSize)
+		}
+	}
+}
+
+func TestECDSAKeyPairRoundTrip(t *testing.T) {
+	tests := []struct {
+		keySize                int
+		curve                  elliptic.Curve
+		wantSignatureAlgorithm x509.SignatureAlgorithm
+	}{
+		{256, elliptic.P256(), x509.ECDSAWithSHA256},
+		{384, elliptic.P384(), x509.ECDSAWithSHA384},
+		{521, elliptic.P521(), x509.ECDSAWithSHA512},
+	}
+	for _, tt := range tests {
+		t.Run(tt.curve.Params().Name, func(t *testing.T) {
+			privKey, pubKey, err := GenerateKeypair("ecdsa", tt.keySize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if curve := pubKey.(*ecdsa.PublicKey).Curve; curve != tt.curve {
+				t.Fatalf("got curve %s, want %s", curve.Params().Name, tt.curve.Params().Name)
+			}
+			if signatureAlgorithm := signatureAlgorithmForKey(privKey); signatureAlgorithm != tt.wantSignatureAlgorithm {
+				t.Fatalf("got signature algorithm %s, want %s", signatureAlgorithm, tt.wantSignatureAlgorithm)
+			}
+
+			// The key is written encrypted and opens again with its passphrase
+			_, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(privKey, "s3cr3t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyPath := t.TempDir() + "/ecdsa"
+			if _, _, err := writeRSAKeyPair(encryptedPrivateKeyBytes, pemEncodePublicKey(pubKey), keyPath); err != nil {
+				t.Fatal(err)
+			}
+			readKey, err := ReadPrivateKey(keyPath+".priv.pem", "s3cr3t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !privKey.(*ecdsa.PrivateKey).Equal(readKey) {
+				t.Fatal("read private key does not match the generated one")
+			}
+			if !pubKey.(*ecdsa.PublicKey).Equal(GetPublicKey(keyPath + ".pub.pem")) {
+				t.Fatal("read public key does not match the generated one")
+			}
+		})
+	}
+}
+
+// unsupportedSigner is a crypto.Signer of a key type Locksmith can not store
+type unsupportedSigner struct{}
+
+func (unsupportedSigner) Public() crypto.PublicKey { return nil }
+
+func (unsupportedSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return nil, nil
+}
+
+func TestPemEncodePrivateKeyUnsupportedKey(t *testing.T) {
+	if _, _, err := pemEncodePrivateKey(unsupportedSigner{}, ""); err == nil {
+		t.Fatal("expected an error for an unsupported private key type")
+	}
+}
+
+func TestWriteKeyFileRefusesExistingFile(t *testing.T) {
+	_, pubKey, err := GenerateKeypair("ecdsa", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := t.TempDir() + "/ecdsa.pub.pem"
+	if _, err := writeKeyFile(pemEncodePublicKey(pubKey), keyPath, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := writeKeyFile(pemEncodePublicKey(pubKey), keyPath, 0644); written || err == nil {
+		t.Fatalf("got written %v error %v, want an error for the existing key file", written, err)
+	}
+}
+
+func TestEd25519KeyPairRoundTrip(t *testing.T) {
+	privKey, pubKey, err := GenerateKeypair("ed25519", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signatureAlgorithm := signatureAlgorithmForKey(pubKey); signatureAlgorithm != x509.PureEd25519 {
+		t.Fatalf("got signature algorithm %s, want Ed25519", signatureAlgorithm)
+	}
+
+	// Ed25519 keys are stored as PKCS #8 whether encrypted or not
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{"plain text", ""},
+		{"encrypted", "s3cr3t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pemEncodedPrivateKey, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(privKey, tt.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(pemEncodedPrivateKey.Bytes()); block == nil || block.Type != "PRIVATE KEY" {
+				t.Fatalf("got PEM block %v, want PRIVATE KEY", block)
+			}
+			if tt.passphrase != "" {
+				pemEncodedPrivateKey = encryptedPrivateKeyBytes
+			}
+			keyPath := t.TempDir() + "/ed25519"
+			if _, _, err := writeRSAKeyPair(pemEncodedPrivateKey, pemEncodePublicKey(pubKey), keyPath); err != nil {
+				t.Fatal(err)
+			}
+			readKey, err := ReadPrivateKey(keyPath+".priv.pem", tt.passphrase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !privKey.(ed25519.PrivateKey).Equal(readKey) {
+				t.Fatal("read private key does not match the generated one")
+			}
+			if !pubKey.(ed25519.PublicKey).Equal(GetPublicKey(keyPath + ".pub.pem")) {
+				t.Fatal("read public key does not match the generated one")
+			}
+		})
+	}
+}
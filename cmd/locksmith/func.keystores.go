@@ -1,7 +1,19 @@
 package locksmith
 
-// createKeyStore creates a new key store
-func createKeyStore(keyStoreName string) (bool, string, error) {
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// createKeyStore creates a new key store with its metadata
+func createKeyStore(keyStoreName string, metadata KeyStoreMetadata) (bool, string, error) {
 	sluggedKeyStoreName := slugger(keyStoreName)
 	basePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreName
 
@@ -12,12 +24,505 @@ func createKeyStore(keyStoreName string) (bool, string, error) {
 	if !keyStoreCheck {
 		// if there is no key store, create one
 		CreateDirectory(basePath)
-		return true, sluggedKeyStoreName, nil
+		metadata.CreatedAt = time.Now().UTC()
+		return true, sluggedKeyStoreName, writeKeyStoreMetadata(basePath, metadata)
 	}
 	return false, sluggedKeyStoreName, Stoerr("Key store exists!")
 }
 
 // listKeyStores returns a list of key stores
 func listKeyStores() []string {
-	return DirectoryListingNames(readConfig.Locksmith.PKIRoot + "/keystores/")
+	return listSubdirectories(readConfig.Locksmith.PKIRoot + "/keystores/")
+}
+
+// listKeyPairs returns the key pair IDs in a key store
+func listKeyPairs(keyStorePath string) []string {
+	return listSubdirectories(keyStorePath)
+}
+
+// listSubdirectories lists the directory names in a path, skipping files such as metadata.json
+func listSubdirectories(path string) []string {
+	var names []string
+	for _, name := range DirectoryListingNames(path) {
+		info, err := os.Stat(path + "/" + name)
+		check(err)
+		if err == nil && info.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// readKeyStoreMetadata loads the metadata.json of a key store
+// Key stores made before metadata was kept are given their directory modification time as the creation time
+func readKeyStoreMetadata(keyStorePath string) (KeyStoreMetadata, error) {
+	var metadata KeyStoreMetadata
+
+	metadataCheck, err := FileExists(keyStorePath + "/metadata.json")
+	if err != nil {
+		return metadata, err
+	}
+	if metadataCheck {
+		metadataBytes, err := ReadFileToBytes(keyStorePath + "/metadata.json")
+		if err != nil {
+			return metadata, err
+		}
+		if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+			return metadata, err
+		}
+	} else {
+		keyStoreInfo, err := os.Stat(keyStorePath)
+		if err != nil {
+			return metadata, err
+		}
+		metadata.CreatedAt = keyStoreInfo.ModTime().UTC()
+	}
+
+	metadata.ID = filepath.Base(keyStorePath)
+	metadata.KeyPairCount = len(listKeyPairs(keyStorePath))
+	return metadata, nil
+}
+
+// writeKeyStoreMetadata saves the metadata.json of a key store
+func writeKeyStoreMetadata(keyStorePath string, metadata KeyStoreMetadata) error {
+	metadata.ID = filepath.Base(keyStorePath)
+	metadataBytes, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyStorePath+"/metadata.json", metadataBytes, 0644)
+}
+
+// resolveKeyStoreID returns the current ID of a key store, following any renames of the ID
+func resolveKeyStoreID(sluggedKeyStoreID string) string {
+	keyStoresPath := readConfig.Locksmith.PKIRoot + "/keystores"
+	return resolveRenamedID(keyStoresPath, sluggedKeyStoreID, listKeyStores(), func(id string) []string {
+		metadata, err := readKeyStoreMetadata(keyStoresPath + "/" + id)
+		if err != nil {
+			return nil
+		}
+		return metadata.PreviousIDs
+	})
+}
+
+// resolveKeyPairID returns the current ID of a key pair in a key store, following any renames of the ID
+func resolveKeyPairID(keyStorePath string, sluggedKeyPairID string) string {
+	return resolveRenamedID(keyStorePath, sluggedKeyPairID, listKeyPairs(keyStorePath), func(id string) []string {
+		metadataCheck, err := FileExists(keyStorePath + "/" + id + "/metadata.json")
+		if err != nil || !metadataCheck {
+			return nil
+		}
+		metadata, err := readKeyPairMetadata(keyStorePath + "/" + id)
+		if err != nil {
+			return nil
+		}
+		return metadata.PreviousIDs
+	})
+}
+
+// resolveRenamedID finds the directory an ID now lives in, an existing directory always wins over a previous ID
+func resolveRenamedID(parentPath string, id string, candidates []string, previousIDs func(string) []string) string {
+	dirCheck, err := DirectoryExists(parentPath + "/" + id)
+	check(err)
+	if dirCheck || id == "" {
+		return id
+	}
+	for _, candidate := range candidates {
+		for _, previousID := range previousIDs(candidate) {
+			if previousID == id {
+				return candidate
+			}
+		}
+	}
+	return id
+}
+
+// renameKeyStore moves a key store to a new ID, the old ID is recorded so it keeps resolving
+func renameKeyStore(sluggedKeyStoreID string, newKeyStoreName string) (string, error) {
+	sluggedNewID := slugger(newKeyStoreName)
+	keyStoresPath := readConfig.Locksmith.PKIRoot + "/keystores"
+
+	if sluggedKeyStoreID == "default" {
+		return sluggedNewID, Stoerr("The default Key Store can not be renamed!")
+	}
+	metadata, err := readKeyStoreMetadata(keyStoresPath + "/" + sluggedKeyStoreID)
+	if err != nil {
+		return sluggedNewID, Stoerr("Key Store '" + sluggedKeyStoreID + "' does not exist!")
+	}
+	if err := renameMetadataDirectory(keyStoresPath, sluggedKeyStoreID, sluggedNewID); err != nil {
+		return sluggedNewID, err
+	}
+
+	metadata.PreviousIDs = appendPreviousID(metadata.PreviousIDs, sluggedKeyStoreID, sluggedNewID)
+	return sluggedNewID, writeKeyStoreMetadata(keyStoresPath+"/"+sluggedNewID, metadata)
+}
+
+// renameKeyPair moves a key pair to a new ID within its key store, the old ID is recorded so it keeps resolving
+func renameKeyPair(keyStorePath string, sluggedKeyPairID string, newKeyPairName string) (string, error) {
+	sluggedNewID := slugger(newKeyPairName)
+
+	metadata, err := readKeyPairMetadata(keyStorePath + "/" + sluggedKeyPairID)
+	if err != nil {
+		return sluggedNewID, Stoerr("Key Pair '" + sluggedKeyPairID + "' does not exist!")
+	}
+	if err := renameMetadataDirectory(keyStorePath, sluggedKeyPairID, sluggedNewID); err != nil {
+		return sluggedNewID, err
+	}
+
+	metadata.PreviousIDs = appendPreviousID(metadata.PreviousIDs, sluggedKeyPairID, sluggedNewID)
+	return sluggedNewID, writeKeyPairMetadata(keyStorePath+"/"+sluggedNewID, metadata)
+}
+
+// renameMetadataDirectory renames a key store or key pair directory, refusing to replace an existing one
+func renameMetadataDirectory(parentPath string, id string, newID string) error {
+	if newID == "" || newID == id {
+		return Stoerr("The new ID must be set and differ from the current ID!")
+	}
+	targetCheck, err := FileExists(parentPath + "/" + newID)
+	if err != nil {
+		return err
+	}
+	if targetCheck {
+		return Stoerr("'" + newID + "' already exists!")
+	}
+	return os.Rename(parentPath+"/"+id, parentPath+"/"+newID)
+}
+
+// appendPreviousID adds an old ID to the rename history, dropping the new ID if the item is renamed back to it
+func appendPreviousID(previousIDs []string, oldID string, newID string) []string {
+	history := []string{}
+	for _, previousID := range previousIDs {
+		if previousID != newID && previousID != oldID {
+			history = append(history, previousID)
+		}
+	}
+	return append(history, oldID)
+}
+
+// keyPairFilePrefix returns the algorithm named file prefix of the key pair stored at a path, eg "rsa" for rsa.pub.pem
+// Key pairs that do not exist yet default to "rsa"
+func keyPairFilePrefix(keyPairPath string) string {
+	for _, keyAlgorithm := range supportedKeyAlgorithms {
+		keyCheck, err := FileExists(keyPairPath + "/" + keyAlgorithm + ".pub.pem")
+		check(err)
+		if keyCheck {
+			return keyAlgorithm
+		}
+	}
+	return "rsa"
+}
+
+// deleteKeyStore removes an empty key store, the default key store is kept
+func deleteKeyStore(keyStoreName string) error {
+	sluggedKeyStoreName := slugger(keyStoreName)
+	basePath := readConfig.Locksmith.PKIRoot + "/keystores/" + sluggedKeyStoreName
+
+	if sluggedKeyStoreName == "default" {
+		return Stoerr("The default Key Store can not be deleted!")
+	}
+	keyStoreCheck, err := DirectoryExists(basePath)
+	if err != nil {
+		return err
+	}
+	if !keyStoreCheck {
+		return Stoerr("Key Store '" + sluggedKeyStoreName + "' does not exist!")
+	}
+	if len(listKeyPairs(basePath)) > 0 {
+		return Stoerr("Key Store '" + sluggedKeyStoreName + "' is not empty, delete its Key Pairs first!")
+	}
+
+	// The IDs of a deleted key store must not resolve to another key store that was once renamed from the same ID
+	aliases := []string{sluggedKeyStoreName}
+	if metadata, err := readKeyStoreMetadata(basePath); err == nil {
+		aliases = append(aliases, metadata.PreviousIDs...)
+	}
+	if err := os.RemoveAll(basePath); err != nil {
+		return err
+	}
+	for _, id := range listKeyStores() {
+		keyStorePath := readConfig.Locksmith.PKIRoot + "/keystores/" + id
+		metadata, err := readKeyStoreMetadata(keyStorePath)
+		if err != nil {
+			return err
+		}
+		if previousIDs, removed := removePreviousIDs(metadata.PreviousIDs, aliases); removed {
+			metadata.PreviousIDs = previousIDs
+			if err := writeKeyStoreMetadata(keyStorePath, metadata); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteKeyPair removes a key pair with all of its versions
+// The IDs of a deleted key pair must not resolve to another key pair that was once renamed from the same ID
+func deleteKeyPair(keyStorePath string, sluggedKeyPairID string) error {
+	aliases := []string{sluggedKeyPairID}
+	if metadata, err := readKeyPairMetadata(keyStorePath + "/" + sluggedKeyPairID); err == nil {
+		aliases = append(aliases, metadata.PreviousIDs...)
+	}
+	if err := os.RemoveAll(keyStorePath + "/" + sluggedKeyPairID); err != nil {
+		return err
+	}
+	for _, id := range listKeyPairs(keyStorePath) {
+		metadataCheck, err := FileExists(keyStorePath + "/" + id + "/metadata.json")
+		if err != nil {
+			return err
+		}
+		if !metadataCheck {
+			// Key pairs without metadata have never been renamed
+			continue
+		}
+		metadata, err := readKeyPairMetadata(keyStorePath + "/" + id)
+		if err != nil {
+			return err
+		}
+		if previousIDs, removed := removePreviousIDs(metadata.PreviousIDs, aliases); removed {
+			metadata.PreviousIDs = previousIDs
+			if err := writeKeyPairMetadata(keyStorePath+"/"+id, metadata); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removePreviousIDs drops the aliases from a rename history, reporting whether any were there
+func removePreviousIDs(previousIDs []string, aliases []string) ([]string, bool) {
+	history := []string{}
+	removed := false
+	for _, previousID := range previousIDs {
+		kept := true
+		for _, alias := range aliases {
+			if previousID == alias {
+				kept = false
+			}
+		}
+		if kept {
+			history = append(history, previousID)
+		} else {
+			removed = true
+		}
+	}
+	return history, removed
+}
+
+// newKeyPairMetadata starts the metadata of a freshly created key pair at version 1
+func newKeyPairMetadata(keyAlgorithm string, keySize int) KeyPairMetadata {
+	return KeyPairMetadata{
+		CreatedAt:      time.Now().UTC(),
+		KeyAlgorithm:   keyAlgorithm,
+		CurrentVersion: 1,
+		Versions: []KeyPairVersion{{
+			Version:      1,
+			KeyAlgorithm: keyAlgorithm,
+			KeySize:      keySize,
+			CreatedAt:    time.Now().UTC(),
+		}},
+	}
+}
+
+// readKeyPairMetadata loads the metadata.json of a key pair
+// Key pairs made before versioning have no metadata and are treated as version 1, created when their public key was written
+func readKeyPairMetadata(keyPairPath string) (KeyPairMetadata, error) {
+	var metadata KeyPairMetadata
+
+	keyAlgorithm := keyPairFilePrefix(keyPairPath)
+	pubKeyPath := keyPairPath + "/" + keyAlgorithm + ".pub.pem"
+	pubKeyInfo, err := os.Stat(pubKeyPath)
+	if err != nil {
+		return metadata, err
+	}
+	pubKey := GetPublicKey(pubKeyPath)
+
+	metadataCheck, err := FileExists(keyPairPath + "/metadata.json")
+	if err != nil {
+		return metadata, err
+	}
+	if metadataCheck {
+		metadataBytes, err := ReadFileToBytes(keyPairPath + "/metadata.json")
+		if err != nil {
+			return metadata, err
+		}
+		if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+			return metadata, err
+		}
+	} else {
+		metadata = newKeyPairMetadata(keyAlgorithm, keySizeForKey(pubKey))
+		metadata.CreatedAt = pubKeyInfo.ModTime().UTC()
+		metadata.Versions[0].CreatedAt = metadata.CreatedAt
+	}
+
+	if metadata.CreatedAt.IsZero() && len(metadata.Versions) > 0 {
+		metadata.CreatedAt = metadata.Versions[0].CreatedAt
+	}
+	metadata.ID = filepath.Base(keyPairPath)
+	metadata.KeyAlgorithm = keyAlgorithm
+	metadata.Fingerprint = publicKeyFingerprint(pubKey)
+	return metadata, nil
+}
+
+// writeKeyPairMetadata saves the metadata.json of a key pair
+func writeKeyPairMetadata(keyPairPath string, metadata KeyPairMetadata) error {
+	metadata.ID = filepath.Base(keyPairPath)
+	metadata.KeyAlgorithm = keyPairFilePrefix(keyPairPath)
+	pubKeyPath := keyPairPath + "/" + metadata.KeyAlgorithm + ".pub.pem"
+	if pubKeyCheck, _ := FileExists(pubKeyPath); pubKeyCheck {
+		metadata.Fingerprint = publicKeyFingerprint(GetPublicKey(pubKeyPath))
+	}
+	metadataBytes, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyPairPath+"/metadata.json", metadataBytes, 0644)
+}
+
+// keyPairVersionPath returns the directory holding a version of a key pair, the current version lives in the key pair directory itself
+func keyPairVersionPath(keyPairPath string, metadata KeyPairMetadata, version int) (string, error) {
+	if version == metadata.CurrentVersion {
+		return keyPairPath, nil
+	}
+	versionPath := keyPairPath + "/versions/" + strconv.Itoa(version)
+	versionCheck, err := DirectoryExists(versionPath)
+	if err != nil {
+		return "", err
+	}
+	if !versionCheck {
+		return "", fmt.Errorf("key pair version %d does not exist", version)
+	}
+	return versionPath, nil
+}
+
+// rotateKeyPair retires the current version of a key pair to versions/<n>/ and generates a new current version
+// The private key of the new version is only written when storePrivateKey is set, as with newly created key pairs
+func rotateKeyPair(keyPairPath string, keyAlgorithm string, keySize int, passphrase string, storePrivateKey bool) (KeyPairMetadata, crypto.Signer, error) {
+	metadata, err := readKeyPairMetadata(keyPairPath)
+	if err != nil {
+		return metadata, nil, err
+	}
+
+	// Generate the new key first so a failure leaves the current version in place
+	privKey, pubKey, err := GenerateKeypair(keyAlgorithm, keySize)
+	if err != nil {
+		return metadata, nil, err
+	}
+
+	// Write the new version next to the current one, it is only renamed into place once the current version is retired
+	stagingPath := keyPairPath + "/.rotating"
+	if err := os.RemoveAll(stagingPath); err != nil {
+		return metadata, nil, err
+	}
+	CreateDirectory(stagingPath)
+	defer os.RemoveAll(stagingPath)
+	if storePrivateKey {
+		var pemEncodedPrivateKey, encryptedPrivateKeyBytes *bytes.Buffer
+		pemEncodedPrivateKey, encryptedPrivateKeyBytes, err = pemEncodePrivateKey(privKey, passphrase)
+		if err != nil {
+			return metadata, nil, err
+		}
+		if passphrase != "" {
+			pemEncodedPrivateKey = encryptedPrivateKeyBytes
+		}
+		_, _, err = writeRSAKeyPair(pemEncodedPrivateKey, pemEncodePublicKey(pubKey), stagingPath+"/"+keyAlgorithm)
+	} else {
+		_, err = writeKeyFile(pemEncodePublicKey(pubKey), stagingPath+"/"+keyAlgorithm+".pub.pem", 0644)
+	}
+	if err != nil {
+		return metadata, nil, err
+	}
+
+	// Move the current key files into their version directory, moving them back when anything after this fails
+	retiredPath := keyPairPath + "/versions/" + strconv.Itoa(metadata.CurrentVersion)
+	CreateDirectory(retiredPath)
+	retiredFiles := []string{}
+	placedFiles := []string{}
+	restore := func() {
+		for _, fileName := range placedFiles {
+			os.Remove(keyPairPath + "/" + fileName)
+		}
+		for _, fileName := range retiredFiles {
+			os.Rename(retiredPath+"/"+fileName, keyPairPath+"/"+fileName)
+		}
+		os.Remove(retiredPath)
+	}
+	for _, prefix := range supportedKeyAlgorithms {
+		for _, suffix := range []string{".pub.pem", ".priv.pem"} {
+			fileCheck, err := FileExists(keyPairPath + "/" + prefix + suffix)
+			if err != nil {
+				restore()
+				return metadata, nil, err
+			}
+			if fileCheck {
+				if err := os.Rename(keyPairPath+"/"+prefix+suffix, retiredPath+"/"+prefix+suffix); err != nil {
+					restore()
+					return metadata, nil, err
+				}
+				retiredFiles = append(retiredFiles, prefix+suffix)
+			}
+		}
+	}
+
+	// Rename the new current version into place
+	for _, suffix := range []string{".pub.pem", ".priv.pem"} {
+		fileCheck, err := FileExists(stagingPath + "/" + keyAlgorithm + suffix)
+		if err != nil {
+			restore()
+			return metadata, nil, err
+		}
+		if fileCheck {
+			if err := os.Rename(stagingPath+"/"+keyAlgorithm+suffix, keyPairPath+"/"+keyAlgorithm+suffix); err != nil {
+				restore()
+				return metadata, nil, err
+			}
+			placedFiles = append(placedFiles, keyAlgorithm+suffix)
+		}
+	}
+
+	now := time.Now().UTC()
+	for i := range metadata.Versions {
+		if metadata.Versions[i].Version == metadata.CurrentVersion {
+			metadata.Versions[i].RetiredAt = &now
+		}
+	}
+	metadata.CurrentVersion++
+	metadata.Versions = append(metadata.Versions, KeyPairVersion{
+		Version:      metadata.CurrentVersion,
+		KeyAlgorithm: keyAlgorithm,
+		KeySize:      keySize,
+		CreatedAt:    now,
+	})
+
+	if err := writeKeyPairMetadata(keyPairPath, metadata); err != nil {
+		restore()
+		return metadata, nil, err
+	}
+	return metadata, privKey, nil
+}
+
+// deleteKeyPairVersion removes a retired version of a key pair, the current version can only be removed with the whole key pair
+func deleteKeyPairVersion(keyPairPath string, version int) error {
+	metadata, err := readKeyPairMetadata(keyPairPath)
+	if err != nil {
+		return err
+	}
+	if version == metadata.CurrentVersion {
+		return Stoerr("the current version of a key pair can not be deleted, rotate it first or delete the key pair")
+	}
+	versionPath, err := keyPairVersionPath(keyPairPath, metadata, version)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(versionPath); err != nil {
+		return err
+	}
+
+	versions := []KeyPairVersion{}
+	for _, keyPairVersion := range metadata.Versions {
+		if keyPairVersion.Version != version {
+			versions = append(versions, keyPairVersion)
+		}
+	}
+	metadata.Versions = versions
+	return writeKeyPairMetadata(keyPairPath, metadata)
 }
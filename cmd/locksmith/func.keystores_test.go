@@ -0,0 +1,222 @@
+package locksmith
+
+import (
+	"crypto"
+	"testing"
+)
+
+// useTestPKIRoot points the configuration at a temporary PKI root for the length of a test
+func useTestPKIRoot(t *testing.T) string {
+	t.Helper()
+	previousConfig := readConfig
+	pkiRoot := t.TempDir()
+	readConfig = &Config{Locksmith: ConfigYAML{PKIRoot: pkiRoot}}
+	t.Cleanup(func() { readConfig = previousConfig })
+	return pkiRoot
+}
+
+// writeTestKeyPair creates a key pair with only its public key stored, as the API does by default
+func writeTestKeyPair(t *testing.T, keyStorePath string, keyPairID string) {
+	t.Helper()
+	_, pubKey, err := GenerateKeypair("ecdsa", 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CreateDirectory(keyStorePath + "/" + keyPairID)
+	if _, err := writeKeyFile(pemEncodePublicKey(pubKey), keyStorePath+"/"+keyPairID+"/ecdsa.pub.pem", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeKeyPairMetadata(keyStorePath+"/"+keyPairID, newKeyPairMetadata("ecdsa", 256)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteKeyPairForgetsAliases(t *testing.T) {
+	keyStorePath := useTestPKIRoot(t) + "/keystores/default"
+	CreateDirectory(keyStorePath)
+
+	// Two key pairs were renamed away from the same ID
+	writeTestKeyPair(t, keyStorePath, "vpn")
+	if _, err := renameKeyPair(keyStorePath, "vpn", "openvpn"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestKeyPair(t, keyStorePath, "vpn")
+	if _, err := renameKeyPair(keyStorePath, "vpn", "wireguard"); err != nil {
+		t.Fatal(err)
+	}
+	if resolved := resolveKeyPairID(keyStorePath, "openvpn"); resolved != "openvpn" {
+		t.Fatalf("openvpn resolved to %q", resolved)
+	}
+
+	if err := deleteKeyPair(keyStorePath, "wireguard"); err != nil {
+		t.Fatal(err)
+	}
+	if resolved := resolveKeyPairID(keyStorePath, "vpn"); resolved != "vpn" {
+		t.Fatalf("the ID of a deleted key pair resolved to %q", resolved)
+	}
+	metadata, err := readKeyPairMetadata(keyStorePath + "/openvpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.PreviousIDs) != 0 {
+		t.Fatalf("got previous IDs %v, want none", metadata.PreviousIDs)
+	}
+}
+
+func TestDeleteKeyStoreForgetsAliases(t *testing.T) {
+	keyStoresPath := useTestPKIRoot(t) + "/keystores"
+	CreateDirectory(keyStoresPath + "/networking")
+	if _, err := renameKeyStore("networking", "network-team"); err != nil {
+		t.Fatal(err)
+	}
+	CreateDirectory(keyStoresPath + "/networking")
+	if _, err := renameKeyStore("networking", "netops"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteKeyStore("netops"); err != nil {
+		t.Fatal(err)
+	}
+	if resolved := resolveKeyStoreID("networking"); resolved != "networking" {
+		t.Fatalf("the ID of a deleted key store resolved to %q", resolved)
+	}
+	if resolved := resolveKeyStoreID("network-team"); resolved != "network-team" {
+		t.Fatalf("network-team resolved to %q", resolved)
+	}
+}
+
+func TestRotateKeyPair(t *testing.T) {
+	keyStorePath := useTestPKIRoot(t) + "/keystores/default"
+	keyPairPath := keyStorePath + "/vpn"
+	CreateDirectory(keyStorePath)
+	writeTestKeyPair(t, keyStorePath, "vpn")
+	firstPubKey := GetPublicKey(keyPairPath + "/ecdsa.pub.pem")
+
+	metadata, privKey, err := rotateKeyPair(keyPairPath, "ecdsa", 384, "s3cr3t", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.CurrentVersion != 2 || len(metadata.Versions) != 2 || metadata.Versions[0].RetiredAt == nil || metadata.Versions[1].RetiredAt != nil {
+		t.Fatalf("got metadata %+v after the first rotation", metadata)
+	}
+
+	// The new version is current and the first one is kept under versions/1
+	storedKey, err := ReadPrivateKey(keyPairPath+"/ecdsa.priv.pem", "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !privKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(storedKey) {
+		t.Fatal("the current private key is not the rotated one")
+	}
+	versionPath, err := keyPairVersionPath(keyPairPath, metadata, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !firstPubKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(GetPublicKey(versionPath + "/ecdsa.pub.pem")) {
+		t.Fatal("version 1 does not hold the original public key")
+	}
+	if exists, _ := DirectoryExists(keyPairPath + "/.rotating"); exists {
+		t.Fatal("the staging directory was left behind")
+	}
+
+	// Rotating to another algorithm retires every key file of the current version
+	metadata, _, err = rotateKeyPair(keyPairPath, "ed25519", 256, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.CurrentVersion != 3 || keyPairFilePrefix(keyPairPath) != "ed25519" {
+		t.Fatalf("got version %d with %s keys, want version 3 with ed25519 keys", metadata.CurrentVersion, keyPairFilePrefix(keyPairPath))
+	}
+	for _, keyFile := range []string{"/ecdsa.pub.pem", "/ecdsa.priv.pem", "/ed25519.priv.pem"} {
+		if exists, _ := FileExists(keyPairPath + keyFile); exists {
+			t.Fatalf("%s is still in the current version", keyFile)
+		}
+	}
+	if exists, _ := FileExists(keyPairPath + "/versions/2/ecdsa.priv.pem"); !exists {
+		t.Fatal("the private key of version 2 was not retired")
+	}
+}
+
+func TestDeleteKeyPairVersion(t *testing.T) {
+	keyStorePath := useTestPKIRoot(t) + "/keystores/default"
+	keyPairPath := keyStorePath + "/vpn"
+	CreateDirectory(keyStorePath)
+	writeTestKeyPair(t, keyStorePath, "vpn")
+	if _, _, err := rotateKeyPair(keyPairPath, "ecdsa", 256, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		version   int
+		wantError bool
+	}{
+		{"current version", 2, true},
+		{"retired version", 1, false},
+		{"deleted version", 1, true},
+		{"unknown version", 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := deleteKeyPairVersion(keyPairPath, tt.version); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+
+	metadata, err := readKeyPairMetadata(keyPairPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Versions) != 1 || metadata.Versions[0].Version != 2 {
+		t.Fatalf("got versions %+v, want only version 2", metadata.Versions)
+	}
+	if exists, _ := FileExists(keyPairPath + "/ecdsa.pub.pem"); !exists {
+		t.Fatal("the current version was removed")
+	}
+}
+
+func TestRenameKeyPair(t *testing.T) {
+	keyStorePath := useTestPKIRoot(t) + "/keystores/default"
+	CreateDirectory(keyStorePath)
+	writeTestKeyPair(t, keyStorePath, "vpn")
+	writeTestKeyPair(t, keyStorePath, "web")
+
+	if _, err := renameKeyPair(keyStorePath, "vpn", "web"); err == nil {
+		t.Fatal("a key pair was renamed over an existing one")
+	}
+	if _, err := renameKeyPair(keyStorePath, "missing", "other"); err == nil {
+		t.Fatal("a missing key pair was renamed")
+	}
+
+	newID, err := renameKeyPair(keyStorePath, "vpn", "OpenVPN Server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newID != "openvpn-server" {
+		t.Fatalf("got new ID %s, want openvpn-server", newID)
+	}
+	if resolved := resolveKeyPairID(keyStorePath, "vpn"); resolved != "openvpn-server" {
+		t.Fatalf("the previous ID resolved to %q", resolved)
+	}
+
+	// Renaming back drops the ID from the history
+	if _, err := renameKeyPair(keyStorePath, "openvpn-server", "vpn"); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := readKeyPairMetadata(keyStorePath + "/vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.PreviousIDs) != 1 || metadata.PreviousIDs[0] != "openvpn-server" {
+		t.Fatalf("got previous IDs %v, want [openvpn-server]", metadata.PreviousIDs)
+	}
+}
+
+func TestRenameDefaultKeyStore(t *testing.T) {
+	pkiRoot := useTestPKIRoot(t)
+	CreateDirectory(pkiRoot + "/keystores/default")
+	if _, err := renameKeyStore("default", "main"); err == nil {
+		t.Fatal("the default key store was renamed")
+	}
+}
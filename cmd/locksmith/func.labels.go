@@ -0,0 +1,93 @@
+package locksmith
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelRequirement is one comma separated term of a label selector
+type labelRequirement struct {
+	key      string
+	value    string
+	operator string
+}
+
+// validateLabels checks label keys and values can be used in label selectors
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if strings.TrimSpace(key) == "" {
+			return Stoerr("label keys can not be empty")
+		}
+		if strings.ContainsAny(key, ",=! ") {
+			return fmt.Errorf("label key '%s' can not contain ',', '=', '!' or spaces", key)
+		}
+		if strings.ContainsAny(value, ",=!") {
+			return fmt.Errorf("label value '%s' of '%s' can not contain ',', '=' or '!'", value, key)
+		}
+	}
+	return nil
+}
+
+// parseLabelSelector reads a label selector such as "env=prod,team!=web,owner,!retired"
+// Terms are key=value (or key==value), key!=value, key to require a label, and !key to require its absence
+func parseLabelSelector(selector string) ([]labelRequirement, error) {
+	var requirements []labelRequirement
+
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var requirement labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			requirement = labelRequirement{key: parts[0], value: parts[1], operator: "!="}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			requirement = labelRequirement{key: parts[0], value: parts[1], operator: "="}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			requirement = labelRequirement{key: parts[0], value: parts[1], operator: "="}
+		case strings.HasPrefix(term, "!"):
+			requirement = labelRequirement{key: strings.TrimPrefix(term, "!"), operator: "!"}
+		default:
+			requirement = labelRequirement{key: term, operator: "exists"}
+		}
+
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if requirement.key == "" || strings.ContainsAny(requirement.key, "=! ") || strings.ContainsAny(requirement.value, "=!") {
+			return nil, fmt.Errorf("invalid label selector term '%s'", term)
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+// matchesLabelSelector checks a set of labels against every requirement of a parsed label selector
+func matchesLabelSelector(labels map[string]string, requirements []labelRequirement) bool {
+	for _, requirement := range requirements {
+		value, present := labels[requirement.key]
+		switch requirement.operator {
+		case "=":
+			if !present || value != requirement.value {
+				return false
+			}
+		case "!=":
+			if present && value == requirement.value {
+				return false
+			}
+		case "exists":
+			if !present {
+				return false
+			}
+		case "!":
+			if present {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package locksmith
+
+import "testing"
+
+func TestValidateLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		wantError bool
+	}{
+		{"none", nil, false},
+		{"plain", map[string]string{"env": "prod", "team": "network ops"}, false},
+		{"empty key", map[string]string{" ": "prod"}, true},
+		{"key with space", map[string]string{"cost center": "42"}, true},
+		{"value with comma", map[string]string{"env": "prod,dev"}, true},
+		{"value with bang", map[string]string{"env": "!prod"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLabels(tt.labels); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "network", "owner": "alice"}
+
+	tests := []struct {
+		selector  string
+		wantMatch bool
+		wantError bool
+	}{
+		{"", true, false},
+		{"env=prod", true, false},
+		{"env==prod", true, false},
+		{"env=dev", false, false},
+		{"team!=web", true, false},
+		{"team!=network", false, false},
+		{"missing!=value", true, false},
+		{"owner", true, false},
+		{"retired", false, false},
+		{"!retired", true, false},
+		{"!owner", false, false},
+		{" env = prod , team != web , owner ", true, false},
+		{"env=prod,!owner", false, false},
+		{"=prod", false, true},
+		{"env=!prod", false, true},
+		{"!", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			requirements, err := parseLabelSelector(tt.selector)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if err != nil {
+				return
+			}
+			if match := matchesLabelSelector(labels, requirements); match != tt.wantMatch {
+				t.Fatalf("got match %v, want %v", match, tt.wantMatch)
+			}
+		})
+	}
+}
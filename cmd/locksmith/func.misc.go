@@ -184,6 +184,17 @@ func PreflightSetup() {
 	CreateDirectory(PKIKeysRootsPath)
 	CreateDirectory(PKIKeysRootsPath + "/default")
 
+	// Load the master key encryption key, if one is configured
+	checkAndFail(loadKEK())
+
+	// Make sure the configured distribution point URL templates are usable
+	for i := range readConfig.Locksmith.DistributionPoints {
+		checkAndFail(validateDistributionPoints(&readConfig.Locksmith.DistributionPoints[i]))
+	}
+
+	// Make sure the configured certificate profiles are usable
+	checkAndFail(validateCertificateProfiles(readConfig.Locksmith.CertificateProfiles))
+
 	logStdOut("Preflight complete!")
 }
 
@@ -0,0 +1,170 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parseIPRanges converts CIDR strings to IP networks
+func parseIPRanges(cidrs []string) ([]*net.IPNet, error) {
+	var ipRanges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range '%s', must be in CIDR notation", cidr)
+		}
+		ipRanges = append(ipRanges, ipRange)
+	}
+	return ipRanges, nil
+}
+
+// applyNameConstraints stamps the name constraints into a CA certificate template
+func applyNameConstraints(certificate *x509.Certificate, nameConstraints *NameConstraints) error {
+	if nameConstraints == nil {
+		return nil
+	}
+
+	permittedIPRanges, err := parseIPRanges(nameConstraints.PermittedIPRanges)
+	if err != nil {
+		return err
+	}
+	excludedIPRanges, err := parseIPRanges(nameConstraints.ExcludedIPRanges)
+	if err != nil {
+		return err
+	}
+
+	certificate.PermittedDNSDomainsCritical = nameConstraints.Critical
+	certificate.PermittedDNSDomains = nameConstraints.PermittedDNSDomains
+	certificate.ExcludedDNSDomains = nameConstraints.ExcludedDNSDomains
+	certificate.PermittedIPRanges = permittedIPRanges
+	certificate.ExcludedIPRanges = excludedIPRanges
+	certificate.PermittedEmailAddresses = nameConstraints.PermittedEmailAddresses
+	certificate.ExcludedEmailAddresses = nameConstraints.ExcludedEmailAddresses
+	certificate.PermittedURIDomains = nameConstraints.PermittedURIDomains
+	certificate.ExcludedURIDomains = nameConstraints.ExcludedURIDomains
+	return nil
+}
+
+// validateNameConstraints makes sure the name constraints can be stamped into a CA certificate
+func validateNameConstraints(nameConstraints *NameConstraints) error {
+	return applyNameConstraints(&x509.Certificate{}, nameConstraints)
+}
+
+// matchDNSConstraint reports whether a DNS name is within a DNS name constraint
+// A constraint with a leading period only matches subdomains, otherwise the domain itself matches as well
+func matchDNSConstraint(domain string, constraint string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+// matchHostConstraint reports whether the host of an email address or URI is within a constraint
+// A constraint with a leading period only matches subdomains, otherwise only the host itself matches
+func matchHostConstraint(host string, constraint string) bool {
+	host = strings.ToLower(host)
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+// matchEmailConstraint reports whether an email address is within an email constraint, either a full mailbox or a host
+func matchEmailConstraint(email string, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return matchHostConstraint(email[at+1:], constraint)
+}
+
+// matchIPConstraint reports whether an IP address is within an IP range of the same address family
+func matchIPConstraint(ip net.IP, constraint *net.IPNet) bool {
+	if ip4 := ip.To4(); ip4 != nil && len(constraint.IP) == net.IPv4len {
+		ip = ip4
+	}
+	if len(ip) != len(constraint.IP) {
+		return false
+	}
+	return constraint.Contains(ip)
+}
+
+// nameConstraintViolations lists the subject alternative names of a certificate that fall outside the name constraints of a CA certificate
+func nameConstraintViolations(caCert *x509.Certificate, certificate *x509.Certificate) []string {
+	var violations []string
+	caName := caCert.Subject.CommonName
+
+	checkName := func(kind string, name string, permitted int, matchPermitted func(int) bool, excluded int, matchExcluded func(int) bool) {
+		for i := 0; i < excluded; i++ {
+			if matchExcluded(i) {
+				violations = append(violations, fmt.Sprintf("%s '%s' is excluded by the name constraints of CA '%s'", kind, name, caName))
+				return
+			}
+		}
+		if permitted == 0 {
+			return
+		}
+		for i := 0; i < permitted; i++ {
+			if matchPermitted(i) {
+				return
+			}
+		}
+		violations = append(violations, fmt.Sprintf("%s '%s' is not permitted by the name constraints of CA '%s'", kind, name, caName))
+	}
+
+	for _, dnsName := range certificate.DNSNames {
+		checkName("DNS name", dnsName,
+			len(caCert.PermittedDNSDomains), func(i int) bool { return matchDNSConstraint(dnsName, caCert.PermittedDNSDomains[i]) },
+			len(caCert.ExcludedDNSDomains), func(i int) bool { return matchDNSConstraint(dnsName, caCert.ExcludedDNSDomains[i]) })
+	}
+	for _, ip := range certificate.IPAddresses {
+		checkName("IP address", ip.String(),
+			len(caCert.PermittedIPRanges), func(i int) bool { return matchIPConstraint(ip, caCert.PermittedIPRanges[i]) },
+			len(caCert.ExcludedIPRanges), func(i int) bool { return matchIPConstraint(ip, caCert.ExcludedIPRanges[i]) })
+	}
+	for _, email := range certificate.EmailAddresses {
+		checkName("Email address", email,
+			len(caCert.PermittedEmailAddresses), func(i int) bool { return matchEmailConstraint(email, caCert.PermittedEmailAddresses[i]) },
+			len(caCert.ExcludedEmailAddresses), func(i int) bool { return matchEmailConstraint(email, caCert.ExcludedEmailAddresses[i]) })
+	}
+	for _, uri := range certificate.URIs {
+		// URIs without a host, or with an IP address as the host, can not satisfy URI domain constraints
+		host := uri.Hostname()
+		hasHost := host != "" && net.ParseIP(host) == nil
+		checkName("URI", uri.String(),
+			len(caCert.PermittedURIDomains), func(i int) bool { return hasHost && matchHostConstraint(host, caCert.PermittedURIDomains[i]) },
+			len(caCert.ExcludedURIDomains), func(i int) bool { return !hasHost || matchHostConstraint(host, caCert.ExcludedURIDomains[i]) })
+	}
+
+	return violations
+}
+
+// checkNameConstraintsForCA checks a certificate against the name constraints of the CA at caPath and of every CA above it
+func checkNameConstraintsForCA(caPath string, certificate *x509.Certificate) ([]string, error) {
+	var violations []string
+	for ; caPath != ""; caPath = parentCAPath(caPath) {
+		caCert, err := ReadCACertificate(caPath)
+		if err != nil {
+			return []string{"CA Certificate could not be read"}, err
+		}
+		if caCert == nil {
+			return []string{"CA Certificate could not be read"}, Stoerr("no-ca-certificate")
+		}
+		violations = append(violations, nameConstraintViolations(caCert, certificate)...)
+	}
+	if len(violations) > 0 {
+		return violations, Stoerr("name-constraint-violation")
+	}
+	return nil, nil
+}
@@ -0,0 +1,136 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestMatchDNSConstraint(t *testing.T) {
+	tests := []struct {
+		domain     string
+		constraint string
+		want       bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"WWW.Example.com.", "example.com", true},
+		{"badexample.com", "example.com", false},
+		{"example.com", ".example.com", false},
+		{"www.example.com", ".example.com", true},
+		{"example.org", "example.com", false},
+		{"anything.test", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchDNSConstraint(tt.domain, tt.constraint); got != tt.want {
+			t.Errorf("matchDNSConstraint(%q, %q) = %v, want %v", tt.domain, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestMatchEmailConstraint(t *testing.T) {
+	tests := []struct {
+		email      string
+		constraint string
+		want       bool
+	}{
+		{"admin@example.com", "admin@example.com", true},
+		{"Admin@Example.com", "admin@example.com", true},
+		{"other@example.com", "admin@example.com", false},
+		{"admin@example.com", "example.com", true},
+		{"admin@mail.example.com", "example.com", false},
+		{"admin@mail.example.com", ".example.com", true},
+		{"not-an-email", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := matchEmailConstraint(tt.email, tt.constraint); got != tt.want {
+			t.Errorf("matchEmailConstraint(%q, %q) = %v, want %v", tt.email, tt.constraint, got, tt.want)
+		}
+	}
+}
+
+func TestNameConstraintViolations(t *testing.T) {
+	caCert := &x509.Certificate{}
+	err := applyNameConstraints(caCert, &NameConstraints{
+		Critical:                true,
+		PermittedDNSDomains:     []string{"example.com"},
+		ExcludedDNSDomains:      []string{"secret.example.com"},
+		PermittedIPRanges:       []string{"10.0.0.0/8"},
+		ExcludedIPRanges:        []string{"10.99.0.0/16"},
+		PermittedEmailAddresses: []string{"example.com"},
+		PermittedURIDomains:     []string{".example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustParseURL := func(rawURL string) *url.URL {
+		parsedURL, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsedURL
+	}
+
+	tests := []struct {
+		name        string
+		certificate *x509.Certificate
+		violations  int
+	}{
+		{"permitted DNS name", &x509.Certificate{DNSNames: []string{"www.example.com"}}, 0},
+		{"DNS name outside permitted", &x509.Certificate{DNSNames: []string{"www.example.org"}}, 1},
+		{"excluded DNS name", &x509.Certificate{DNSNames: []string{"db.secret.example.com"}}, 1},
+		{"permitted IP", &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, 0},
+		{"IP outside permitted", &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}}, 1},
+		{"excluded IP", &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.99.1.1")}}, 1},
+		{"IPv4 mapped IPv6 address", &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("::ffff:0a00:0001")}}, 0},
+		{"other IPv6", &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("2001:db8::1")}}, 1},
+		{"permitted email", &x509.Certificate{EmailAddresses: []string{"admin@example.com"}}, 0},
+		{"email outside permitted", &x509.Certificate{EmailAddresses: []string{"admin@example.org"}}, 1},
+		{"permitted URI", &x509.Certificate{URIs: []*url.URL{mustParseURL("https://api.example.com/v1")}}, 0},
+		{"URI outside permitted", &x509.Certificate{URIs: []*url.URL{mustParseURL("https://example.org")}}, 1},
+		{"URI with IP host", &x509.Certificate{URIs: []*url.URL{mustParseURL("https://10.0.0.1")}}, 1},
+		{"several violations", &x509.Certificate{DNSNames: []string{"www.example.com", "example.net"}, EmailAddresses: []string{"a@example.net"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := nameConstraintViolations(caCert, tt.certificate)
+			if len(violations) != tt.violations {
+				t.Fatalf("got %d violations %v, want %d", len(violations), violations, tt.violations)
+			}
+		})
+	}
+}
+
+func TestValidateNameConstraints(t *testing.T) {
+	tests := []struct {
+		name            string
+		nameConstraints *NameConstraints
+		wantError       bool
+	}{
+		{"none", nil, false},
+		{"valid ranges", &NameConstraints{PermittedIPRanges: []string{"10.0.0.0/8", "2001:db8::/32"}}, false},
+		{"bare IP", &NameConstraints{PermittedIPRanges: []string{"10.0.0.1"}}, true},
+		{"invalid excluded range", &NameConstraints{ExcludedIPRanges: []string{"10.0.0.0/33"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateNameConstraints(tt.nameConstraints); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestCheckNameConstraintsForCAWithoutCertificate(t *testing.T) {
+	caPath := t.TempDir()
+	if err := os.MkdirAll(caPath+"/certs", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := checkNameConstraintsForCA(caPath, &x509.Certificate{DNSNames: []string{"www.example.com"}}); err == nil {
+		t.Fatal("expected an error for the missing CA Certificate")
+	}
+}
@@ -0,0 +1,275 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// unlockSession holds the opened private key of an offline CA until the session is locked or expires
+type unlockSession struct {
+	caPath  string
+	signer  crypto.Signer
+	timer   *time.Timer
+	session UnlockSession
+}
+
+// errCAOffline is returned when signing with an offline CA that has no open unlock session
+// errCALocked is returned when locking an offline CA that has no open unlock session
+// Their messages double as the status returned by the API
+var (
+	errCAOffline = errors.New("ca-offline")
+	errCALocked  = errors.New("ca-locked")
+)
+
+// unlockSessions are the open unlock sessions, keyed by the absolute CA path
+var (
+	unlockSessions      = map[string]*unlockSession{}
+	unlockSessionsMutex sync.Mutex
+)
+
+// offlineSignerBackend refuses to sign for an offline CA unless an unlock session is open for it
+type offlineSignerBackend struct {
+	caPath  string
+	backend SignerBackend
+}
+
+// unlockSessionSigner signs with the key held by an unlock session and records every signature in the session
+type unlockSessionSigner struct {
+	caPath    string
+	sessionID string
+	publicKey crypto.PublicKey
+}
+
+// isCAOffline checks if the CA at caPath is marked offline
+func isCAOffline(caPath string) (bool, error) {
+	return FileExists(caPath + "/" + offlineCAFileName)
+}
+
+// setCAOffline marks the CA at caPath offline, or back online which also ends its unlock session
+func setCAOffline(caPath string, offline bool) error {
+	if offline {
+		_, err := WriteByteFile(caPath+"/"+offlineCAFileName, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0600, true)
+		return err
+	}
+	if _, err := endUnlockSession(caPath, "locked"); err != nil && !errors.Is(err, errCALocked) {
+		return err
+	}
+	if err := os.Remove(caPath + "/" + offlineCAFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// unlockSessionKey returns the key an unlock session of the CA at caPath is kept under
+func unlockSessionKey(caPath string) string {
+	absCAPath, err := filepath.Abs(caPath)
+	if err != nil {
+		return caPath
+	}
+	return absCAPath
+}
+
+// appendUnlockSessionEvent adds an event to the unlock session log of the CA at caPath
+func appendUnlockSessionEvent(caPath string, event UnlockSessionEvent) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(caPath+"/"+unlockSessionLogFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	_, err = logFile.Write(append(eventBytes, '\n'))
+	return err
+}
+
+// unlockCA opens the private key of an offline CA and keeps it in memory for the duration of an unlock session
+func unlockCA(caPath string, passphrase string, duration time.Duration) (UnlockSession, []string, error) {
+	offline, err := isCAOffline(caPath)
+	if err != nil {
+		return UnlockSession{}, []string{"CA could not be read"}, err
+	}
+	if !offline {
+		return UnlockSession{}, []string{"CA is not offline and signs without an unlock session"}, Stoerr("ca-not-offline")
+	}
+	if duration <= 0 || duration > maxUnlockSessionDuration {
+		return UnlockSession{}, []string{"Unlock sessions last between 1s and " + maxUnlockSessionDuration.String()}, Stoerr("invalid-duration")
+	}
+
+	unlockSessionsMutex.Lock()
+	defer unlockSessionsMutex.Unlock()
+
+	sessionKey := unlockSessionKey(caPath)
+	if openSession, ok := unlockSessions[sessionKey]; ok {
+		return openSession.session, []string{"CA is already unlocked until " + openSession.session.ExpiresAt.Format(time.RFC3339)}, Stoerr("ca-unlocked")
+	}
+
+	signerBackend, err := configuredSignerBackendForCA(caPath)
+	if err != nil {
+		return UnlockSession{}, []string{"CA Signer Backend is misconfigured!"}, err
+	}
+	signer, err := signerBackend.Signer(passphrase)
+	if err != nil {
+		return UnlockSession{}, []string{"CA Private Key could not be opened: " + err.Error()}, Stoerr("invalid-ca-key")
+	}
+
+	sessionIDBytes := make([]byte, 16)
+	if _, err := rand.Read(sessionIDBytes); err != nil {
+		return UnlockSession{}, []string{"Unlock Session ID could not be generated"}, err
+	}
+	openedAt := time.Now().UTC()
+	session := &unlockSession{
+		caPath: caPath,
+		signer: signer,
+		session: UnlockSession{
+			ID:        hex.EncodeToString(sessionIDBytes),
+			OpenedAt:  openedAt,
+			ExpiresAt: openedAt.Add(duration),
+			Signings:  []UnlockSessionEvent{},
+		},
+	}
+	if err := appendUnlockSessionEvent(caPath, UnlockSessionEvent{Time: openedAt, SessionID: session.session.ID, Event: "opened", ExpiresAt: &session.session.ExpiresAt}); err != nil {
+		return UnlockSession{}, []string{"Unlock Session Log could not be written"}, err
+	}
+
+	// The session locks itself once it expires
+	sessionID := session.session.ID
+	session.timer = time.AfterFunc(duration, func() {
+		unlockSessionsMutex.Lock()
+		defer unlockSessionsMutex.Unlock()
+		if openSession, ok := unlockSessions[sessionKey]; ok && openSession.session.ID == sessionID {
+			closeUnlockSession(sessionKey, "expired")
+			logStdOut("Unlock session " + sessionID + " of CA " + caPath + " expired")
+		}
+	})
+	unlockSessions[sessionKey] = session
+
+	return session.session, []string{"CA unlocked until " + session.session.ExpiresAt.Format(time.RFC3339)}, nil
+}
+
+// closeUnlockSession forgets the opened private key of a session and logs why it ended, the caller holds unlockSessionsMutex
+func closeUnlockSession(sessionKey string, reason string) UnlockSession {
+	session := unlockSessions[sessionKey]
+	delete(unlockSessions, sessionKey)
+	session.timer.Stop()
+	session.signer = nil
+
+	closedAt := time.Now().UTC()
+	session.session.ClosedAt = &closedAt
+	err := appendUnlockSessionEvent(session.caPath, UnlockSessionEvent{Time: closedAt, SessionID: session.session.ID, Event: reason})
+	check(err)
+	return session.session
+}
+
+// endUnlockSession locks an offline CA again before its unlock session expires
+func endUnlockSession(caPath string, reason string) (UnlockSession, error) {
+	unlockSessionsMutex.Lock()
+	defer unlockSessionsMutex.Unlock()
+
+	sessionKey := unlockSessionKey(caPath)
+	if _, ok := unlockSessions[sessionKey]; !ok {
+		return UnlockSession{}, errCALocked
+	}
+	return closeUnlockSession(sessionKey, reason), nil
+}
+
+// unlockSessionForCA returns the open unlock session of the CA at caPath, if any
+func unlockSessionForCA(caPath string) (UnlockSession, bool) {
+	unlockSessionsMutex.Lock()
+	defer unlockSessionsMutex.Unlock()
+
+	session, ok := unlockSessions[unlockSessionKey(caPath)]
+	if !ok {
+		return UnlockSession{}, false
+	}
+	return session.session, true
+}
+
+// checkCAUnlocked refuses offline CAs without an open unlock session before anything is written for a signing
+func checkCAUnlocked(caPath string) ([]string, error) {
+	offline, err := isCAOffline(caPath)
+	if err != nil {
+		return []string{"CA Offline Mode Failure"}, err
+	}
+	if !offline {
+		return []string{}, nil
+	}
+	if _, unlocked := unlockSessionForCA(caPath); !unlocked {
+		return []string{"CA is offline, open an unlock session before signing with it"}, errCAOffline
+	}
+	return []string{}, nil
+}
+
+// HasKey checks the backend holding the offline CA key pair
+func (backend *offlineSignerBackend) HasKey() (bool, error) {
+	return backend.backend.HasKey()
+}
+
+// GenerateKey creates the key pair in the backend holding the offline CA key pair
+func (backend *offlineSignerBackend) GenerateKey(keyAlgorithm string, keySize int, passphrase string) (crypto.PublicKey, error) {
+	return backend.backend.GenerateKey(keyAlgorithm, keySize, passphrase)
+}
+
+// DeleteKey removes the key pair from the backend holding the offline CA key pair
+func (backend *offlineSignerBackend) DeleteKey() error {
+	return backend.backend.DeleteKey()
+}
+
+// Signer hands out the key of the open unlock session, the passphrase is not used as the key was opened when unlocking the CA
+func (backend *offlineSignerBackend) Signer(passphrase string) (crypto.Signer, error) {
+	unlockSessionsMutex.Lock()
+	defer unlockSessionsMutex.Unlock()
+
+	session, ok := unlockSessions[unlockSessionKey(backend.caPath)]
+	if !ok {
+		return nil, errCAOffline
+	}
+	return &unlockSessionSigner{caPath: backend.caPath, sessionID: session.session.ID, publicKey: session.signer.Public()}, nil
+}
+
+// Public returns the public key of the offline CA
+func (signer *unlockSessionSigner) Public() crypto.PublicKey {
+	return signer.publicKey
+}
+
+// Sign signs with the key of the unlock session and records the signature, refusing once the session has been locked or expired
+func (signer *unlockSessionSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	unlockSessionsMutex.Lock()
+	defer unlockSessionsMutex.Unlock()
+
+	session, ok := unlockSessions[unlockSessionKey(signer.caPath)]
+	if !ok || session.session.ID != signer.sessionID {
+		return nil, errCAOffline
+	}
+
+	signature, err := session.signer.Sign(rand, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Messages signed without hashing first, as with Ed25519, are recorded by their SHA-256
+	event := UnlockSessionEvent{Time: time.Now().UTC(), SessionID: session.session.ID, Event: "signed"}
+	if opts.HashFunc() == 0 {
+		messageDigest := sha256.Sum256(digest)
+		event.Hash = "SHA-256 of message"
+		event.Digest = hex.EncodeToString(messageDigest[:])
+	} else {
+		event.Hash = opts.HashFunc().String()
+		event.Digest = hex.EncodeToString(digest)
+	}
+	session.session.Signings = append(session.session.Signings, event)
+	if err := appendUnlockSessionEvent(session.caPath, event); err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
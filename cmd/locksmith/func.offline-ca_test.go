@@ -0,0 +1,153 @@
+package locksmith
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// readUnlockSessionLog lists the events of the unlock session log of the CA at caPath
+func readUnlockSessionLog(t *testing.T, caPath string) []string {
+	t.Helper()
+	logFile, err := os.Open(caPath + "/" + unlockSessionLogFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	var events []string
+	scanner := bufio.NewScanner(logFile)
+	for scanner.Scan() {
+		var event UnlockSessionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event.Event)
+	}
+	return events
+}
+
+func TestUnlockOfflineCA(t *testing.T) {
+	useTestPKIRoot(t)
+	certConfig := testCertificateConfiguration("Offline Root CA", "ecdsa")
+	certConfig.RSAPrivateKeyPassphrase = "r00t"
+	caPath, _ := createTestRootCA(t, certConfig)
+	t.Cleanup(func() { endUnlockSession(caPath, "locked") })
+
+	if _, _, err := unlockCA(caPath, "r00t", time.Minute); err == nil {
+		t.Fatal("unlocked a CA that is not offline")
+	}
+	if err := setCAOffline(caPath, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// An offline CA refuses to sign until it is unlocked
+	if _, err := checkCAUnlocked(caPath); !errors.Is(err, errCAOffline) {
+		t.Fatalf("got %v, want %v", err, errCAOffline)
+	}
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", nil)
+	if _, _, _, err := createNewCertificateFromCSR(caPath, "r00t", csr, "server", csr.PublicKey, []int{0, 1, 0}); !errors.Is(err, errCAOffline) {
+		t.Fatalf("got %v, want %v", err, errCAOffline)
+	}
+	if _, err := endUnlockSession(caPath, "locked"); !errors.Is(err, errCALocked) {
+		t.Fatalf("got %v, want %v", err, errCALocked)
+	}
+
+	for _, duration := range []time.Duration{0, maxUnlockSessionDuration + time.Second} {
+		if _, _, err := unlockCA(caPath, "r00t", duration); err == nil {
+			t.Fatalf("unlocked the CA for %s", duration)
+		}
+	}
+	if _, _, err := unlockCA(caPath, "wrong", time.Minute); err == nil {
+		t.Fatal("unlocked the CA with the wrong passphrase")
+	}
+
+	session, messages, err := unlockCA(caPath, "r00t", time.Minute)
+	if err != nil {
+		t.Fatalf("unlock failed: %v %v", err, messages)
+	}
+	if _, _, err := unlockCA(caPath, "r00t", time.Minute); err == nil {
+		t.Fatal("unlocked the CA twice")
+	}
+	if _, err := checkCAUnlocked(caPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing during the session is recorded, without the passphrase as the key was opened when unlocking
+	created, _, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	openSession, unlocked := unlockSessionForCA(caPath)
+	if !unlocked || openSession.ID != session.ID || len(openSession.Signings) != 1 {
+		t.Fatalf("got session %+v, want one signing", openSession)
+	}
+
+	// A signer handed out during the session stops signing once the CA is locked again
+	signerBackend, err := signerBackendForCA(caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signerBackend.Signer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedSession, err := endUnlockSession(caPath, "locked")
+	if err != nil || closedSession.ClosedAt == nil {
+		t.Fatalf("got session %+v %v, want it closed", closedSession, err)
+	}
+	digest := sha256.Sum256([]byte("after the session"))
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); !errors.Is(err, errCAOffline) {
+		t.Fatalf("got %v, want %v", err, errCAOffline)
+	}
+
+	events := readUnlockSessionLog(t, caPath)
+	if len(events) != 3 || events[0] != "opened" || events[1] != "signed" || events[2] != "locked" {
+		t.Fatalf("got unlock session log %v", events)
+	}
+
+	// Back online the CA signs with its passphrase again
+	if err := setCAOffline(caPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkCAUnlocked(caPath); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnlockSessionExpires(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, _ := createTestRootCA(t, testCertificateConfiguration("Expiring Root CA", "ecdsa"))
+	t.Cleanup(func() { endUnlockSession(caPath, "locked") })
+	if err := setCAOffline(caPath, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, messages, err := unlockCA(caPath, "", 50*time.Millisecond); err != nil {
+		t.Fatalf("unlock failed: %v %v", err, messages)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, unlocked := unlockSessionForCA(caPath); !unlocked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("unlock session did not expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := checkCAUnlocked(caPath); !errors.Is(err, errCAOffline) {
+		t.Fatalf("got %v, want %v", err, errCAOffline)
+	}
+	events := readUnlockSessionLog(t, caPath)
+	if len(events) != 2 || events[1] != "expired" {
+		t.Fatalf("got unlock session log %v", events)
+	}
+}
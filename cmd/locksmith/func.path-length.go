@@ -0,0 +1,90 @@
+package locksmith
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// caPathLength returns the path length a CA certificate is created with, nil when the path length is unlimited
+// An authority-no-subs CA can only issue leaf certificates, which is a path length of 0
+func caPathLength(certConfig CertificateConfiguration) *int {
+	if certConfig.CertificateType == "authority-no-subs" {
+		pathLength := 0
+		return &pathLength
+	}
+	return certConfig.MaxPathLength
+}
+
+// validatePathLength makes sure the path length of a CA certificate configuration is usable
+func validatePathLength(certConfig CertificateConfiguration) error {
+	if certConfig.MaxPathLength == nil {
+		return nil
+	}
+	if *certConfig.MaxPathLength < 0 {
+		return fmt.Errorf("max_path_length can not be negative")
+	}
+	if certConfig.CertificateType == "authority-no-subs" && *certConfig.MaxPathLength != 0 {
+		return fmt.Errorf("authority-no-subs CAs can not have a max_path_length above 0")
+	}
+	return nil
+}
+
+// applyPathLength stamps the path length into a CA certificate template, a nil path length leaves it unlimited
+func applyPathLength(certificate *x509.Certificate, pathLength *int) {
+	certificate.MaxPathLen = -1
+	certificate.MaxPathLenZero = false
+	if pathLength != nil {
+		certificate.MaxPathLen = *pathLength
+		certificate.MaxPathLenZero = *pathLength == 0
+	}
+}
+
+// certificatePathLength returns the path length a CA certificate carries and if it carries one at all
+func certificatePathLength(certificate *x509.Certificate) (int, bool) {
+	if !certificate.BasicConstraintsValid || certificate.MaxPathLen < 0 || (certificate.MaxPathLen == 0 && !certificate.MaxPathLenZero) {
+		return 0, false
+	}
+	return certificate.MaxPathLen, true
+}
+
+// checkPathLengthForCA checks that the CA at caPath and every CA above it allow another CA to be created under it
+// The path length the new CA can be created with is returned, requesting more than the CAs above allow is refused and
+// leaving it unset limits the new CA to what is left of the path
+func checkPathLengthForCA(caPath string, requestedPathLength *int) (*int, []string, error) {
+	var allowedPathLength *int
+	for depth := 0; caPath != ""; caPath, depth = parentCAPath(caPath), depth+1 {
+		caCert, err := ReadCACertificate(caPath)
+		if err != nil {
+			return nil, []string{"CA Certificate could not be read"}, err
+		}
+		if caCert == nil {
+			return nil, []string{"CA Certificate could not be read"}, Stoerr("no-ca-certificate")
+		}
+		if !caCert.IsCA {
+			return nil, []string{"'" + caCert.Subject.CommonName + "' is not a CA Certificate"}, Stoerr("path-length-exceeded")
+		}
+		pathLength, limited := certificatePathLength(caCert)
+		if !limited {
+			continue
+		}
+		// The new CA is one more CA below every CA above it
+		remaining := pathLength - depth - 1
+		if remaining < 0 {
+			return nil, []string{fmt.Sprintf("CA '%s' has a path length of %d and can not have any more CAs under it", caCert.Subject.CommonName, pathLength)}, Stoerr("path-length-exceeded")
+		}
+		if allowedPathLength == nil || remaining < *allowedPathLength {
+			allowedPathLength = &remaining
+		}
+	}
+
+	if allowedPathLength == nil {
+		return requestedPathLength, nil, nil
+	}
+	if requestedPathLength == nil {
+		return allowedPathLength, nil, nil
+	}
+	if *requestedPathLength > *allowedPathLength {
+		return nil, []string{fmt.Sprintf("max_path_length can be at most %d under this CA", *allowedPathLength)}, Stoerr("path-length-exceeded")
+	}
+	return requestedPathLength, nil, nil
+}
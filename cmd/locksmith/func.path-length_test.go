@@ -0,0 +1,162 @@
+package locksmith
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func intPointer(i int) *int {
+	return &i
+}
+
+// writeTestCA creates a CA certificate with the path length in caPath/certs/ca.pem, signed by the parent CA or self-signed
+func writeTestCA(t *testing.T, caPath string, commonName string, pathLength *int, parentCert *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	applyPathLength(template, pathLength)
+	if parentCert == nil {
+		parentCert, parentKey = template, privKey
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, parentCert, privKey.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(caPath+"/certs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeCertificateFile(pemEncodeCertificate(certBytes), caPath+"/certs/ca.pem"); err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, privKey
+}
+
+func TestApplyPathLength(t *testing.T) {
+	tests := []struct {
+		name        string
+		pathLength  *int
+		wantLength  int
+		wantLimited bool
+	}{
+		{"unlimited", nil, 0, false},
+		{"zero", intPointer(0), 0, true},
+		{"two", intPointer(2), 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate, _ := writeTestCA(t, t.TempDir(), "Test CA", tt.pathLength, nil, nil)
+			length, limited := certificatePathLength(certificate)
+			if length != tt.wantLength || limited != tt.wantLimited {
+				t.Fatalf("got path length %d limited %v, want %d limited %v", length, limited, tt.wantLength, tt.wantLimited)
+			}
+		})
+	}
+}
+
+func TestValidatePathLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		certConfig CertificateConfiguration
+		wantError  bool
+	}{
+		{"unset", CertificateConfiguration{}, false},
+		{"positive", CertificateConfiguration{MaxPathLength: intPointer(3)}, false},
+		{"negative", CertificateConfiguration{MaxPathLength: intPointer(-1)}, true},
+		{"authority-no-subs with zero", CertificateConfiguration{CertificateType: "authority-no-subs", MaxPathLength: intPointer(0)}, false},
+		{"authority-no-subs with one", CertificateConfiguration{CertificateType: "authority-no-subs", MaxPathLength: intPointer(1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePathLength(tt.certConfig); (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+		})
+	}
+
+	if pathLength := caPathLength(CertificateConfiguration{CertificateType: "authority-no-subs"}); pathLength == nil || *pathLength != 0 {
+		t.Fatal("authority-no-subs CAs have to get a path length of 0")
+	}
+}
+
+func TestCheckPathLengthForCA(t *testing.T) {
+	tests := []struct {
+		name                string
+		rootPathLength      *int
+		intermedPathLength  *int
+		requestedPathLength *int
+		wantPathLength      *int
+		wantError           bool
+	}{
+		{"unlimited chain", nil, nil, nil, nil, false},
+		{"unlimited chain keeps the requested path length", nil, nil, intPointer(4), intPointer(4), false},
+		{"root of two leaves zero", intPointer(2), nil, nil, intPointer(0), false},
+		{"root of two refuses one", intPointer(2), nil, intPointer(1), nil, true},
+		{"root of one is used up", intPointer(1), nil, nil, nil, true},
+		{"intermediate of zero is used up", nil, intPointer(0), nil, nil, true},
+		{"intermediate of two leaves one", nil, intPointer(2), nil, intPointer(1), false},
+		{"tightest CA wins", intPointer(5), intPointer(2), intPointer(1), intPointer(1), false},
+		{"tightest CA refuses", intPointer(5), intPointer(2), intPointer(2), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootPath := filepath.Join(t.TempDir(), "roots", "test-root")
+			rootCert, rootKey := writeTestCA(t, rootPath, "Test Root", tt.rootPathLength, nil, nil)
+			intermedPath := rootPath + "/intermed-ca/test-intermediate"
+			writeTestCA(t, intermedPath, "Test Intermediate", tt.intermedPathLength, rootCert, rootKey)
+
+			// A new CA is created under the Intermediate CA
+			pathLength, messages, err := checkPathLengthForCA(intermedPath, tt.requestedPathLength)
+			if tt.wantError {
+				if err == nil || err.Error() != "path-length-exceeded" {
+					t.Fatalf("got error %v, want path-length-exceeded", err)
+				}
+				if len(messages) == 0 {
+					t.Fatal("a refused path length needs a message")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (pathLength == nil) != (tt.wantPathLength == nil) || (pathLength != nil && *pathLength != *tt.wantPathLength) {
+				t.Fatalf("got path length %v, want %v", pathLength, tt.wantPathLength)
+			}
+		})
+	}
+}
+
+func TestCheckPathLengthForCAWithoutCertificate(t *testing.T) {
+	rootPath := filepath.Join(t.TempDir(), "roots", "test-root")
+	writeTestCA(t, rootPath, "Test Root", nil, nil, nil)
+	intermedPath := rootPath + "/intermed-ca/half-created"
+	if err := os.MkdirAll(intermedPath+"/certs", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, messages, err := checkPathLengthForCA(intermedPath, nil); err == nil || len(messages) == 0 {
+		t.Fatalf("got error %v, want an error for the missing CA Certificate", err)
+	}
+}
@@ -0,0 +1,220 @@
+package locksmith
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// keyEncryptionFormat returns the configured format for new passphrase protected private keys
+func keyEncryptionFormat() string {
+	if readConfig != nil && readConfig.Locksmith.KeyEncryptionFormat != "" {
+		return readConfig.Locksmith.KeyEncryptionFormat
+	}
+	return keyEncryptionFormatPKCS8
+}
+
+// encryptPKCS8PrivateKey marshals a private key to PKCS #8 and encrypts it with PBES2, PBKDF2-HMAC-SHA256 and AES-256-CBC
+func encryptPKCS8PrivateKey(privKey crypto.Signer, passphrase string) (*pem.Block, error) {
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), salt, pkcs8PBKDF2Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS #7 pad the key out to a full block before CBC encryption
+	padding := aes.BlockSize - len(keyBytes)%aes.BlockSize
+	encryptedData := append(keyBytes, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encryptedData, encryptedData)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs8PBKDF2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData:       encryptedData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+}
+
+// decryptPKCS8PrivateKey decrypts the DER of a PBES2 encrypted PKCS #8 private key and returns the plain PKCS #8 DER
+func decryptPKCS8PrivateKey(der []byte, passphrase string) ([]byte, error) {
+	var keyInfo encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &keyInfo); err != nil {
+		return nil, err
+	}
+	if !keyInfo.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported PKCS #8 encryption algorithm %v, only PBES2 is supported", keyInfo.EncryptionAlgorithm.Algorithm)
+	}
+
+	var schemeParams pbes2Params
+	if _, err := asn1.Unmarshal(keyInfo.EncryptionAlgorithm.Parameters.FullBytes, &schemeParams); err != nil {
+		return nil, err
+	}
+	if !schemeParams.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported PBES2 key derivation function %v, only PBKDF2 is supported", schemeParams.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(schemeParams.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+	if kdfParams.IterationCount < 1 || kdfParams.IterationCount > maxPKCS8PBKDF2Iterations {
+		return nil, Stoerr("PBKDF2 iteration count is out of range")
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA224):
+		prf = sha256.New224
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF %v", kdfParams.PRF.Algorithm)
+	}
+
+	// DES-EDE3-CBC is only read, it is what OpenSSL encrypts keys with by default
+	var keyLength int
+	newCipher := aes.NewCipher
+	switch {
+	case schemeParams.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case schemeParams.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case schemeParams.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	case schemeParams.EncryptionScheme.Algorithm.Equal(oidDESEDE3CBC):
+		keyLength = 24
+		newCipher = des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported PBES2 encryption scheme %v", schemeParams.EncryptionScheme.Algorithm)
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), kdfParams.Salt, kdfParams.IterationCount, keyLength, prf)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(schemeParams.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, Stoerr("invalid CBC IV length")
+	}
+	if len(keyInfo.EncryptedData) == 0 || len(keyInfo.EncryptedData)%block.BlockSize() != 0 {
+		return nil, Stoerr("encrypted PKCS #8 data is not a multiple of the block size")
+	}
+
+	keyBytes := make([]byte, len(keyInfo.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(keyBytes, keyInfo.EncryptedData)
+
+	// A bad passphrase almost always shows up as bad padding, otherwise as a key that will not parse
+	padding := int(keyBytes[len(keyBytes)-1])
+	if padding < 1 || padding > block.BlockSize() || !bytes.Equal(keyBytes[len(keyBytes)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, Stoerr("incorrect passphrase for encrypted private key")
+	}
+	keyBytes = keyBytes[:len(keyBytes)-padding]
+	if _, err := x509.ParsePKCS8PrivateKey(keyBytes); err != nil {
+		return nil, Stoerr("incorrect passphrase for encrypted private key")
+	}
+
+	return keyBytes, nil
+}
+
+// encryptPrivateKeyPEM encrypts a plain text private key PEM into the bytes that are written to disk in the configured key encryption format
+func encryptPrivateKeyPEM(privKeyPEM []byte, passphrase string) ([]byte, error) {
+	if keyEncryptionFormat() == keyEncryptionFormatEnvelope {
+		return []byte(B64EncodeBytesToStr(encryptBytes(privKeyPEM, passphrase))), nil
+	}
+
+	_, keyPem := DecodePrivateKeyPem(privKeyPEM)
+	if keyPem == nil {
+		return nil, Stoerr("unable to decode private key PEM")
+	}
+	privKey := parsePrivateKey(keyPem)
+	if privKey == nil {
+		return nil, Stoerr("unable to parse private key")
+	}
+
+	encryptedBlock, err := encryptPKCS8PrivateKey(privKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(encryptedBlock), nil
+}
+
+// decryptPrivateKeyBytes takes the contents of an encrypted private key file in either the PKCS #8 or key envelope format and returns if it was decrypted, and if so a plain text PEM
+func decryptPrivateKeyBytes(keyBytes []byte, passphrase string) (decrypted bool, privKeyPEM []byte, err error) {
+	if block, _ := pem.Decode(keyBytes); block != nil && block.Type == "ENCRYPTED PRIVATE KEY" {
+		keyDer, err := decryptPKCS8PrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			return false, []byte{}, err
+		}
+		return true, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), nil
+	}
+
+	// File is a base64 encoded key envelope
+	decodedPrivKey, err := B64DecodeStrToBytes(string(keyBytes))
+	if err != nil {
+		return false, []byte{}, err
+	}
+	return decryptBytes(decodedPrivKey, passphrase)
+}
@@ -0,0 +1,340 @@
+package locksmith
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// parentCAPath returns the path of the CA that issued the CA at caPath, or an empty string for a top level CA
+func parentCAPath(caPath string) string {
+	if filepath.Base(filepath.Dir(caPath)) == "intermed-ca" {
+		return filepath.Dir(filepath.Dir(caPath))
+	}
+	return ""
+}
+
+// nextCAVersion returns the number of the versions/<n>/ directory the current CA certificate is archived to
+func nextCAVersion(caPath string) (int, error) {
+	versionDirs, err := ioutil.ReadDir(caPath + "/versions")
+	if os.IsNotExist(err) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version := 1
+	for _, versionDir := range versionDirs {
+		if n, err := strconv.Atoi(versionDir.Name()); err == nil && n >= version {
+			version = n + 1
+		}
+	}
+	return version, nil
+}
+
+// renewedCATemplate copies a CA certificate into the template of a new certificate for the same CA
+// Extensions are carried over as they are, except for the key identifiers and distribution points which are set again
+func renewedCATemplate(caCert *x509.Certificate, serialNumber int64, notBefore time.Time, notAfter time.Time, subjectKeyID []byte) *x509.Certificate {
+	template := *caCert
+	template.SerialNumber = big.NewInt(serialNumber)
+	template.NotBefore = notBefore
+	template.NotAfter = notAfter
+	template.SubjectKeyId = subjectKeyID
+	template.AuthorityKeyId = nil
+	template.PublicKey = nil
+	template.ExtraExtensions = nil
+
+	for _, extension := range caCert.Extensions {
+		excluded := false
+		for _, excludedExtension := range renewedCAExcludedExtensions {
+			if extension.Id.Equal(excludedExtension) {
+				excluded = true
+			}
+		}
+		if !excluded {
+			template.ExtraExtensions = append(template.ExtraExtensions, extension)
+		}
+	}
+	return &template
+}
+
+// crossCATemplate sets up a certificate for one key of a CA signed by its other key, issued by the CA itself and valid until notAfter
+func crossCATemplate(caCert *x509.Certificate, caPath string, serialNumber int64, notBefore time.Time, notAfter time.Time, authorityKeyID []byte) (*x509.Certificate, error) {
+	template := renewedCATemplate(caCert, serialNumber, notBefore, notAfter, caCert.SubjectKeyId)
+	template.AuthorityKeyId = authorityKeyID
+	template.CRLDistributionPoints = nil
+	template.IssuingCertificateURL = nil
+	template.OCSPServer = nil
+	return template, applyDistributionPoints(template, caPath, caCert.Subject.CommonName)
+}
+
+// issueCACertificate signs a CA certificate template, increases the serial of the issuing CA, and records the certificate in its newcerts/ and CA Index
+func issueCACertificate(template *x509.Certificate, issuerCert *x509.Certificate, pubKey interface{}, issuerKey interface{}, issuerPath string) (*x509.Certificate, error) {
+	certBytes, err := CreateCert(template, issuerCert, pubKey, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := IncreaseSerialNumberAbs(issuerPath + "/ca.serial"); err != nil {
+		return nil, err
+	}
+	newCertPath := issuerPath + "/newcerts/" + formatSerialHex(certificate.SerialNumber) + ".pem"
+	if _, err := writeCertificateFile(pemEncodeCertificate(certBytes), newCertPath); err != nil {
+		return nil, err
+	}
+	absoluteCertPath, _ := filepath.Abs(newCertPath)
+	if _, err := AddEntryToCAIndex(issuerPath+"/ca.index", absoluteCertPath); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// renewCA issues a new certificate for the CA at caPath and archives the current one to versions/<n>/
+// With rekey a new key pair replaces the current one, which is archived along with the certificate, and the old and new keys
+// cross-sign each other so chains built with either CA certificate keep validating during the rollover
+func renewCA(caPath string, renewal RESTPOSTRenewCAJSONIn, rekey bool) (bool, []string, CARenewal, error) {
+	if len(renewal.ExpirationDate) != 3 {
+		return false, []string{"Missing Expiration Date field"}, CARenewal{}, Stoerr("cert-config-error")
+	}
+
+	oldCert, err := ReadCACertificate(caPath)
+	if err != nil || oldCert == nil {
+		return false, []string{"Certificate Authority Certificate PEM File does not exists!"}, CARenewal{}, Stoerr("no-ca-certificate")
+	}
+
+	// Top level CAs imported with a chain were issued outside of Locksmith
+	parentPath := parentCAPath(caPath)
+	if parentPath == "" && !isSelfSignedCertificate(oldCert) {
+		return false, []string{"CA '" + oldCert.Subject.CommonName + "' was issued outside of Locksmith, renew it with its issuer and import it again"}, CARenewal{}, Stoerr("ca-renewal-unsupported")
+	}
+
+	signerBackend, err := signerBackendForCA(caPath)
+	if err != nil {
+		return false, []string{"CA Signer Backend Failure"}, CARenewal{}, err
+	}
+	oldKey, err := signerBackend.Signer(renewal.RSAPrivateKeyPassphrase)
+	if err != nil {
+		if errors.Is(err, errCAOffline) {
+			return false, []string{"CA is offline, open an unlock session before renewing or rekeying it"}, CARenewal{}, err
+		}
+		return false, []string{"CA Private Key could not be opened: " + err.Error()}, CARenewal{}, Stoerr("invalid-ca-key")
+	}
+
+	// A top level CA issues its own certificate, intermediates are issued by their parent
+	issuerPath := caPath
+	issuerKey := oldKey
+	var issuerCert *x509.Certificate
+	if parentPath != "" {
+		issuerPath = parentPath
+		issuerCert, err = ReadCACertificate(parentPath)
+		if err != nil || issuerCert == nil {
+			return false, []string{"Signing CA Certificate could not be read"}, CARenewal{}, Stoerr("no-signing-ca-certificate")
+		}
+		issuerSignerBackend, err := signerBackendForCA(parentPath)
+		if err != nil {
+			return false, []string{"Signing CA Signer Backend Failure"}, CARenewal{}, err
+		}
+		issuerKey, err = issuerSignerBackend.Signer(renewal.SigningPrivateKeyPassphrase)
+		if err != nil {
+			if errors.Is(err, errCAOffline) {
+				return false, []string{"Signing CA is offline, open an unlock session before renewing or rekeying under it"}, CARenewal{}, err
+			}
+			return false, []string{"Signing CA Private Key could not be opened: " + err.Error()}, CARenewal{}, Stoerr("invalid-signing-ca-key")
+		}
+	}
+
+	// Generate the new key first so a failure leaves the current CA in place
+	newKey := oldKey
+	subjectKeyID := oldCert.SubjectKeyId
+	var fileBackend *fileSignerBackend
+	if rekey {
+		// An offline CA is rekeyed during an unlock session, its key pair is kept by the backend underneath
+		keyBackend := signerBackend
+		if offlineBackend, ok := signerBackend.(*offlineSignerBackend); ok {
+			keyBackend = offlineBackend.backend
+		}
+		var ok bool
+		fileBackend, ok = keyBackend.(*fileSignerBackend)
+		if !ok {
+			return false, []string{"Only CAs keeping their key in the file signer backend can be rekeyed"}, CARenewal{}, Stoerr("ca-rekey-unsupported")
+		}
+
+		// Keep the algorithm and size of the current key unless new ones are requested
+		keyAlgorithm, keySize := renewal.KeyAlgorithm, renewal.KeySize
+		if keyAlgorithm == "" {
+			keyAlgorithm = keyAlgorithmForKey(oldCert.PublicKey)
+			if keySize == 0 {
+				keySize = keySizeForKey(oldCert.PublicKey)
+			}
+		}
+		newKey, _, err = GenerateKeypair(keyAlgorithm, keySize)
+		if err != nil {
+			return false, []string{err.Error()}, CARenewal{}, Stoerr("cert-config-error")
+		}
+		if parentPath == "" {
+			issuerKey = newKey
+		}
+
+		publicKeyBytes, _, err := marshalPublicKey(newKey.Public())
+		if err != nil {
+			return false, []string{err.Error()}, CARenewal{}, Stoerr("cert-config-error")
+		}
+		h := sha1.Sum(publicKeyBytes)
+		subjectKeyID = h[:]
+	}
+
+	// Set time for UTC format
+	currentTime := time.Now()
+	yesterdayTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).Add(-24 * time.Hour)
+	notAfter := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, time.UTC).AddDate(renewal.ExpirationDate[0], renewal.ExpirationDate[1], renewal.ExpirationDate[2])
+
+	// Issue the new CA Certificate
+	newTemplate := renewedCATemplate(oldCert, readSerialNumberAsInt64Abs(issuerPath+"/ca.serial"), yesterdayTime, notAfter, subjectKeyID)
+	if parentPath == "" {
+		newTemplate.AuthorityKeyId = subjectKeyID
+		issuerCert = newTemplate
+	} else {
+		newTemplate.AuthorityKeyId = issuerCert.SubjectKeyId
+	}
+	if err := applyDistributionPoints(newTemplate, issuerPath, issuerCert.Subject.CommonName); err != nil {
+		return false, []string{"Signing CA Distribution Points Failure"}, CARenewal{}, err
+	}
+	newCert, err := issueCACertificate(newTemplate, issuerCert, newKey.Public(), issuerKey, issuerPath)
+	if err != nil {
+		return false, []string{"CA Certificate Creation Failure"}, CARenewal{}, err
+	}
+	renewed := CARenewal{Certificate: newCert}
+
+	// Write the new CA Certificate, and on rekey its key pair, next to the current ones so they are only swapped in once everything is on disk
+	stagingPath := caPath + "/.renewing"
+	if err := os.RemoveAll(stagingPath); err != nil {
+		return false, []string{"CA Renewal Failure"}, CARenewal{}, err
+	}
+	CreateDirectory(stagingPath)
+	defer os.RemoveAll(stagingPath)
+	newCertPEM := pemEncodeCertificate(newCert.Raw).Bytes()
+	if _, err := WriteByteFile(stagingPath+"/ca.pem", newCertPEM, 0600, true); err != nil {
+		return false, []string{"CA Certificate Failure"}, CARenewal{}, err
+	}
+	stagedFiles := map[string]string{stagingPath + "/ca.pem": caPath + "/certs/ca.pem"}
+	if rekey {
+		newPassphrase := renewal.NewRSAPrivateKeyPassphrase
+		if newPassphrase == "" {
+			newPassphrase = renewal.RSAPrivateKeyPassphrase
+		}
+		stagedBackend := &fileSignerBackend{keyPath: stagingPath + "/ca"}
+		if err := stagedBackend.writeKeyPair(newKey, newPassphrase); err != nil {
+			return false, []string{"CA Private Key Failure"}, CARenewal{}, err
+		}
+		stagedFiles[stagingPath+"/ca.priv.pem"] = fileBackend.keyPath + ".priv.pem"
+		stagedFiles[stagingPath+"/ca.pub.pem"] = fileBackend.keyPath + ".pub.pem"
+	}
+
+	// Archive the current CA Certificate, and on rekey its key pair and CSR
+	renewed.Version, err = nextCAVersion(caPath)
+	if err != nil {
+		return false, []string{"CA Versions could not be read"}, CARenewal{}, err
+	}
+	versionPath := caPath + "/versions/" + strconv.Itoa(renewed.Version)
+	CreateDirectory(versionPath)
+	archivedFiles := map[string]string{caPath + "/certs/ca.pem": versionPath + "/ca.pem"}
+	if rekey {
+		CreateDirectory(versionPath + "/private")
+		archivedFiles[caPath+"/private/ca.priv.pem"] = versionPath + "/private/ca.priv.pem"
+		archivedFiles[caPath+"/private/ca.pub.pem"] = versionPath + "/private/ca.pub.pem"
+		archivedFiles[caPath+"/certreqs/ca.pem"] = versionPath + "/ca.csr.pem"
+	}
+
+	// Anything moved so far is put back when a step fails, leaving the CA with its current key and certificate
+	movedFiles := map[string]string{}
+	placedFiles := []string{}
+	swapFailed := func(message string, err error) (bool, []string, CARenewal, error) {
+		for _, placedFile := range placedFiles {
+			os.Remove(placedFile)
+		}
+		for currentPath, archivedPath := range movedFiles {
+			os.Rename(archivedPath, currentPath)
+		}
+		os.RemoveAll(versionPath)
+		return false, []string{message}, CARenewal{}, err
+	}
+	for currentPath, archivedPath := range archivedFiles {
+		fileCheck, err := FileExists(currentPath)
+		if err != nil {
+			return swapFailed("CA Archive Failure", err)
+		}
+		if fileCheck {
+			if err := os.Rename(currentPath, archivedPath); err != nil {
+				return swapFailed("CA Archive Failure", err)
+			}
+			movedFiles[currentPath] = archivedPath
+		}
+	}
+	for stagedPath, currentPath := range stagedFiles {
+		if err := os.Rename(stagedPath, currentPath); err != nil {
+			return swapFailed("CA Certificate Failure", err)
+		}
+		placedFiles = append(placedFiles, currentPath)
+	}
+
+	if parentPath != "" {
+		if _, err := WriteByteFile(parentPath+"/certs/"+slugger(newCert.Subject.CommonName)+".pem", newCertPEM, 0600, true); err != nil {
+			return false, []string{"Signing CA Certificate Copy Failure"}, CARenewal{}, err
+		}
+	}
+
+	if !rekey {
+		return true, []string{"Renewed CA: " + newCert.Subject.CommonName}, renewed, nil
+	}
+
+	// The new key signs the old one and the old key signs the new one, both valid as long as the old CA Certificate
+	crossNotAfter := oldCert.NotAfter
+	if newCert.NotAfter.Before(crossNotAfter) {
+		crossNotAfter = newCert.NotAfter
+	}
+	newWithOldTemplate, err := crossCATemplate(newCert, caPath, readSerialNumberAsInt64Abs(caPath+"/ca.serial"), yesterdayTime, crossNotAfter, oldCert.SubjectKeyId)
+	if err != nil {
+		return false, []string{"CA Distribution Points Failure"}, CARenewal{}, err
+	}
+	renewed.NewWithOld, err = issueCACertificate(newWithOldTemplate, oldCert, newKey.Public(), oldKey, caPath)
+	if err != nil {
+		return false, []string{"New with Old Cross Certificate Failure"}, CARenewal{}, err
+	}
+	oldWithNewTemplate, err := crossCATemplate(oldCert, caPath, readSerialNumberAsInt64Abs(caPath+"/ca.serial"), yesterdayTime, crossNotAfter, newCert.SubjectKeyId)
+	if err != nil {
+		return false, []string{"CA Distribution Points Failure"}, CARenewal{}, err
+	}
+	renewed.OldWithNew, err = issueCACertificate(oldWithNewTemplate, newCert, oldCert.PublicKey, newKey, caPath)
+	if err != nil {
+		return false, []string{"Old with New Cross Certificate Failure"}, CARenewal{}, err
+	}
+	for fileName, crossCert := range map[string]*x509.Certificate{"new-with-old.pem": renewed.NewWithOld, "old-with-new.pem": renewed.OldWithNew} {
+		if _, err := writeCertificateFile(pemEncodeCertificate(crossCert.Raw), versionPath+"/"+fileName); err != nil {
+			return false, []string{"Cross Certificate Failure"}, CARenewal{}, err
+		}
+	}
+
+	// The CRL is signed with the new key from now on
+	if err := reissueCRLForCA(caPath, newCert, newKey); err != nil {
+		return false, []string{"CA CRL Creation Error"}, CARenewal{}, err
+	}
+
+	// The unlock session of an offline CA holds the old key, so the CA is locked again
+	messages := []string{"Rekeyed CA: " + newCert.Subject.CommonName}
+	if _, err := endUnlockSession(caPath, "locked"); err == nil {
+		messages = append(messages, "Locked the CA, open a new unlock session to sign with the new key")
+	}
+
+	return true, messages, renewed, nil
+}
@@ -0,0 +1,161 @@
+package locksmith
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParentCAPath(t *testing.T) {
+	tests := []struct {
+		caPath string
+		want   string
+	}{
+		{"/pki/roots/root-ca", ""},
+		{"/pki/roots/root-ca/intermed-ca/intermediate-ca", "/pki/roots/root-ca"},
+		{"/pki/roots/root-ca/intermed-ca/intermediate-ca/intermed-ca/issuing-ca", "/pki/roots/root-ca/intermed-ca/intermediate-ca"},
+	}
+	for _, tt := range tests {
+		if got := parentCAPath(tt.caPath); got != tt.want {
+			t.Errorf("parentCAPath(%q) = %q, want %q", tt.caPath, got, tt.want)
+		}
+	}
+}
+
+func TestNextCAVersion(t *testing.T) {
+	caPath := t.TempDir()
+	if version, err := nextCAVersion(caPath); err != nil || version != 1 {
+		t.Fatalf("got version %d %v, want 1 without a versions directory", version, err)
+	}
+	for _, versionDir := range []string{"1", "3", "notes"} {
+		CreateDirectory(caPath + "/versions/" + versionDir)
+	}
+	if version, err := nextCAVersion(caPath); err != nil || version != 4 {
+		t.Fatalf("got version %d %v, want 4", version, err)
+	}
+}
+
+func TestRenewCA(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, oldCert := createTestRootCA(t, testCertificateConfiguration("Renew Root CA", "ecdsa"))
+
+	if renewed, _, _, err := renewCA(caPath, RESTPOSTRenewCAJSONIn{}, false); renewed || err == nil {
+		t.Fatal("expected an error without an expiration date")
+	}
+
+	renewed, messages, renewal, err := renewCA(caPath, RESTPOSTRenewCAJSONIn{ExpirationDate: []int{2, 0, 0}}, false)
+	if err != nil || !renewed {
+		t.Fatalf("renewal failed: %v %v", err, messages)
+	}
+	newCert := renewal.Certificate
+	if renewal.Version != 1 || renewal.OldWithNew != nil || renewal.NewWithOld != nil {
+		t.Fatalf("got version %d with cross certificates, want version 1 without", renewal.Version)
+	}
+
+	// The renewed certificate keeps the key and subject of the CA with a new serial and validity
+	if !bytes.Equal(newCert.RawSubjectPublicKeyInfo, oldCert.RawSubjectPublicKeyInfo) || newCert.Subject.CommonName != oldCert.Subject.CommonName {
+		t.Fatal("renewed certificate changed the key or subject of the CA")
+	}
+	if newCert.SerialNumber.Cmp(oldCert.SerialNumber) == 0 || !newCert.NotAfter.After(oldCert.NotAfter) {
+		t.Fatal("renewed certificate did not get a new serial and validity")
+	}
+	if err := newCert.CheckSignatureFrom(newCert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous certificate is archived and the new one takes its place
+	archivedCert, err := ReadCertFromFile(caPath + "/versions/1/ca.pem")
+	if err != nil || !archivedCert.Equal(&oldCert) {
+		t.Fatalf("previous CA Certificate was not archived: %v", err)
+	}
+	currentCert, err := ReadCACertificate(caPath)
+	if err != nil || !currentCert.Equal(newCert) {
+		t.Fatalf("renewed CA Certificate was not put in place: %v", err)
+	}
+	if exists, _ := DirectoryExists(caPath + "/.renewing"); exists {
+		t.Fatal("renewal left its staging directory behind")
+	}
+}
+
+func TestRekeyCA(t *testing.T) {
+	useTestPKIRoot(t)
+	caPath, oldCert := createTestRootCA(t, testCertificateConfiguration("Rekey Root CA", "ecdsa"))
+	oldKeyPEM, err := ioutil.ReadFile(caPath + "/private/ca.priv.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, messages, renewal, err := renewCA(caPath, RESTPOSTRenewCAJSONIn{ExpirationDate: []int{2, 0, 0}}, true)
+	if err != nil || !renewed {
+		t.Fatalf("rekey failed: %v %v", err, messages)
+	}
+	newCert := renewal.Certificate
+	if bytes.Equal(newCert.RawSubjectPublicKeyInfo, oldCert.RawSubjectPublicKeyInfo) {
+		t.Fatal("rekeyed certificate kept the old key")
+	}
+	if newCert.PublicKeyAlgorithm != oldCert.PublicKeyAlgorithm {
+		t.Fatalf("got key algorithm %s, want %s", newCert.PublicKeyAlgorithm, oldCert.PublicKeyAlgorithm)
+	}
+
+	// The old key pair is archived along with the certificate
+	archivedKeyPEM, err := ioutil.ReadFile(caPath + "/versions/1/private/ca.priv.pem")
+	if err != nil || !bytes.Equal(archivedKeyPEM, oldKeyPEM) {
+		t.Fatalf("previous CA Private Key was not archived: %v", err)
+	}
+
+	// The old and new keys cross-sign each other
+	if err := renewal.NewWithOld.CheckSignatureFrom(&oldCert); err != nil {
+		t.Fatalf("new with old: %v", err)
+	}
+	if !bytes.Equal(renewal.NewWithOld.RawSubjectPublicKeyInfo, newCert.RawSubjectPublicKeyInfo) {
+		t.Fatal("new with old does not certify the new key")
+	}
+	if err := renewal.OldWithNew.CheckSignatureFrom(newCert); err != nil {
+		t.Fatalf("old with new: %v", err)
+	}
+	if !bytes.Equal(renewal.OldWithNew.RawSubjectPublicKeyInfo, oldCert.RawSubjectPublicKeyInfo) {
+		t.Fatal("old with new does not certify the old key")
+	}
+
+	// Certificates are signed with the new key from now on
+	csr, _ := createTestCSR(t, "www.example.labs", "ecdsa", &x509.CertificateRequest{DNSNames: []string{"www.example.labs"}})
+	created, certificate, messages, err := createNewCertificateFromCSR(caPath, "", csr, "server", csr.PublicKey, []int{0, 1, 0})
+	if err != nil || !created {
+		t.Fatalf("signing failed: %v %v", err, messages)
+	}
+	if err := certificate.CheckSignatureFrom(newCert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenewIntermediateCA(t *testing.T) {
+	useTestPKIRoot(t)
+	rootPath, rootCert := createTestRootCA(t, testCertificateConfiguration("Renew Parent Root CA", "ecdsa"))
+	created, messages, _, err := createNewIntermediateCA(RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Renew Intermediate CA", "ecdsa")}, rootPath)
+	if err != nil || !created {
+		t.Fatalf("creating the Intermediate CA failed: %v %v", err, messages)
+	}
+	caPath := rootPath + "/intermed-ca/" + slugger("Renew Intermediate CA")
+	parentSerial := readSerialNumberAsInt64Abs(rootPath + "/ca.serial")
+
+	renewed, messages, renewal, err := renewCA(caPath, RESTPOSTRenewCAJSONIn{ExpirationDate: []int{0, 6, 0}}, false)
+	if err != nil || !renewed {
+		t.Fatalf("renewal failed: %v %v", err, messages)
+	}
+
+	// The parent CA issues the renewed certificate from its own serial and keeps a copy of it
+	if err := renewal.Certificate.CheckSignatureFrom(&rootCert); err != nil {
+		t.Fatal(err)
+	}
+	if renewal.Certificate.SerialNumber.Int64() != parentSerial {
+		t.Fatalf("got serial %d, want the parent serial %d", renewal.Certificate.SerialNumber.Int64(), parentSerial)
+	}
+	if serial := readSerialNumberAsInt64Abs(rootPath + "/ca.serial"); serial != parentSerial+1 {
+		t.Fatalf("got parent serial %d, want %d", serial, parentSerial+1)
+	}
+	parentCopy, err := ReadCertFromFile(rootPath + "/certs/" + slugger("Renew Intermediate CA") + ".pem")
+	if err != nil || !parentCopy.Equal(renewal.Certificate) {
+		t.Fatalf("parent CA copy was not updated: %v", err)
+	}
+}
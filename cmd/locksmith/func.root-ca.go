@@ -1,20 +1,18 @@
 package locksmith
 
 import (
-	"bytes"
-	"crypto/rsa"
+	"crypto"
 	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"fmt"
 	"math/big"
 	"path/filepath"
 	"time"
 )
 
 // setupCACert creates a Certificate resource
-func setupCACert(serialNumber int64, commonName string, organization []string, organizationalUnit []string, country []string, province []string, locality []string, streetAddress []string, postalCode []string, addTime []int, sanData SANData, pubKey *rsa.PublicKey) *x509.Certificate {
+func setupCACert(serialNumber int64, commonName string, organization []string, organizationalUnit []string, country []string, province []string, locality []string, streetAddress []string, postalCode []string, addTime []int, sanData SANData, pubKey crypto.PublicKey) *x509.Certificate {
 	// set up our CA certificate
 
 	// Convert string slice of URLs into actual URI objects
@@ -37,8 +35,7 @@ func setupCACert(serialNumber int64, commonName string, organization []string, o
 	subjectKeyID := h[:]
 
 	return &x509.Certificate{
-		SignatureAlgorithm: x509.SHA512WithRSA,
-		SerialNumber:       big.NewInt(serialNumber),
+		SerialNumber: big.NewInt(serialNumber),
 		Subject: pkix.Name{
 			CommonName:         commonName,
 			Organization:       organization,
@@ -60,8 +57,6 @@ func setupCACert(serialNumber int64, commonName string, organization []string, o
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		ExtraExtensions:       []pkix.Extension{issuerAltName},
-		CRLDistributionPoints: []string{"https://ca.example.labs:443/crl/ca.example.labs_Root_Certification_Authority.crl"},
-		IssuingCertificateURL: []string{"https://ca.example.labs:443/certs/ca.example.labs_Root_Certification_Authority.cert.pem"},
 	}
 }
 
@@ -92,6 +87,22 @@ func createNewCA(certConfig CertificateConfiguration) (bool, []string, x509.Cert
 		checkInputError = true
 		checkInputErrors = append(checkInputErrors, "Missing Expiration Date field")
 	}
+	if _, _, err := normalizeKeyAlgorithm(certConfig.KeyAlgorithm, certConfig.KeySize); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+	if err := validateDistributionPoints(certConfig.DistributionPoints); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+	if err := validatePathLength(certConfig); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+	if err := validateCertificatePolicies(certConfig.CertificatePolicies); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
 	if checkInputError {
 		return false, checkInputErrors, x509.Certificate{}, Stoerr("cert-config-error")
 	}
@@ -102,38 +113,45 @@ func createNewCA(certConfig CertificateConfiguration) (bool, []string, x509.Cert
 	// Create the CA base directories and files
 	certPaths := setupCAFileStructure(rootSlugPath)
 
-	// Check for certificate authority key pair
-	caKeyCheck, err := FileExists(certPaths.RootCAKeysPath + "/ca.priv.pem")
-	check(err)
-
-	if !caKeyCheck {
-		// if there is no private key, create one
-		rootPrivKey, rootPubKey, err := GenerateRSAKeypair(4096)
-		check(err)
+	// Keep the URL templates for the certificates this CA issues
+	if certConfig.DistributionPoints != nil {
+		if err := writeDistributionPoints(rootSlugPath, certConfig.DistributionPoints); err != nil {
+			return false, []string{"Root CA Distribution Points Failure"}, x509.Certificate{}, err
+		}
+	}
 
-		pemEncodedPrivateKey, encryptedPrivateKeyBytes := pemEncodeRSAPrivateKey(rootPrivKey, rsaPrivateKeyPassword)
+	// Keep the certificate policies for the certificates this CA issues
+	if certConfig.CertificatePolicies != nil {
+		if err := writeCertificatePolicies(rootSlugPath, certConfig.CertificatePolicies); err != nil {
+			return false, []string{"Root CA Certificate Policies Failure"}, x509.Certificate{}, err
+		}
+	}
 
-		if rsaPrivateKeyPassword == "" {
-			rootPrivKeyFile, rootPubKeyFile, err := writeRSAKeyPair(pemEncodedPrivateKey, pemEncodeRSAPublicKey(rootPubKey), certPaths.RootCAKeysPath+"/ca")
-			check(err)
-			if !rootPrivKeyFile || !rootPubKeyFile {
-				return false, []string{"Root CA Private Key Failure"}, x509.Certificate{}, err
-			}
-		} else {
+	// Find where the certificate authority key pair is kept
+	signerBackend, err := signerBackendForCA(rootSlugPath)
+	if err != nil {
+		return false, []string{"Root CA Signer Backend Failure"}, x509.Certificate{}, err
+	}
 
-			encStr := B64EncodeBytesToStr(encryptedPrivateKeyBytes.Bytes())
-			encBufferB := bytes.NewBufferString(encStr)
+	// Check for certificate authority key pair
+	caKeyCheck, err := signerBackend.HasKey()
+	if err != nil {
+		return false, []string{"Root CA Signer Backend Failure"}, x509.Certificate{}, err
+	}
 
-			rootPrivKeyFile, rootPubKeyFile, err := writeRSAKeyPair(encBufferB, pemEncodeRSAPublicKey(rootPubKey), certPaths.RootCAKeysPath+"/ca")
-			check(err)
-			if !rootPrivKeyFile || !rootPubKeyFile {
-				return false, []string{"Root CA Private Key Failure"}, x509.Certificate{}, err
-			}
+	if !caKeyCheck {
+		// if there is no private key, create one
+		_, err := signerBackend.GenerateKey(certConfig.KeyAlgorithm, certConfig.KeySize, rsaPrivateKeyPassword)
+		if err != nil {
+			return false, []string{"Root CA Private Key Failure"}, x509.Certificate{}, err
 		}
 	}
 
 	// Read in the Private key
-	privateKeyFromFile := GetPrivateKey(certPaths.RootCAKeysPath+"/ca.priv.pem", rsaPrivateKeyPassword)
+	privateKeyFromFile, err := signerBackend.Signer(rsaPrivateKeyPassword)
+	if err != nil {
+		return false, []string{"Root CA Private Key Failure"}, x509.Certificate{}, err
+	}
 
 	// Read in the Public key
 	pubKeyFromFile := GetPublicKey(certPaths.RootCAKeysPath + "/ca.pub.pem")
@@ -173,6 +191,19 @@ func createNewCA(certConfig CertificateConfiguration) (bool, []string, x509.Cert
 		// Create CA Object
 		rootCA := setupCACert(readSerialNumberAsInt64(rootSlug), caCSRPEM.Subject.CommonName, caCSRPEM.Subject.Organization, caCSRPEM.Subject.OrganizationalUnit, caCSRPEM.Subject.Country, caCSRPEM.Subject.Province, caCSRPEM.Subject.Locality, caCSRPEM.Subject.StreetAddress, caCSRPEM.Subject.PostalCode, certConfig.ExpirationDate, certConfig.SANData, pubKeyFromFile)
 
+		// Limit how many CAs can be chained under the Root CA
+		applyPathLength(rootCA, caPathLength(certConfig))
+
+		// Stamp in the certificate policies of the Root CA
+		if err := applyCertificatePolicies(rootCA, certConfig.CertificatePolicies, true); err != nil {
+			return false, []string{"Root CA Certificate Policies Failure"}, x509.Certificate{}, err
+		}
+
+		// A Root CA issues its own certificate
+		if err := applyDistributionPoints(rootCA, rootSlugPath, caCSRPEM.Subject.CommonName); err != nil {
+			return false, []string{"Root CA Distribution Points Failure"}, x509.Certificate{}, err
+		}
+
 		// Byte Encode the Certificate - https://golang.org/pkg/crypto/x509/#CreateCertificate
 		caBytes, err := CreateCert(rootCA, rootCA, pubKeyFromFile, privateKeyFromFile)
 		check(err)
@@ -197,7 +228,7 @@ func createNewCA(certConfig CertificateConfiguration) (bool, []string, x509.Cert
 	check(err)
 
 	// Copy CA Certificate File to the CA's newcerts folder
-	serialNumber := fmt.Sprintf("%02d", caCert.SerialNumber)
+	serialNumber := formatSerialHex(caCert.SerialNumber)
 	copyCertErr := CopyFile(certPaths.RootCACertsPath+"/ca.pem", certPaths.RootCANewCertsPath+"/"+serialNumber+".pem", 4096)
 	check(copyCertErr)
 
@@ -217,6 +248,13 @@ func createNewCA(certConfig CertificateConfiguration) (bool, []string, x509.Cert
 		return false, []string{"Root CA CRL Creation Error"}, x509.Certificate{}, err
 	}
 
+	// An offline Root CA only signs again during an unlock session
+	if certConfig.Offline {
+		if err := setCAOffline(rootSlugPath, true); err != nil {
+			return false, []string{"Root CA Offline Mode Failure"}, x509.Certificate{}, err
+		}
+	}
+
 	return true, []string{"Finished creating Root CA: " + caCert.Subject.CommonName}, *caCert, nil
 }
 
@@ -0,0 +1,114 @@
+package locksmith
+
+import (
+	"crypto/rand"
+)
+
+// gf256Multiply multiplies two elements of GF(2^8), reduced by the AES polynomial x^8 + x^4 + x^3 + x + 1
+func gf256Multiply(a byte, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// gf256Inverse finds the multiplicative inverse of a non-zero element of GF(2^8), a^254
+func gf256Inverse(a byte) byte {
+	inverse := byte(1)
+	for i := 0; i < 254; i++ {
+		inverse = gf256Multiply(inverse, a)
+	}
+	return inverse
+}
+
+// gf256Evaluate evaluates the polynomial with the coefficients, lowest degree first, at x
+func gf256Evaluate(coefficients []byte, x byte) byte {
+	var value byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		value = gf256Multiply(value, x) ^ coefficients[i]
+	}
+	return value
+}
+
+// splitSecret splits a secret into parts shares with Shamir's Secret Sharing, any threshold of them recover it
+// Every share is the secret length plus a trailing byte holding its x coordinate
+func splitSecret(secret []byte, parts int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, Stoerr("the secret is empty")
+	}
+	if threshold < 2 || threshold > parts || parts > 255 {
+		return nil, Stoerr("shares need a threshold between 2 and the number of shares, at most 255")
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	// Each byte of the secret is the constant term of its own random polynomial of degree threshold-1
+	coefficients := make([]byte, threshold)
+	for secretIndex, secretByte := range secret {
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		coefficients[0] = secretByte
+		for i := range shares {
+			shares[i][secretIndex] = gf256Evaluate(coefficients, byte(i+1))
+		}
+	}
+	return shares, nil
+}
+
+// combineShares recovers the secret from shares made by splitSecret with Lagrange interpolation at x = 0
+func combineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, Stoerr("at least two shares are needed")
+	}
+	shareLength := len(shares[0])
+	if shareLength < 2 {
+		return nil, Stoerr("the shares are too short")
+	}
+	xCoordinates := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != shareLength {
+			return nil, Stoerr("the shares are of different lengths")
+		}
+		xCoordinates[i] = share[shareLength-1]
+		if xCoordinates[i] == 0 {
+			return nil, Stoerr("a share has an invalid x coordinate")
+		}
+		for j := 0; j < i; j++ {
+			if xCoordinates[j] == xCoordinates[i] {
+				return nil, Stoerr("the same share was submitted twice")
+			}
+		}
+	}
+
+	secret := make([]byte, shareLength-1)
+	for secretIndex := range secret {
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j := range shares {
+				if i == j {
+					continue
+				}
+				// In GF(2^8) subtraction is addition, so 0 - x_j over x_i - x_j is x_j / (x_i ^ x_j)
+				basis = gf256Multiply(basis, gf256Multiply(xCoordinates[j], gf256Inverse(xCoordinates[i]^xCoordinates[j])))
+			}
+			value ^= gf256Multiply(share[secretIndex], basis)
+		}
+		secret[secretIndex] = value
+	}
+	return secret, nil
+}
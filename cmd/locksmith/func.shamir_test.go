@@ -0,0 +1,151 @@
+package locksmith
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGF256MultiplyInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if product := gf256Multiply(byte(a), gf256Inverse(byte(a))); product != 1 {
+			t.Fatalf("%#x times its inverse is %#x, want 1", a, product)
+		}
+	}
+	// 0x53 and 0xca are inverses in the AES field
+	if product := gf256Multiply(0x53, 0xca); product != 0x01 {
+		t.Fatalf("0x53 * 0xca = %#x, want 0x01", product)
+	}
+}
+
+func TestSplitSecretRoundTrip(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		name      string
+		parts     int
+		threshold int
+	}{
+		{"two of two", 2, 2},
+		{"two of three", 3, 2},
+		{"three of five", 5, 3},
+		{"five of five", 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares, err := splitSecret(secret, tt.parts, tt.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shares) != tt.parts {
+				t.Fatalf("got %d shares, want %d", len(shares), tt.parts)
+			}
+
+			// Every set of threshold shares, in any order, recovers the secret
+			for _, subset := range shareSubsets(tt.parts, tt.threshold) {
+				var picked [][]byte
+				for i := len(subset) - 1; i >= 0; i-- {
+					picked = append(picked, shares[subset[i]])
+				}
+				recovered, err := combineShares(picked)
+				if err != nil {
+					t.Fatalf("shares %v: %v", subset, err)
+				}
+				if !bytes.Equal(recovered, secret) {
+					t.Fatalf("shares %v recovered %x, want %x", subset, recovered, secret)
+				}
+			}
+
+			// All shares recover it too
+			recovered, err := combineShares(shares)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(recovered, secret) {
+				t.Fatalf("all shares recovered %x, want %x", recovered, secret)
+			}
+
+			// Fewer than threshold shares do not
+			if tt.threshold > 2 {
+				for _, subset := range shareSubsets(tt.parts, tt.threshold-1) {
+					var picked [][]byte
+					for _, i := range subset {
+						picked = append(picked, shares[i])
+					}
+					recovered, err := combineShares(picked)
+					if err != nil {
+						t.Fatalf("shares %v: %v", subset, err)
+					}
+					if bytes.Equal(recovered, secret) {
+						t.Fatalf("shares %v below the threshold recovered the secret", subset)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSplitSecretErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		parts     int
+		threshold int
+	}{
+		{"empty secret", []byte{}, 3, 2},
+		{"threshold of one", []byte("secret"), 3, 1},
+		{"threshold above parts", []byte("secret"), 2, 3},
+		{"too many parts", []byte("secret"), 256, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := splitSecret(tt.secret, tt.parts, tt.threshold); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestCombineSharesErrors(t *testing.T) {
+	shares, err := splitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zeroX := append([]byte{}, shares[1]...)
+	zeroX[len(zeroX)-1] = 0
+
+	tests := []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"single share", [][]byte{shares[0]}},
+		{"same share twice", [][]byte{shares[0], shares[0]}},
+		{"different lengths", [][]byte{shares[0], shares[1][1:]}},
+		{"zero x coordinate", [][]byte{shares[0], zeroX}},
+		{"too short", [][]byte{{1}, {2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := combineShares(tt.shares); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+// shareSubsets lists every set of size indexes out of 0 to n-1
+func shareSubsets(n int, size int) [][]int {
+	var subsets [][]int
+	var pick func(start int, subset []int)
+	pick = func(start int, subset []int) {
+		if len(subset) == size {
+			subsets = append(subsets, append([]int{}, subset...))
+			return
+		}
+		for i := start; i < n; i++ {
+			pick(i+1, append(subset, i))
+		}
+	}
+	pick(0, nil)
+	return subsets
+}
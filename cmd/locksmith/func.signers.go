@@ -0,0 +1,152 @@
+package locksmith
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SignerBackend holds the private key a Certificate Authority signs with
+type SignerBackend interface {
+	// HasKey checks if the CA key pair already exists in the backend
+	HasKey() (bool, error)
+	// GenerateKey creates a new CA key pair in the backend and returns the public key
+	GenerateKey(keyAlgorithm string, keySize int, passphrase string) (crypto.PublicKey, error)
+	// Signer opens the CA private key for signing, the passphrase is only used by backends that encrypt keys at rest
+	Signer(passphrase string) (crypto.Signer, error)
+	// DeleteKey removes the CA key pair from the backend, used to clean up after a CA whose creation failed
+	DeleteKey() error
+}
+
+// fileSignerBackend keeps the CA key pair in private/ca.priv.pem and private/ca.pub.pem
+type fileSignerBackend struct {
+	keyPath string
+}
+
+// signerBackendForCA returns the backend the CA at caPath signs with, offline CAs only sign during an unlock session and archived CAs not at all
+func signerBackendForCA(caPath string) (SignerBackend, error) {
+	signerBackend, err := configuredSignerBackendForCA(caPath)
+	if err != nil {
+		return nil, err
+	}
+	archivedPath, err := archivedCAForPath(caPath)
+	if err != nil {
+		return nil, err
+	}
+	if archivedPath != "" {
+		return &archivedSignerBackend{caPath: caPath, backend: signerBackend}, nil
+	}
+	offline, err := isCAOffline(caPath)
+	if err != nil {
+		return nil, err
+	}
+	if offline {
+		return &offlineSignerBackend{caPath: caPath, backend: signerBackend}, nil
+	}
+	return signerBackend, nil
+}
+
+// configuredSignerBackendForCA returns the backend configured for the CA at caPath, defaulting to the file backend
+func configuredSignerBackendForCA(caPath string) (SignerBackend, error) {
+	keyPath := caPath + "/private/ca"
+
+	signerConfig, err := signerConfigForCA(caPath)
+	if err != nil {
+		return nil, err
+	}
+	if signerConfig == nil {
+		return &fileSignerBackend{keyPath: keyPath}, nil
+	}
+
+	switch signerConfig.Backend {
+	case "", "file":
+		return &fileSignerBackend{keyPath: keyPath}, nil
+	case "pkcs11":
+		return newPKCS11SignerBackend(signerConfig.PKCS11, keyPath)
+	}
+	return nil, fmt.Errorf("unknown signer backend '%s' for CA path '%s'", signerConfig.Backend, signerConfig.CAPath)
+}
+
+// signerConfigForCA finds the signer configuration whose ca_path points at the CA directory, if any
+func signerConfigForCA(caPath string) (*SignerConfig, error) {
+	if readConfig == nil {
+		return nil, nil
+	}
+
+	absCAPath, err := filepath.Abs(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, signerConfig := range readConfig.Locksmith.Signers {
+		absConfigPath, err := filepath.Abs(readConfig.Locksmith.PKIRoot + "/roots/" + splitCACNChainToPath(signerConfig.CAPath))
+		if err != nil {
+			return nil, err
+		}
+		if absConfigPath == absCAPath {
+			return &readConfig.Locksmith.Signers[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// HasKey checks for the private key file
+func (backend *fileSignerBackend) HasKey() (bool, error) {
+	return FileExists(backend.keyPath + ".priv.pem")
+}
+
+// GenerateKey creates a key pair and writes it to disk, encrypting the private key if there is a passphrase
+func (backend *fileSignerBackend) GenerateKey(keyAlgorithm string, keySize int, passphrase string) (crypto.PublicKey, error) {
+	privKey, pubKey, err := GenerateKeypair(keyAlgorithm, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := backend.writeKeyPair(privKey, passphrase); err != nil {
+		return nil, err
+	}
+	return pubKey, nil
+}
+
+// DeleteKey removes the key pair files
+func (backend *fileSignerBackend) DeleteKey() error {
+	for _, keyFile := range []string{backend.keyPath + ".priv.pem", backend.keyPath + ".pub.pem"} {
+		if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKeyPair writes a generated or imported key pair to disk, encrypting the private key if there is a passphrase
+func (backend *fileSignerBackend) writeKeyPair(privKey crypto.Signer, passphrase string) error {
+	pemEncodedPrivateKey, encryptedPrivateKeyBytes, err := pemEncodePrivateKey(privKey, passphrase)
+	if err != nil {
+		return err
+	}
+	if passphrase != "" {
+		pemEncodedPrivateKey = encryptedPrivateKeyBytes
+	}
+
+	privKeyFile, pubKeyFile, err := writeRSAKeyPair(pemEncodedPrivateKey, pemEncodePublicKey(privKey.Public()), backend.keyPath)
+	if err != nil {
+		return err
+	}
+	if !privKeyFile || !pubKeyFile {
+		return Stoerr("key pair files already exist at " + backend.keyPath)
+	}
+	return nil
+}
+
+// Signer reads the private key from disk
+func (backend *fileSignerBackend) Signer(passphrase string) (crypto.Signer, error) {
+	privKey, err := ReadPrivateKey(backend.keyPath+".priv.pem", passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if privKey == nil {
+		return nil, Stoerr("no private key at " + backend.keyPath + ".priv.pem")
+	}
+	return privKey, nil
+}
@@ -0,0 +1,9 @@
+//go:build !cgo
+// +build !cgo
+
+package locksmith
+
+// newPKCS11SignerBackend is unavailable as the PKCS #11 module is loaded through cgo
+func newPKCS11SignerBackend(config PKCS11Config, keyPath string) (SignerBackend, error) {
+	return nil, Stoerr("the pkcs11 signer backend needs Locksmith to be built with cgo enabled")
+}
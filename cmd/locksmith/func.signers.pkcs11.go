@@ -0,0 +1,165 @@
+//go:build cgo
+// +build cgo
+
+package locksmith
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// pkcs11SignerBackend keeps the CA private key in a PKCS #11 token, with a copy of the public key in private/ca.pub.pem
+type pkcs11SignerBackend struct {
+	config  PKCS11Config
+	keyPath string
+}
+
+// pkcs11Contexts caches open PKCS #11 modules, a module can only be initialized once per process
+var pkcs11Contexts = map[string]*crypto11.Context{}
+var pkcs11ContextsLock sync.Mutex
+
+// newPKCS11SignerBackend creates a signer backend for a CA key held in a PKCS #11 token
+func newPKCS11SignerBackend(config PKCS11Config, keyPath string) (SignerBackend, error) {
+	return &pkcs11SignerBackend{config: config, keyPath: keyPath}, nil
+}
+
+// context opens the PKCS #11 module and logs in to the token, reusing an already open one
+func (backend *pkcs11SignerBackend) context() (*crypto11.Context, error) {
+	pkcs11ContextsLock.Lock()
+	defer pkcs11ContextsLock.Unlock()
+
+	cacheKey := backend.config.Module + "|" + backend.config.TokenLabel
+	if backend.config.Slot != nil {
+		cacheKey = cacheKey + "|" + strconv.Itoa(*backend.config.Slot)
+	}
+	if ctx, ok := pkcs11Contexts[cacheKey]; ok {
+		return ctx, nil
+	}
+
+	pin := backend.config.Pin
+	if backend.config.PinEnv != "" {
+		pin = os.Getenv(backend.config.PinEnv)
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       backend.config.Module,
+		TokenLabel: backend.config.TokenLabel,
+		SlotNumber: backend.config.Slot,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pkcs11Contexts[cacheKey] = ctx
+	return ctx, nil
+}
+
+// findKey looks up the CA key pair in the token by its label
+func (backend *pkcs11SignerBackend) findKey() (crypto11.Signer, error) {
+	if backend.config.KeyLabel == "" {
+		return nil, Stoerr("pkcs11 signer backend needs a key_label")
+	}
+	ctx, err := backend.context()
+	if err != nil {
+		return nil, err
+	}
+	return ctx.FindKeyPair(nil, []byte(backend.config.KeyLabel))
+}
+
+// HasKey checks the token for a key pair with the configured label
+func (backend *pkcs11SignerBackend) HasKey() (bool, error) {
+	signer, err := backend.findKey()
+	if err != nil {
+		return false, err
+	}
+	return signer != nil, nil
+}
+
+// GenerateKey creates a key pair in the token and writes the public key to disk, Ed25519 is not supported
+func (backend *pkcs11SignerBackend) GenerateKey(keyAlgorithm string, keySize int, passphrase string) (crypto.PublicKey, error) {
+	keyAlgorithm, keySize, err := normalizeKeyAlgorithm(keyAlgorithm, keySize)
+	if err != nil {
+		return nil, err
+	}
+	if backend.config.KeyLabel == "" {
+		return nil, Stoerr("pkcs11 signer backend needs a key_label")
+	}
+	ctx, err := backend.context()
+	if err != nil {
+		return nil, err
+	}
+
+	var signer crypto11.Signer
+	keyID := []byte(backend.config.KeyLabel)
+	switch keyAlgorithm {
+	case "rsa":
+		signer, err = ctx.GenerateRSAKeyPairWithLabel(keyID, keyID, keySize)
+	case "ecdsa":
+		curve, curveErr := ellipticCurveFromKeySize(keySize)
+		if curveErr != nil {
+			return nil, curveErr
+		}
+		signer, err = ctx.GenerateECDSAKeyPairWithLabel(keyID, keyID, curve)
+	default:
+		return nil, fmt.Errorf("key algorithm '%s' is not supported by the pkcs11 signer backend", keyAlgorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := backend.writePublicKey(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// Signer finds the key pair in the token, the passphrase is not used as the token is unlocked with its PIN
+func (backend *pkcs11SignerBackend) Signer(passphrase string) (crypto.Signer, error) {
+	signer, err := backend.findKey()
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, Stoerr("no key pair labeled '" + backend.config.KeyLabel + "' in the pkcs11 token")
+	}
+
+	// Keys made outside of Locksmith won't have a public key on disk yet
+	pubKeyExists, err := FileExists(backend.keyPath + ".pub.pem")
+	if err != nil {
+		return nil, err
+	}
+	if !pubKeyExists {
+		if err := backend.writePublicKey(signer.Public()); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
+
+// DeleteKey destroys the key pair in the token and removes the copy of its public key
+func (backend *pkcs11SignerBackend) DeleteKey() error {
+	signer, err := backend.findKey()
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		if err := signer.Delete(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(backend.keyPath + ".pub.pem"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writePublicKey keeps a copy of the token public key with the CA so it can be read without the token
+func (backend *pkcs11SignerBackend) writePublicKey(pubKey crypto.PublicKey) error {
+	_, err := writePublicKey(pemEncodePublicKey(pubKey), backend.keyPath+".pub.pem")
+	return err
+}
@@ -0,0 +1,141 @@
+package locksmith
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFileSignerBackend(t *testing.T) {
+	backend := &fileSignerBackend{keyPath: t.TempDir() + "/ca"}
+
+	if hasKey, err := backend.HasKey(); err != nil || hasKey {
+		t.Fatalf("got HasKey %v %v before generating a key", hasKey, err)
+	}
+	pubKey, err := backend.GenerateKey("ecdsa", 384, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasKey, err := backend.HasKey(); err != nil || !hasKey {
+		t.Fatalf("got HasKey %v %v after generating a key", hasKey, err)
+	}
+	if _, err := backend.GenerateKey("ecdsa", 384, "s3cr3t"); err == nil {
+		t.Fatal("an existing key pair was overwritten")
+	}
+
+	if _, err := backend.Signer("wrong"); err == nil {
+		t.Fatal("the key opened with the wrong passphrase")
+	}
+	signer, err := backend.Signer("s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pubKey) {
+		t.Fatal("the signer does not hold the generated key")
+	}
+	digest := sha256.Sum256([]byte("locksmith"))
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.DeleteKey(); err != nil {
+		t.Fatal(err)
+	}
+	if hasKey, err := backend.HasKey(); err != nil || hasKey {
+		t.Fatalf("got HasKey %v %v after deleting the key", hasKey, err)
+	}
+	if err := backend.DeleteKey(); err != nil {
+		t.Fatalf("deleting a missing key pair failed: %v", err)
+	}
+}
+
+func TestConfiguredSignerBackendForCA(t *testing.T) {
+	pkiRoot := useTestPKIRoot(t)
+	readConfig.Locksmith.Signers = []SignerConfig{
+		{CAPath: "File Root CA", Backend: "file"},
+		{CAPath: "Vault Root CA", Backend: "vault"},
+	}
+
+	tests := []struct {
+		name      string
+		caPath    string
+		wantError bool
+	}{
+		{"not configured", pkiRoot + "/roots/other-root-ca", false},
+		{"file backend", pkiRoot + "/roots/file-root-ca", false},
+		{"unknown backend", pkiRoot + "/roots/vault-root-ca", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := configuredSignerBackendForCA(tt.caPath)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("got error %v, want error %v", err, tt.wantError)
+			}
+			if err == nil {
+				if fileBackend, ok := backend.(*fileSignerBackend); !ok || fileBackend.keyPath != tt.caPath+"/private/ca" {
+					t.Fatalf("got backend %#v, want the file backend", backend)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateIntermediateCASignsWithParentBackend(t *testing.T) {
+	useTestPKIRoot(t)
+	rootConfig := testCertificateConfiguration("Signer Root CA", "ecdsa")
+	rootConfig.RSAPrivateKeyPassphrase = "r00t"
+	rootPath, rootCert := createTestRootCA(t, rootConfig)
+	intermediateConfig := RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Signer Intermediate CA", "ed25519")}
+
+	// A wrong Signing CA passphrase fails before anything is written
+	intermediateConfig.SigningPrivateKeyPassphrase = "wrong"
+	if created, _, _, err := createNewIntermediateCA(intermediateConfig, rootPath); created || err == nil {
+		t.Fatal("the Intermediate CA was created with the wrong Signing CA passphrase")
+	}
+	if exists, _ := DirectoryExists(rootPath + "/intermed-ca/signer-intermediate-ca"); exists {
+		t.Fatal("a failed Intermediate CA left its directory behind")
+	}
+
+	intermediateConfig.SigningPrivateKeyPassphrase = "r00t"
+	created, messages, intermediateCert, err := createNewIntermediateCA(intermediateConfig, rootPath)
+	if err != nil || !created {
+		t.Fatalf("creating the Intermediate CA failed: %v %v", err, messages)
+	}
+	if err := intermediateCert.CheckSignatureFrom(&rootCert); err != nil {
+		t.Fatal(err)
+	}
+	if serial := readSerialNumberAsInt64Abs(rootPath + "/ca.serial"); serial != intermediateCert.SerialNumber.Int64()+1 {
+		t.Fatalf("got Signing CA serial %d after issuing serial %d", serial, intermediateCert.SerialNumber.Int64())
+	}
+}
+
+func TestCreateIntermediateCAFailureCleansUp(t *testing.T) {
+	useTestPKIRoot(t)
+	rootPath, _ := createTestRootCA(t, testCertificateConfiguration("Cleanup Root CA", "ecdsa"))
+	serialBefore := readSerialNumberAbs(rootPath + "/ca.serial")
+
+	// A certificate of the same name already issued by the Signing CA fails the creation after the key pair was generated
+	existingCertificatePath := rootPath + "/certs/cleanup-intermediate-ca.pem"
+	if err := ioutil.WriteFile(existingCertificatePath, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	created, _, _, err := createNewIntermediateCA(RESTPOSTIntermedCAJSONIn{CertificateConfiguration: testCertificateConfiguration("Cleanup Intermediate CA", "ecdsa")}, rootPath)
+	if created || err == nil {
+		t.Fatal("the Intermediate CA was created over an existing certificate")
+	}
+	if exists, _ := DirectoryExists(rootPath + "/intermed-ca/cleanup-intermediate-ca"); exists {
+		t.Fatal("a failed Intermediate CA left its directory and key pair behind")
+	}
+	if exists, _ := FileExists(rootPath + "/certreqs/cleanup-intermediate-ca.pem"); exists {
+		t.Fatal("a failed Intermediate CA left its request with the Signing CA")
+	}
+	if existing, err := ioutil.ReadFile(existingCertificatePath); err != nil || string(existing) != "existing" {
+		t.Fatal("the existing certificate was removed")
+	}
+	if serialAfter := readSerialNumberAbs(rootPath + "/ca.serial"); serialAfter != serialBefore {
+		t.Fatalf("Signing CA serial moved from %s to %s for a failed Intermediate CA", serialBefore, serialAfter)
+	}
+}
@@ -231,6 +231,30 @@ func ValidateCertificateConfiguration(c CertificateConfiguration) (bool, []strin
 		checkInputErrors = append(checkInputErrors, "Missing OrganizationalUnit field")
 	}
 
+	// Ensure the requested key algorithm and size are supported
+	if _, _, err := normalizeKeyAlgorithm(c.KeyAlgorithm, c.KeySize); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+
+	// Ensure the distribution point URL templates are usable
+	if err := validateDistributionPoints(c.DistributionPoints); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+
+	// Ensure the certificate policies can be encoded
+	if err := validateCertificatePolicies(c.CertificatePolicies); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+
+	// Ensure the path length fits the certificate type
+	if err := validatePathLength(c); err != nil {
+		checkInputError = true
+		checkInputErrors = append(checkInputErrors, err.Error())
+	}
+
 	// Validate certificate types
 	switch c.CertificateType {
 	case "client":
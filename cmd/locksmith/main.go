@@ -1,5 +1,7 @@
 package locksmith
 
+import "flag"
+
 // Func main should be as small as possible and do as little as possible by convention
 func main() {
 	// Generate our config based on the config supplied
@@ -13,6 +15,12 @@ func main() {
 	// Run preflight
 	PreflightSetup()
 
+	// Run a maintenance command instead of the server if one was given
+	if flag.NArg() > 0 {
+		checkAndFail(RunCommand(flag.Args()))
+		return
+	}
+
 	// Run the server
 	cfg.RunHTTPServer()
 }
@@ -1,7 +1,7 @@
 package locksmith
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"math/big"
@@ -31,6 +31,100 @@ type Config struct {
 type ConfigYAML struct {
 	PKIRoot string `yaml:"pki_root"`
 	Server  Server `yaml:"server"`
+
+	// KeyEncryptionFormat is how new passphrase protected private keys are written, pkcs8 (default) or envelope
+	KeyEncryptionFormat string `yaml:"key_encryption_format"`
+
+	// KEKFile is a file holding a base64 encoded 256-bit key encryption key that wraps every private key written, the LOCKSMITH_KEK environment variable is used if unset
+	KEKFile string `yaml:"kek_file"`
+
+	// Signers maps Certificate Authorities to the backend holding their private key, CAs not listed use the file backend
+	Signers []SignerConfig `yaml:"signers"`
+
+	// DistributionPoints sets the CRL, caIssuers and OCSP URLs stamped into the certificates a CA issues
+	DistributionPoints []DistributionPointConfig `yaml:"distribution_points"`
+
+	// CertificateProfiles are custom certificate types that can be requested on top of the built-in ones
+	CertificateProfiles []CertificateProfileConfig `yaml:"certificate_profiles"`
+}
+
+// CertificateProfileConfig sets the key usages stamped into certificates issued with a custom certificate type
+type CertificateProfileConfig struct {
+	// Name is the certificate_type the profile is requested with, it can not be one of the built-in certificate types
+	Name string `yaml:"name"`
+
+	// KeyUsage lists digital_signature, content_commitment, key_encipherment, data_encipherment, key_agreement, encipher_only or decipher_only
+	KeyUsage []string `yaml:"key_usage"`
+
+	// ExtKeyUsage lists server_auth, client_auth, code_signing, email_protection, time_stamping, ocsp_signing, any, or dotted OIDs
+	ExtKeyUsage []string `yaml:"ext_key_usage"`
+
+	// CriticalExtKeyUsage marks the extended key usage extension critical
+	CriticalExtKeyUsage bool `yaml:"critical_ext_key_usage"`
+
+	// RequireEmailAddress refuses CSRs without an email address
+	RequireEmailAddress bool `yaml:"require_email_address"`
+}
+
+// certificateProfile is what a certificate type stamps into the certificates issued with it
+type certificateProfile struct {
+	KeyUsage x509.KeyUsage
+
+	// RSAKeyEncipherment adds key encipherment for RSA keys, which can transport keys where EC keys can not
+	RSAKeyEncipherment bool
+
+	ExtKeyUsage         []string
+	CriticalExtKeyUsage bool
+
+	// OCSPNoCheck tells relying parties not to check the revocation of an OCSP responder certificate
+	OCSPNoCheck bool
+
+	RequireEmailAddress bool
+	IsCA                bool
+}
+
+// DistributionPointConfig sets the URL templates stamped into every certificate a Certificate Authority issues
+// Templates can use the {ca_slug}, {ca_slug_path} and {ca_common_name} placeholders of the issuing CA
+type DistributionPointConfig struct {
+	// CAPath is the CommonName or slugged CA Path, eg "Example Root CA/Example Signing CA", an entry without one applies to every CA not listed
+	CAPath string `yaml:"ca_path" json:"-"`
+
+	// CRLURLs are the CRL Distribution Points
+	CRLURLs []string `yaml:"crl_urls" json:"crl_urls,omitempty"`
+
+	// IssuerURLs are the caIssuers Authority Information Access URLs the issuing CA certificate can be downloaded from
+	IssuerURLs []string `yaml:"issuer_urls" json:"issuer_urls,omitempty"`
+
+	// OCSPURLs are the OCSP Authority Information Access URLs
+	OCSPURLs []string `yaml:"ocsp_urls" json:"ocsp_urls,omitempty"`
+}
+
+// SignerConfig sets the signer backend for a Certificate Authority
+type SignerConfig struct {
+	// CAPath is the CommonName or slugged CA Path, eg "Example Root CA/Example Signing CA"
+	CAPath string `yaml:"ca_path"`
+
+	// Backend is either file (default) or pkcs11
+	Backend string `yaml:"backend"`
+
+	PKCS11 PKCS11Config `yaml:"pkcs11"`
+}
+
+// PKCS11Config configures the PKCS #11 token a CA private key is held in
+type PKCS11Config struct {
+	// Module is the path to the PKCS #11 library, eg /usr/lib/softhsm/libsofthsm2.so
+	Module string `yaml:"module"`
+
+	// TokenLabel or Slot selects the token in the module
+	TokenLabel string `yaml:"token_label"`
+	Slot       *int   `yaml:"slot"`
+
+	// Pin is the token user PIN, or PinEnv the name of an environment variable holding it
+	Pin    string `yaml:"pin"`
+	PinEnv string `yaml:"pin_env"`
+
+	// KeyLabel is the CKA_LABEL of the CA key pair in the token
+	KeyLabel string `yaml:"key_label"`
 }
 
 // Server configures the HTTP server
@@ -61,6 +155,18 @@ type Server struct {
 	} `yaml:"timeout"`
 }
 
+// keyEnvelopeHeader describes how an encrypted private key was wrapped
+// Param1-3 are the KDF cost parameters: time, memory (KiB) and threads for argon2id, or N, r and p for scrypt
+type keyEnvelopeHeader struct {
+	Version uint8
+	KDF     uint8
+	Param1  uint32
+	Param2  uint32
+	Param3  uint8
+	Salt    []byte
+	Nonce   []byte
+}
+
 // CertificateAuthorityPaths returns all the default paths generated by a new CA
 type CertificateAuthorityPaths struct {
 	RootCAPath               string
@@ -103,18 +209,33 @@ CertificateConfiguration is a struct to pass Certificate Config Information into
 
 `RSAPrivateKeyPassphrase` is optional - this is used to secure the key if generated via PKI
 
+`KeyAlgorithm` is optional - the algorithm of the key generated via PKI.  Options: rsa|ecdsa|ed25519, defaults to rsa
+
+`KeySize` is optional - the RSA modulus size (2048|3072|4096, default 4096) or ECDSA curve size (256|384|521, default 256) of the key generated via PKI, Ed25519 keys have a fixed size
+
 `SANData` is a SANData object
 
 `CertificateType` is a string representing what type of certificate is being requested or generated and is used in validation checks.  Options: server|client|authority|authority-no-subs
+
+`DistributionPoints` is optional - when creating a CA, the CRL, caIssuers and OCSP URL templates stamped into every certificate it issues
+
+`KeyCeremony` is optional - when creating a Root CA, splits a generated key passphrase between custodians instead of using `RSAPrivateKeyPassphrase`
 */
 type CertificateConfiguration struct {
 	Subject                 CertificateConfigurationSubject `json:"subject"`
 	ExpirationDate          []int                           `json:"expiration_date,omitempty"`
 	RSAPrivateKey           string                          `json:"rsa_private_key,omitempty"`
 	RSAPrivateKeyPassphrase string                          `json:"rsa_private_key_passphrase,omitempty"`
+	KeyAlgorithm            string                          `json:"key_algorithm,omitempty"`
+	KeySize                 int                             `json:"key_size,omitempty"`
 	SerialNumber            string                          `json:"serial_number,omitempty"`
 	SANData                 SANData                         `json:"san_data,omitempty"`
 	CertificateType         string                          `json:"certificate_type,omitempty"`
+	DistributionPoints      *DistributionPointConfig        `json:"distribution_points,omitempty"`
+	MaxPathLength           *int                            `json:"max_path_length,omitempty"`
+	CertificatePolicies     *CertificatePolicyConfig        `json:"certificate_policies,omitempty"`
+	Offline                 bool                            `json:"offline,omitempty"`
+	KeyCeremony             *KeyCeremonyConfig              `json:"key_ceremony,omitempty"`
 }
 
 // CertificateConfigurationSubject is simply a redefinition of pkix.Name
@@ -129,6 +250,49 @@ type CertificateConfigurationSubject struct {
 	PostalCode         []string `json:"postal_code,omitempty"`
 }
 
+// NameConstraints provides the RFC 5280 name constraints of a CA certificate, IP ranges are in CIDR notation
+type NameConstraints struct {
+	Critical                bool     `json:"critical,omitempty"`
+	PermittedDNSDomains     []string `json:"permitted_dns_domains,omitempty"`
+	ExcludedDNSDomains      []string `json:"excluded_dns_domains,omitempty"`
+	PermittedIPRanges       []string `json:"permitted_ip_ranges,omitempty"`
+	ExcludedIPRanges        []string `json:"excluded_ip_ranges,omitempty"`
+	PermittedEmailAddresses []string `json:"permitted_email_addresses,omitempty"`
+	ExcludedEmailAddresses  []string `json:"excluded_email_addresses,omitempty"`
+	PermittedURIDomains     []string `json:"permitted_uri_domains,omitempty"`
+	ExcludedURIDomains      []string `json:"excluded_uri_domains,omitempty"`
+}
+
+// CertificatePolicyConfig provides the RFC 5280 certificate policies of a CA, which are stamped into its CA Certificate and every certificate it issues
+// Policy mappings and the policy constraints only apply to the CA Certificate
+type CertificatePolicyConfig struct {
+	Policies              []CertificatePolicy `json:"policies,omitempty"`
+	PolicyMappings        []PolicyMapping     `json:"policy_mappings,omitempty"`
+	RequireExplicitPolicy *int                `json:"require_explicit_policy,omitempty"`
+	InhibitPolicyMapping  *int                `json:"inhibit_policy_mapping,omitempty"`
+	InhibitAnyPolicy      *int                `json:"inhibit_any_policy,omitempty"`
+}
+
+// CertificatePolicy is a certificate policy OID with its CPS URI and user notice qualifiers
+type CertificatePolicy struct {
+	OID         string       `json:"oid"`
+	CPSURIs     []string     `json:"cps_uris,omitempty"`
+	UserNotices []UserNotice `json:"user_notices,omitempty"`
+}
+
+// UserNotice is the user notice qualifier of a certificate policy, a notice reference is an organization with its notice numbers
+type UserNotice struct {
+	Organization  string `json:"organization,omitempty"`
+	NoticeNumbers []int  `json:"notice_numbers,omitempty"`
+	ExplicitText  string `json:"explicit_text,omitempty"`
+}
+
+// PolicyMapping maps a policy of the issuing CA's domain to an equivalent policy of the subject CA's domain
+type PolicyMapping struct {
+	IssuerDomainPolicy  string `json:"issuer_domain_policy"`
+	SubjectDomainPolicy string `json:"subject_domain_policy"`
+}
+
 // SANData provides a collection of SANData for a certificate
 type SANData struct {
 	IPAddresses    []net.IP `json:"ip_addresses,omitempty"`
@@ -150,6 +314,43 @@ type pkcs1PublicKey struct {
 	E int
 }
 
+// jsonWebKey reflects the members of a RFC 7517 JSON Web Key for RSA, EC and OKP (Ed25519) keys
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	X       string `json:"x,omitempty"`
+	Y       string `json:"y,omitempty"`
+	D       string `json:"d,omitempty"`
+	P       string `json:"p,omitempty"`
+	Q       string `json:"q,omitempty"`
+	DP      string `json:"dp,omitempty"`
+	DQ      string `json:"dq,omitempty"`
+	QI      string `json:"qi,omitempty"`
+}
+
+// encryptedPrivateKeyInfo reflects the ASN.1 structure of a RFC 5958 encrypted PKCS #8 private key
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params reflects the ASN.1 structure of the RFC 8018 PBES2 parameters
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params reflects the ASN.1 structure of the RFC 8018 PBKDF2 parameters, the PRF defaults to hmacWithSHA1 when absent
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
 /*====================================================================================================
   API - General
 ====================================================================================================*/
@@ -167,15 +368,19 @@ type ReturnGenericMessage struct {
 
 // RESTGETKeyStoresJSONReturn handles the data returned by the GET /keystores endpoint for key store listings
 type RESTGETKeyStoresJSONReturn struct {
-	Status    string   `json:"status"`
-	Errors    []string `json:"errors"`
-	Messages  []string `json:"messages"`
-	KeyStores []string `json:"key_stores,omitempty"`
+	Status    string             `json:"status"`
+	Errors    []string           `json:"errors"`
+	Messages  []string           `json:"messages"`
+	KeyStores []string           `json:"key_stores,omitempty"`
+	Metadata  []KeyStoreMetadata `json:"metadata,omitempty"`
 }
 
 // RESTPOSTKeyStoresJSONIn handles the data returned by the GET /keystores endpoint for key store listings
 type RESTPOSTKeyStoresJSONIn struct {
-	KeyStore string `json:"key_store_name"`
+	KeyStore    string            `json:"key_store_name"`
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 }
 
 // RESTPOSTKeyStoresJSONReturn handles the data returned by the GET /keystores endpoint for key store listings
@@ -192,18 +397,23 @@ type RESTPOSTKeyStoresJSONReturn struct {
 
 // RESTGETKeyPairsJSONReturn handles the data returned by the GET /keys endpoint for key pair listings
 type RESTGETKeyPairsJSONReturn struct {
-	Status   string   `json:"status"`
-	Errors   []string `json:"errors"`
-	Messages []string `json:"messages"`
-	KeyPairs []string `json:"key_pairs,omitempty"`
+	Status   string            `json:"status"`
+	Errors   []string          `json:"errors"`
+	Messages []string          `json:"messages"`
+	KeyPairs []string          `json:"key_pairs,omitempty"`
+	Metadata []KeyPairMetadata `json:"metadata,omitempty"`
 }
 
 // RESTGETKeyPairJSONReturn handles the data returned by the GET /keys endpoint for specific key pair id data
 type RESTGETKeyPairJSONReturn struct {
-	Status   string   `json:"status"`
-	Errors   []string `json:"errors"`
-	Messages []string `json:"messages"`
-	KeyPair  KeyPair  `json:"key_pair,omitempty"`
+	Status   string           `json:"status"`
+	Errors   []string         `json:"errors"`
+	Messages []string         `json:"messages"`
+	KeyPair  KeyPair          `json:"key_pair,omitempty"`
+	Format   string           `json:"format,omitempty"`
+	KeyID    string           `json:"kid,omitempty"`
+	Version  int              `json:"version,omitempty"`
+	Versions []KeyPairVersion `json:"versions,omitempty"`
 }
 
 // KeyPair combines a string for a Public and Private Key Base64 PEM
@@ -214,8 +424,43 @@ type KeyPair struct {
 
 // RealKeyPair combines a string for a Public and Private Key objects
 type RealKeyPair struct {
-	PublicKey  *rsa.PublicKey  `json:"public_key,omitempty"`
-	PrivateKey *rsa.PrivateKey `json:"private_key,omitempty"`
+	PublicKey  crypto.PublicKey `json:"public_key,omitempty"`
+	PrivateKey crypto.Signer    `json:"private_key,omitempty"`
+}
+
+// KeyStoreMetadata is kept in the metadata.json of a key store
+type KeyStoreMetadata struct {
+	ID           string            `json:"key_store_id"`
+	Description  string            `json:"description,omitempty"`
+	Owner        string            `json:"owner,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	PreviousIDs  []string          `json:"previous_ids,omitempty"`
+	KeyPairCount int               `json:"key_pair_count"`
+}
+
+// KeyPairMetadata is kept in the metadata.json of a key store key pair
+// The ID, key algorithm, and fingerprint are refreshed from the current version whenever it is read
+type KeyPairMetadata struct {
+	ID             string            `json:"key_pair_id"`
+	Description    string            `json:"description,omitempty"`
+	Owner          string            `json:"owner,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	KeyAlgorithm   string            `json:"key_algorithm"`
+	Fingerprint    string            `json:"fingerprint"`
+	PreviousIDs    []string          `json:"previous_ids,omitempty"`
+	CurrentVersion int               `json:"current_version"`
+	Versions       []KeyPairVersion  `json:"versions"`
+}
+
+// KeyPairVersion records when a version of a key pair was created and retired
+type KeyPairVersion struct {
+	Version      int        `json:"version"`
+	KeyAlgorithm string     `json:"key_algorithm"`
+	KeySize      int        `json:"key_size"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RetiredAt    *time.Time `json:"retired_at,omitempty"`
 }
 
 // RESTPOSTNewKeyPairIn organizes the data required for creating a new Key Pair
@@ -224,6 +469,23 @@ type RESTPOSTNewKeyPairIn struct {
 	KeyStoreID      string `json:"key_store_id,omitempty"`
 	Passphrase      string `json:"passphrase,omitempty"`
 	StorePrivateKey bool   `json:"store_private_key"`
+	KeyAlgorithm    string `json:"key_algorithm,omitempty"`
+	KeySize         int    `json:"key_size,omitempty"`
+	// The import fields take an existing key pair as base64 encoded PEM, DER or OpenSSH instead of generating one
+	ImportPrivateKey string `json:"import_private_key,omitempty"`
+	ImportPublicKey  string `json:"import_public_key,omitempty"`
+	ImportPassphrase string `json:"import_passphrase,omitempty"`
+	// Metadata recorded with the key pair
+	Description string            `json:"description,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// RESTPOSTRenameJSONIn organizes the data required for renaming a Key Store or Key Pair
+type RESTPOSTRenameJSONIn struct {
+	KeyStoreID string `json:"key_store_id,omitempty"`
+	KeyPairID  string `json:"key_pair_id,omitempty"`
+	NewID      string `json:"new_id"`
 }
 
 // RESTPOSTNewKeyPairReturn handles the data returned by the POST /keys endpoint for generated key pairs
@@ -233,6 +495,7 @@ type RESTPOSTNewKeyPairReturn struct {
 	Messages  []string `json:"messages"`
 	KeyPair   KeyPair  `json:"key_pair,omitempty"`
 	KeyPairID string   `json:"key_pair_id,omitempty"`
+	Version   int      `json:"version,omitempty"`
 }
 
 /*====================================================================================================
@@ -249,10 +512,11 @@ type ReturnGetRoots struct {
 
 // ReturnPostRoots - POST /roots, handles the returned data from creating a Root CA
 type ReturnPostRoots struct {
-	Status   string   `json:"status"`
-	Errors   []string `json:"errors"`
-	Messages []string `json:"messages"`
-	Root     RootInfo `json:"root"`
+	Status    string     `json:"status"`
+	Errors    []string   `json:"errors"`
+	Messages  []string   `json:"messages"`
+	Root      RootInfo   `json:"root"`
+	KeyShares []KeyShare `json:"key_shares,omitempty"`
 }
 
 // RootInfo provides general root informations
@@ -278,14 +542,192 @@ type CAIndex struct {
 
 // RESTGETAuthorityJSONReturn handles the data returned by the GET /authority endpoint
 type RESTGETAuthorityJSONReturn struct {
+	Status              string                   `json:"status"`
+	Errors              []string                 `json:"errors"`
+	Messages            []string                 `json:"messages"`
+	Slug                string                   `json:"slug"`
+	CertificatePEM      string                   `json:"certificate_pem"`
+	CertificateInfo     *x509.Certificate        `json:"certificate_information"`
+	CertificatePolicies *CertificatePolicyConfig `json:"certificate_policies,omitempty"`
+	Archived            bool                     `json:"archived,omitempty"`
+}
+
+// RESTPOSTArchiveCAJSONIn handles the data required by the POST /authority/archive endpoint
+type RESTPOSTArchiveCAJSONIn struct {
+	CommonNamePath string `json:"cn_path,omitempty"`
+	SlugPath       string `json:"slug_path,omitempty"`
+}
+
+// RESTDELETEAuthorityJSONReturn handles the data returned by the DELETE /authority endpoint, the CA directory is moved to the archive path
+type RESTDELETEAuthorityJSONReturn struct {
+	Status      string   `json:"status"`
+	Errors      []string `json:"errors"`
+	Messages    []string `json:"messages"`
+	Slug        string   `json:"slug"`
+	ArchivePath string   `json:"archive_path"`
+}
+
+// RESTPOSTRenewCAJSONIn handles the data required by the POST /authority/renew and /authority/rekey endpoints
+// The CA Path points at the CA being renewed, the signing passphrase opens the key of its parent CA and is not used for top level CAs
+type RESTPOSTRenewCAJSONIn struct {
+	CommonNamePath              string   `json:"cn_path,omitempty"`
+	SlugPath                    string   `json:"slug_path,omitempty"`
+	ExpirationDate              []int    `json:"expiration_date"`
+	RSAPrivateKeyPassphrase     string   `json:"rsa_private_key_passphrase,omitempty"`
+	SigningPrivateKeyPassphrase string   `json:"signing_key_passphrase,omitempty"`
+	KeyAlgorithm                string   `json:"key_algorithm,omitempty"`
+	KeySize                     int      `json:"key_size,omitempty"`
+	NewRSAPrivateKeyPassphrase  string   `json:"new_rsa_private_key_passphrase,omitempty"`
+	KeyShares                   []string `json:"key_shares,omitempty"`
+	SigningKeyShares            []string `json:"signing_key_shares,omitempty"`
+}
+
+// RESTPOSTRenewCAJSONReturn handles the data returned by the POST /authority/renew and /authority/rekey endpoints
+// Certificates are base64 encoded PEM, the cross certificates are only returned on rekey
+type RESTPOSTRenewCAJSONReturn struct {
+	Status          string            `json:"status"`
+	Errors          []string          `json:"errors"`
+	Messages        []string          `json:"messages"`
+	Slug            string            `json:"slug"`
+	ArchivedVersion int               `json:"archived_version"`
+	CertificatePEM  string            `json:"certificate_pem"`
+	CertificateInfo *x509.Certificate `json:"certificate_information"`
+	OldWithNewPEM   string            `json:"old_with_new_pem,omitempty"`
+	NewWithOldPEM   string            `json:"new_with_old_pem,omitempty"`
+}
+
+// CARenewal holds the certificates created when a CA is renewed or rekeyed, and the version its previous certificate was archived as
+type CARenewal struct {
+	Version     int
+	Certificate *x509.Certificate
+	OldWithNew  *x509.Certificate
+	NewWithOld  *x509.Certificate
+}
+
+// RESTPOSTCrossSignJSONIn handles the data required by the POST /authority/cross-sign endpoint
+// The CA Path points at the signing CA, the CA being certified is either another Locksmith CA at the subject CA Path or an uploaded base64 encoded CA Certificate
+type RESTPOSTCrossSignJSONIn struct {
+	CommonNamePath              string                   `json:"cn_path,omitempty"`
+	SlugPath                    string                   `json:"slug_path,omitempty"`
+	SigningPrivateKeyPassphrase string                   `json:"signing_key_passphrase,omitempty"`
+	SubjectCommonNamePath       string                   `json:"subject_cn_path,omitempty"`
+	SubjectSlugPath             string                   `json:"subject_slug_path,omitempty"`
+	Certificate                 string                   `json:"certificate,omitempty"`
+	ExpirationDate              []int                    `json:"expiration_date"`
+	MaxPathLength               *int                     `json:"max_path_length,omitempty"`
+	NameConstraints             *NameConstraints         `json:"name_constraints,omitempty"`
+	CertificatePolicies         *CertificatePolicyConfig `json:"certificate_policies,omitempty"`
+	SigningKeyShares            []string                 `json:"signing_key_shares,omitempty"`
+}
+
+// RESTPOSTCrossSignJSONReturn handles the data returned by the POST /authority/cross-sign endpoint
+type RESTPOSTCrossSignJSONReturn struct {
 	Status          string            `json:"status"`
 	Errors          []string          `json:"errors"`
 	Messages        []string          `json:"messages"`
 	Slug            string            `json:"slug"`
+	CertificateID   string            `json:"certificate_id"`
 	CertificatePEM  string            `json:"certificate_pem"`
 	CertificateInfo *x509.Certificate `json:"certificate_information"`
 }
 
+// RESTPOSTUnlockCAJSONIn handles the data required by the POST /authority/unlock endpoint
+// Duration is a Go duration string, eg "15m", and defaults to 15 minutes
+type RESTPOSTUnlockCAJSONIn struct {
+	CommonNamePath          string   `json:"cn_path,omitempty"`
+	SlugPath                string   `json:"slug_path,omitempty"`
+	RSAPrivateKeyPassphrase string   `json:"rsa_private_key_passphrase,omitempty"`
+	Duration                string   `json:"duration,omitempty"`
+	KeyShares               []string `json:"key_shares,omitempty"`
+}
+
+// RESTPOSTLockCAJSONIn handles the data required by the POST /authority/lock endpoint
+type RESTPOSTLockCAJSONIn struct {
+	CommonNamePath string `json:"cn_path,omitempty"`
+	SlugPath       string `json:"slug_path,omitempty"`
+}
+
+// RESTUnlockSessionJSONReturn handles the data returned by the /authority/unlock and /authority/lock endpoints
+type RESTUnlockSessionJSONReturn struct {
+	Status   string         `json:"status"`
+	Errors   []string       `json:"errors"`
+	Messages []string       `json:"messages"`
+	Slug     string         `json:"slug"`
+	Offline  bool           `json:"offline"`
+	Session  *UnlockSession `json:"session,omitempty"`
+}
+
+// UnlockSession is a time-limited window in which an offline CA can sign, with every signature it made
+type UnlockSession struct {
+	ID        string               `json:"session_id"`
+	OpenedAt  time.Time            `json:"opened_at"`
+	ExpiresAt time.Time            `json:"expires_at"`
+	ClosedAt  *time.Time           `json:"closed_at,omitempty"`
+	Signings  []UnlockSessionEvent `json:"signings"`
+}
+
+// UnlockSessionEvent is an entry of the unlock session log of an offline CA, the event is opened, signed, locked or expired
+type UnlockSessionEvent struct {
+	Time      time.Time  `json:"time"`
+	SessionID string     `json:"session_id"`
+	Event     string     `json:"event"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	Digest    string     `json:"digest,omitempty"`
+}
+
+// KeyCeremonyConfig names the custodians a Root CA key passphrase is split between, any Threshold of them open the key together
+type KeyCeremonyConfig struct {
+	Threshold  int      `json:"threshold"`
+	Custodians []string `json:"custodians"`
+}
+
+// KeyShare is the share of the key passphrase handed to a custodian, base64 encoded
+type KeyShare struct {
+	Custodian string `json:"custodian"`
+	Share     string `json:"share"`
+}
+
+// KeyCeremony is kept in the CA directory, the shares themselves are only known to the custodians
+type KeyCeremony struct {
+	Threshold  int                    `json:"threshold"`
+	Custodians []KeyCeremonyCustodian `json:"custodians"`
+	HeldAt     time.Time              `json:"held_at"`
+}
+
+// KeyCeremonyCustodian identifies the share of a custodian by its SHA-256 fingerprint
+type KeyCeremonyCustodian struct {
+	Name             string `json:"name"`
+	ShareFingerprint string `json:"share_fingerprint"`
+}
+
+// KeyCeremonyEvent is an entry of the key ceremony transcript of a Root CA, the event is held, opened or refused
+type KeyCeremonyEvent struct {
+	Time              time.Time              `json:"time"`
+	Event             string                 `json:"event"`
+	Operation         string                 `json:"operation,omitempty"`
+	Subject           string                 `json:"subject,omitempty"`
+	Threshold         int                    `json:"threshold,omitempty"`
+	Custodians        []KeyCeremonyCustodian `json:"custodians,omitempty"`
+	CertificateSHA256 string                 `json:"certificate_sha256,omitempty"`
+	Reason            string                 `json:"reason,omitempty"`
+}
+
+// RESTPOSTImportCAJSONIn handles the data required by the POST /authority/import endpoint
+// Certificate, PrivateKey and Chain are base64 encoded, the parent CA Path is left empty to import a top level CA
+type RESTPOSTImportCAJSONIn struct {
+	CommonNamePath          string                   `json:"cn_path,omitempty"`
+	SlugPath                string                   `json:"slug_path,omitempty"`
+	Certificate             string                   `json:"certificate"`
+	PrivateKey              string                   `json:"private_key"`
+	PrivateKeyPassphrase    string                   `json:"private_key_passphrase,omitempty"`
+	Chain                   string                   `json:"chain,omitempty"`
+	RSAPrivateKeyPassphrase string                   `json:"rsa_private_key_passphrase,omitempty"`
+	NextSerial              int64                    `json:"next_serial,omitempty"`
+	NextCRLNumber           int64                    `json:"next_crl_number,omitempty"`
+	DistributionPoints      *DistributionPointConfig `json:"distribution_points,omitempty"`
+}
+
 /*====================================================================================================
   API - Intermediate CAs
 ====================================================================================================*/
@@ -310,6 +752,47 @@ type RESTPOSTIntermedCAJSONIn struct {
 	SlugPath                    string                   `json:"slug_path,omitempty"`
 	CertificateConfiguration    CertificateConfiguration `json:"certificate_config"`
 	SigningPrivateKeyPassphrase string                   `json:"rsa_private_key_passphrase,omitempty"`
+	NameConstraints             *NameConstraints         `json:"name_constraints,omitempty"`
+	SigningKeyShares            []string                 `json:"signing_key_shares,omitempty"`
+}
+
+/*====================================================================================================
+  API - CA Hierarchy
+====================================================================================================*/
+
+// RESTGETHierarchyJSONReturn handles the data returned by the GET /hierarchy endpoint
+type RESTGETHierarchyJSONReturn struct {
+	Status    string            `json:"status"`
+	Errors    []string          `json:"errors"`
+	Messages  []string          `json:"messages"`
+	Hierarchy []CAHierarchyNode `json:"hierarchy"`
+}
+
+// CAHierarchyNode is a CA in the hierarchy tree with the Intermediate CAs under it
+type CAHierarchyNode struct {
+	Slug           string                       `json:"slug"`
+	SlugPath       string                       `json:"slug_path"`
+	CommonNamePath string                       `json:"cn_path"`
+	CommonName     string                       `json:"common_name"`
+	Subject        string                       `json:"subject"`
+	SerialNumber   string                       `json:"serial_number"`
+	NotBefore      *time.Time                   `json:"not_before,omitempty"`
+	NotAfter       *time.Time                   `json:"not_after,omitempty"`
+	KeyAlgorithm   string                       `json:"key_algorithm"`
+	KeySize        int                          `json:"key_size"`
+	MaxPathLength  *int                         `json:"max_path_length,omitempty"`
+	Certificates   CAHierarchyCertificateCounts `json:"certificates"`
+	Status         string                       `json:"status"`
+	Errors         []string                     `json:"errors,omitempty"`
+	Intermediates  []CAHierarchyNode            `json:"intermediate_certificate_authorities"`
+}
+
+// CAHierarchyCertificateCounts counts the certificates a CA issued by their state in its CA Index
+type CAHierarchyCertificateCounts struct {
+	Total   int `json:"total"`
+	Valid   int `json:"valid"`
+	Expired int `json:"expired"`
+	Revoked int `json:"revoked"`
 }
 
 /*====================================================================================================
@@ -371,12 +854,31 @@ type RESTGETCertificatesJSONReturn struct {
 
 // RESTGETCertificateInformationJSONReturn handles the data returned by the GET /certificate endpoint
 type RESTGETCertificateInformationJSONReturn struct {
-	Status          string            `json:"status"`
-	Errors          []string          `json:"errors"`
-	Messages        []string          `json:"messages"`
-	Slug            string            `json:"slug"`
-	CertificatePEM  string            `json:"certificate_pem"`
-	CertificateInfo *x509.Certificate `json:"certificate_information"`
+	Status              string                   `json:"status"`
+	Errors              []string                 `json:"errors"`
+	Messages            []string                 `json:"messages"`
+	Slug                string                   `json:"slug"`
+	CertificatePEM      string                   `json:"certificate_pem"`
+	CertificateInfo     *x509.Certificate        `json:"certificate_information"`
+	CertificatePolicies *CertificatePolicyConfig `json:"certificate_policies,omitempty"`
+}
+
+// RESTPOSTCertificatePKCS12JSONIn handles the data required by the POST /certificate/pkcs12 endpoint
+type RESTPOSTCertificatePKCS12JSONIn struct {
+	CommonNamePath string `json:"cn_path,omitempty"`
+	SlugPath       string `json:"slug_path,omitempty"`
+	CertificateID  string `json:"certificate_id"`
+	Passphrase     string `json:"passphrase,omitempty"`
+	ExportPassword string `json:"export_password"`
+}
+
+// RESTPOSTCertificatePKCS12JSONReturn handles the data returned by the POST /certificate/pkcs12 endpoint
+type RESTPOSTCertificatePKCS12JSONReturn struct {
+	Status   string   `json:"status"`
+	Errors   []string `json:"errors"`
+	Messages []string `json:"messages"`
+	Slug     string   `json:"slug"`
+	PKCS12   string   `json:"pkcs12"`
 }
 
 // RESTPOSTCertificateJSONIn handles the data required by the POST /certificate endpoint
@@ -386,6 +888,7 @@ type RESTPOSTCertificateJSONIn struct {
 	SigningPrivateKeyPassphrase string                  `json:"signing_key_passphrase,omitempty"`
 	CertificateRequestInput     CertificateRequestInput `json:"csr_input"`
 	ExpirationDate              []int                   `json:"expiration_date,omitempty"`
+	SigningKeyShares            []string                `json:"signing_key_shares,omitempty"`
 }
 
 // CertificateRequestInput provides a set of possible input sources for a CSR in Certificate Generation
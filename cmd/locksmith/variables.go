@@ -1,6 +1,10 @@
 package locksmith
 
-import "encoding/asn1"
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+)
 
 var locksmithVersion string = "0.0.1"
 var readConfig *Config
@@ -10,6 +14,188 @@ var BUFFERSIZE int64 = 4096 // 4096 bits = default page size on OSX
 
 const serverUA = "Locksmith/0.0.1"
 
+// Versioned envelope used for passphrase encrypted private keys
+// The header carries the KDF id and its parameters, a random salt, and the AES-GCM nonce
+const (
+	keyEnvelopeMagic   = "LKSM"
+	keyEnvelopeVersion = 1
+
+	kdfArgon2id = 1
+	kdfScrypt   = 2
+)
+
+// keyEnvelopeKDF is the KDF used when encrypting new keys, existing envelopes record their own
+var keyEnvelopeKDF uint8 = kdfArgon2id
+
+// KDF cost parameters for new key envelopes, and upper limits accepted when reading envelopes back in
+var (
+	argon2idTime      uint32 = 3
+	argon2idMemory    uint32 = 64 * 1024 // KiB
+	argon2idThreads   uint8  = 4
+	maxArgon2idTime   uint32 = 16
+	maxArgon2idMemory uint32 = 1024 * 1024 // KiB
+
+	scryptN    uint32 = 1 << 15
+	scryptR    uint32 = 8
+	scryptP    uint8  = 1
+	maxScryptN uint32 = 1 << 20
+	maxScryptR uint32 = 32
+	maxScryptP uint8  = 16
+)
+
+// Master key encryption key that wraps all private key files when configured, loaded by PreflightSetup
+var masterKEK []byte
+
+// kekEnvVar is read for a base64 encoded KEK when no kek_file is configured
+const kekEnvVar = "LOCKSMITH_KEK"
+
+// kekWrappedPEMType is the PEM block type of a private key file wrapped with the KEK
+const kekWrappedPEMType = "LOCKSMITH WRAPPED KEY"
+
+// Formats that passphrase protected private keys can be written in
+// pkcs8 is a standard RFC 5958 ENCRYPTED PRIVATE KEY PEM, envelope is the Locksmith specific base64 key envelope
+const (
+	keyEncryptionFormatPKCS8    = "pkcs8"
+	keyEncryptionFormatEnvelope = "envelope"
+)
+
+// PBKDF2 iteration count for new encrypted PKCS #8 keys, and the upper limit accepted when reading keys back in
+var (
+	pkcs8PBKDF2Iterations    = 600000
+	maxPKCS8PBKDF2Iterations = 10000000
+)
+
+// caChainFileName holds the certificates above a top level CA that was imported with its chain
+const caChainFileName = "ca.chain.pem"
+
+// distributionPointsFileName holds the URL templates set for a CA when it was created
+const distributionPointsFileName = "distribution-points.json"
+
+// offlineCAFileName marks a CA as offline, it only signs during an unlock session
+const offlineCAFileName = "ca.offline"
+
+// unlockSessionLogFileName records every unlock session of an offline CA and each signature made during it
+const unlockSessionLogFileName = "unlock-sessions.log"
+
+// How long an unlock session of an offline CA lasts when no duration is requested, and the longest one that can be requested
+const (
+	defaultUnlockSessionDuration = 15 * time.Minute
+	maxUnlockSessionDuration     = 8 * time.Hour
+)
+
+// archivedCAFileName marks a CA as archived, it keeps serving its chain and CRL but no longer signs
+const archivedCAFileName = "ca.archived"
+
+// keyCeremonyFileName holds the threshold and custodians of a Root CA created in a key ceremony
+const keyCeremonyFileName = "key-ceremony.json"
+
+// keyCeremonyTranscriptFileName records the key ceremony of a Root CA and every time its custodians opened the key
+const keyCeremonyTranscriptFileName = "key-ceremony.log"
+
+// certificatePoliciesFileName holds the certificate policies set for a CA when it was created
+const certificatePoliciesFileName = "certificate-policies.json"
+
+// RFC 5280 certificate policy extensions and qualifiers
+var (
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidExtensionPolicyMappings      = asn1.ObjectIdentifier{2, 5, 29, 33}
+	oidExtensionPolicyConstraints   = asn1.ObjectIdentifier{2, 5, 29, 36}
+	oidExtensionInhibitAnyPolicy    = asn1.ObjectIdentifier{2, 5, 29, 54}
+	oidAnyPolicy                    = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+	oidPolicyQualifierCPS           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidPolicyQualifierUserNotice    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// Extensions stamped in by certificate profiles, the extended key usage is only written by hand when it has to be critical
+var (
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtensionOCSPNoCheck      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+)
+
+// Extended key usages certificate profiles can name, with their OIDs
+var extKeyUsagesByName = map[string]struct {
+	usage x509.ExtKeyUsage
+	oid   asn1.ObjectIdentifier
+}{
+	"any":              {x509.ExtKeyUsageAny, asn1.ObjectIdentifier{2, 5, 29, 37, 0}},
+	"server_auth":      {x509.ExtKeyUsageServerAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}},
+	"client_auth":      {x509.ExtKeyUsageClientAuth, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}},
+	"code_signing":     {x509.ExtKeyUsageCodeSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}},
+	"email_protection": {x509.ExtKeyUsageEmailProtection, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}},
+	"time_stamping":    {x509.ExtKeyUsageTimeStamping, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}},
+	"ocsp_signing":     {x509.ExtKeyUsageOCSPSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}},
+}
+
+// Key usages custom certificate profiles can name, signing certificates and CRLs is left to CAs
+var keyUsagesByName = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"encipher_only":      x509.KeyUsageEncipherOnly,
+	"decipher_only":      x509.KeyUsageDecipherOnly,
+}
+
+// Built-in certificate types, an empty certificate type is a server certificate
+var builtinCertificateProfiles = map[string]certificateProfile{
+	"server":         {KeyUsage: x509.KeyUsageDigitalSignature, RSAKeyEncipherment: true, ExtKeyUsage: []string{"server_auth"}},
+	"client":         {KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []string{"client_auth"}},
+	"server-client":  {KeyUsage: x509.KeyUsageDigitalSignature, RSAKeyEncipherment: true, ExtKeyUsage: []string{"server_auth", "client_auth"}},
+	"code-signing":   {KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []string{"code_signing"}},
+	"email":          {KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, RSAKeyEncipherment: true, ExtKeyUsage: []string{"email_protection"}, RequireEmailAddress: true},
+	"ocsp-signing":   {KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []string{"ocsp_signing"}, OCSPNoCheck: true},
+	"timestamping":   {KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []string{"time_stamping"}, CriticalExtKeyUsage: true},
+	"subordinate-ca": {KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign, IsCA: true},
+}
+
+// Extensions of a CA certificate that are set again instead of being carried over when it is renewed or rekeyed:
+// the subject and authority key identifiers, CRL distribution points, and authority information access
+var renewedCAExcludedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},
+	{2, 5, 29, 35},
+	{2, 5, 29, 31},
+	{1, 3, 6, 1, 5, 5, 7, 1, 1},
+}
+
+// File names looked for in an OpenSSL CA directory, the openssl.cnf defaults and the openssl_extras layout
+var (
+	openSSLCACertificateFiles = []string{"cacert.pem", "ca.cert", "ca.cert.pem", "ca.pem", "certs/ca.cert.pem"}
+	openSSLCAPrivateKeyFiles  = []string{"private/cakey.pem", "private/ca.key.pem", "private/ca.key"}
+	openSSLCAIndexFiles       = []string{"index.txt", "ca.index"}
+	openSSLCASerialFiles      = []string{"serial", "ca.serial"}
+	openSSLCACRLNumberFiles   = []string{"crlnumber", "ca.crlnum"}
+)
+
+// crlReasonCodes maps the revocation reasons OpenSSL writes to index files to their CRL reason codes
+var crlReasonCodes = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"keyTime":              1,
+	"CACompromise":         2,
+	"CAkeyTime":            2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"holdInstruction":      6,
+	"removeFromCRL":        8,
+}
+
+// supportedKeyAlgorithms lists the key algorithms that can be generated for key pairs, CSRs, and CAs
+var supportedKeyAlgorithms = []string{"rsa", "ecdsa", "ed25519"}
+
+// Key export formats for the GET /v1/key endpoint
+const (
+	keyExportFormatPEM     = "pem"
+	keyExportFormatDER     = "der"
+	keyExportFormatJWK     = "jwk"
+	keyExportFormatOpenSSH = "openssh"
+)
+
+// supportedKeyExportFormats lists the formats key pairs can be read in
+var supportedKeyExportFormats = []string{keyExportFormatPEM, keyExportFormatDER, keyExportFormatJWK, keyExportFormatOpenSSH}
+
 // RFC 3279, 2.3 Public Key Algorithms
 //
 // pkcs-1 OBJECT IDENTIFIER ::== { iso(1) member-body(2) us(840)
@@ -137,6 +323,24 @@ var (
 	oidISOSignatureSHA1WithRSA = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 29}
 )
 
+// RFC 8018 PBES2 Object Identifiers used for encrypted PKCS #8 private keys
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
 const (
 	nameTypeEmail = 1
 	nameTypeDNS   = 2
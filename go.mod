@@ -3,9 +3,12 @@ module github.com/kenmoini/locksmith
 go 1.15
 
 require (
+	github.com/ThalesIgnite/crypto11 v1.2.5
 	github.com/gosimple/slug v1.13.1
 	github.com/jszwec/csvutil v1.5.0
 	github.com/kr/pretty v0.1.0 // indirect
+	golang.org/x/crypto v0.0.0-20220331220935-ae2d96664a29
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/yaml.v2 v2.4.0
+	software.sslmate.com/src/go-pkcs12 v0.2.0
 )